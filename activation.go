@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SD_LISTEN_FDS_START is the first file descriptor passed by systemd.
+const SD_LISTEN_FDS_START = 3
+
+// activationListeners returns the sockets passed by systemd socket
+// activation, or nil when the server was not socket-activated. TCP sockets
+// are served with TLS unless their FileDescriptorName is "plain"; Unix
+// sockets are always plaintext. The returned labels describe each socket
+// for the log.
+func activationListeners() ([]net.Listener, []string, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	var config *tls.Config
+	var listeners []net.Listener
+	var labels []string
+	for i := 0; i < count; i++ {
+		fd := SD_LISTEN_FDS_START + i
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, nil, fmt.Errorf("systemd socket %d: %v", fd, err)
+		}
+		scheme := "tls"
+		if listener.Addr().Network() != "unix" {
+			listener = throttle(listener)
+		}
+		if listener.Addr().Network() == "unix" || name == "plain" {
+			scheme = listener.Addr().Network()
+		} else {
+			if config == nil {
+				if config, err = loadTLSConfig(DEFAULT_CERT_FILE, DEFAULT_KEY_FILE); err != nil {
+					listener.Close()
+					closeListeners(listeners)
+					return nil, nil, err
+				}
+			}
+			listener = tls.NewListener(listener, config)
+		}
+		listeners = append(listeners, listener)
+		labels = append(labels, fmt.Sprintf("%s://%s (systemd socket %d)", scheme, listener.Addr(), fd))
+	}
+	return listeners, labels, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ACTIVATION_CHILD is set in the environment of the test binary that
+// TestActivation starts, which then plays a socket-activated server.
+const ACTIVATION_CHILD = "GOCHAT_ACTIVATION_CHILD"
+
+// TestActivationChild runs only in the child: it takes the sockets it was
+// passed, reports each one's label or the error, and greets one client on
+// each.
+func TestActivationChild(t *testing.T) {
+	if os.Getenv(ACTIVATION_CHILD) == "" {
+		t.Skip("only run by TestActivation")
+	}
+	// Only the child knows its pid, so it sets LISTEN_PID as systemd would.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, labels, err := activationListeners()
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(name); ok {
+			fmt.Println("error:", name, "still set")
+		}
+	}
+	for _, label := range labels {
+		fmt.Println("label:", label)
+	}
+	for _, listener := range listeners {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		conn.Write([]byte("hello\n"))
+		conn.Close()
+	}
+}
+
+// activationChild starts the test binary as a socket-activated server
+// with files as its descriptors from 3 on, named names, and returns its
+// output as it comes.
+func activationChild(t *testing.T, names string, files ...*os.File) *bufio.Reader {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationChild$")
+	cmd.Env = append(os.Environ(), ACTIVATION_CHILD+"=1", fmt.Sprintf("LISTEN_FDS=%d", len(files)), "LISTEN_FDNAMES="+names)
+	cmd.ExtraFiles = files
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return bufio.NewReader(output)
+}
+
+// nextResult returns the next label or error line from a child.
+func nextResult(t *testing.T, output *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := output.ReadString('\n')
+		if err != nil {
+			t.Fatalf("the child ended without a result: %v", err)
+		}
+		if strings.HasPrefix(line, "label: ") || strings.HasPrefix(line, "error: ") {
+			return strings.TrimSpace(line)
+		}
+	}
+}
+
+func TestActivation(t *testing.T) {
+	unixListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "chat.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+	unixFile, err := unixListener.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixFile.Close()
+	tcpFile, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpFile.Close()
+
+	output := activationChild(t, "chat:plain", unixFile, tcpFile)
+	for _, want := range []string{
+		fmt.Sprintf("label: unix://%s (systemd socket 3)", unixListener.Addr()),
+		fmt.Sprintf("label: tcp://%s (systemd socket 4)", tcpListener.Addr()),
+	} {
+		if got := nextResult(t, output); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	for _, addr := range []net.Addr{unixListener.Addr(), tcpListener.Addr()} {
+		conn, err := net.DialTimeout(addr.Network(), addr.String(), LINE_TIMEOUT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(LINE_TIMEOUT))
+		greeting, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(greeting) != "hello\n" {
+			t.Errorf("%s: got %q, %v from the inherited socket", addr.Network(), greeting, err)
+		}
+	}
+}
+
+func TestActivationRejectsNonSocket(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	output := activationChild(t, "", reader)
+	if got := nextResult(t, output); !strings.HasPrefix(got, "error: systemd socket 3: ") {
+		t.Errorf("got %q, want an error for the pipe", got)
+	}
+}
+
+func TestActivationIgnoresOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, labels, err := activationListeners()
+	if listeners != nil || labels != nil || err != nil {
+		t.Errorf("got %v, %q, %v for another process's sockets", listeners, labels, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ACTIVITY_MINUTES is how many one-minute buckets of chat a room keeps for
+// its messages-per-hour figure.
+const ACTIVITY_MINUTES = 60
+
+// roomActivity counts the chat messages of a room per minute over the last
+// hour, so /list can tell live rooms from quiet ones without walking the
+// history.
+type roomActivity struct {
+	last time.Time
+	// counts[i] is the number of messages in the minute minutes[i], as
+	// Unix time divided by 60.
+	minutes [ACTIVITY_MINUTES]int64
+	counts  [ACTIVITY_MINUTES]int
+}
+
+// record counts a chat message sent at now. The caller must hold mutex.
+func (a *roomActivity) record(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % ACTIVITY_MINUTES
+	if a.minutes[i] != minute {
+		a.minutes[i], a.counts[i] = minute, 0
+	}
+	a.counts[i]++
+	a.last = now
+}
+
+// perHour returns the number of messages in the hour before now. The
+// caller must hold mutex.
+func (a *roomActivity) perHour(now time.Time) int {
+	minute := now.Unix() / 60
+	total := 0
+	for i, count := range a.counts {
+		if minute-a.minutes[i] < ACTIVITY_MINUTES {
+			total += count
+		}
+	}
+	return total
+}
+
+// sortByActivity orders entries by their last message, most recent first,
+// with rooms nobody has written in yet last in alphabetical order.
+func sortByActivity(entries []directoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].LastMessage, entries[j].LastMessage
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.After(*b)
+	})
+}
+
+// agoText formats how long ago something happened for /list, e.g. "2m".
+func agoText(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
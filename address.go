@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UNIX_ANONYMOUS is the address of a Unix socket client whose credentials
+// could not be read. Bans do not apply to it.
+const UNIX_ANONYMOUS = "unix"
+
+// clientAddress identifies the remote end of conn for bans and the admin
+// console: the IP and port for network connections, and the peer's user
+// ID for Unix sockets, which have no meaningful remote address.
+func clientAddress(conn net.Conn) string {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return unixPeer(unixConn)
+	}
+	return conn.RemoteAddr().String()
+}
+
+// MIN_BAN_PREFIX_V4 and MIN_BAN_PREFIX_V6 are the shortest network prefixes
+// that can be banned without forcing it; shorter ones are most likely typos.
+const (
+	MIN_BAN_PREFIX_V4 = 8
+	MIN_BAN_PREFIX_V6 = 32
+)
+
+// parseBan parses what an admin asked to ban: a CIDR network such as
+// 203.0.113.0/24 or 2001:db8::/48, an IPv4 wildcard such as 203.0.113.*,
+// a bare IP, which covers every port, or an exact address as returned by
+// clientAddress. Networks wider than the MIN_BAN_PREFIX constants are
+// refused unless force is set.
+func parseBan(target string, force bool) (BannedUser, error) {
+	if target == "" {
+		return BannedUser{}, errors.New("Enter an address or network to ban.")
+	}
+	if target == UNIX_ANONYMOUS {
+		return BannedUser{}, errors.New("Unix socket clients without credentials cannot be banned.")
+	}
+	if strings.HasSuffix(target, "*") {
+		cidr, ok := wildcardCIDR(target)
+		if !ok {
+			return BannedUser{}, fmt.Errorf("Invalid wildcard %s. Use a form such as 203.0.113.*.", target)
+		}
+		target = cidr
+	}
+	if strings.Contains(target, "/") {
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return BannedUser{}, fmt.Errorf("Invalid network %s. Use a form such as 203.0.113.0/24.", target)
+		}
+		ones, bits := network.Mask.Size()
+		least := MIN_BAN_PREFIX_V6
+		if bits == 32 {
+			least = MIN_BAN_PREFIX_V4
+		}
+		if ones < least && !force {
+			return BannedUser{}, fmt.Errorf("%s covers too many addresses; force the ban to apply it anyway.", network)
+		}
+		return BannedUser{Address: network.String(), Net: network}, nil
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		return BannedUser{Address: ip.String()}, nil
+	}
+	return BannedUser{Address: target}, nil
+}
+
+// wildcardCIDR turns an IPv4 wildcard, whose trailing octets are *, into
+// the network it covers.
+func wildcardCIDR(pattern string) (string, bool) {
+	octets := strings.Split(pattern, ".")
+	if len(octets) > 4 || octets[len(octets)-1] != "*" {
+		return "", false
+	}
+	fixed := 0
+	for fixed < len(octets) && octets[fixed] != "*" {
+		fixed++
+	}
+	for _, octet := range octets[fixed:] {
+		if octet != "*" {
+			return "", false
+		}
+	}
+	ip := append(octets[:fixed:fixed], "0", "0", "0", "0")[:4]
+	return fmt.Sprintf("%s/%d", strings.Join(ip, "."), 8*fixed), true
+}
+
+// banHost bans the IP of addr, as returned by clientAddress, or all of it
+// for a Unix socket client, so that reconnecting from another port does
+// not get around the ban. The reason, which may be empty, is shown to the
+// host when it tries to connect. It returns what it banned, or "" for
+// UNIX_ANONYMOUS, which cannot be banned.
+func banHost(addr, reason string) string {
+	ban, err := parseBan(addressHost(addr), true)
+	if err != nil {
+		return ""
+	}
+	ban.Reason = reason
+	addBan(ban)
+	return ban.Address
+}
+
+// addBan records ban, as returned by parseBan, replacing any ban on the
+// same address or network. It disconnects no one; see bannedClients.
+func addBan(ban BannedUser) {
+	ban.Time = time.Now()
+	mutex.Lock()
+	bannedUsers[ban.Address] = ban
+	mutex.Unlock()
+	log.Printf("Banned %s%s", ban.Address, reasonSuffix(ban.Reason))
+}
+
+// bannedClients returns the connected clients that the current bans cover.
+func bannedClients() []*Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var list []*Client
+	for _, client := range clients {
+		if _, banned := isBanned(client.address); banned {
+			list = append(list, client)
+		}
+	}
+	return list
+}
+
+// unbanAddress lifts the server ban on addr, which may be written in any
+// form parseBan accepts, reporting whether there was one. Overlapping bans
+// are left in place.
+func unbanAddress(addr string) bool {
+	if ban, err := parseBan(addr, true); err == nil {
+		addr = ban.Address
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, banned := bannedUsers[addr]
+	delete(bannedUsers, addr)
+	if banned {
+		log.Printf("Unbanned %s", addr)
+	}
+	return banned
+}
+
+// isBanned returns the ban on addr, as returned by clientAddress, if there
+// is one. An exact ban on the address wins, then one on its IP, then the
+// narrowest banned network holding the IP. The caller must hold mutex.
+func isBanned(addr string) (BannedUser, bool) {
+	if addr == UNIX_ANONYMOUS {
+		return BannedUser{}, false
+	}
+	if ban, banned := bannedUsers[addr]; banned {
+		return ban, true
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return BannedUser{}, false
+	}
+	if ban, banned := bannedUsers[ip.String()]; banned {
+		return ban, true
+	}
+	var match BannedUser
+	longest := -1
+	for _, ban := range bannedUsers {
+		if ban.Net == nil || !ban.Net.Contains(ip) {
+			continue
+		}
+		if ones, _ := ban.Net.Mask.Size(); ones > longest {
+			match, longest = ban, ones
+		}
+	}
+	return match, longest >= 0
+}
+
+// sortedBans returns the bans ordered by address, with networks after
+// single addresses. The caller must hold mutex.
+func sortedBans() []BannedUser {
+	list := make([]BannedUser, 0, len(bannedUsers))
+	for _, ban := range bannedUsers {
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Net == nil) != (list[j].Net == nil) {
+			return list[i].Net == nil
+		}
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// reasonSuffix formats an optional reason for log lines and leave notices.
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseBan(t *testing.T) {
+	tests := []struct {
+		target  string
+		force   bool
+		address string
+		network bool
+		wantErr bool
+	}{
+		{"203.0.113.7", false, "203.0.113.7", false, false},
+		{"203.0.113.7:4000", false, "203.0.113.7:4000", false, false},
+		{"2001:db8::1", false, "2001:db8::1", false, false},
+		{"2001:0db8:0000::1", false, "2001:db8::1", false, false},
+		{"[2001:db8::1]:4000", false, "[2001:db8::1]:4000", false, false},
+		{"203.0.113.0/24", false, "203.0.113.0/24", true, false},
+		{"203.0.113.99/24", false, "203.0.113.0/24", true, false},
+		{"2001:db8::/48", false, "2001:db8::/48", true, false},
+		{"203.0.113.*", false, "203.0.113.0/24", true, false},
+		{"203.0.*.*", false, "203.0.0.0/16", true, false},
+		{"10.*", false, "10.0.0.0/8", true, false},
+		{"10.0.0.0/7", false, "", false, true},
+		{"10.0.0.0/7", true, "10.0.0.0/7", true, false},
+		{"*", false, "", false, true},
+		{"*", true, "0.0.0.0/0", true, false},
+		{"2001:db8::/31", false, "", false, true},
+		{"203.*.113.*", false, "", false, true},
+		{"203.0.113.7.*", false, "", false, true},
+		{"203.0.113.0/33", false, "", false, true},
+		{"", false, "", false, true},
+		{UNIX_ANONYMOUS, true, "", false, true},
+		{"unix:uid=1000", false, "unix:uid=1000", false, false},
+	}
+	for _, test := range tests {
+		ban, err := parseBan(test.target, test.force)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseBan(%q, %t) error = %v, want error %t", test.target, test.force, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if ban.Address != test.address || (ban.Net != nil) != test.network {
+			t.Errorf("parseBan(%q, %t) = %s (network %t), want %s (network %t)", test.target, test.force, ban.Address, ban.Net != nil, test.address, test.network)
+		}
+	}
+}
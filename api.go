@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	API_DEFAULT_LIMIT = 100
+	API_MAX_LIMIT     = 1000
+)
+
+// apiRoute is an endpoint of the REST API. The routes also describe the
+// API at GET /api/.
+type apiRoute struct {
+	method  string
+	path    string
+	summary string
+	handler http.HandlerFunc
+}
+
+var apiRoutes = []apiRoute{
+	{"GET", "/api/rooms", "List rooms with their member counts. Paginated with offset and limit.", apiListRooms},
+	{"GET", "/api/rooms/{name}", "Show a room's members, owner and last sequence number.", apiGetRoom},
+	{"GET", "/api/clients", "List connected clients. Paginated with offset and limit.", apiListClients},
+	{"GET", "/api/bans", "List banned addresses. Paginated with offset and limit.", apiListBans},
+	{"POST", "/api/bans", `Ban {"address": "...", "reason": "..."}, disconnecting the clients it covers. The address may be a CIDR network or an IPv4 wildcard; networks wider than /8 (IPv4) or /32 (IPv6) need "force": true. The reason is optional.`, apiAddBan},
+	{"DELETE", "/api/bans/{address...}", "Lift the ban on an address or network.", apiDeleteBan},
+	{"GET", "/api/debug/clients/{who}", "Dump the server-side state of the clients named who or connected from the address who.", apiDebugClient},
+	{"POST", "/api/kick", `Disconnect the client with {"address": "..."} or {"username": "..."}, with an optional "reason".`, apiKick},
+}
+
+type apiPage struct {
+	Items  any `json:"items"`
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+type apiRoom struct {
+	Name      string   `json:"name"`
+	Members   int      `json:"members"`
+	Names     []string `json:"member_names,omitempty"`
+	Owner     string   `json:"owner,omitempty"`
+	LastSeq   uint64   `json:"last_seq"`
+	Whitelist bool     `json:"whitelist"`
+}
+
+type apiClient struct {
+	Username    string    `json:"username"`
+	Address     string    `json:"address"`
+	Room        string    `json:"room,omitempty"`
+	Software    string    `json:"software"`
+	Connected   time.Time `json:"connected"`
+	IdleSeconds int       `json:"idle_seconds"`
+}
+
+type apiBan struct {
+	Address string    `json:"address"`
+	Network bool      `json:"network"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+type apiTarget struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	Force    bool   `json:"force"`
+}
+
+// mountAPI serves the REST API under /api/ to requests bearing token.
+func mountAPI(token string) {
+	for _, route := range apiRoutes {
+		httpMux.Handle(route.method+" "+route.path, requireToken(token, route.handler))
+	}
+	httpMux.Handle("GET /api/{$}", requireToken(token, http.HandlerFunc(apiDescribe)))
+}
+
+func requireToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chat api"`)
+			apiError(w, http.StatusUnauthorized, "Missing or wrong bearer token.")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func apiDescribe(w http.ResponseWriter, r *http.Request) {
+	type endpoint struct {
+		Method  string `json:"method"`
+		Path    string `json:"path"`
+		Summary string `json:"summary"`
+	}
+	endpoints := make([]endpoint, len(apiRoutes))
+	for i, route := range apiRoutes {
+		endpoints[i] = endpoint{route.method, route.path, route.summary}
+	}
+	apiJSON(w, http.StatusOK, map[string]any{"auth": "Authorization: Bearer <token>", "endpoints": endpoints})
+}
+
+func apiListRooms(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := apiPagination(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mutex.Lock()
+	names := roomNames()
+	list := make([]apiRoom, 0, len(names))
+	for _, name := range names {
+		list = append(list, apiRoom{Name: name, Members: len(rooms[name]), LastSeq: lastSeq(name)})
+	}
+	mutex.Unlock()
+	apiJSON(w, http.StatusOK, paginate(list, offset, limit))
+}
+
+func apiGetRoom(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	mutex.Lock()
+	members, ok := rooms[name]
+	room := apiRoom{Name: name, Members: len(members), Names: roomMembers(name), LastSeq: lastSeq(name)}
+	if meta := roomMetas[name]; meta != nil {
+		if meta.owner != nil {
+			room.Owner = meta.owner.username
+		}
+		room.Whitelist = meta.whitelist
+	}
+	mutex.Unlock()
+	if !ok {
+		apiError(w, http.StatusNotFound, "Room "+name+" does not exist.")
+		return
+	}
+	apiJSON(w, http.StatusOK, room)
+}
+
+func apiListClients(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := apiPagination(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mutex.Lock()
+	list := make([]apiClient, 0, len(clients))
+	for _, client := range clients {
+		idle := time.Since(time.Unix(0, client.lastActive.Load()))
+		list = append(list, apiClient{Username: client.username, Address: client.address, Room: client.room, Software: client.softwareLabel(), Connected: client.connected, IdleSeconds: int(idle.Seconds())})
+	}
+	mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	apiJSON(w, http.StatusOK, paginate(list, offset, limit))
+}
+
+func apiListBans(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := apiPagination(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mutex.Lock()
+	list := make([]apiBan, 0, len(bannedUsers))
+	for _, ban := range sortedBans() {
+		list = append(list, toAPIBan(ban))
+	}
+	mutex.Unlock()
+	apiJSON(w, http.StatusOK, paginate(list, offset, limit))
+}
+
+func apiAddBan(w http.ResponseWriter, r *http.Request) {
+	var target apiTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil || target.Address == "" {
+		apiError(w, http.StatusBadRequest, `Send {"address": "..."}.`)
+		return
+	}
+	ban, err := parseBan(target.Address, target.Force)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ban.Reason = target.Reason
+	addBan(ban)
+	// The kicks can wait on slow connections; the reply does not.
+	for _, client := range bannedClients() {
+		go evictUser(client, "banned", ban.Reason)
+	}
+	mutex.Lock()
+	ban = bannedUsers[ban.Address]
+	mutex.Unlock()
+	apiJSON(w, http.StatusCreated, toAPIBan(ban))
+}
+
+func toAPIBan(ban BannedUser) apiBan {
+	return apiBan{Address: ban.Address, Network: ban.Net != nil, Reason: ban.Reason, Since: ban.Time}
+}
+
+func apiDeleteBan(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !unbanAddress(address) {
+		apiError(w, http.StatusNotFound, address+" is not banned.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiKick(w http.ResponseWriter, r *http.Request) {
+	var target apiTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil || (target.Address == "") == (target.Username == "") {
+		apiError(w, http.StatusBadRequest, `Send {"address": "..."} or {"username": "..."}.`)
+		return
+	}
+	var client *Client
+	if target.Address != "" {
+		client = clientByAddress(target.Address)
+	} else {
+		mutex.Lock()
+		client = findClient(target.Username)
+		mutex.Unlock()
+	}
+	if client == nil {
+		apiError(w, http.StatusNotFound, "No such client.")
+		return
+	}
+	go kickUser(client, target.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPagination reads the offset and limit query parameters.
+func apiPagination(r *http.Request) (offset, limit int, err error) {
+	limit = API_DEFAULT_LIMIT
+	if s := r.URL.Query().Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, errors.New("The offset must be a number of at least 0.")
+		}
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > API_MAX_LIMIT {
+			return 0, 0, errors.New("The limit must be a number from 1 to " + strconv.Itoa(API_MAX_LIMIT) + ".")
+		}
+	}
+	return offset, limit, nil
+}
+
+func paginate[T any](list []T, offset, limit int) apiPage {
+	total := len(list)
+	start := min(offset, total)
+	end := min(start+limit, total)
+	return apiPage{Items: list[start:end], Total: total, Offset: offset, Limit: limit}
+}
+
+func apiJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, map[string]string{"error": message})
+}
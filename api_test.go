@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	list := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		offset, limit int
+		want          []int
+	}{
+		{0, 100, []int{1, 2, 3, 4, 5}},
+		{0, 2, []int{1, 2}},
+		{2, 2, []int{3, 4}},
+		{4, 2, []int{5}},
+		{5, 2, []int{}},
+		{99, 2, []int{}},
+	}
+	for _, test := range tests {
+		page := paginate(list, test.offset, test.limit)
+		items, _ := page.Items.([]int)
+		if !slices.Equal(items, test.want) {
+			t.Errorf("paginate(offset %d, limit %d) items = %v, want %v", test.offset, test.limit, items, test.want)
+		}
+		if page.Total != len(list) || page.Offset != test.offset || page.Limit != test.limit {
+			t.Errorf("paginate(offset %d, limit %d) = total %d, offset %d, limit %d", test.offset, test.limit, page.Total, page.Offset, page.Limit)
+		}
+	}
+}
+
+// getBans runs GET /api/bans?query and decodes the page it returns.
+func getBans(t *testing.T, query string) (int, map[string]json.RawMessage) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	apiListBans(recorder, httptest.NewRequest("GET", "/api/bans?"+query, nil))
+	var page map[string]json.RawMessage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		t.Fatalf("GET /api/bans?%s: %v in %q", query, err, recorder.Body)
+	}
+	return recorder.Code, page
+}
+
+func TestAPIListBans(t *testing.T) {
+	startServer()
+	mutex.Lock()
+	saved := bannedUsers
+	bannedUsers = make(map[string]BannedUser)
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		bannedUsers = saved
+		mutex.Unlock()
+	})
+
+	if code, page := getBans(t, ""); code != http.StatusOK || string(page["items"]) != "[]" || string(page["total"]) != "0" {
+		t.Errorf("with no bans: %d, items %s, total %s; want 200, [] and 0", code, page["items"], page["total"])
+	}
+
+	for _, target := range []string{"203.0.113.7", "198.51.100.0/24", "192.0.2.1:4000"} {
+		ban, err := parseBan(target, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addBan(ban)
+	}
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"192.0.2.1:4000", "203.0.113.7", "198.51.100.0/24"}},
+		{"limit=1", http.StatusOK, []string{"192.0.2.1:4000"}},
+		{"offset=1&limit=1", http.StatusOK, []string{"203.0.113.7"}},
+		{"offset=3", http.StatusOK, []string{}},
+		{"limit=0", http.StatusBadRequest, nil},
+		{"offset=-1", http.StatusBadRequest, nil},
+		{"limit=many", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		code, page := getBans(t, test.query)
+		if code != test.code {
+			t.Errorf("GET /api/bans?%s = %d, want %d", test.query, code, test.code)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		var items []apiBan
+		if err := json.Unmarshal(page["items"], &items); err != nil || items == nil {
+			t.Errorf("GET /api/bans?%s items = %s, want a list", test.query, page["items"])
+			continue
+		}
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Address)
+		}
+		if !slices.Equal(got, test.want) && len(got)+len(test.want) > 0 {
+			t.Errorf("GET /api/bans?%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MAX_AWAY_LENGTH = 200
+
+func init() {
+	registerCommand("/away", chatCommand{usage: "/away [message]", help: "Mark yourself away with a message, or come back", run: awayCommand})
+}
+
+// awayCommand sets the client's away message, shown in /who until it is
+// cleared with a bare /away. Unlike autoAway it outlasts the lines the
+// client sends.
+func awayCommand(client *Client, room, args string) {
+	message := strings.TrimSpace(oneLine(args))
+	if utf8.RuneCountInString(message) > MAX_AWAY_LENGTH {
+		client.say("away.too_long", MAX_AWAY_LENGTH)
+		return
+	}
+	mutex.Lock()
+	client.awayMessage = message
+	mutex.Unlock()
+	if message == "" {
+		client.say("away.back")
+		return
+	}
+	client.say("away.set", message)
+}
+
+// autoAway reports whether the client has sent nothing for away-after.
+// Only /who shows it: rooms are not told, the next line the client sends
+// ends it, and nobody is disconnected for it.
+func (c *Client) autoAway(now time.Time) bool {
+	after := config().awayAfter
+	return after > 0 && now.Sub(time.Unix(0, c.lastActive.Load())) >= after
+}
+
+// awayLabel returns label marked as away in the language of viewer if c
+// is, by its away message or else by autoAway. The caller must hold mutex.
+func (c *Client) awayLabel(viewer *Client, label string, now time.Time) string {
+	switch {
+	case c.awayMessage != "":
+		return viewer.tr("who.away_message", label, c.awayMessage)
+	case c.autoAway(now):
+		return viewer.tr("who.away", label)
+	}
+	return label
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TEST_AWAY_AFTER is the away-after of the away tests.
+const TEST_AWAY_AFTER = 200 * time.Millisecond
+
+// whoLabel sends /who as viewer and returns the label of the member
+// called name.
+func whoLabel(viewer *testClient, name string) string {
+	viewer.t.Helper()
+	viewer.send("/who")
+	line := viewer.expectLine("Users in ")
+	_, list, _ := strings.Cut(line, ": ")
+	for _, label := range strings.Split(list, ", ") {
+		if label == name || strings.HasPrefix(label, name+" ") {
+			return label
+		}
+	}
+	viewer.t.Fatalf("%s is not in %q", name, line)
+	return ""
+}
+
+func TestAway(t *testing.T) {
+	withSettings(t, func(s *settings) { s.awayAfter = TEST_AWAY_AFTER })
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("away")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	bob.expectLine("Joined room " + room)
+	expect := func(what, want string) {
+		t.Helper()
+		if got := whoLabel(alice, bob.name); got != want {
+			t.Errorf("%s: /who shows %q, want %q", what, got, want)
+		}
+	}
+
+	expect("active", bob.name)
+	time.Sleep(2 * TEST_AWAY_AFTER)
+	expect("idle", bob.name+" (away)")
+	bob.send("back again")
+	bob.expectLine("back again")
+	expect("active again", bob.name)
+
+	bob.send("/away at lunch")
+	bob.expectLine("You are marked away: at lunch")
+	expect("away", bob.name+" (away: at lunch)")
+	time.Sleep(2 * TEST_AWAY_AFTER)
+	expect("away and idle", bob.name+" (away: at lunch)")
+	bob.send("still at lunch")
+	bob.expectLine("still at lunch")
+	expect("away and active", bob.name+" (away: at lunch)")
+
+	bob.send("/away")
+	bob.expectLine("You are no longer marked away.")
+	expect("back", bob.name)
+	bob.send("/away " + strings.Repeat("x", MAX_AWAY_LENGTH+1))
+	bob.expectLine("ERR_TOO_LONG: ")
+	expect("message too long", bob.name)
+}
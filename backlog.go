@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_BROADCAST_BUFFER = 256
+	// The broadcast channel is backed up when it is BACKLOG_FULL_PERCENT
+	// full; staying so for BACKLOG_WARN_AFTER logs a warning.
+	BACKLOG_FULL_PERCENT   = 80
+	BACKLOG_WARN_AFTER     = 5 * time.Second
+	BACKLOG_CHECK_INTERVAL = time.Second
+)
+
+var (
+	// broadcastBuffer is the capacity of the broadcast channel, set with
+	// -broadcast-buffer. A full channel blocks every client that sends to
+	// a room, so watchBacklog warns before it fills.
+	broadcastBuffer = DEFAULT_BROADCAST_BUFFER
+
+	// Total for /stats.
+	backlogWarnings atomic.Int64
+
+	// slowestBroadcast is the slowest broadcast since watchBacklog last
+	// took it, to name the likely culprit when the channel backs up.
+	slowestBroadcast struct {
+		sync.Mutex
+		latency time.Duration
+		room    string
+		client  string
+		queued  int
+	}
+)
+
+// noteBroadcast keeps the broadcast of message to members for
+// slowestBroadcast if it took longer than the slowest so far. The caller
+// must hold mutex.
+func noteBroadcast(elapsed time.Duration, message Message, members []*Client) {
+	slowestBroadcast.Lock()
+	defer slowestBroadcast.Unlock()
+	if elapsed <= slowestBroadcast.latency {
+		return
+	}
+	slowestBroadcast.latency, slowestBroadcast.room = elapsed, message.room
+	slowestBroadcast.client, slowestBroadcast.queued = slowestMember(members)
+}
+
+// watchBacklog checks the depth of the broadcast channel every
+// BACKLOG_CHECK_INTERVAL and warns once each time it stays
+// BACKLOG_FULL_PERCENT full for BACKLOG_WARN_AFTER.
+func watchBacklog() {
+	if broadcastBuffer == 0 {
+		return
+	}
+	ticker := time.NewTicker(BACKLOG_CHECK_INTERVAL)
+	defer ticker.Stop()
+	var since time.Time
+	warned := false
+	for now := range ticker.C {
+		depth := len(broadcast)
+		if depth*100 < cap(broadcast)*BACKLOG_FULL_PERCENT {
+			if warned {
+				log.Printf("Broadcast channel recovered after %v; %d of %d queued", now.Sub(since).Round(time.Second), depth, cap(broadcast))
+			}
+			since, warned = time.Time{}, false
+			takeSlowestBroadcast()
+			continue
+		}
+		if since.IsZero() {
+			since = now
+		}
+		if warned || now.Sub(since) < BACKLOG_WARN_AFTER {
+			continue
+		}
+		warned = true
+		backlogWarnings.Add(1)
+		latency, room, client, queued := takeSlowestBroadcast()
+		log.Printf("Warning: broadcast channel backed up: %d of %d queued for %v; slowest broadcast: room=%q latency=%v slowest=%q queued=%d", depth, cap(broadcast), now.Sub(since).Round(time.Second), room, latency.Round(time.Microsecond), client, queued)
+	}
+}
+
+// takeSlowestBroadcast returns slowestBroadcast and resets it.
+func takeSlowestBroadcast() (time.Duration, string, string, int) {
+	slowestBroadcast.Lock()
+	defer slowestBroadcast.Unlock()
+	latency, room, client, queued := slowestBroadcast.latency, slowestBroadcast.room, slowestBroadcast.client, slowestBroadcast.queued
+	slowestBroadcast.latency, slowestBroadcast.room, slowestBroadcast.client, slowestBroadcast.queued = 0, "", "", 0
+	return latency, room, client, queued
+}
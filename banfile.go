@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// BAN_IMPORT_BATCH is how many bans /banimport adds per hold of mutex.
+const BAN_IMPORT_BATCH = 256
+
+// A ban file has one ban per line, as the REST API shows them:
+// {"address":"203.0.113.0/24","network":true,"reason":"spam","since":"..."}.
+// /banexport writes the bans sorted like /banned, and importing that file
+// into a server without bans exports to the same bytes.
+
+// exportBans writes every server ban to path, returning how many.
+func exportBans(path string) (int, error) {
+	mutex.Lock()
+	list := sortedBans()
+	mutex.Unlock()
+	var data []byte
+	for _, ban := range list {
+		line, err := json.Marshal(toAPIBan(ban))
+		if err != nil {
+			return 0, err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
+
+// banImport is the outcome of reading a ban file. problems holds a
+// "line N: ..." message for each invalid entry.
+type banImport struct {
+	bans     []BannedUser
+	skipped  int
+	problems []string
+}
+
+// readBanFile parses the ban file at path. A file with lines that are not
+// ban objects is rejected as a whole, with every such line listed in the
+// error; entries that parse but cannot be banned are only counted as
+// invalid. Addresses repeated in the file are skipped after the first.
+func readBanFile(path string) (banImport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return banImport{}, err
+	}
+	defer file.Close()
+	var result banImport
+	var malformed []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry apiBan
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil || decoder.More() {
+			if err == nil {
+				err = errors.New("more than one ban on the line")
+			}
+			malformed = append(malformed, fmt.Sprintf("line %d: %v", n, err))
+			continue
+		}
+		ban, err := parseBan(entry.Address, true)
+		if err == nil && (ban.Net != nil) != entry.Network {
+			err = fmt.Errorf("%s does not match \"network\": %t", entry.Address, entry.Network)
+		}
+		if err != nil {
+			result.problems = append(result.problems, fmt.Sprintf("line %d: %v", n, err))
+			continue
+		}
+		if seen[ban.Address] {
+			result.skipped++
+			continue
+		}
+		seen[ban.Address] = true
+		ban.Reason = entry.Reason
+		ban.Time = entry.Since
+		if ban.Time.IsZero() {
+			ban.Time = time.Now()
+		}
+		result.bans = append(result.bans, ban)
+	}
+	if err := scanner.Err(); err != nil {
+		return banImport{}, err
+	}
+	if len(malformed) > 0 {
+		return banImport{}, fmt.Errorf("%s is not a ban file:\n  %s", path, strings.Join(malformed, "\n  "))
+	}
+	return result, nil
+}
+
+// importBans loads the bans read from a file, taking mutex once per
+// BAN_IMPORT_BATCH of them. With replace the current bans are dropped
+// first; otherwise bans on addresses already banned are kept and the
+// file's skipped. It returns how many were added and skipped, and how to
+// undo the import.
+func importBans(bans []BannedUser, replace bool) (int, int, func()) {
+	mutex.Lock()
+	previous := make(map[string]BannedUser, len(bannedUsers))
+	for address, ban := range bannedUsers {
+		previous[address] = ban
+	}
+	if replace {
+		clear(bannedUsers)
+	}
+	mutex.Unlock()
+	var added []string
+	skipped := 0
+	for start := 0; start < len(bans); start += BAN_IMPORT_BATCH {
+		mutex.Lock()
+		for _, ban := range bans[start:min(start+BAN_IMPORT_BATCH, len(bans))] {
+			if _, exists := bannedUsers[ban.Address]; exists {
+				skipped++
+				continue
+			}
+			bannedUsers[ban.Address] = ban
+			added = append(added, ban.Address)
+		}
+		mutex.Unlock()
+	}
+	undo := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if replace {
+			clear(bannedUsers)
+			for address, ban := range previous {
+				bannedUsers[address] = ban
+			}
+			return
+		}
+		for _, address := range added {
+			delete(bannedUsers, address)
+		}
+	}
+	return len(added), skipped, undo
+}
+
+// banImportCommand runs /banimport <path> [merge|replace] and disconnects
+// the clients the new bans cover.
+func banImportCommand(args string) {
+	fields := strings.Fields(args)
+	mode := "merge"
+	if len(fields) == 2 {
+		mode = fields[1]
+	}
+	if len(fields) < 1 || len(fields) > 2 || (mode != "merge" && mode != "replace") {
+		fmt.Println("Usage: /banimport <path> [merge|replace]")
+		return
+	}
+	path := fields[0]
+	result, err := readBanFile(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, problem := range result.problems {
+		fmt.Println("Invalid, " + problem)
+	}
+	added, skipped, undo := importBans(result.bans, mode == "replace")
+	skipped += result.skipped
+	pushUndo("ban import from "+path, undo)
+	log.Printf("Admin imported bans from %s (%s): %d added, %d skipped as duplicates, %d invalid", path, mode, added, skipped, len(result.problems))
+	fmt.Printf("Imported bans from %s: %d added, %d skipped as duplicates, %d invalid.\n", path, added, skipped, len(result.problems))
+
+	type eviction struct {
+		client *Client
+		reason string
+	}
+	var evictions []eviction
+	mutex.Lock()
+	for _, client := range clients {
+		if ban, banned := isBanned(client.address); banned {
+			evictions = append(evictions, eviction{client, ban.Reason})
+		}
+	}
+	mutex.Unlock()
+	for _, e := range evictions {
+		evictUser(e.client, "banned", e.reason)
+		fmt.Printf("Disconnected %s.\n", e.client.address)
+	}
+}
+
+func banExportCommand(args string) {
+	path := strings.TrimSpace(args)
+	if path == "" {
+		fmt.Println("Usage: /banexport <path>")
+		return
+	}
+	count, err := exportBans(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Wrote %d bans to %s.\n", count, path)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand("/block", chatCommand{usage: "/block [username]", help: "Refuse private messages and invites from a user", run: blockCommand})
+	registerCommand("/unblock", chatCommand{usage: "/unblock [username]", help: "Accept private messages and invites from a user again", run: unblockCommand})
+	registerCommand("/blocks", chatCommand{usage: "/blocks", help: "List the users you blocked", run: blocksCommand})
+}
+
+// blockError returns the error told to sender when target blocked them.
+// Blocks cover private messages and invites, not room chat. The caller
+// must hold mutex.
+func blockError(sender, target *Client) error {
+	if target.blocked[sender.username] {
+		return localErrorf("block.refused", target.username)
+	}
+	return nil
+}
+
+func blockCommand(client *Client, room, name string) {
+	if name == "" || strings.Contains(name, " ") {
+		client.say("block.usage")
+		return
+	}
+	if name == client.username {
+		client.say("block.self")
+		return
+	}
+	mutex.Lock()
+	if client.blocked == nil {
+		client.blocked = make(map[string]bool)
+	}
+	client.blocked[name] = true
+	mutex.Unlock()
+	client.say("block.done", name)
+}
+
+func unblockCommand(client *Client, room, name string) {
+	if name == "" {
+		client.say("unblock.usage")
+		return
+	}
+	mutex.Lock()
+	blocked := client.blocked[name]
+	delete(client.blocked, name)
+	mutex.Unlock()
+	if !blocked {
+		client.say("unblock.not_blocked", name)
+		return
+	}
+	client.say("unblock.done", name)
+}
+
+func blocksCommand(client *Client, room, args string) {
+	mutex.Lock()
+	names := make([]string, 0, len(client.blocked))
+	for name := range client.blocked {
+		names = append(names, name)
+	}
+	mutex.Unlock()
+	if len(names) == 0 {
+		client.say("blocks.none")
+		return
+	}
+	sort.Strings(names)
+	client.say("blocks.list", strings.Join(names, ", "))
+}
+
+// renameBlocks makes blocks of oldName follow a user to newName, so a
+// rename does not get around them. The caller must hold mutex.
+func renameBlocks(oldName, newName string) {
+	for _, c := range clients {
+		if c.blocked[oldName] {
+			delete(c.blocked, oldName)
+			c.blocked[newName] = true
+		}
+	}
+}
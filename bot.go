@@ -0,0 +1,28 @@
+package main
+
+// A connection whose HELLO ends with "bot", as in "HELLO ci-bot 1.0 bot",
+// is a bot's: it is not sent the directory or room greetings, it is
+// tagged with BOT_TAG in /who and chat lines, bot-message-rate limits its
+// chat, and user counts shown to people leave it out.
+const BOT_TAG = "[bot]"
+
+// humanCount returns how many of members are not bots. The caller must
+// hold mutex.
+func humanCount(members []*Client) int {
+	count := 0
+	for _, c := range members {
+		if !c.bot {
+			count++
+		}
+	}
+	return count
+}
+
+// chatRate is the message-rate that applies to the client: bot-message-rate
+// for bots unless it is -1. The caller must hold mutex.
+func (c *Client) chatRate() int {
+	if c.bot && config().botMessageRate >= 0 {
+		return config().botMessageRate
+	}
+	return config().messageRate
+}
@@ -0,0 +1,408 @@
+package main
+
+// Bridge mode links servers so that federated rooms are shared between
+// them. Each server forwards the chat messages, joins, leaves and renames
+// that happen locally in a federated room to every connected peer as one
+// JSON object per line; remote users show up as name@peer.
+//
+// Consistency caveats:
+//   - Delivery is at most once. Events sent while a peer link is down, or
+//     while its queue is full, are dropped and never replayed.
+//   - Servers only forward events that originated locally and only accept
+//     events carrying the ID of the peer that sent them, so peers must be
+//     connected as a full mesh for everyone to see everything. There is no
+//     relaying.
+//   - Member lists (/who) only show local users, and ordering is only
+//     preserved per peer link, not across servers.
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BRIDGE_QUEUE_SIZE  = 256
+	BRIDGE_MAX_BACKOFF = 30 * time.Second
+	BRIDGE_NONCE_SIZE  = 16
+	// BRIDGE_EXPORTER labels the TLS keying material handshake proofs are
+	// bound to.
+	BRIDGE_EXPORTER = "EXPORTER-gochat-bridge"
+)
+
+// bridgeEvent is the wire format between peers.
+type bridgeEvent struct {
+	Origin string `json:"origin"`
+	Room   string `json:"room"`
+	Kind   string `json:"kind"`
+	From   string `json:"from"`
+	Body   string `json:"body,omitempty"`
+}
+
+type peer struct {
+	id     string
+	conn   net.Conn
+	events chan bridgeEvent
+}
+
+type bridgeConfig struct {
+	serverID string
+	secret   string
+	rooms    map[string]bool
+	tls      *tls.Config
+}
+
+var (
+	bridge      *bridgeConfig
+	peers       = make(map[*peer]bool)
+	peersMutex  = &sync.Mutex{}
+	bridgeKinds = map[string]bool{MESSAGE_CHAT: true, MESSAGE_JOIN: true, MESSAGE_LEAVE: true, MESSAGE_NICK: true}
+)
+
+// setupBridge validates the bridge flags and creates the federated rooms
+// so local users can join them before any peer connects.
+func setupBridge(serverID, secret, roomList, caFile string) error {
+	if secret == "" {
+		return errors.New("bridge mode needs -bridge-secret or GOCHAT_BRIDGE_SECRET")
+	}
+	if strings.ContainsAny(secret, " \t\r\n") {
+		return errors.New("bridge secret must not contain whitespace")
+	}
+	if serverID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		serverID = hostname
+	}
+	if !validName(serverID) || strings.Contains(serverID, "@") {
+		return fmt.Errorf("invalid server ID %q", serverID)
+	}
+
+	config := &tls.Config{InsecureSkipVerify: true}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config = &tls.Config{RootCAs: pool}
+	} else {
+		log.Println("Bridge: peer certificates are not verified, so peers are only known by the secret; use -bridge-cafile to verify them")
+	}
+
+	bridge = &bridgeConfig{serverID: serverID, secret: secret, rooms: make(map[string]bool), tls: config}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, room := range strings.Split(roomList, ",") {
+		room = strings.TrimSpace(room)
+		if room == "" {
+			continue
+		}
+		if !validName(room) {
+			return fmt.Errorf("invalid federated room name %q", room)
+		}
+		bridge.rooms[room] = true
+		if _, exists := rooms[room]; !exists {
+			rooms[room] = []*Client{}
+		}
+	}
+	if len(bridge.rooms) == 0 {
+		log.Println("Bridge: no rooms federated; use -federate to share rooms")
+	}
+	log.Printf("Bridge: server ID %s", serverID)
+	return nil
+}
+
+// federated reports whether room is shared with peers.
+func federated(room string) bool {
+	return bridge != nil && bridge.rooms[room]
+}
+
+// forwardToPeers queues a locally originated room event for every peer.
+// It never blocks; a peer whose queue is full misses the event.
+func forwardToPeers(message Message) {
+	if message.origin != "" || !federated(message.room) || !bridgeKinds[message.kind] {
+		return
+	}
+	event := bridgeEvent{Origin: bridge.serverID, Room: message.room, Kind: message.kind, From: message.from, Body: message.body}
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	for p := range peers {
+		select {
+		case p.events <- event:
+		default:
+			log.Printf("Bridge: queue to peer %s is full, dropping event", p.id)
+		}
+	}
+}
+
+// acceptPeers serves inbound peer connections.
+func acceptPeers(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("Error: ", err)
+			continue
+		}
+		go func() {
+			id, err := bridge.handshake(conn, false)
+			if err != nil {
+				log.Printf("Bridge: rejected peer %v: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			runPeer(id, conn)
+		}()
+	}
+}
+
+// dialPeer keeps an outbound link to addr open, reconnecting with
+// exponential backoff.
+func dialPeer(addr string) {
+	backoff := time.Second
+	for {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		conn, err := tls.DialWithDialer(dialer, CONN_TYPE, addr, bridge.tls)
+		if err == nil {
+			var id string
+			if id, err = bridge.handshake(conn, true); err == nil {
+				backoff = time.Second
+				runPeer(id, conn)
+				log.Printf("Bridge: link to %s (%s) closed, reconnecting", id, addr)
+				continue
+			}
+			conn.Close()
+		}
+		log.Printf("Bridge: cannot reach peer %s: %v (retrying in %v)", addr, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, BRIDGE_MAX_BACKOFF)
+	}
+}
+
+// The bridge handshake proves that both sides know the secret without
+// sending it:
+//
+//	dialing side:   BRIDGE <id> <nonce>
+//	accepting side: BRIDGE <id> <nonce> <proof>
+//	dialing side:   PROOF <proof>
+//	accepting side: OK
+//
+// A proof is the HMAC-SHA256, keyed with the secret, of the side's role,
+// both IDs and nonces, and keying material exported from the TLS session.
+// Someone in the middle has a different TLS session with each side, so the
+// proofs it passes along do not match, even when peer certificates are
+// not verified.
+
+// handshake runs the bridge handshake on a new peer link and returns the
+// peer's server ID. The dialing side speaks first.
+func (b *bridgeConfig) handshake(conn net.Conn, dialing bool) (string, error) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	binding, err := tlsBinding(conn)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, BRIDGE_NONCE_SIZE)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ours := hex.EncodeToString(nonce)
+	reader := bufio.NewReader(conn)
+	expect := func(word string, fields int) ([]string, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Fields(line)
+		if len(parts) != fields || parts[0] != word {
+			return nil, fmt.Errorf("bad handshake")
+		}
+		return parts, nil
+	}
+	send := func(format string, args ...any) error {
+		_, err := fmt.Fprintf(conn, format+"\n", args...)
+		return err
+	}
+
+	if dialing {
+		if err := send("BRIDGE %s %s", b.serverID, ours); err != nil {
+			return "", err
+		}
+		parts, err := expect("BRIDGE", 4)
+		if err != nil {
+			return "", err
+		}
+		id, theirs := parts[1], parts[2]
+		if err := b.checkPeerID(id); err != nil {
+			return "", err
+		}
+		if !b.checkProof(parts[3], "accepting", b.serverID, ours, id, theirs, binding) {
+			return "", fmt.Errorf("wrong secret from %s", id)
+		}
+		if err := send("PROOF %s", b.proof("dialing", b.serverID, ours, id, theirs, binding)); err != nil {
+			return "", err
+		}
+		if _, err := expect("OK", 1); err != nil {
+			return "", fmt.Errorf("%s refused our proof: %w", id, err)
+		}
+		return id, nil
+	}
+
+	parts, err := expect("BRIDGE", 3)
+	if err != nil {
+		return "", err
+	}
+	id, theirs := parts[1], parts[2]
+	if err := b.checkPeerID(id); err != nil {
+		return "", err
+	}
+	if err := send("BRIDGE %s %s %s", b.serverID, ours, b.proof("accepting", id, theirs, b.serverID, ours, binding)); err != nil {
+		return "", err
+	}
+	if parts, err = expect("PROOF", 2); err != nil {
+		return "", err
+	}
+	if !b.checkProof(parts[1], "dialing", id, theirs, b.serverID, ours, binding) {
+		return "", fmt.Errorf("wrong secret from %s", id)
+	}
+	if err := send("OK"); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkPeerID returns why id cannot be a peer's server ID, if it cannot.
+func (b *bridgeConfig) checkPeerID(id string) error {
+	if id == b.serverID {
+		return fmt.Errorf("peer uses our own server ID %s", id)
+	}
+	if !validName(id) || strings.Contains(id, "@") {
+		return fmt.Errorf("invalid peer server ID %q", id)
+	}
+	return nil
+}
+
+// proof is the handshake proof of the side in role, given the dialing and
+// accepting sides' IDs and nonces.
+func (b *bridgeConfig) proof(role, dialID, dialNonce, acceptID, acceptNonce string, binding []byte) string {
+	mac := hmac.New(sha256.New, []byte(b.secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n", role, dialID, dialNonce, acceptID, acceptNonce)
+	mac.Write(binding)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *bridgeConfig) checkProof(got, role, dialID, dialNonce, acceptID, acceptNonce string, binding []byte) bool {
+	return hmac.Equal([]byte(got), []byte(b.proof(role, dialID, dialNonce, acceptID, acceptNonce, binding)))
+}
+
+// tlsBinding completes the TLS handshake of a peer link and returns the
+// keying material that handshake proofs are bound to.
+func tlsBinding(conn net.Conn) ([]byte, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, errors.New("peer links must use TLS")
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return state.ExportKeyingMaterial(BRIDGE_EXPORTER, nil, sha256.Size)
+}
+
+// runPeer pumps events in both directions until the link fails.
+func runPeer(id string, conn net.Conn) {
+	p := &peer{id: id, conn: conn, events: make(chan bridgeEvent, BRIDGE_QUEUE_SIZE)}
+	peersMutex.Lock()
+	peers[p] = true
+	peersMutex.Unlock()
+	log.Printf("Bridge: linked with peer %s (%v)", id, conn.RemoteAddr())
+
+	done := make(chan struct{})
+	go func() {
+		encoder := json.NewEncoder(conn)
+		for {
+			select {
+			case event := <-p.events:
+				if err := encoder.Encode(event); err != nil {
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, MAX_LINE_LENGTH), 4*MAX_LINE_LENGTH)
+	for scanner.Scan() {
+		var event bridgeEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Printf("Bridge: bad event from %s: %v", id, err)
+			continue
+		}
+		// Peers do not relay, so a peer may only speak for itself.
+		if event.Origin != id {
+			log.Printf("Bridge: dropping event from peer %s that claims to come from %q", id, event.Origin)
+			continue
+		}
+		if !federated(event.Room) || !validUsername(event.From) {
+			continue
+		}
+		if message, ok := remoteMessage(event); ok {
+			broadcast <- message
+		}
+	}
+
+	close(done)
+	conn.Close()
+	peersMutex.Lock()
+	delete(peers, p)
+	peersMutex.Unlock()
+}
+
+// remoteMessage turns a peer's event into a room message for local clients.
+func remoteMessage(event bridgeEvent) (Message, bool) {
+	from := event.From + "@" + event.Origin
+	var message Message
+	switch event.Kind {
+	case MESSAGE_CHAT:
+		message = chatMessage(event.Room, from, event.Body)
+	case MESSAGE_JOIN:
+		message = joinNotice(event.Room, from, false)
+	case MESSAGE_LEAVE:
+		message = leaveNotice(event.Room, from, event.Body)
+	case MESSAGE_NICK:
+		// The new name is shown like From, so it must be as valid.
+		if !validUsername(event.Body) {
+			return Message{}, false
+		}
+		renamed := event.Body + "@" + event.Origin
+		message = localMessage(event.Room, MESSAGE_NICK, "notice.renamed", event.Room, from, renamed)
+		message.from, message.body = from, renamed
+	default:
+		return Message{}, false
+	}
+	message.origin = event.Origin
+	return message, true
+}
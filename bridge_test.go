@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerEventsMustComeFromThePeer(t *testing.T) {
+	room := TEST_FEDERATED
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	c.send("/join " + room)
+	c.expectLine("Joined room " + room)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go runPeer("beta", local)
+	encoder := json.NewEncoder(remote)
+	for _, event := range []bridgeEvent{
+		{Origin: "gamma", Room: room, Kind: MESSAGE_CHAT, From: "mallory", Body: "forged"},
+		{Origin: "alpha", Room: room, Kind: MESSAGE_CHAT, From: "mallory", Body: "ours"},
+		{Origin: "beta", Room: room, Kind: MESSAGE_CHAT, From: "bob", Body: "genuine"},
+	} {
+		if err := encoder.Encode(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Events from a link are handled in order, so anything forged would
+	// arrive before the genuine one.
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for the genuine event: %v", err)
+		}
+		if strings.Contains(line, "forged") || strings.Contains(line, "ours") {
+			t.Errorf("event with a false origin was delivered: %q", line)
+		}
+		if strings.Contains(line, "bob@beta: genuine") {
+			break
+		}
+	}
+}
+
+// tlsPipe returns the two ends of a TLS connection over loopback, the
+// accepting end first.
+func tlsPipe(t *testing.T, cert tls.Certificate) (*tls.Conn, *tls.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	d, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close(); d.Close() })
+	return tls.Server(a, &tls.Config{Certificates: []tls.Certificate{cert}}), tls.Client(d, &tls.Config{InsecureSkipVerify: true})
+}
+
+type handshakeResult struct {
+	id  string
+	err error
+}
+
+// runHandshakes runs the handshake of dialing on one end of a link and
+// of accepting on the other.
+func runHandshakes(dialing, accepting *bridgeConfig, dialConn, acceptConn net.Conn) (handshakeResult, handshakeResult) {
+	results := make(chan handshakeResult, 1)
+	go func() {
+		id, err := accepting.handshake(acceptConn, false)
+		acceptConn.Close()
+		results <- handshakeResult{id, err}
+	}()
+	id, err := dialing.handshake(dialConn, true)
+	dialConn.Close()
+	return handshakeResult{id, err}, <-results
+}
+
+func TestBridgeHandshake(t *testing.T) {
+	cert, _ := testCertificate(t)
+	alpha := &bridgeConfig{serverID: "alpha", secret: "s3cret"}
+	tests := []struct {
+		name   string
+		peer   *bridgeConfig
+		linked bool
+	}{
+		{"same secret", &bridgeConfig{serverID: "beta", secret: "s3cret"}, true},
+		{"wrong secret", &bridgeConfig{serverID: "beta", secret: "guess"}, false},
+		{"same server ID", &bridgeConfig{serverID: "alpha", secret: "s3cret"}, false},
+		{"bad server ID", &bridgeConfig{serverID: "beta@gamma", secret: "s3cret"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accept, dial := tlsPipe(t, cert)
+			dialed, accepted := runHandshakes(alpha, test.peer, dial, accept)
+			if !test.linked {
+				if dialed.err == nil || accepted.err == nil {
+					t.Errorf("handshake succeeded: dialing side %v, accepting side %v", dialed.err, accepted.err)
+				}
+				return
+			}
+			if dialed.err != nil || accepted.err != nil {
+				t.Fatalf("handshake failed: dialing side %v, accepting side %v", dialed.err, accepted.err)
+			}
+			if dialed.id != "beta" || accepted.id != "alpha" {
+				t.Errorf("peers are %q and %q, want beta and alpha", dialed.id, accepted.id)
+			}
+		})
+	}
+}
+
+// recorder keeps what passes through it.
+type recorder struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.b.Write(p)
+}
+
+func TestBridgeHandshakeResistsRelaying(t *testing.T) {
+	cert, _ := testCertificate(t)
+	alpha := &bridgeConfig{serverID: "alpha", secret: "s3cret"}
+	beta := &bridgeConfig{serverID: "beta", secret: "s3cret"}
+	// Someone in the middle ends alpha's TLS session and starts its own
+	// with beta, passing the plaintext along both ways.
+	middleAccept, dial := tlsPipe(t, cert)
+	accept, middleDial := tlsPipe(t, cert)
+	var seen recorder
+	relay := func(to, from *tls.Conn) {
+		io.Copy(io.MultiWriter(to, &seen), from)
+		to.Close()
+	}
+	go relay(middleDial, middleAccept)
+	go relay(middleAccept, middleDial)
+
+	dialed, accepted := runHandshakes(alpha, beta, dial, accept)
+	if dialed.err == nil || accepted.err == nil {
+		t.Errorf("relayed handshake succeeded: dialing side %v, accepting side %v", dialed.err, accepted.err)
+	}
+	seen.mu.Lock()
+	defer seen.mu.Unlock()
+	if bytes.Contains(seen.b.Bytes(), []byte("s3cret")) {
+		t.Errorf("the secret was sent: %q", seen.b.String())
+	}
+}
+
+func TestRemoteRenameIsTranslated(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	c.send("/lang ru")
+	c.expectLine("ru")
+	c.send("/join " + TEST_FEDERATED)
+	c.expectLine(TEST_FEDERATED)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go runPeer("beta", local)
+	name := uniqueName("bob")
+	json.NewEncoder(remote).Encode(bridgeEvent{Origin: "beta", Room: TEST_FEDERATED, Kind: MESSAGE_NICK, From: name, Body: name + "-2"})
+	want := translate("ru", "notice.renamed", TEST_FEDERATED, name+"@beta", name+"-2@beta")
+	c.expectLine(want)
+}
+
+func TestRemoteRenameMustBeValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"bob-2", true},
+		{"", false},
+		{"two words", false},
+		{"[PM from alice]", false},
+		{"bob\n[federated] forged", false},
+		{"bob\x1b[2J", false},
+	}
+	for _, tt := range tests {
+		event := bridgeEvent{Origin: "beta", Room: TEST_FEDERATED, Kind: MESSAGE_NICK, From: "bob", Body: tt.name}
+		if _, ok := remoteMessage(event); ok != tt.valid {
+			t.Errorf("rename to %q accepted: %v, want %v", tt.name, ok, tt.valid)
+		}
+	}
+}
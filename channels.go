@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Every JSON event carries the channel it belongs to. Clients pick the
+// channels they get with the subscribe and unsubscribe requests, for all
+// rooms or for one. Replies to a client's own requests are always sent,
+// so the system and error channels only hold back events the server sends
+// unasked.
+const (
+	CHANNEL_CHAT     = "chat"
+	CHANNEL_NOTICE   = "notice"
+	CHANNEL_PRESENCE = "presence"
+	CHANNEL_SYSTEM   = "system"
+	CHANNEL_ERROR    = "error"
+	CHANNEL_PM       = "pm"
+)
+
+var allChannels = []string{CHANNEL_CHAT, CHANNEL_NOTICE, CHANNEL_PRESENCE, CHANNEL_SYSTEM, CHANNEL_ERROR, CHANNEL_PM}
+
+// messageChannel returns the channel of a room event or private message.
+func messageChannel(message Message) string {
+	switch message.kind {
+	case MESSAGE_CHAT:
+		if message.room == "" {
+			return CHANNEL_PM
+		}
+		return CHANNEL_CHAT
+	case MESSAGE_JOIN, MESSAGE_LEAVE, MESSAGE_NICK:
+		return CHANNEL_PRESENCE
+	}
+	return CHANNEL_NOTICE
+}
+
+// eventChannel returns the channel of a JSON event that is not a room
+// event or private message.
+func eventChannel(event jsonEvent) string {
+	switch event.Type {
+	case "error":
+		return CHANNEL_ERROR
+	case "encrypted_pm":
+		return CHANNEL_PM
+	}
+	return CHANNEL_SYSTEM
+}
+
+// subscribed reports whether c gets channel for room; room is "" for
+// private messages and other events outside rooms. The caller must hold
+// mutex.
+func (c *Client) subscribed(room, channel string) bool {
+	set, ok := c.channels[room]
+	if !ok {
+		set, ok = c.channels[""]
+	}
+	return !ok || set[channel]
+}
+
+// subscribe handles the subscribe and unsubscribe requests: subscribe sets
+// the channels the client gets, in room or by default, and unsubscribe
+// takes channels away. It returns the channels left.
+func subscribe(client *Client, room string, channels []string, add bool) ([]string, error) {
+	for _, channel := range channels {
+		if !slices.Contains(allChannels, channel) {
+			return nil, localErrorf("json.unknown_channel", channel, strings.Join(allChannels, ", "))
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	set := make(map[string]bool)
+	if add {
+		for _, channel := range channels {
+			set[channel] = true
+		}
+	} else {
+		for _, channel := range allChannels {
+			if client.subscribed(room, channel) && !slices.Contains(channels, channel) {
+				set[channel] = true
+			}
+		}
+	}
+	if client.channels == nil {
+		client.channels = make(map[string]map[string]bool)
+	}
+	client.channels[room] = set
+	var left []string
+	for _, channel := range allChannels {
+		if set[channel] {
+			left = append(left, channel)
+		}
+	}
+	return left, nil
+}
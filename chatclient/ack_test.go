@@ -0,0 +1,128 @@
+package chatclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"final_project/codes"
+)
+
+// jsonStub connects a Client in JSON mode to a stub, past the handshake.
+func jsonStub(t *testing.T) (*Client, *stub) {
+	t.Helper()
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	return client, server
+}
+
+// outstanding returns how many requests client still waits on and how many
+// chat texts it keeps for echoes.
+func outstanding(client *Client) (pending, echoes int) {
+	client.pendingMu.Lock()
+	defer client.pendingMu.Unlock()
+	return len(client.pending), len(client.echoes)
+}
+
+// result is what a SendWait call returned.
+type result struct {
+	msg Message
+	err error
+}
+
+func sendWait(client *Client, line string) <-chan result {
+	done := make(chan result, 1)
+	go func() {
+		msg, err := client.SendWait(line, LINE_TIMEOUT)
+		done <- result{msg, err}
+	}()
+	return done
+}
+
+func waitResult(t *testing.T, done <-chan result) result {
+	t.Helper()
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(LINE_TIMEOUT):
+		t.Fatal("SendWait did not return")
+	}
+	return result{}
+}
+
+func TestSendWaitAck(t *testing.T) {
+	client, server := jsonStub(t)
+	done := sendWait(client, "hello")
+	server.expect(`{"id":"c1","text":"hello","type":"chat"}`)
+	server.send(`{"type":"ack","id":"c1","room":"general","seq":7,"from":"alice","recipients":3}`)
+
+	r := waitResult(t, done)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.msg.Type != "ack" || r.msg.Room != "general" || r.msg.Seq != 7 || r.msg.Recipients != 3 {
+		t.Errorf("SendWait returned %+v, want the ack for general #7 with 3 recipients", r.msg)
+	}
+	echo := next(t, client)
+	if !echo.Echo || echo.Type != "chat" || echo.Text != "hello" || echo.Seq != 7 || echo.User != "alice" {
+		t.Errorf("got %+v, want the echo of hello", echo)
+	}
+	if pending, echoes := outstanding(client); pending != 0 || echoes != 0 {
+		t.Errorf("%d requests and %d echoes left after the ack", pending, echoes)
+	}
+}
+
+func TestSendWaitRejected(t *testing.T) {
+	client, server := jsonStub(t)
+	done := sendWait(client, "hello")
+	server.expect(`{"id":"c1","text":"hello","type":"chat"}`)
+	server.send(`{"type":"error","id":"c1","code":"ERR_RATE_LIMITED","text":"slow down"}`)
+
+	r := waitResult(t, done)
+	var serverErr *ServerError
+	if !errors.As(r.err, &serverErr) || serverErr.Code != codes.ERR_RATE_LIMITED {
+		t.Fatalf("SendWait returned %v, want a ServerError with ERR_RATE_LIMITED", r.err)
+	}
+	if pending, echoes := outstanding(client); pending != 0 || echoes != 0 {
+		t.Errorf("%d requests and %d echoes left after the error", pending, echoes)
+	}
+}
+
+func TestUnsentChatIsForgotten(t *testing.T) {
+	client, _ := jsonStub(t)
+	client.Close()
+	if err := client.Send("hello"); err == nil {
+		t.Fatal("Send on a closed client succeeded")
+	}
+	if _, err := client.SendWait("hello", LINE_TIMEOUT); err == nil {
+		t.Fatal("SendWait on a closed client succeeded")
+	}
+	if pending, echoes := outstanding(client); pending != 0 || echoes != 0 {
+		t.Errorf("%d requests and %d echoes kept for messages never sent", pending, echoes)
+	}
+}
+
+func TestHangupDropsRequests(t *testing.T) {
+	client, server := jsonStub(t)
+	for range 3 {
+		if err := client.Send("hello"); err != nil {
+			t.Fatal(err)
+		}
+		server.request()
+	}
+	done := sendWait(client, "waiting")
+	server.request()
+
+	server.conn.Close()
+	if r := waitResult(t, done); !errors.Is(r.err, ErrClosed) {
+		t.Errorf("SendWait returned %v, want ErrClosed", r.err)
+	}
+	<-client.Done()
+	if pending, echoes := outstanding(client); pending != 0 || echoes != 0 {
+		t.Errorf("%d requests and %d echoes left after the server hung up", pending, echoes)
+	}
+	if err := client.Send("late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after the hangup returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,947 @@
+package chatclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"final_project/framing"
+)
+
+// Config controls how Dial connects to the chat server.
+type Config struct {
+	// TLS is used for the connection when non-nil, otherwise plain TCP is used.
+	TLS *tls.Config
+	// Username is sent with /nick right after connecting when non-empty.
+	Username string
+	// Proxy, when set, is a socks5:// or http:// URL the connection is
+	// made through before the TLS handshake.
+	Proxy *url.URL
+	// Timeout bounds connecting, including the proxy and TLS handshakes.
+	// Zero means DEFAULT_TIMEOUT.
+	Timeout time.Duration
+	// JSON switches the connection to the server's structured protocol.
+	// Messages then carry sequence numbers and missed events are reported
+	// as gaps.
+	JSON bool
+	// Framing asks the server for length-prefixed frames instead of
+	// newline-delimited lines. Connecting fails if it does not offer them.
+	Framing bool
+	// Bot identifies the connection as a bot's. The server then skips the
+	// directory and room greetings and tags its messages as a bot's.
+	Bot bool
+	// Heartbeat, when set, makes the client ping the server after
+	// receiving nothing for that long. If nothing arrives within
+	// HeartbeatGrace (zero means DEFAULT_HEARTBEAT_GRACE) the connection
+	// is closed and Err returns an ErrStalled.
+	Heartbeat      time.Duration
+	HeartbeatGrace time.Duration
+}
+
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// HELLO is sent first on every connection to name this library and the
+// protocol features it understands. With commands the server lists its
+// commands in the WELCOME; see Commands. JSON mode adds the directory and
+// no-self-echo features: the server then leaves out this client's own chat
+// messages and the library makes them from the acks instead.
+const HELLO = "HELLO chatclient 1 features=json,ping,history,e2e,commands"
+
+// Message is a single line received from the server. All fields after PM
+// are only set in JSON mode.
+type Message struct {
+	Raw    string `json:"raw"`
+	Type   string `json:"type,omitempty"`
+	Room   string `json:"room,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+	Time   string `json:"time,omitempty"`
+	User   string `json:"user,omitempty"`
+	Text   string `json:"text"`
+	Notice bool   `json:"notice,omitempty"`
+	PM     bool   `json:"pm,omitempty"`
+	// Code is set, in both modes, on the server's rejection of a request;
+	// see package codes. Text is then the explanation without the code.
+	Code    string `json:"code,omitempty"`
+	LastSeq uint64 `json:"last_seq,omitempty"`
+	Replay  bool   `json:"replay,omitempty"`
+	Gap     *Gap   `json:"gap,omitempty"`
+	// ID and Recipients are set on replies to requests made with an ID,
+	// such as the "ack" for SendWait. Request names the request a reply
+	// answers.
+	ID         string `json:"id,omitempty"`
+	Request    string `json:"request,omitempty"`
+	Recipients int    `json:"recipients,omitempty"`
+	// Members is the room's member list in a "joined" reply.
+	Members []string `json:"members,omitempty"`
+	// Key is a user's public key in a "key" reply. Encrypted marks a
+	// private message that was end-to-end encrypted.
+	Key       string `json:"key,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// Rooms is the room directory in a "directory" message, which JSON
+	// mode clients get on connect and in reply to List.
+	Rooms []DirectoryEntry `json:"rooms,omitempty"`
+	// Cursor is set on a directory message that does not hold every room;
+	// see ListMore.
+	Cursor string `json:"cursor,omitempty"`
+	// Channel is the channel the event belongs to: chat, notice,
+	// presence, system, error or pm. See Subscribe. Channels are the
+	// channels left in the reply to Subscribe and Unsubscribe.
+	Channel  string   `json:"channel,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	// Bot marks a chat message from a bot. JSON mode only.
+	Bot bool `json:"bot,omitempty"`
+	// Messages are the chat messages of an "export" message; see Export.
+	Messages []Message `json:"messages,omitempty"`
+	// Echo marks a chat message of this client's own, made by the library
+	// when the server acknowledged it. It takes the place of the copy the
+	// server would have sent, with the same room and sequence number.
+	Echo bool `json:"echo,omitempty"`
+}
+
+// DirectoryEntry is a room in the server's directory, most active rooms
+// first. Invite-only rooms are only listed when the client may join them.
+// LastMessage is zero for rooms nobody has written in. Tags are set by the
+// room's owner.
+type DirectoryEntry struct {
+	Name            string    `json:"name"`
+	Members         int       `json:"members"`
+	InviteOnly      bool      `json:"invite_only"`
+	MessagesPerHour int       `json:"messages_per_hour"`
+	LastMessage     time.Time `json:"last_message"`
+	Tags            []string  `json:"tags"`
+}
+
+// Gap reports room events from FromSeq to ToSeq (inclusive) that were
+// missed. Messages of type "gap" carry one, either because the server said
+// so during a backfill or because a sequence number was skipped.
+type Gap struct {
+	FromSeq uint64 `json:"from_seq"`
+	ToSeq   uint64 `json:"to_seq"`
+}
+
+// Client is a connection to the chat server.
+type Client struct {
+	conn      net.Conn
+	messages  chan Message
+	done      chan struct{}
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	err       error
+	json      bool
+	// lastSeq is the newest sequence number seen per room, used by the
+	// read loop only.
+	lastSeq map[string]uint64
+	// pending maps the IDs of SendWait requests to their waiters. echoes
+	// holds the text of chat messages sent in JSON mode until they are
+	// acknowledged, the request fails to send or the connection ends. Both
+	// are emptied when the read loop stops, after which closed is set.
+	pendingMu sync.Mutex
+	pending   map[string]chan Message
+	echoes    map[string]string
+	nextID    int
+	closed    bool
+	// members is the member set of the joined room, kept up to date from
+	// presence events.
+	membersMu sync.Mutex
+	members   map[string]map[string]bool
+	// The key pair of EnableEncryption.
+	keyMu      sync.Mutex
+	publicKey  *[32]byte
+	privateKey *[32]byte
+	// reader is the connection's input. With framing, frames reads from
+	// it and frameWriter writes to the connection; early holds the lines
+	// that came before the switch.
+	reader      *bufio.Reader
+	frames      *framing.Reader
+	frameWriter *framing.Writer
+	early       []string
+	// maxLength and maxName are from the banner, commands from the
+	// WELCOME.
+	maxLength atomic.Int64
+	maxName   atomic.Int64
+	commands  atomic.Pointer[[]string]
+	// filtered is set once a subscription leaves out channels; skipped
+	// events then look like gaps, so none are reported.
+	filtered atomic.Bool
+	// lastRead is when the server last sent something, in Unix
+	// nanoseconds. probes counts the heartbeat pings of a text mode
+	// connection still waiting for their PONG, and stall is why the
+	// heartbeat closed the connection.
+	lastRead atomic.Int64
+	probes   atomic.Int32
+	stall    atomic.Value
+}
+
+var ErrClosed = errors.New("chatclient: connection closed")
+
+// Dial connects to the server at addr.
+func Dial(addr string, cfg Config) (*Client, error) {
+	return DialContext(context.Background(), addr, cfg)
+}
+
+// DialContext connects to the server at addr, giving up when ctx is done or
+// cfg.Timeout elapses. An addr of the form unix:///path connects to a Unix
+// socket; the proxy does not apply there.
+func DialContext(ctx context.Context, addr string, cfg Config) (*Client, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		conn, err = newDialer().DialContext(ctx, "unix", path)
+	} else if cfg.Proxy != nil {
+		conn, err = dialProxy(ctx, cfg.Proxy, addr)
+	} else {
+		conn, err = newDialer().DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLS != nil {
+		tlsConfig := cfg.TLS.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			// The record header holds the first five bytes the server
+			// sent, which a plaintext listener's banner starts with.
+			var header tls.RecordHeaderError
+			if errors.As(err, &header) && strings.HasPrefix(string(header.RecordHeader[:]), "GOCHA") {
+				return nil, fmt.Errorf("chatclient: %s answered without TLS; it is a plaintext listener", addr)
+			}
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return New(conn, cfg)
+}
+
+// New wraps an already established connection.
+func New(conn net.Conn, cfg Config) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		messages: make(chan Message),
+		done:     make(chan struct{}),
+		json:     cfg.JSON,
+		lastSeq:  make(map[string]uint64),
+		pending:  make(map[string]chan Message),
+		echoes:   make(map[string]string),
+		members:  make(map[string]map[string]bool),
+	}
+	c.reader = bufio.NewReader(conn)
+	hello := HELLO
+	if c.json {
+		hello += ",directory,no-self-echo"
+	}
+	if cfg.Framing {
+		hello += ",framing"
+	}
+	if cfg.Bot {
+		hello += " bot"
+	}
+	if err := c.write(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cfg.Framing {
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = DEFAULT_TIMEOUT
+		}
+		if err := c.startFraming(timeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.json {
+		if err := c.write("/json"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.lastRead.Store(time.Now().UnixNano())
+	go c.readLoop()
+	if cfg.Heartbeat > 0 {
+		grace := cfg.HeartbeatGrace
+		if grace == 0 {
+			grace = DEFAULT_HEARTBEAT_GRACE
+		}
+		go c.heartbeat(cfg.Heartbeat, grace)
+	}
+	if cfg.Username != "" {
+		if err := c.Nick(cfg.Username); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Messages returns the channel of incoming messages. It is closed when the
+// connection ends; Err then reports why.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Done returns a channel that is closed once the connection has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// ConnectionState returns the TLS state of the connection; ok is false for
+// plain connections.
+func (c *Client) ConnectionState() (state tls.ConnectionState, ok bool) {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
+// Err returns the error that ended the read loop, if any.
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Send writes a raw line to the server. Lines starting with "/" are
+// interpreted as commands by the server. In JSON mode the line is sent as
+// a chat message instead.
+func (c *Client) Send(line string) error {
+	if c.json {
+		return c.chat(line)
+	}
+	return c.write(line)
+}
+
+// SendBlock sends text, which may span several lines, to the current room
+// as one chat message. Without JSON mode or framing the line breaks are
+// escaped into a single /paste line.
+func (c *Client) SendBlock(text string) error {
+	if c.json {
+		return c.chat(text)
+	}
+	if c.frameWriter != nil {
+		return c.write(text)
+	}
+	escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "").Replace(text)
+	return c.write("/paste " + escaped)
+}
+
+// chat sends text as a chat message in JSON mode, with an ID so its ack
+// can be turned into an Echo message.
+func (c *Client) chat(text string) error {
+	c.pendingMu.Lock()
+	if c.closed {
+		c.pendingMu.Unlock()
+		return ErrClosed
+	}
+	id := c.newID()
+	c.echoes[id] = text
+	c.pendingMu.Unlock()
+	err := c.request(map[string]any{"type": "chat", "id": id, "text": text})
+	if err != nil {
+		c.forgetEcho(id)
+	}
+	return err
+}
+
+// forgetEcho drops the text kept for a chat message that will never be
+// acknowledged.
+func (c *Client) forgetEcho(id string) {
+	c.pendingMu.Lock()
+	delete(c.echoes, id)
+	c.pendingMu.Unlock()
+}
+
+// dropRequests forgets every request still waiting for a reply once no
+// more replies can arrive. Waiting SendWait calls return ErrClosed.
+func (c *Client) dropRequests() {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	clear(c.pending)
+	clear(c.echoes)
+	c.closed = true
+}
+
+// newID returns a fresh request ID. The caller must hold pendingMu.
+func (c *Client) newID() string {
+	c.nextID++
+	return fmt.Sprintf("c%d", c.nextID)
+}
+
+func (c *Client) write(line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.frameWriter != nil {
+		return c.frameWriter.WriteFrame([]byte(line))
+	}
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+// startFraming waits for the server's WELCOME to the HELLO and switches to
+// frames if the server agreed to them. Lines received before are kept for
+// the read loop.
+func (c *Client) startFraming(timeout time.Duration) error {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		features, ok := strings.CutPrefix(line, "WELCOME features=")
+		if !ok {
+			c.early = append(c.early, line)
+			continue
+		}
+		c.readWelcome(line)
+		features, _, _ = strings.Cut(features, " ")
+		if !slices.Contains(strings.Split(features, ","), "framing") {
+			return errors.New("chatclient: server does not support framing")
+		}
+		c.frames = framing.NewReader(c.reader, framing.DEFAULT_MAX_SIZE)
+		c.frameWriter = framing.NewWriter(c.conn, framing.DEFAULT_MAX_SIZE)
+		return nil
+	}
+}
+
+// SendWait sends line as a chat message and waits up to timeout for the
+// server to acknowledge it. The returned message has type "ack" with the
+// message's Room and Seq and the number of Recipients it was delivered to.
+// If the server rejects the message its error text is returned. The message
+// itself arrives on Messages as an Echo. JSON mode only.
+func (c *Client) SendWait(line string, timeout time.Duration) (Message, error) {
+	if !c.json {
+		return Message{}, errors.New("chatclient: acknowledgments need JSON mode")
+	}
+	return c.call(map[string]any{"type": "chat", "text": line}, timeout)
+}
+
+// echo makes the Echo message for the ack to a chat message sent by this
+// client. It reports false for other messages.
+func (c *Client) echo(ack Message) (Message, bool) {
+	c.pendingMu.Lock()
+	text, ok := c.echoes[ack.ID]
+	delete(c.echoes, ack.ID)
+	c.pendingMu.Unlock()
+	if !ok || ack.Type != "ack" {
+		return Message{}, false
+	}
+	raw, _ := json.Marshal(map[string]any{"type": "chat", "room": ack.Room, "seq": ack.Seq, "time": ack.Time, "from": ack.User, "text": text, "echo": true})
+	return Message{Raw: string(raw), Type: "chat", Room: ack.Room, Seq: ack.Seq, Time: ack.Time, User: ack.User, Text: text, Echo: true}, true
+}
+
+// call sends req with a fresh ID and waits up to timeout for the reply,
+// returning the server's error as a *ServerError. The text of a chat
+// request outlives a timeout, so that a late ack still makes its Echo.
+func (c *Client) call(req map[string]any, timeout time.Duration) (Message, error) {
+	reply := make(chan Message, 1)
+	c.pendingMu.Lock()
+	if c.closed {
+		c.pendingMu.Unlock()
+		return Message{}, ErrClosed
+	}
+	id := c.newID()
+	c.pending[id] = reply
+	if req["type"] == "chat" {
+		c.echoes[id] = req["text"].(string)
+	}
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	req["id"] = id
+	if err := c.request(req); err != nil {
+		c.forgetEcho(id)
+		return Message{}, err
+	}
+	select {
+	case msg := <-reply:
+		if msg.Type == "error" {
+			return msg, serverError(msg)
+		}
+		return msg, nil
+	case <-c.done:
+		return Message{}, ErrClosed
+	case <-time.After(timeout):
+		return Message{}, fmt.Errorf("chatclient: no reply within %v", timeout)
+	}
+}
+
+// request sends a structured request in JSON mode.
+func (c *Client) request(req map[string]any) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.write(string(data))
+}
+
+func (c *Client) Join(room string) error {
+	if c.json {
+		return c.request(map[string]any{"type": "join", "room": room})
+	}
+	return c.Send("/join " + room)
+}
+
+func (c *Client) Nick(name string) error {
+	if c.json {
+		return c.request(map[string]any{"type": "nick", "name": name})
+	}
+	return c.Send("/nick " + name)
+}
+
+// Msg sends a private message to another user.
+func (c *Client) Msg(username, text string) error {
+	if c.json {
+		return c.request(map[string]any{"type": "msg", "to": username, "text": text})
+	}
+	return c.Send("/msg " + username + " " + text)
+}
+
+// Backfill asks the server to replay the events of room starting at
+// fromSeq. Replayed messages are followed by a message of type "ok"; events
+// the server no longer has are reported as a gap first. JSON mode only.
+func (c *Client) Backfill(room string, fromSeq uint64) error {
+	if !c.json {
+		return errors.New("chatclient: backfill needs JSON mode")
+	}
+	return c.request(map[string]any{"type": "backfill", "room": room, "from_seq": fromSeq})
+}
+
+// List asks for the room directory, which arrives as a "directory"
+// message. JSON mode only.
+func (c *Client) List() error {
+	if !c.json {
+		return errors.New("chatclient: the directory needs JSON mode")
+	}
+	return c.request(map[string]any{"type": "list"})
+}
+
+// Export asks for the last count chat messages of the current room that
+// this client may read, at most 500; 0 asks for as many as allowed. They
+// arrive as one "export" message. The server allows one export a minute.
+// JSON mode only.
+func (c *Client) Export(count int) error {
+	if !c.json {
+		return errors.New("chatclient: Export needs JSON mode")
+	}
+	return c.request(map[string]any{"type": "export", "count": count})
+}
+
+// ListMore asks for the rooms after a directory message, passing its
+// Cursor. JSON mode only.
+func (c *Client) ListMore(cursor string) error {
+	if !c.json {
+		return errors.New("chatclient: the directory needs JSON mode")
+	}
+	return c.request(map[string]any{"type": "list", "cursor": cursor})
+}
+
+// allChannels are the channels the server sorts JSON events into.
+var allChannels = []string{"chat", "notice", "presence", "system", "error", "pm"}
+
+// Subscribe sets the channels the server sends this client in room, or in
+// every room without a setting of its own when room is "". It returns the
+// channels now subscribed. Without presence, Members goes stale. Replies
+// to this client's own requests always arrive. Once any channel is left
+// out, missed events are no longer reported as gaps. JSON mode only.
+func (c *Client) Subscribe(room string, channels ...string) ([]string, error) {
+	return c.subscribe("subscribe", room, channels)
+}
+
+// Unsubscribe stops the channels in room, or by default when room is "",
+// and returns the channels left. JSON mode only.
+func (c *Client) Unsubscribe(room string, channels ...string) ([]string, error) {
+	return c.subscribe("unsubscribe", room, channels)
+}
+
+func (c *Client) subscribe(kind, room string, channels []string) ([]string, error) {
+	if !c.json {
+		return nil, errors.New("chatclient: channels need JSON mode")
+	}
+	if channels == nil {
+		channels = []string{}
+	}
+	reply, err := c.call(map[string]any{"type": kind, "room": room, "channels": channels}, DEFAULT_TIMEOUT)
+	if err == nil && len(reply.Channels) < len(allChannels) {
+		c.filtered.Store(true)
+	}
+	return reply.Channels, err
+}
+
+// Quit asks the server to end the session, optionally with a parting
+// message shown to the room. The server closes the connection afterwards.
+func (c *Client) Quit(message string) error {
+	if c.json {
+		return c.request(map[string]any{"type": "quit", "text": message})
+	}
+	if message == "" {
+		return c.Send("/quit")
+	}
+	return c.Send("/quit " + message)
+}
+
+func (c *Client) Close() error {
+	err := ErrClosed
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	defer close(c.messages)
+	defer c.dropRequests()
+	for _, line := range c.early {
+		c.handleLine(line)
+	}
+	for {
+		var lines []string
+		if c.frames != nil {
+			payload, err := c.frames.ReadFrame()
+			if err != nil {
+				c.err = c.readError(err)
+				return
+			}
+			lines = strings.Split(string(payload), "\n")
+		} else {
+			line, err := c.reader.ReadString('\n')
+			if err != nil {
+				c.err = c.readError(err)
+				return
+			}
+			lines = []string{strings.TrimRight(line, "\r\n")}
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+		for _, line := range lines {
+			c.handleLine(line)
+		}
+	}
+}
+
+// readError returns why reading failed with err: the heartbeat's reason
+// if it closed the connection.
+func (c *Client) readError(err error) error {
+	if stall, ok := c.stall.Load().(error); ok {
+		return stall
+	}
+	return err
+}
+
+func (c *Client) handleLine(line string) {
+	if strings.HasPrefix(line, "GOCHAT/") {
+		c.readBanner(line)
+	}
+	if strings.HasPrefix(line, "WELCOME ") {
+		c.readWelcome(line)
+	}
+	if !c.json {
+		if c.probeReply(line) {
+			return
+		}
+		c.messages <- ParseLine(line)
+		return
+	}
+	msg := ParseEvent(line)
+	if msg.Type == "encrypted_pm" {
+		c.decrypt(&msg)
+	}
+	c.trackMembers(msg)
+	if msg.ID != "" {
+		// A SendWait caller gets the ack and Messages the echo.
+		echo, ok := c.echo(msg)
+		answered := c.answer(msg)
+		if ok {
+			msg = echo
+		} else if answered {
+			return
+		}
+	}
+	if gap := c.checkSeq(msg); gap != nil {
+		c.messages <- Message{Type: "gap", Room: msg.Room, Gap: gap}
+	}
+	c.messages <- msg
+}
+
+// readBanner notes the limits the server announces in its banner.
+func (c *Client) readBanner(line string) {
+	for _, field := range strings.Fields(line) {
+		key, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "max-length":
+			c.maxLength.Store(int64(n))
+		case "max-name":
+			c.maxName.Store(int64(n))
+		}
+	}
+}
+
+// readWelcome notes the commands listed in the WELCOME to the HELLO.
+func (c *Client) readWelcome(line string) {
+	for _, field := range strings.Fields(line) {
+		if list, ok := strings.CutPrefix(field, "commands="); ok {
+			commands := strings.Split(list, ",")
+			c.commands.Store(&commands)
+		}
+	}
+}
+
+// MaxLength returns the longest line the server accepts, or 0 if it did
+// not say.
+func (c *Client) MaxLength() int {
+	return int(c.maxLength.Load())
+}
+
+// MaxName returns the most characters a username or room name may have,
+// or 0 if the server did not say.
+func (c *Client) MaxName() int {
+	return int(c.maxName.Load())
+}
+
+// Commands returns the commands and aliases the server knows, sorted, or
+// nil if it did not list them.
+func (c *Client) Commands() []string {
+	if commands := c.commands.Load(); commands != nil {
+		return *commands
+	}
+	return nil
+}
+
+// answer hands a reply to the SendWait call waiting for it, reporting
+// whether there was one.
+func (c *Client) answer(msg Message) bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	reply, ok := c.pending[msg.ID]
+	if ok {
+		reply <- msg
+		delete(c.pending, msg.ID)
+	}
+	return ok
+}
+
+// Members returns the sorted users in room as of the last message received
+// from the server, or nil when the client is not in room. It is kept from
+// the join reply and the presence events that follow it. JSON mode only.
+func (c *Client) Members(room string) []string {
+	c.membersMu.Lock()
+	defer c.membersMu.Unlock()
+	set, ok := c.members[room]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// trackMembers applies msg to the member lists. The server sends a join
+// reply through the same queue as room events, so everything after it is
+// newer than its member list.
+func (c *Client) trackMembers(msg Message) {
+	if msg.Replay {
+		return
+	}
+	c.membersMu.Lock()
+	defer c.membersMu.Unlock()
+	switch msg.Type {
+	case "joined":
+		// Joining a room leaves the previous one.
+		set := make(map[string]bool, len(msg.Members))
+		for _, name := range msg.Members {
+			set[name] = true
+		}
+		c.members = map[string]map[string]bool{msg.Room: set}
+	case "ok":
+		if msg.Request == "leave" {
+			delete(c.members, msg.Room)
+		}
+	case "user_joined_room":
+		if set, ok := c.members[msg.Room]; ok {
+			set[msg.User] = true
+		}
+	case "user_left_room":
+		if set, ok := c.members[msg.Room]; ok {
+			delete(set, msg.User)
+		}
+	case "user_renamed":
+		if set, ok := c.members[msg.Room]; ok {
+			delete(set, msg.User)
+			set[msg.Text] = true
+		}
+	}
+}
+
+// checkSeq tracks the newest sequence number per room and returns the
+// missed range when msg skips ahead. Join replies reset the count for
+// their room, so events from before the join are not reported.
+func (c *Client) checkSeq(msg Message) *Gap {
+	if msg.Type == "joined" {
+		c.lastSeq[msg.Room] = msg.LastSeq
+		return nil
+	}
+	if msg.Seq == 0 || msg.Replay || msg.Type == "ack" {
+		return nil
+	}
+	last, seen := c.lastSeq[msg.Room]
+	if msg.Seq <= last {
+		return nil
+	}
+	c.lastSeq[msg.Room] = msg.Seq
+	if seen && msg.Seq > last+1 && !c.filtered.Load() {
+		return &Gap{FromSeq: last + 1, ToSeq: msg.Seq - 1}
+	}
+	return nil
+}
+
+// event is a line of the server's JSON protocol.
+type event struct {
+	Type       string            `json:"type"`
+	Code       string            `json:"code"`
+	ID         string            `json:"id"`
+	Request    string            `json:"request"`
+	Room       string            `json:"room"`
+	Seq        uint64            `json:"seq"`
+	Time       string            `json:"time"`
+	From       string            `json:"from"`
+	Name       string            `json:"name"`
+	Text       string            `json:"text"`
+	LastSeq    uint64            `json:"last_seq"`
+	FromSeq    uint64            `json:"from_seq"`
+	ToSeq      uint64            `json:"to_seq"`
+	Replay     bool              `json:"replay"`
+	Recipients int               `json:"recipients"`
+	Members    []string          `json:"members"`
+	Key        string            `json:"key"`
+	Rooms      []DirectoryEntry  `json:"rooms"`
+	Channel    string            `json:"channel"`
+	Channels   []string          `json:"channels"`
+	Cursor     string            `json:"cursor"`
+	Bot        bool              `json:"bot"`
+	Messages   []json.RawMessage `json:"messages"`
+}
+
+// ParseEvent converts a line of the server's JSON protocol into a Message.
+// Lines that are not valid JSON are returned with only Raw and Text set.
+func ParseEvent(line string) Message {
+	var e event
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		return Message{Raw: line, Text: line}
+	}
+	msg := Message{
+		Raw:        line,
+		Type:       e.Type,
+		Code:       e.Code,
+		Room:       e.Room,
+		Seq:        e.Seq,
+		Time:       e.Time,
+		User:       e.From,
+		Text:       e.Text,
+		LastSeq:    e.LastSeq,
+		Replay:     e.Replay,
+		ID:         e.ID,
+		Request:    e.Request,
+		Recipients: e.Recipients,
+		Members:    e.Members,
+		Key:        e.Key,
+		Rooms:      e.Rooms,
+		Channel:    e.Channel,
+		Channels:   e.Channels,
+		Cursor:     e.Cursor,
+		Bot:        e.Bot,
+	}
+	for _, raw := range e.Messages {
+		msg.Messages = append(msg.Messages, ParseEvent(string(raw)))
+	}
+	switch e.Type {
+	case "pm":
+		msg.PM = true
+	case "user_joined_room", "user_left_room", "user_renamed", "notice":
+		msg.Notice = true
+	case "gap":
+		msg.Gap = &Gap{FromSeq: e.FromSeq, ToSeq: e.ToSeq}
+	}
+	if msg.Text == "" {
+		msg.Text = e.Name
+	}
+	return msg
+}
+
+// ParseLine splits a server line of the form "[room] 3:04PM - user: text",
+// "[room] Notice: ...", "[PM from user] text" or "ERR_CODE: text" into its
+// parts. Lines that match none of them are returned with only Raw and Text
+// set.
+func ParseLine(line string) Message {
+	msg := Message{Raw: line, Text: line}
+	if code, text, ok := cutCode(line); ok {
+		msg.Code = code
+		msg.Text = text
+		return msg
+	}
+	if !strings.HasPrefix(line, "[") {
+		return msg
+	}
+	end := strings.Index(line, "] ")
+	if end < 0 {
+		return msg
+	}
+	room, rest := line[1:end], line[end+2:]
+	if sender, ok := strings.CutPrefix(room, "PM from "); ok {
+		msg.PM = true
+		msg.User = sender
+		msg.Text = rest
+		return msg
+	}
+	if strings.HasPrefix(rest, "Notice: ") {
+		msg.Room = room
+		msg.Notice = true
+		msg.Text = strings.TrimPrefix(rest, "Notice: ")
+		return msg
+	}
+	timestamp, rest, ok := strings.Cut(rest, " - ")
+	if !ok {
+		return msg
+	}
+	user, text, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return msg
+	}
+	msg.Room = room
+	msg.Time = timestamp
+	msg.User = user
+	msg.Text = text
+	return msg
+}
@@ -0,0 +1,306 @@
+package chatclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LINE_TIMEOUT is how long the tests wait for a line or a message.
+const LINE_TIMEOUT = 5 * time.Second
+
+// stub is the server end of a connection, driven by a test.
+type stub struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+	out   chan string
+}
+
+// serveStub reads what the client writes on conn into lines, and writes
+// what is queued on out, in order, until the connection ends.
+func serveStub(t *testing.T, conn net.Conn) *stub {
+	s := &stub{t: t, conn: conn, lines: make(chan string, 100), out: make(chan string, 100)}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		defer close(s.lines)
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			s.lines <- strings.TrimRight(line, "\n")
+		}
+	}()
+	go func() {
+		for line := range s.out {
+			if _, err := conn.Write([]byte(line + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// newStub connects a Client made with cfg to a stub over net.Pipe.
+func newStub(t *testing.T, cfg Config) (*Client, *stub) {
+	t.Helper()
+	server, conn := net.Pipe()
+	s := serveStub(t, server)
+	client, err := New(conn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, s
+}
+
+// expect fails the test unless the next line the client sent is want.
+func (s *stub) expect(want string) {
+	s.t.Helper()
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			s.t.Fatalf("waiting for %q: the client hung up", want)
+		}
+		if line != want {
+			s.t.Fatalf("client sent %q, want %q", line, want)
+		}
+	case <-time.After(LINE_TIMEOUT):
+		s.t.Fatalf("client did not send %q", want)
+	}
+}
+
+// send queues line for the client.
+func (s *stub) send(line string) {
+	s.out <- line
+}
+
+// next returns the next message the client passes on.
+func next(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-client.Messages():
+		if !ok {
+			t.Fatalf("messages closed: %v", client.Err())
+		}
+		return msg
+	case <-time.After(LINE_TIMEOUT):
+		t.Fatal("no message from the client")
+	}
+	return Message{}
+}
+
+func TestNewSendsHelloAndNick(t *testing.T) {
+	_, server := newStub(t, Config{Username: "bob"})
+	server.expect(HELLO)
+	server.expect("/nick bob")
+}
+
+func TestNewJSON(t *testing.T) {
+	_, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+}
+
+func TestDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan *stub, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- serveStub(t, conn)
+	}()
+
+	client, err := Dial(listener.Addr().String(), Config{Username: "bob", Timeout: LINE_TIMEOUT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	server.expect(HELLO)
+	server.expect("/nick bob")
+	server.send("Username set to bob")
+	if msg := next(t, client); msg.Raw != "Username set to bob" {
+		t.Errorf("got %q, want the server's reply", msg.Raw)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	if client, err := Dial(addr, Config{Timeout: LINE_TIMEOUT}); err == nil {
+		client.Close()
+		t.Fatal("Dial succeeded with nothing listening")
+	}
+}
+
+// TestDialTLSToPlaintext dials with TLS a listener that answers in
+// plaintext. A chat server's banner must be reported as a plaintext
+// listener; anything else is an ordinary handshake error.
+func TestDialTLSToPlaintext(t *testing.T) {
+	tests := []struct {
+		answer    string
+		plaintext bool
+	}{
+		{"GOCHAT/1 chat server\n", true},
+		{"HTTP/1.1 400 Bad Request\r\n\r\n", false},
+	}
+	for _, tt := range tests {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte(tt.answer))
+			io.Copy(io.Discard, conn)
+		}()
+		client, err := Dial(listener.Addr().String(), Config{TLS: &tls.Config{InsecureSkipVerify: true}, Timeout: LINE_TIMEOUT})
+		if err == nil {
+			client.Close()
+			t.Fatalf("TLS handshake with %q succeeded", tt.answer)
+		}
+		if got := strings.Contains(err.Error(), "plaintext listener"); got != tt.plaintext {
+			t.Errorf("answer %q: got %v", tt.answer, err)
+		}
+	}
+}
+
+func TestTextRequests(t *testing.T) {
+	client, server := newStub(t, Config{})
+	server.expect(HELLO)
+	tests := []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{"Send", func() error { return client.Send("hello") }, "hello"},
+		{"Send command", func() error { return client.Send("/who") }, "/who"},
+		{"Join", func() error { return client.Join("golang") }, "/join golang"},
+		{"Nick", func() error { return client.Nick("alice") }, "/nick alice"},
+		{"Msg", func() error { return client.Msg("bob", "hi there") }, "/msg bob hi there"},
+		{"SendBlock", func() error { return client.SendBlock("a\nb\\c") }, `/paste a\nb\\c`},
+		{"Quit", func() error { return client.Quit("bye") }, "/quit bye"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); err != nil {
+				t.Fatal(err)
+			}
+			server.expect(tt.want)
+		})
+	}
+}
+
+func TestJSONRequests(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	tests := []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{"Send", func() error { return client.Send("hello") }, `{"id":"c1","text":"hello","type":"chat"}`},
+		{"Join", func() error { return client.Join("golang") }, `{"room":"golang","type":"join"}`},
+		{"Nick", func() error { return client.Nick("alice") }, `{"name":"alice","type":"nick"}`},
+		{"Msg", func() error { return client.Msg("bob", "hi") }, `{"text":"hi","to":"bob","type":"msg"}`},
+		{"Backfill", func() error { return client.Backfill("golang", 7) }, `{"from_seq":7,"room":"golang","type":"backfill"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); err != nil {
+				t.Fatal(err)
+			}
+			server.expect(tt.want)
+		})
+	}
+}
+
+func TestMessagesCloseOnEOF(t *testing.T) {
+	client, server := newStub(t, Config{})
+	server.expect(HELLO)
+	server.send("Goodbye!")
+	if msg := next(t, client); msg.Raw != "Goodbye!" {
+		t.Fatalf("got %q, want the goodbye", msg.Raw)
+	}
+	server.conn.Close()
+	select {
+	case msg, ok := <-client.Messages():
+		if ok {
+			t.Fatalf("got %q after the server hung up", msg.Raw)
+		}
+	case <-time.After(LINE_TIMEOUT):
+		t.Fatal("messages still open after the server hung up")
+	}
+	<-client.Done()
+	if err := client.Err(); !errors.Is(err, io.EOF) {
+		t.Errorf("Err() = %v, want EOF", err)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{"[golang] 3:04PM - alice: hi: there", Message{Room: "golang", Time: "3:04PM", User: "alice", Text: "hi: there"}},
+		{`[golang] Notice: "bob" joined the chat room.`, Message{Room: "golang", Notice: true, Text: `"bob" joined the chat room.`}},
+		{"[PM from alice] psst", Message{PM: true, User: "alice", Text: "psst"}},
+		{"ERR_NO_ROOM: You must join a room first.", Message{Code: "ERR_NO_ROOM", Text: "You must join a room first."}},
+		{"Err: not a code", Message{Text: "Err: not a code"}},
+		{"Joined room golang", Message{Text: "Joined room golang"}},
+		{"[golang] no separator", Message{Text: "[golang] no separator"}},
+		{"[unclosed", Message{Text: "[unclosed"}},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.line
+		if got := ParseLine(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{`{"type":"chat","room":"golang","seq":4,"from":"alice","text":"hi"}`, Message{Type: "chat", Room: "golang", Seq: 4, User: "alice", Text: "hi"}},
+		{`{"type":"pm","from":"alice","text":"psst"}`, Message{Type: "pm", PM: true, User: "alice", Text: "psst"}},
+		{`{"type":"user_renamed","room":"golang","from":"bob","name":"rob"}`, Message{Type: "user_renamed", Room: "golang", User: "bob", Text: "rob", Notice: true}},
+		{`{"type":"gap","room":"golang","from_seq":3,"to_seq":5}`, Message{Type: "gap", Room: "golang", Gap: &Gap{FromSeq: 3, ToSeq: 5}}},
+		{`{"type":"error","code":"ERR_NO_ROOM","text":"Join a room."}`, Message{Type: "error", Code: "ERR_NO_ROOM", Text: "Join a room."}},
+		{`{"type":"joined","room":"golang","last_seq":9,"members":["alice","bob"]}`, Message{Type: "joined", Room: "golang", LastSeq: 9, Members: []string{"alice", "bob"}}},
+		{"not json", Message{Text: "not json"}},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.line
+		if got := ParseEvent(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseEvent(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package chatclient
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// EnableEncryption creates a key pair for this connection and publishes
+// the public key, so that other users can send it encrypted private
+// messages. Those arrive as messages of type "pm" with Encrypted set and
+// a lock in front of Raw. JSON mode only.
+func (c *Client) EnableEncryption() error {
+	if !c.json {
+		return errors.New("chatclient: encryption needs JSON mode")
+	}
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	c.keyMu.Lock()
+	c.publicKey, c.privateKey = public, private
+	c.keyMu.Unlock()
+	return c.request(map[string]any{"type": "pubkey", "key": base64.StdEncoding.EncodeToString(public[:])})
+}
+
+// PeerKey fetches the public key username published, along with its
+// fingerprint for comparing out of band.
+func (c *Client) PeerKey(username string, timeout time.Duration) (*[32]byte, string, error) {
+	reply, err := c.call(map[string]any{"type": "key", "name": username}, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := decodeKey(reply.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, KeyFingerprint(key), nil
+}
+
+// SendEncrypted encrypts text for username with NaCl box and sends it as a
+// private message the server cannot read. It fails if username has not
+// published a key. EnableEncryption must have been called.
+func (c *Client) SendEncrypted(username, text string, timeout time.Duration) error {
+	c.keyMu.Lock()
+	private := c.privateKey
+	c.keyMu.Unlock()
+	if private == nil {
+		return errors.New("chatclient: call EnableEncryption first")
+	}
+	peer, _, err := c.PeerKey(username, timeout)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	sealed := box.Seal(nil, []byte(text), &nonce, peer, private)
+	_, err = c.call(map[string]any{
+		"type":       "encrypted_pm",
+		"to":         username,
+		"nonce":      base64.StdEncoding.EncodeToString(nonce[:]),
+		"ciphertext": base64.StdEncoding.EncodeToString(sealed),
+	}, timeout)
+	return err
+}
+
+// KeyFingerprint returns the SHA-256 hash of key as "sha256:" followed by
+// lowercase hex, the same form the server's /key shows.
+func KeyFingerprint(key *[32]byte) string {
+	sum := sha256.Sum256(key[:])
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func decodeKey(encoded string) (*[32]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("chatclient: server sent an invalid key")
+	}
+	var key [32]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+// decrypt turns an encrypted_pm event into a private message.
+func (c *Client) decrypt(msg *Message) {
+	var e struct {
+		Key        string `json:"key"`
+		Nonce      string `json:"nonce"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	msg.Type, msg.PM, msg.Encrypted = "pm", true, true
+	msg.Text = "(could not decrypt message)"
+	json.Unmarshal([]byte(msg.Raw), &e)
+	c.keyMu.Lock()
+	private := c.privateKey
+	c.keyMu.Unlock()
+	peer, err := decodeKey(e.Key)
+	nonce, nonceErr := base64.StdEncoding.DecodeString(e.Nonce)
+	sealed, sealedErr := base64.StdEncoding.DecodeString(e.Ciphertext)
+	if private != nil && err == nil && nonceErr == nil && sealedErr == nil && len(nonce) == 24 {
+		if opened, ok := box.Open(nil, sealed, (*[24]byte)(nonce), peer, private); ok {
+			msg.Text = string(opened)
+		}
+	}
+	msg.Raw = fmt.Sprintf("🔒 [PM from %s] %s", msg.User, msg.Text)
+}
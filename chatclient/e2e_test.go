@@ -0,0 +1,109 @@
+package chatclient
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// request returns the next JSON request the client sent.
+func (s *stub) request() map[string]any {
+	s.t.Helper()
+	select {
+	case line := <-s.lines:
+		var req map[string]any
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			s.t.Fatalf("client sent %q: %v", line, err)
+		}
+		return req
+	case <-time.After(LINE_TIMEOUT):
+		s.t.Fatal("client sent no request")
+	}
+	return nil
+}
+
+// reply queues event for the client as a JSON line.
+func (s *stub) reply(event map[string]any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.send(string(data))
+}
+
+// encryptingStub connects a JSON mode client that enabled encryption and
+// returns the key it published.
+func encryptingStub(t *testing.T) (*Client, *stub, string) {
+	t.Helper()
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	if err := client.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	req := server.request()
+	if req["type"] != "pubkey" {
+		t.Fatalf("client sent %v, want its key", req)
+	}
+	return client, server, req["key"].(string)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	alice, aliceServer, aliceKey := encryptingStub(t)
+	bob, bobServer, bobKey := encryptingStub(t)
+
+	sent := make(chan error, 1)
+	go func() { sent <- alice.SendEncrypted("bob", "meet at noon", LINE_TIMEOUT) }()
+	lookup := aliceServer.request()
+	if lookup["type"] != "key" || lookup["name"] != "bob" {
+		t.Fatalf("client sent %v, want a key lookup for bob", lookup)
+	}
+	aliceServer.reply(map[string]any{"type": "key", "id": lookup["id"], "name": "bob", "key": bobKey})
+	pm := aliceServer.request()
+	if pm["type"] != "encrypted_pm" || pm["to"] != "bob" {
+		t.Fatalf("client sent %v, want an encrypted_pm to bob", pm)
+	}
+	for _, field := range []string{"nonce", "ciphertext"} {
+		if pm[field] == "" {
+			t.Fatalf("encrypted_pm has no %s: %v", field, pm)
+		}
+	}
+	aliceServer.reply(map[string]any{"type": "ok", "id": pm["id"], "request": "encrypted_pm"})
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	// The server relays the message with the sender's key.
+	relay := map[string]any{"type": "encrypted_pm", "from": "alice", "key": aliceKey, "nonce": pm["nonce"], "ciphertext": pm["ciphertext"]}
+	bobServer.reply(relay)
+	msg := next(t, bob)
+	if !msg.Encrypted || !msg.PM || msg.User != "alice" || msg.Text != "meet at noon" {
+		t.Errorf("bob got %+v, want the decrypted message from alice", msg)
+	}
+
+	// Signed with another key, the message does not open.
+	_, _, otherKey := encryptingStub(t)
+	relay["key"] = otherKey
+	bobServer.reply(relay)
+	if msg := next(t, bob); msg.Text != "(could not decrypt message)" {
+		t.Errorf("bob opened a message with the wrong key: %+v", msg)
+	}
+}
+
+func TestSendEncryptedNeedsKeys(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	if err := client.SendEncrypted("bob", "hi", LINE_TIMEOUT); err == nil {
+		t.Error("SendEncrypted worked without EnableEncryption")
+	}
+
+	alice, aliceServer, _ := encryptingStub(t)
+	sent := make(chan error, 1)
+	go func() { sent <- alice.SendEncrypted("carol", "hi", LINE_TIMEOUT) }()
+	lookup := aliceServer.request()
+	aliceServer.reply(map[string]any{"type": "error", "id": lookup["id"], "code": "ERR_REJECTED", "text": "carol has no key."})
+	if err := <-sent; !IsCode(err, "ERR_REJECTED") {
+		t.Errorf("SendEncrypted to a user without a key = %v, want the server's error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package chatclient
+
+import (
+	"errors"
+	"strings"
+
+	"final_project/codes"
+)
+
+// ServerError is a request the server rejected. Code is one of the
+// constants of package codes and is what programs should check; Text is
+// the explanation for people, which may be translated.
+type ServerError struct {
+	Code string
+	Text string
+}
+
+func (e *ServerError) Error() string {
+	return e.Code + ": " + e.Text
+}
+
+// IsCode reports whether err is a ServerError with the given code.
+func IsCode(err error, code string) bool {
+	var server *ServerError
+	return errors.As(err, &server) && server.Code == code
+}
+
+// serverError returns the rejection in msg. Servers from before error
+// codes send none; their errors get ERR_REJECTED.
+func serverError(msg Message) *ServerError {
+	code := msg.Code
+	if code == "" {
+		code = codes.ERR_REJECTED
+	}
+	return &ServerError{Code: code, Text: msg.Text}
+}
+
+// cutCode splits a text mode line of the form "ERR_CODE: text".
+func cutCode(line string) (code, text string, ok bool) {
+	code, text, ok = strings.Cut(line, ": ")
+	if !ok || len(code) <= len("ERR_") || !strings.HasPrefix(code, "ERR_") {
+		return "", "", false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && r != '_' {
+			return "", "", false
+		}
+	}
+	return code, text, true
+}
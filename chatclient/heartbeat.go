@@ -0,0 +1,82 @@
+package chatclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// KEEPALIVE is the TCP keepalive period of dialed connections, which
+	// lets the operating system notice a peer that vanished.
+	KEEPALIVE = 30 * time.Second
+	// DEFAULT_HEARTBEAT_GRACE is how long a heartbeat ping may go
+	// unanswered when Config.HeartbeatGrace is zero.
+	DEFAULT_HEARTBEAT_GRACE = 15 * time.Second
+)
+
+// ErrStalled ends a connection on which the server did not answer a
+// heartbeat ping; see Config.Heartbeat.
+var ErrStalled = errors.New("chatclient: server stopped answering")
+
+func newDialer() *net.Dialer {
+	return &net.Dialer{KeepAlive: KEEPALIVE}
+}
+
+// heartbeat pings the server once nothing has been received for idle and
+// closes the connection if nothing arrives within grace after that. It
+// stops when the connection ends.
+func (c *Client) heartbeat(idle, grace time.Duration) {
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	var probed time.Time
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+		}
+		last := time.Unix(0, c.lastRead.Load())
+		switch {
+		case !probed.IsZero() && last.Before(probed):
+			c.stall.Store(fmt.Errorf("%w: nothing received for %v, not even a reply to a ping", ErrStalled, time.Since(last).Round(time.Second)))
+			c.Close()
+			return
+		case time.Since(last) < idle:
+			probed = time.Time{}
+			timer.Reset(idle - time.Since(last))
+		default:
+			probed = time.Now()
+			c.probe()
+			timer.Reset(grace)
+		}
+	}
+}
+
+// probe sends a ping whose reply is not passed on to Messages. Anything
+// received counts as a sign of life, so the reply itself is not waited
+// for.
+func (c *Client) probe() {
+	if c.json {
+		c.pendingMu.Lock()
+		id := c.newID()
+		c.pending[id] = make(chan Message, 1)
+		c.pendingMu.Unlock()
+		c.request(map[string]any{"type": "ping", "id": id})
+		return
+	}
+	c.probes.Add(1)
+	c.write("/ping")
+}
+
+// probeReply reports whether line answers a ping sent by probe. The server
+// answers pings in order, so a PONG is taken as the oldest one's.
+func (c *Client) probeReply(line string) bool {
+	if c.probes.Load() == 0 || !strings.HasPrefix(line, "PONG ") {
+		return false
+	}
+	c.probes.Add(-1)
+	return true
+}
@@ -0,0 +1,81 @@
+package chatclient
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_IDLE  = 50 * time.Millisecond
+	TEST_GRACE = 50 * time.Millisecond
+)
+
+// fakeServer reads what the client sends over a pipe, answering each
+// /ping with a PONG if answer is set, until the client hangs up.
+func fakeServer(t *testing.T, answer bool) *Client {
+	t.Helper()
+	server, conn := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if answer && strings.TrimSpace(line) == "/ping" {
+				server.Write([]byte("PONG 1\n"))
+			}
+		}
+	}()
+	client, err := New(conn, Config{Heartbeat: TEST_IDLE, HeartbeatGrace: TEST_GRACE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestHeartbeatDropsSilentServer(t *testing.T) {
+	client := fakeServer(t, false)
+	select {
+	case msg, ok := <-client.Messages():
+		if ok {
+			t.Fatalf("got %q from a silent server", msg.Raw)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection to a silent server is still open")
+	}
+	if err := client.Err(); !errors.Is(err, ErrStalled) {
+		t.Errorf("Err() = %v, want ErrStalled", err)
+	}
+}
+
+func TestHeartbeatKeepsAnsweringServer(t *testing.T) {
+	client := fakeServer(t, true)
+	select {
+	case msg, ok := <-client.Messages():
+		if ok {
+			t.Fatalf("the heartbeat's PONG was passed on as %q", msg.Raw)
+		}
+		t.Fatalf("the connection closed: %v", client.Err())
+	case <-time.After(10 * (TEST_IDLE + TEST_GRACE)):
+	}
+}
+
+func TestCloseStopsHeartbeat(t *testing.T) {
+	client := fakeServer(t, true)
+	client.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client is still running after Close")
+	}
+	if err := client.Err(); errors.Is(err, ErrStalled) {
+		t.Errorf("Err() = %v after Close, not a stall", err)
+	}
+}
@@ -0,0 +1,104 @@
+package chatclient
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestMembersFollowPresence(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	steps := []struct {
+		event string
+		want  []string
+	}{
+		{`{"type":"joined","room":"go","last_seq":3,"members":["alice","bob"]}`, []string{"alice", "bob"}},
+		{`{"type":"user_joined_room","room":"go","seq":4,"from":"carol"}`, []string{"alice", "bob", "carol"}},
+		{`{"type":"user_renamed","room":"go","seq":5,"from":"bob","name":"rob"}`, []string{"alice", "carol", "rob"}},
+		{`{"type":"user_left_room","room":"go","seq":6,"from":"alice","text":"too slow"}`, []string{"carol", "rob"}},
+		// Replayed events are older than the member list.
+		{`{"type":"user_left_room","room":"go","seq":2,"from":"carol","replay":true}`, []string{"carol", "rob"}},
+		// Events of other rooms leave it alone.
+		{`{"type":"user_joined_room","room":"rust","seq":9,"from":"dave"}`, []string{"carol", "rob"}},
+		{`{"type":"ok","request":"leave","room":"go"}`, nil},
+	}
+	for _, step := range steps {
+		server.send(step.event)
+		next(t, client)
+		if got := client.Members("go"); !slices.Equal(got, step.want) {
+			t.Fatalf("after %s Members = %q, want %q", step.event, got, step.want)
+		}
+	}
+}
+
+func TestMembersJoinLeavesPreviousRoom(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	server.send(`{"type":"joined","room":"go","members":["alice"]}`)
+	next(t, client)
+	server.send(`{"type":"joined","room":"rust","members":["alice","bob"]}`)
+	next(t, client)
+	if got := client.Members("go"); got != nil {
+		t.Errorf("Members of the room left = %q, want nil", got)
+	}
+	if got := client.Members("rust"); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("Members = %q, want alice and bob", got)
+	}
+}
+
+// TestMembersWhileRenamesAndLeavesArrive reads the member list while the
+// read loop applies renames and departures, for the race detector.
+func TestMembersWhileRenamesAndLeavesArrive(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	const count = 100
+	var names []string
+	for i := range count {
+		names = append(names, fmt.Sprint("user", i))
+	}
+	data := `{"type":"joined","room":"go","members":["` + names[0]
+	for _, name := range names[1:] {
+		data += `","` + name
+	}
+	server.send(data + `"]}`)
+	next(t, client)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				client.Members("go")
+			}
+		}
+	}()
+	for i, name := range names {
+		if i%2 == 0 {
+			server.send(fmt.Sprintf(`{"type":"user_renamed","room":"go","from":%q,"name":"renamed%d"}`, name, i))
+		} else {
+			server.send(fmt.Sprintf(`{"type":"user_left_room","room":"go","from":%q}`, name))
+		}
+		next(t, client)
+	}
+	close(stop)
+	wg.Wait()
+
+	var want []string
+	for i := 0; i < count; i += 2 {
+		want = append(want, fmt.Sprint("renamed", i))
+	}
+	slices.Sort(want)
+	if got := client.Members("go"); !slices.Equal(got, want) {
+		t.Errorf("Members = %q, want %q", got, want)
+	}
+}
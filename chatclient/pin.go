@@ -0,0 +1,59 @@
+package chatclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint returns the SHA-256 hash of the certificate's public key
+// (SPKI) as "sha256:" followed by lowercase hex.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// NormalizePin accepts a fingerprint with or without the "sha256:" prefix,
+// in either case and with optional colons, and returns it in the form
+// produced by Fingerprint.
+func NormalizePin(pin string) (string, error) {
+	pin = strings.ToLower(strings.TrimSpace(pin))
+	pin = strings.TrimPrefix(pin, "sha256:")
+	pin = strings.ReplaceAll(pin, ":", "")
+	raw, err := hex.DecodeString(pin)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q: want a hex SHA-256 fingerprint", pin)
+	}
+	return "sha256:" + pin, nil
+}
+
+// PinTLS makes config accept the server only if its leaf certificate's key
+// matches pin. Without RootCAs the pin replaces CA verification; with them
+// both have to pass.
+func PinTLS(config *tls.Config, pin string) error {
+	want, err := NormalizePin(pin)
+	if err != nil {
+		return err
+	}
+	if config.RootCAs == nil {
+		config.InsecureSkipVerify = true
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if got := Fingerprint(cert); got != want {
+			return fmt.Errorf("certificate pin mismatch: server presented %s, expected %s", got, want)
+		}
+		return nil
+	}
+	return nil
+}
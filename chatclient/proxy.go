@@ -0,0 +1,143 @@
+package chatclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyError reports a failure to connect through a proxy. Reached tells
+// whether the proxy itself could be contacted: when it is false the proxy
+// is unreachable, otherwise the proxy could not reach the server.
+type ProxyError struct {
+	Proxy   string
+	Reached bool
+	Err     error
+}
+
+func (e *ProxyError) Error() string {
+	if !e.Reached {
+		return fmt.Sprintf("proxy %s unreachable: %v", e.Proxy, e.Err)
+	}
+	return fmt.Sprintf("server unreachable through proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// ProxyFromEnvironment returns the proxy named by ALL_PROXY or HTTPS_PROXY
+// (or their lowercase forms), or nil when none is set.
+func ProxyFromEnvironment() (*url.URL, error) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			return url.Parse(value)
+		}
+	}
+	return nil, nil
+}
+
+// dialProxy opens a TCP connection to addr through the proxy at proxyURL,
+// which must use the socks5 or http scheme.
+func dialProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return dialSOCKS5(ctx, proxyURL, addr)
+	case "http":
+		return dialHTTPConnect(ctx, proxyURL, addr)
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// forwardDialer records whether the connection to the proxy succeeded so
+// errors can be attributed to the proxy or to the server behind it.
+type forwardDialer struct {
+	reached bool
+}
+
+func (d *forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := newDialer().DialContext(ctx, network, addr)
+	d.reached = err == nil
+	return conn, err
+}
+
+func dialSOCKS5(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	forward := &forwardDialer{}
+	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &ProxyError{Proxy: proxyURL.Host, Reached: forward.reached, Err: err}
+	}
+	return conn, nil
+}
+
+func dialHTTPConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := newDialer().DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, &ProxyError{Proxy: proxyURL.Host, Err: err}
+	}
+	// Interrupt the CONNECT exchange when ctx ends.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: proxyURL.Host, Reached: true, Err: err}
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: proxyURL.Host, Reached: true, Err: err}
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, &ProxyError{Proxy: proxyURL.Host, Reached: true, Err: fmt.Errorf("CONNECT %s: %s", addr, response.Status)}
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn serves bytes the proxy sent after its response before
+// reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,73 @@
+package chatclient
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// chatEvent returns a chat event line for room with seq.
+func chatEvent(room string, seq int, replay bool) string {
+	return fmt.Sprintf(`{"type":"chat","room":%q,"seq":%d,"from":"alice","text":"m%d","replay":%v}`, room, seq, seq, replay)
+}
+
+func TestSkippedSeqIsBackfilled(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	server.send(`{"type":"joined","room":"golang","last_seq":3}`)
+	if msg := next(t, client); msg.Type != "joined" {
+		t.Fatalf("got %+v, want the join reply", msg)
+	}
+	server.send(chatEvent("golang", 4, false))
+	if msg := next(t, client); msg.Seq != 4 {
+		t.Fatalf("got %+v, want seq 4", msg)
+	}
+
+	// Seqs 5 and 6 never arrive; the client reports them before seq 7.
+	server.send(chatEvent("golang", 7, false))
+	gap := next(t, client)
+	if gap.Type != "gap" || gap.Room != "golang" || !reflect.DeepEqual(gap.Gap, &Gap{FromSeq: 5, ToSeq: 6}) {
+		t.Fatalf("got %+v, want a gap of 5 to 6", gap)
+	}
+	if msg := next(t, client); msg.Seq != 7 {
+		t.Fatalf("got %+v, want seq 7", msg)
+	}
+
+	// The replay fills the gap without reporting another, and the live
+	// events after it carry on from seq 7.
+	if err := client.Backfill("golang", gap.Gap.FromSeq); err != nil {
+		t.Fatal(err)
+	}
+	server.expect(`{"from_seq":5,"room":"golang","type":"backfill"}`)
+	server.send(chatEvent("golang", 5, true))
+	server.send(chatEvent("golang", 6, true))
+	server.send(`{"type":"ok","request":"backfill","room":"golang"}`)
+	server.send(chatEvent("golang", 8, false))
+	var got []string
+	for range 4 {
+		msg := next(t, client)
+		got = append(got, fmt.Sprintf("%s %d %v", msg.Type, msg.Seq, msg.Replay))
+	}
+	want := []string{"chat 5 true", "chat 6 true", "ok 0 false", "chat 8 false"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after the backfill got %q, want %q", got, want)
+	}
+}
+
+func TestOldSeqIsNotAGap(t *testing.T) {
+	client, server := newStub(t, Config{JSON: true})
+	server.expect(HELLO + ",directory,no-self-echo")
+	server.expect("/json")
+	server.send(`{"type":"joined","room":"golang","last_seq":10}`)
+	server.send(chatEvent("golang", 11, false))
+	server.send(chatEvent("golang", 9, false))
+	server.send(`{"type":"joined","room":"rust","last_seq":2}`)
+	server.send(chatEvent("rust", 3, false))
+	server.send(chatEvent("golang", 12, false))
+	for range 6 {
+		if msg := next(t, client); msg.Type == "gap" {
+			t.Fatalf("got a gap %+v", msg.Gap)
+		}
+	}
+}
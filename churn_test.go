@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	CHURN_CLIENTS = 300
+	CHURN_BANNED  = 30
+)
+
+// churnClient connects, joins room and leaves in the way picked by i: with
+// /quit, by hanging up, or kicked.
+func churnClient(t *testing.T, addr, room string, i int) {
+	c := newTestClient(t, addr)
+	c.send("/join " + room)
+	c.expectLine("Joined room " + room)
+	c.send(fmt.Sprintf("message %d", i))
+	c.expectLine(fmt.Sprintf("message %d", i))
+	switch i % 3 {
+	case 0:
+		c.send("/quit churned")
+		c.expectClosed()
+	case 1:
+		c.conn.Close()
+	case 2:
+		mutex.Lock()
+		client := findClient(c.name)
+		mutex.Unlock()
+		if client == nil {
+			t.Errorf("%s is not in clients", c.name)
+			return
+		}
+		kickUser(client, "churn")
+		c.expectLine("You have been kicked from the chat: churn")
+		c.expectClosed()
+	}
+}
+
+// expectBanned connects to addr and expects to be turned away as banned
+// for reason.
+func expectBanned(t *testing.T, addr, reason string) {
+	conn := dialTest(t, addr)
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	c.expectLine("You are banned from the chat: " + reason)
+	c.expectClosed()
+}
+
+func TestConnectionChurn(t *testing.T) {
+	addr := newTestServer(t)
+	baseline := runtime.NumGoroutine()
+
+	owner := newTestClient(t, addr)
+	room := uniqueName("churn")
+	owner.send("/create " + room)
+	owner.expectLine("Created and joined room " + room)
+
+	// The group returns once all its parallel subtests have.
+	t.Run("group", func(t *testing.T) {
+		for i := range CHURN_CLIENTS {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				t.Parallel()
+				churnClient(t, addr, room, i)
+			})
+		}
+	})
+
+	// Ban loopback as the admin /ban does, which drops the clients still
+	// connected, then check that new connections are turned away.
+	var stayed []*testClient
+	for range CHURN_BANNED {
+		c := newTestClient(t, addr)
+		c.send("/join " + room)
+		c.expectLine("Joined room " + room)
+		stayed = append(stayed, c)
+	}
+	ban, err := parseBan("127.0.0.1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ban.Reason = "churn"
+	addBan(ban)
+	t.Cleanup(func() { unbanAddress("127.0.0.1") })
+	for _, client := range bannedClients() {
+		evictUser(client, "banned", "churn")
+	}
+	for _, c := range stayed {
+		c.expectLine("You have been banned from the chat: churn")
+		c.expectClosed()
+	}
+	owner.expectClosed()
+	for range CHURN_BANNED {
+		expectBanned(t, addr, "churn")
+	}
+	unbanAddress("127.0.0.1")
+
+	waitFor(t, "clients to drain", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(clients) == 0
+	})
+	mutex.Lock()
+	for room, members := range rooms {
+		if len(members) != 0 {
+			t.Errorf("room %s still has %d members", room, len(members))
+		}
+	}
+	mutex.Unlock()
+	var goroutines int
+	waitFor(t, "goroutines to return to baseline", func() bool {
+		goroutines = runtime.NumGoroutine()
+		return goroutines <= baseline
+	})
+}
+
+// MIDBROADCAST_MEMBERS is the size of the room a member is killed in, large
+// enough that delivery is split across the broadcast workers.
+const MIDBROADCAST_MEMBERS = 2000
+
+// triggerConn is a sinkConn that calls fire the first time it is written
+// a line containing its match.
+type triggerConn struct {
+	*sinkConn
+	once sync.Once
+	fire func()
+}
+
+func (c *triggerConn) Write(b []byte) (int, error) {
+	if bytes.Contains(b, c.match) {
+		c.once.Do(c.fire)
+	}
+	return c.sinkConn.Write(b)
+}
+
+// TestMemberKilledDuringBroadcast hangs up a member while a message to a
+// large room is being delivered. Every other member must still get the
+// message, and the members that read must then see the leave notice.
+func TestMemberKilledDuringBroadcast(t *testing.T) {
+	addr := newTestServer(t)
+	room := uniqueName("killed")
+	alice := newTestClient(t, addr)
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	var watchers []*testClient
+	for range 3 {
+		c := newTestClient(t, addr)
+		c.send("/join " + room)
+		c.expectLine("Joined room " + room)
+		watchers = append(watchers, c)
+	}
+	victim := newTestClient(t, addr)
+	victim.send("/join " + room)
+	victim.expectLine("Joined room " + room)
+
+	// The trigger is delivered to before the simulated members, so the
+	// victim hangs up while the rest of the room is still being reached.
+	const text = "everyone but the victim"
+	var count atomic.Int64
+	trigger := newClient(&triggerConn{
+		sinkConn: &sinkConn{
+			addr:   &net.TCPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 1},
+			match:  []byte(text),
+			count:  &count,
+			closed: make(chan struct{}),
+		},
+		fire: func() { victim.conn.Close() },
+	})
+	trigger.username = uniqueName("trigger")
+	mutex.Lock()
+	clients[trigger.conn] = trigger
+	trigger.room = room
+	rooms[room] = append(rooms[room], trigger)
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		delete(clients, trigger.conn)
+		removeMember(room, trigger)
+		mutex.Unlock()
+		trigger.stop()
+		trigger.conn.Close()
+	})
+	simulatedClients(t, MIDBROADCAST_MEMBERS, room, text, &count)
+
+	alice.send(text)
+	for _, c := range append(watchers, alice) {
+		c.expectLine(": " + text)
+		c.expectLine(`"` + victim.name + `" left the chat room`)
+	}
+	waitFor(t, "every simulated member to get the message", func() bool {
+		return count.Load() == MIDBROADCAST_MEMBERS+1
+	})
+}
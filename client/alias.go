@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// MAX_ALIAS_DEPTH is how many aliases one command may expand through, so
+// aliases that refer to each other fail instead of looping.
+const MAX_ALIAS_DEPTH = 8
+
+// expandAlias rewrites a command whose name is one of aliases, which map
+// names to commands without their slashes, e.g. "g" to "join golang".
+// Arguments typed after the alias are appended to its command, and the
+// result is expanded again if it names another alias. Chat text and
+// multi-line input are returned unchanged.
+func expandAlias(aliases map[string]string, line string) (string, error) {
+	if !strings.HasPrefix(line, "/") || strings.Contains(line, "\n") {
+		return line, nil
+	}
+	for depth := 0; ; depth++ {
+		name, args, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+		command, ok := aliases[name]
+		if !ok {
+			return line, nil
+		}
+		if depth == MAX_ALIAS_DEPTH {
+			return "", fmt.Errorf("alias /%s expands more than %d times; check your aliases for a loop", name, MAX_ALIAS_DEPTH)
+		}
+		line = "/" + strings.TrimPrefix(strings.TrimSpace(command), "/")
+		if args = strings.TrimSpace(args); args != "" {
+			line += " " + args
+		}
+	}
+}
+
+// checkAliases returns warnings about aliases that cannot work.
+func checkAliases(aliases map[string]string) []string {
+	var warnings []string
+	for _, name := range slices.Sorted(maps.Keys(aliases)) {
+		command := aliases[name]
+		if name == "" || strings.ContainsAny(name, " /") {
+			warnings = append(warnings, fmt.Sprintf("alias %q: names are single words without a slash", name))
+		}
+		if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), "/")) == "" {
+			warnings = append(warnings, fmt.Sprintf("alias %q: empty command", name))
+		}
+	}
+	return warnings
+}
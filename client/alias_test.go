@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	aliases := map[string]string{
+		"g":     "join golang",
+		"slash": "/join gophers",
+		"m":     "msg",
+		"mb":    "m bob",
+		"j":     "join",
+		"ping":  "ping",
+		"loop":  "loop",
+		"a":     "b",
+		"b":     "a",
+	}
+	// A chain of MAX_ALIAS_DEPTH aliases still expands.
+	for i := range MAX_ALIAS_DEPTH - 1 {
+		aliases[strings.Repeat("c", i+1)] = strings.Repeat("c", i+2)
+	}
+	aliases[strings.Repeat("c", MAX_ALIAS_DEPTH)] = "who"
+	tests := []struct {
+		line string
+		want string
+		loop bool
+	}{
+		{"/g", "/join golang", false},
+		{"/g  ", "/join golang", false},
+		{"/slash", "/join gophers", false},
+		{"/m bob hi there", "/msg bob hi there", false},
+		{"/mb hi", "/msg bob hi", false},
+		{"/j", "/join", false},
+		{"/j  lobby ", "/join lobby", false},
+		{"/unknown args", "/unknown args", false},
+		{"/join g", "/join g", false},
+		{"g", "g", false},
+		{"/g\nsecond line", "/g\nsecond line", false},
+		{"/c", "/who", false},
+		{"/ping", "", true},
+		{"/loop", "", true},
+		{"/a", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandAlias(aliases, tt.line)
+		if tt.loop {
+			if err == nil {
+				t.Errorf("expandAlias(%q) = %q, want a loop error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandAlias(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckAliases(t *testing.T) {
+	got := checkAliases(map[string]string{
+		"g":         "join golang",
+		"two words": "who",
+		"/slash":    "who",
+		"empty":     " / ",
+		"":          "who",
+	})
+	want := []string{
+		`alias "": names are single words without a slash`,
+		`alias "/slash": names are single words without a slash`,
+		`alias "empty": empty command`,
+		`alias "two words": names are single words without a slash`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("checkAliases:\n got %q\nwant %q", got, want)
+	}
+}
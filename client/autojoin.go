@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+// autoJoiner joins a list of rooms one at a time, waiting for the server's
+// reply to each /join before sending the next so that the last room that
+// could be joined ends up as the active one.
+type autoJoiner struct {
+	rooms         []string
+	createMissing bool
+	current       string
+	creating      bool
+	joined        []string
+}
+
+func newAutoJoiner(rooms []string, createMissing bool) *autoJoiner {
+	var cleaned []string
+	for _, room := range rooms {
+		if room = strings.TrimSpace(room); room != "" {
+			cleaned = append(cleaned, room)
+		}
+	}
+	return &autoJoiner{rooms: cleaned, createMissing: createMissing}
+}
+
+// Next returns the next command to send, or "" when all rooms were tried.
+func (a *autoJoiner) Next() string {
+	if a.current != "" || len(a.rooms) == 0 {
+		return ""
+	}
+	a.current, a.rooms = a.rooms[0], a.rooms[1:]
+	a.creating = false
+	return "/join " + a.current
+}
+
+// Pending reports whether rooms are left to try.
+func (a *autoJoiner) Pending() bool {
+	return a.current != "" || len(a.rooms) > 0
+}
+
+// Joined reports whether room was joined successfully.
+func (a *autoJoiner) Joined(room string) bool {
+	for _, joined := range a.joined {
+		if joined == room {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejoin returns an autoJoiner for a new connection. It joins the rooms
+// this one joined and those it had yet to try, then active, the room the
+// user was in, so that it is the active room again. While rooms are left
+// to try, the last of them would have become the active one anyway.
+func (a *autoJoiner) Rejoin(active string) *autoJoiner {
+	rooms := slices.Clone(a.joined)
+	if a.current != "" {
+		rooms = append(rooms, a.current)
+	}
+	rooms = append(rooms, a.rooms...)
+	if active != "" && !a.Pending() {
+		rooms = slices.DeleteFunc(rooms, func(room string) bool { return room == active })
+		rooms = append(rooms, active)
+	}
+	return newAutoJoiner(rooms, a.createMissing)
+}
+
+// Observe inspects a server reply to the pending command and returns the
+// command to send next, if any.
+func (a *autoJoiner) Observe(msg chatclient.Message) string {
+	if a.current == "" || msg.Room != "" {
+		return ""
+	}
+	_, joined := joinedRoom(msg)
+	switch {
+	case joined:
+		a.joined = append(a.joined, a.current)
+		a.current = ""
+	case !a.creating && a.createMissing && msg.Code == codes.ERR_NO_SUCH_ROOM:
+		a.creating = true
+		return "/create " + a.current
+	case msg.Code != "":
+		a.current = ""
+	default:
+		return ""
+	}
+	return a.Next()
+}
+
+// joinedRoom returns the room msg says the client joined, if it is the
+// reply to a /join or /create.
+func joinedRoom(msg chatclient.Message) (string, bool) {
+	if msg.Room != "" {
+		return "", false
+	}
+	if name, ok := strings.CutPrefix(msg.Raw, "Created and joined room "); ok {
+		return name, true
+	}
+	if name, ok := strings.CutPrefix(msg.Raw, "Joined room "); ok {
+		return name, true
+	}
+	return "", false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+// joinAll runs joiner against replies, one per command it sends, and
+// returns the commands.
+func joinAll(joiner *autoJoiner, replies ...chatclient.Message) []string {
+	var sent []string
+	command := joiner.Next()
+	for _, reply := range replies {
+		if command == "" {
+			break
+		}
+		sent = append(sent, command)
+		command = joiner.Observe(reply)
+	}
+	return sent
+}
+
+func TestRejoin(t *testing.T) {
+	tests := []struct {
+		name    string
+		joiner  *autoJoiner
+		active  string
+		want    []string
+		pending bool
+	}{
+		{"joined", &autoJoiner{joined: []string{"a", "b"}}, "b", []string{"a", "b"}, false},
+		{"joined earlier room again", &autoJoiner{joined: []string{"a", "b"}}, "a", []string{"b", "a"}, false},
+		{"joined another room", &autoJoiner{joined: []string{"a", "b"}}, "c", []string{"a", "b", "c"}, false},
+		{"no room", &autoJoiner{}, "", nil, false},
+		{"still joining", &autoJoiner{joined: []string{"a"}, current: "b", rooms: []string{"c"}}, "a", []string{"a", "b", "c"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejoin := tt.joiner.Rejoin(tt.active)
+			if !slices.Equal(rejoin.rooms, tt.want) {
+				t.Errorf("Rejoin(%q) joins %q, want %q", tt.active, rejoin.rooms, tt.want)
+			}
+			if tt.joiner.Pending() != tt.pending {
+				t.Errorf("Pending() = %v, want %v", tt.joiner.Pending(), tt.pending)
+			}
+		})
+	}
+}
+
+func TestRejoinAfterFailedRoom(t *testing.T) {
+	joiner := newAutoJoiner([]string{"general", "gone", "golang"}, false)
+	sent := joinAll(joiner,
+		chatclient.ParseLine("Joined room general"),
+		chatclient.ParseLine(codes.ERR_NO_SUCH_ROOM+": Room gone does not exist."),
+		chatclient.ParseLine("Joined room golang"),
+	)
+	if want := []string{"/join general", "/join gone", "/join golang"}; !slices.Equal(sent, want) {
+		t.Fatalf("sent %q, want %q", sent, want)
+	}
+	rejoin := joiner.Rejoin("golang")
+	sent = joinAll(rejoin,
+		chatclient.ParseLine("Joined room general"),
+		chatclient.ParseLine("Joined room golang"),
+	)
+	if want := []string{"/join general", "/join golang"}; !slices.Equal(sent, want) {
+		t.Errorf("after a reconnect sent %q, want %q", sent, want)
+	}
+}
+
+func TestJoinedRoom(t *testing.T) {
+	tests := []struct {
+		line string
+		room string
+		ok   bool
+	}{
+		{"Joined room general", "general", true},
+		{"Created and joined room dev", "dev", true},
+		{"[general] 3:04PM alice: Joined room x", "", false},
+		{codes.ERR_NO_SUCH_ROOM + ": Room x does not exist.", "", false},
+	}
+	for _, tt := range tests {
+		room, ok := joinedRoom(chatclient.ParseLine(tt.line))
+		if room != tt.room || ok != tt.ok {
+			t.Errorf("joinedRoom(%q) = %q, %v, want %q, %v", tt.line, room, ok, tt.room, tt.ok)
+		}
+	}
+}
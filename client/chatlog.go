@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"final_project/chatclient"
+)
+
+const (
+	// LOG_QUEUE_SIZE is how many lines may wait for the log writer before
+	// new ones are dropped; the display never waits for the disk.
+	LOG_QUEUE_SIZE     = 256
+	LOG_FLUSH_INTERVAL = 5 * time.Second
+	// MAX_LOG_FILES is how many log files are kept open. The one written
+	// least recently is closed to make room for another.
+	MAX_LOG_FILES = 16
+)
+
+// chatLog appends the messages shown to files under dir: one per room, one
+// per private message correspondent and server.log for the rest. Lines are
+// written by a goroutine of its own and flushed every LOG_FLUSH_INTERVAL
+// and on Close.
+type chatLog struct {
+	dir     string
+	enabled bool
+	dropped int
+	entries chan logEntry
+	done    chan struct{}
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+type logEntry struct {
+	file string
+	line string
+}
+
+// logFile is an open log file and when it was last written.
+type logFile struct {
+	file *os.File
+	w    *bufio.Writer
+	used time.Time
+}
+
+// newChatLog starts logging to dir. Without a dir, logging is unavailable
+// and /log on says so.
+func newChatLog(dir string) (*chatLog, error) {
+	if dir == "" {
+		return &chatLog{}, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l := &chatLog{dir: dir, enabled: true, entries: make(chan logEntry, LOG_QUEUE_SIZE), done: make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+// Write queues msg for its log file. It never blocks: when the writer is
+// behind, the line is dropped and counted.
+func (l *chatLog) Write(msg chatclient.Message) {
+	if !l.enabled {
+		return
+	}
+	select {
+	case l.entries <- logEntry{file: logFileName(msg), line: logLine(msg, time.Now())}:
+	default:
+		l.dropped++
+	}
+}
+
+// Command handles /log on|off and a bare /log, which shows the state.
+func (l *chatLog) Command(args []string) string {
+	if len(args) == 0 {
+		if l.entries == nil {
+			return "Logging is unavailable; start the client with -log-dir."
+		}
+		state := "off"
+		if l.enabled {
+			state = "on"
+		}
+		status := fmt.Sprintf("Logging to %s is %s.", l.dir, state)
+		if l.dropped > 0 {
+			status += fmt.Sprintf(" %d lines were dropped because the disk was too slow.", l.dropped)
+		}
+		if err := l.err(); err != nil {
+			status += " Last error: " + err.Error()
+		}
+		return status
+	}
+	if len(args) > 1 || (args[0] != "on" && args[0] != "off") {
+		return "Usage: /log [on|off]"
+	}
+	if l.entries == nil {
+		return "Logging is unavailable; start the client with -log-dir."
+	}
+	l.enabled = args[0] == "on"
+	if l.enabled {
+		return "Logging to " + l.dir + " is on."
+	}
+	return "Logging is off."
+}
+
+// Close writes out what is queued and closes the files.
+func (l *chatLog) Close() {
+	if l.entries == nil {
+		return
+	}
+	close(l.entries)
+	<-l.done
+}
+
+func (l *chatLog) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastErr
+}
+
+func (l *chatLog) fail(err error) {
+	l.mu.Lock()
+	l.lastErr = err
+	l.mu.Unlock()
+}
+
+func (l *chatLog) run() {
+	defer close(l.done)
+	files := make(map[string]*logFile)
+	ticker := time.NewTicker(LOG_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-l.entries:
+			if !ok {
+				for _, f := range files {
+					l.closeFile(f)
+				}
+				return
+			}
+			f := files[entry.file]
+			if f == nil {
+				if len(files) >= MAX_LOG_FILES {
+					l.closeIdlest(files)
+				}
+				file, err := os.OpenFile(filepath.Join(l.dir, entry.file), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+				if err != nil {
+					l.fail(err)
+					continue
+				}
+				f = &logFile{file: file, w: bufio.NewWriter(file)}
+				files[entry.file] = f
+			}
+			f.used = time.Now()
+			if _, err := f.w.WriteString(entry.line); err != nil {
+				l.fail(err)
+			}
+		case <-ticker.C:
+			for _, f := range files {
+				if err := f.w.Flush(); err != nil {
+					l.fail(err)
+				}
+			}
+		}
+	}
+}
+
+// closeIdlest closes the file written least recently.
+func (l *chatLog) closeIdlest(files map[string]*logFile) {
+	idlest := ""
+	for name, f := range files {
+		if idlest == "" || f.used.Before(files[idlest].used) {
+			idlest = name
+		}
+	}
+	l.closeFile(files[idlest])
+	delete(files, idlest)
+}
+
+func (l *chatLog) closeFile(f *logFile) {
+	if err := errors.Join(f.w.Flush(), f.file.Close()); err != nil {
+		l.fail(err)
+	}
+}
+
+// logFileName is the file msg is logged to: room-<room>.log,
+// pm-<user>.log or server.log.
+func logFileName(msg chatclient.Message) string {
+	switch {
+	case msg.PM:
+		return "pm-" + safeFileName(msg.User) + ".log"
+	case msg.Room != "":
+		return "room-" + safeFileName(msg.Room) + ".log"
+	}
+	return "server.log"
+}
+
+// safeFileName replaces what could escape the log directory or trouble a
+// file system with underscores.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// logLine formats msg with the full date and time, e.g.
+// "2006-01-02 15:04:05 [golang] alice: hi". Messages with an RFC3339 time,
+// as in JSON mode, are dated by it, the rest by now.
+func logLine(msg chatclient.Message, now time.Time) string {
+	stamp := now
+	if t, err := time.Parse(time.RFC3339, msg.Time); err == nil {
+		stamp = t.Local()
+	}
+	text := msg.Raw
+	switch {
+	case msg.PM:
+		text = "[PM from " + msg.User + "] " + msg.Text
+	case msg.Room != "" && msg.Notice:
+		text = "[" + msg.Room + "] Notice: " + msg.Text
+	case msg.Room != "" && msg.User != "":
+		text = "[" + msg.Room + "] " + msg.User + ": " + msg.Text
+	}
+	return stamp.Format(time.DateTime) + " " + strings.ReplaceAll(text, "\n", "\n    ") + "\n"
+}
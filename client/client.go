@@ -1,81 +1,404 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
+
+	"final_project/chatclient"
 )
 
 const (
 	SERVER_HOST = "localhost"
 	SERVER_PORT = "3334"
-	SERVER_TYPE = "tcp"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run returns the process exit status: 0 after /quit, 1 when the connection
+// fails or the server closes it. A connection that breaks is dialed again,
+// unless -reconnect=false.
+func run() int {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	flags.String("host", SERVER_HOST, "server host, or unix:///path for a Unix socket ($"+ENV_VARS["host"]+")")
+	flags.String("port", SERVER_PORT, "server port ($"+ENV_VARS["port"]+")")
+	flags.String("user", "", "username to set after connecting ($"+ENV_VARS["username"]+")")
+	flags.String("cafile", "", "PEM file with the CA used to verify the server ($"+ENV_VARS["cafile"]+")")
+	flags.Bool("insecure", true, "skip server certificate verification when no -cafile is given")
+	yesIKnow := flags.Bool("yes-i-know", false, "allow -insecure with a server that is not on this machine")
+	flags.String("join", "", "comma-separated rooms to join after connecting")
+	notifyCmd := flags.String("notify-cmd", "", "command run with sender and message for private messages, mentions and /notify keywords")
+	bell := flags.Bool("bell", false, "ring the terminal bell for private messages, mentions and /notify keywords")
+	proxyFlag := flags.String("proxy", "", "connect through a socks5:// or http:// proxy (default $ALL_PROXY or $HTTPS_PROXY)")
+	room := flags.String("room", "", "room to join after the -join rooms; it becomes the active room")
+	oneshot := flags.Bool("oneshot", false, "send each line of stdin as a message and exit")
+	pace := flags.Duration("pace", 200*time.Millisecond, "delay between messages in -oneshot mode")
+	listenOnly := flags.Bool("listen-only", false, "print incoming messages to stdout as JSON and never send")
+	createMissing := flags.Bool("create-missing", false, "create auto-join rooms that do not exist")
+	flags.String("pin", "", "require the server key to match this SHA-256 fingerprint")
+	printFingerprint := flags.Bool("print-fingerprint", false, "print the server's certificate fingerprint and exit")
+	timeout := flags.Duration("timeout", chatclient.DEFAULT_TIMEOUT, "give up connecting after this long")
+	heartbeat := flags.Duration("heartbeat", time.Minute, "ping the server after receiving nothing for this long (0 never does)")
+	heartbeatGrace := flags.Duration("heartbeat-grace", chatclient.DEFAULT_HEARTBEAT_GRACE, "drop the connection when a -heartbeat ping goes unanswered this long")
+	autoReconnect := flags.Bool("reconnect", true, "reconnect, waiting longer after each failed attempt, when the connection breaks")
+	profileName := flags.String("profile", "", "profile to load from "+configPath())
+	writeProfile := flags.String("write-profile", "", "save the current settings as the named profile and exit")
+	logDir := flags.String("log-dir", "", "append the conversations shown to per-room files in this directory")
+	flags.Parse(os.Args[1:])
+
+	file, warnings, err := loadConfig(configPath())
+	if err != nil {
+		fmt.Println("Error reading config:", err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Println("Warning:", warning)
+	}
+	profile := Profile{}
+	if *profileName != "" {
+		var ok bool
+		if profile, ok = file.Profiles[*profileName]; !ok {
+			fmt.Printf("Profile %q not found in %s\n", *profileName, configPath())
+			return 1
+		}
+	}
+	settings := resolveSettings(profile, os.Getenv, flags)
+	for _, warning := range checkColors(settings.Colors) {
+		fmt.Println("Warning:", warning)
+	}
+	for _, warning := range checkAliases(file.Aliases) {
+		fmt.Println("Warning:", warning)
+	}
+
+	if *writeProfile != "" {
+		if err := saveProfile(configPath(), *writeProfile, settings); err != nil {
+			fmt.Println("Error saving profile:", err)
+			return 1
+		}
+		fmt.Printf("Saved profile %q to %s\n", *writeProfile, configPath())
+		return 0
+	}
+
 	// Configure TLS settings
-	config := &tls.Config{
-		InsecureSkipVerify: true, // For testing purposes only, should use proper CA verification in production
+	tlsConfig, err := settings.tlsConfig()
+	if err != nil {
+		fmt.Println("Error configuring TLS:", err)
+		return 1
+	}
+	config := chatclient.Config{TLS: tlsConfig, Username: settings.Username, Timeout: *timeout, Heartbeat: *heartbeat, HeartbeatGrace: *heartbeatGrace}
+	if *proxyFlag != "" {
+		config.Proxy, err = url.Parse(*proxyFlag)
+	} else {
+		config.Proxy, err = chatclient.ProxyFromEnvironment()
+	}
+	if err != nil {
+		fmt.Println("Error parsing proxy URL:", err)
+		return 1
+	}
+
+	// Unix sockets are local and served without TLS.
+	addr := net.JoinHostPort(settings.Host, settings.Port)
+	if strings.HasPrefix(settings.Host, "unix://") {
+		addr = settings.Host
+		config.TLS = nil
+	}
+
+	if *printFingerprint {
+		return printServerFingerprint(addr, config)
+	}
+	if warn, err := checkInsecure(settings.Host, config.TLS, settings.Pin, *yesIKnow); err != nil {
+		fmt.Println(err)
+		return 1
+	} else if warn {
+		warnInsecure()
 	}
 
 	// Connect to server
-	connection, err := tls.Dial(SERVER_TYPE, SERVER_HOST+":"+SERVER_PORT, config)
+	client, err := dial(addr, config)
 	if err != nil {
 		fmt.Println("Error connecting to server:", err)
-		return
+		return 1
+	}
+	// client changes when the connection is dialed again.
+	defer func() { client.Close() }()
+
+	rooms := settings.Join
+	if *room != "" {
+		rooms = append(rooms, *room)
+	}
+	joiner := newAutoJoiner(rooms, *createMissing)
+	if *oneshot {
+		return runOneshot(client, settings.Username, joiner, *room, *pace)
+	}
+	if *listenOnly {
+		return runListenOnly(client, settings.Username, joiner)
 	}
-	defer connection.Close()
 
 	fmt.Println("Connected to chat server")
 
+	chatLog, err := newChatLog(*logDir)
+	if err != nil {
+		fmt.Println("Error opening log directory:", err)
+		return 1
+	}
+	defer chatLog.Close()
+
+	keywords := newKeywords(file.Keywords)
+	con := newConsole()
+	con.colors = settings.Colors
+	con.keywords = keywords
+	defer con.Close()
+	comp := newCompleter()
+	con.SetCompleter(comp.Complete)
+
 	// Create a channel to read input from the console
 	input := make(chan string)
-	go readInput(input)
+	go readInput(con, input)
 
-	// Create a channel to read messages from the server
-	messages := make(chan string)
-	go readMessages(connection, messages)
+	username := settings.Username
+	// nick is the name the user chose, which a new connection asks for
+	// again; username may be a guest name the server gave out.
+	nick := settings.Username
+	// activeRoom is the room the user is in, joined again after a
+	// reconnect.
+	activeRoom := ""
+	notify := newNotifier(*notifyCmd, *bell, keywords)
+	var ping pinger
+	var checker inputChecker
+
+	if command := joiner.Next(); command != "" {
+		client.Send(command)
+	}
 
+	messages := client.Messages()
+	lastMessage := ""
 	for {
 		select {
 		case msg := <-input:
-			if strings.TrimSpace(msg) == "/quit" {
-				fmt.Println("Disconnecting from chat server...")
-				return
-			}
-			_, err := connection.Write([]byte(msg + "\n"))
+			msg, err := expandAlias(file.Aliases, msg)
 			if err != nil {
-				fmt.Println("Error sending message:", err)
-				return
+				con.Println("Error:", err)
+				continue
+			}
+			command := ""
+			fields := strings.Fields(msg)
+			if len(fields) > 0 {
+				command = fields[0]
+			}
+			if command != "" && runLocal(localEnv{con: con, chatLog: chatLog, keywords: keywords, username: username}, fields) {
+				continue
+			}
+			if command == "/quit" {
+				con.Println("Disconnecting from chat server...")
+				client.Send(msg)
+				drainMessages(con, messages)
+				return 0
+			}
+			if warning := checker.Check(client, msg); warning != "" {
+				con.Println(warning)
+				continue
+			}
+			if command == "/ping" {
+				ping.Sent()
+			}
+			if err := sendInput(client, msg); err != nil {
+				con.Println("Error sending message:", err)
+				return 1
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				err := client.Err()
+				switch {
+				case lostConnection(err):
+					// Including a server that stopped answering
+					// the heartbeat, err says how long ago.
+					con.Println("Connection to server lost:", err)
+				case lastMessage != "":
+					con.Println("Disconnected by server:", lastMessage)
+					return 1
+				default:
+					con.Println("Connection to server lost:", err)
+					return 1
+				}
+				if !*autoReconnect {
+					return 1
+				}
+				config.Username = nick
+				if client = reconnect(con, addr, config, input); client == nil {
+					return 1
+				}
+				con.Println("Reconnected to chat server")
+				messages = client.Messages()
+				lastMessage = ""
+				joiner = joiner.Rejoin(activeRoom)
+				if command := joiner.Next(); command != "" {
+					client.Send(command)
+				}
+				continue
+			}
+			lastMessage = msg.Raw
+			if line := ping.Observe(msg); line != "" {
+				con.Println(line)
+				continue
 			}
-		case msg := <-messages:
-			fmt.Println(msg)
+			if strings.HasPrefix(msg.Raw, "WELCOME ") {
+				// The library keeps what it says; see inputChecker.
+				continue
+			}
+			if name, ok := strings.CutPrefix(msg.Raw, "Username set to "); ok {
+				username = name
+				nick = name
+			}
+			if name, ok := joinedRoom(msg); ok {
+				activeRoom = name
+			}
+			if rest, ok := strings.CutPrefix(msg.Raw, "Welcome! You are "); ok && username == "" {
+				username, _, _ = strings.Cut(rest, ".")
+			}
+			comp.Observe(msg)
+			con.PrintMessage(msg, username)
+			chatLog.Write(msg)
+			notify.Notify(msg, username)
+			if command := joiner.Observe(msg); command != "" {
+				client.Send(command)
+			}
+		}
+	}
+}
+
+// sendInput sends what the user typed. A pasted block goes out as one
+// message; chat too long for the server is split into numbered parts.
+func sendInput(client *chatclient.Client, msg string) error {
+	if strings.Contains(msg, "\n") {
+		return client.SendBlock(msg)
+	}
+	if strings.HasPrefix(msg, "/") {
+		return client.Send(msg)
+	}
+	for _, part := range splitMessage(msg, client.MaxLength()) {
+		if err := client.Send(part); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func readInput(input chan<- string) {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		input <- scanner.Text()
+// errAborted is the error of a dial that Ctrl-C aborted.
+var errAborted = errors.New("aborted")
+
+// dial connects like chatclient.DialContext, printing a progress note when
+// the attempt is slow and aborting it on Ctrl-C.
+func dial(addr string, config chatclient.Config) (*chatclient.Client, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	slow := time.AfterFunc(2*time.Second, func() {
+		fmt.Fprintf(os.Stderr, "Still connecting to %s (Ctrl-C to abort)...\n", addr)
+	})
+	defer slow.Stop()
+
+	client, err := chatclient.DialContext(ctx, addr, config)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return nil, errAborted
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading from console:", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %v", config.Timeout)
+	}
+	return client, err
+}
+
+// printServerFingerprint connects without verifying the server and prints
+// the fingerprint to use with -pin.
+func printServerFingerprint(addr string, config chatclient.Config) int {
+	config.TLS = &tls.Config{InsecureSkipVerify: true}
+	config.Username = ""
+	client, err := dial(addr, config)
+	if err != nil {
+		fmt.Println("Error connecting to server:", err)
+		return 1
+	}
+	defer client.Close()
+	state, ok := client.ConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		fmt.Println("Server did not present a certificate")
+		return 1
+	}
+	fmt.Println(chatclient.Fingerprint(state.PeerCertificates[0]))
+	return 0
+}
+
+// drainMessages prints what the server sends until it closes the connection,
+// giving up after a short wait.
+func drainMessages(con *console, messages <-chan chatclient.Message) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			con.Println(msg.Raw)
+		case <-timeout:
+			return
+		}
 	}
 }
 
-func readMessages(conn net.Conn, messages chan<- string) {
-	reader := bufio.NewReader(conn)
+func readInput(con *console, input chan<- string) {
 	for {
-		message, err := reader.ReadString('\n')
+		line, err := con.ReadLine()
+		if err == io.EOF && con.Interactive() {
+			// Ctrl-C or Ctrl-D on an empty line
+			input <- "/quit"
+			return
+		}
 		if err != nil {
-			fmt.Println("Error reading from server:", err)
-			close(messages)
+			if err != io.EOF {
+				con.Println("Error reading from console:", err)
+			}
 			return
 		}
-		messages <- strings.TrimSpace(message)
+		if strings.TrimSpace(line) == "/paste" {
+			block, more := readPaste(con)
+			if block != "" {
+				input <- block
+			}
+			if !more {
+				return
+			}
+			continue
+		}
+		input <- line
+	}
+}
+
+// readPaste collects lines until a lone "." or Ctrl-D and returns them as
+// one block, reporting whether there is more input to read.
+func readPaste(con *console) (string, bool) {
+	con.Println("Paste mode: end with a line containing only . or Ctrl-D.")
+	var lines []string
+	for {
+		line, err := con.ReadLine()
+		if err == io.EOF && con.Interactive() {
+			return strings.Join(lines, "\n"), true
+		}
+		if err != nil {
+			return strings.Join(lines, "\n"), false
+		}
+		if line == "." {
+			return strings.Join(lines, "\n"), true
+		}
+		lines = append(lines, line)
 	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"final_project/chatclient"
+)
+
+var COMMANDS = []string{"/8ball", "/buffer", "/clear", "/create", "/export", "/flip", "/help", "/history", "/join", "/last", "/list", "/log", "/nick", "/notify", "/paste", "/ping", "/quit", "/roll", "/who"}
+
+// completer offers tab completion for commands, room names and usernames.
+// Names are learned from the messages the server sends.
+type completer struct {
+	mu    sync.Mutex
+	users map[string]bool
+	rooms map[string]bool
+	cycle *completion
+}
+
+// completion is the state kept between repeated Tab presses.
+type completion struct {
+	head       string
+	tail       string
+	candidates []string
+	index      int
+	line       string
+	pos        int
+}
+
+func newCompleter() *completer {
+	return &completer{users: make(map[string]bool), rooms: make(map[string]bool)}
+}
+
+// Observe updates the known users and rooms from a server message.
+func (c *completer) Observe(msg chatclient.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg.Room != "" {
+		c.rooms[msg.Room] = true
+	}
+	if msg.User != "" {
+		c.users[msg.User] = true
+	}
+	if msg.Notice {
+		name, rest, ok := cutQuoted(msg.Text)
+		if !ok {
+			return
+		}
+		switch {
+		case strings.HasPrefix(rest, " left"):
+			delete(c.users, name)
+		case strings.HasPrefix(rest, " is now known as "):
+			delete(c.users, name)
+			if newName, _, ok := cutQuoted(strings.TrimPrefix(rest, " is now known as ")); ok {
+				c.users[newName] = true
+			}
+		default:
+			c.users[name] = true
+		}
+		return
+	}
+	if rest, ok := strings.CutPrefix(msg.Raw, "Users in "); ok {
+		if _, names, ok := strings.Cut(rest, ": "); ok {
+			for _, name := range strings.Split(names, ", ") {
+				c.users[name] = true
+			}
+		}
+	}
+	if rest, ok := strings.CutPrefix(msg.Raw, "Rooms: "); ok {
+		for _, entry := range strings.Split(rest, ", ") {
+			name, _, _ := strings.Cut(entry, " (")
+			c.rooms[name] = true
+		}
+	}
+	if name, ok := strings.CutPrefix(msg.Raw, "Created and joined room "); ok {
+		c.rooms[name] = true
+	}
+	if name, ok := strings.CutPrefix(msg.Raw, "Joined room "); ok {
+		c.rooms[name] = true
+	}
+}
+
+// Complete is used as the terminal's AutoCompleteCallback.
+func (c *completer) Complete(line string, pos int, key rune) (string, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key != '\t' {
+		c.cycle = nil
+		return "", 0, false
+	}
+	if c.cycle != nil && c.cycle.line == line && c.cycle.pos == pos {
+		c.cycle.index = (c.cycle.index + 1) % len(c.cycle.candidates)
+		return c.cycle.apply()
+	}
+	head := line[:pos]
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+	candidates := filterPrefix(c.pool(head[:start], word), word)
+	if len(candidates) == 0 {
+		c.cycle = nil
+		return "", 0, false
+	}
+	c.cycle = &completion{head: head[:start], tail: line[pos:], candidates: candidates}
+	return c.cycle.apply()
+}
+
+func (c *completer) pool(before, word string) []string {
+	if before == "" && strings.HasPrefix(word, "/") {
+		return COMMANDS
+	}
+	if strings.HasPrefix(word, "@") {
+		return prefixAll(keys(c.users), "@")
+	}
+	fields := strings.Fields(before)
+	if len(fields) != 1 {
+		return nil
+	}
+	switch fields[0] {
+	case "/join":
+		return keys(c.rooms)
+	case "/msg":
+		return keys(c.users)
+	}
+	return nil
+}
+
+func (s *completion) apply() (string, int, bool) {
+	candidate := s.candidates[s.index]
+	if !strings.HasPrefix(s.tail, " ") {
+		candidate += " "
+	}
+	s.line = s.head + candidate + s.tail
+	s.pos = len(s.head) + len(candidate)
+	return s.line, s.pos, true
+}
+
+func cutQuoted(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, "\"") {
+		return "", "", false
+	}
+	end := strings.Index(text[1:], "\"")
+	if end < 0 {
+		return "", "", false
+	}
+	return text[1 : end+1], text[end+2:], true
+}
+
+func keys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func prefixAll(values []string, prefix string) []string {
+	for i, value := range values {
+		values[i] = prefix + value
+	}
+	return values
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var result []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
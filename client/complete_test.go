@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"final_project/chatclient"
+)
+
+// testCompleter returns a completer that has seen lines from the server.
+func testCompleter(lines ...string) *completer {
+	c := newCompleter()
+	for _, line := range lines {
+		c.Observe(chatclient.ParseLine(line))
+	}
+	return c
+}
+
+func TestComplete(t *testing.T) {
+	c := testCompleter(
+		"Rooms: golang (3), gophers (1), rust (2)",
+		"Users in golang: alice, albert, bob",
+		`[golang] Notice: "carol" joined the chat room.`,
+		`[golang] Notice: "bob" is now known as "bobby".`,
+		`[golang] Notice: "albert" left the chat room.`,
+	)
+	tests := []struct {
+		name string
+		line string
+		pos  int
+		want string
+		ok   bool
+	}{
+		{"command", "/jo", 3, "/join ", true},
+		{"ambiguous command takes the first", "/h", 2, "/help ", true},
+		{"unknown command", "/zz", 3, "", false},
+		{"command only first", "hi /jo", 6, "", false},
+		{"room", "/join go", 8, "/join golang ", true},
+		{"room not a user", "/join al", 8, "", false},
+		{"nick", "/msg al", 7, "/msg alice ", true},
+		{"renamed nick", "/msg bo", 7, "/msg bobby ", true},
+		{"joined nick", "/msg c", 6, "/msg carol ", true},
+		{"mention", "hi @ca", 6, "hi @carol ", true},
+		{"no completion in text", "hi al", 5, "", false},
+		{"middle of the line", "/msg al hi", 7, "/msg alice hi", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.Complete("", 0, 'x')
+			line, pos, ok := c.Complete(tt.line, tt.pos, '\t')
+			if line != tt.want || ok != tt.ok {
+				t.Errorf("Complete(%q, %d) = %q, %v, want %q, %v", tt.line, tt.pos, line, ok, tt.want, tt.ok)
+			}
+			// The cursor ends up after the completion, before the rest.
+			if wantPos := len(tt.want) - len(tt.line[tt.pos:]); ok && pos != wantPos {
+				t.Errorf("cursor at %d of %q, want %d", pos, line, wantPos)
+			}
+		})
+	}
+}
+
+func TestCompleteCycles(t *testing.T) {
+	c := testCompleter("Rooms: golang (3), gophers (1)")
+	line, pos := "/join go", 8
+	var got []string
+	for range 3 {
+		var ok bool
+		line, pos, ok = c.Complete(line, pos, '\t')
+		if !ok {
+			t.Fatal("no completion")
+		}
+		got = append(got, line)
+	}
+	want := []string{"/join golang ", "/join gophers ", "/join golang "}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Tab presses gave %q, want %q", got, want)
+		}
+	}
+	// Any other key ends the cycle; the next Tab starts from the line.
+	c.Complete(line, pos, 'x')
+	if line, _, _ := c.Complete("/join gop", 9, '\t'); line != "/join gophers " {
+		t.Errorf("after the cycle ended got %q", line)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"final_project/chatclient"
+)
+
+// Profile holds the connection settings for one server. It is also the
+// shape of each entry under "profiles" in the config file.
+type Profile struct {
+	Host     string            `json:"host,omitempty"`
+	Port     string            `json:"port,omitempty"`
+	Username string            `json:"username,omitempty"`
+	CAFile   string            `json:"cafile,omitempty"`
+	Insecure *bool             `json:"insecure,omitempty"`
+	Pin      string            `json:"pin,omitempty"`
+	Join     []string          `json:"join,omitempty"`
+	Colors   map[string]string `json:"colors,omitempty"`
+}
+
+type configFile struct {
+	Profiles map[string]Profile `json:"profiles"`
+	// Aliases map command names to what they stand for, both without the
+	// slash: "g": "join golang" makes /g send /join golang.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Keywords are the words set with /notify.
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+var profileKeys = map[string]bool{
+	"host": true, "port": true, "username": true, "cafile": true,
+	"insecure": true, "pin": true, "join": true, "colors": true,
+}
+
+var ENV_VARS = map[string]string{
+	"host":     "GOCHAT_HOST",
+	"port":     "GOCHAT_PORT",
+	"username": "GOCHAT_USER",
+	"cafile":   "GOCHAT_CAFILE",
+}
+
+func configPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gochat", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file is not an error.
+// Unknown keys are reported as warnings.
+func loadConfig(path string) (configFile, []string, error) {
+	config := configFile{Profiles: make(map[string]Profile)}
+	if path == "" {
+		return config, nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil, nil
+	}
+	if err != nil {
+		return config, nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return config, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var warnings []string
+	for key := range raw {
+		if key != "profiles" && key != "aliases" && key != "keywords" {
+			warnings = append(warnings, fmt.Sprintf("%s: unknown key %q", path, key))
+		}
+	}
+	var rawProfiles map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw["profiles"], &rawProfiles); err != nil && raw["profiles"] != nil {
+		return config, nil, fmt.Errorf("%s: profiles: %v", path, err)
+	}
+	for name, fields := range rawProfiles {
+		for key := range fields {
+			if !profileKeys[key] {
+				warnings = append(warnings, fmt.Sprintf("%s: profile %q: unknown key %q", path, name, key))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	return config, warnings, nil
+}
+
+// saveProfile stores profile under name, keeping the other profiles in the file.
+func saveProfile(path, name string, profile Profile) error {
+	if path == "" {
+		return errors.New("no config directory available")
+	}
+	config, _, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	config.Profiles[name] = profile
+	return writeConfig(path, config)
+}
+
+// saveKeywords stores the /notify keywords, keeping the rest of the file.
+func saveKeywords(path string, words []string) error {
+	if path == "" {
+		return errors.New("no config directory available")
+	}
+	config, _, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	config.Keywords = words
+	return writeConfig(path, config)
+}
+
+func writeConfig(path string, config configFile) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func defaultProfile() Profile {
+	insecure := true // For testing purposes only, should use proper CA verification in production
+	return Profile{Host: SERVER_HOST, Port: SERVER_PORT, Insecure: &insecure}
+}
+
+// resolveSettings merges the settings sources. Later sources win:
+// defaults, then the profile, then environment variables, then flags that
+// were set explicitly on the command line.
+func resolveSettings(profile Profile, getenv func(string) string, flags *flag.FlagSet) Profile {
+	settings := defaultProfile()
+	settings.merge(profile)
+
+	env := Profile{
+		Host:     getenv(ENV_VARS["host"]),
+		Port:     getenv(ENV_VARS["port"]),
+		Username: getenv(ENV_VARS["username"]),
+		CAFile:   getenv(ENV_VARS["cafile"]),
+	}
+	settings.merge(env)
+
+	explicit := Profile{}
+	flags.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "host":
+			explicit.Host = value
+		case "port":
+			explicit.Port = value
+		case "user":
+			explicit.Username = value
+		case "cafile":
+			explicit.CAFile = value
+		case "pin":
+			explicit.Pin = value
+		case "join":
+			explicit.Join = strings.Split(value, ",")
+		case "insecure":
+			insecure := value == "true"
+			explicit.Insecure = &insecure
+		}
+	})
+	settings.merge(explicit)
+	return settings
+}
+
+// merge copies the fields that are set in other onto p.
+func (p *Profile) merge(other Profile) {
+	if other.Host != "" {
+		p.Host = other.Host
+	}
+	if other.Port != "" {
+		p.Port = other.Port
+	}
+	if other.Username != "" {
+		p.Username = other.Username
+	}
+	if other.CAFile != "" {
+		p.CAFile = other.CAFile
+	}
+	if other.Insecure != nil {
+		p.Insecure = other.Insecure
+	}
+	if other.Pin != "" {
+		p.Pin = other.Pin
+	}
+	if other.Join != nil {
+		p.Join = other.Join
+	}
+	if other.Colors != nil {
+		p.Colors = other.Colors
+	}
+}
+
+// tlsConfig verifies the server against CAFile when one is given, and
+// otherwise skips verification only if Insecure is set. A Pin is checked
+// in addition to CAFile, or instead of it.
+func (p Profile) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: p.Host}
+	if p.CAFile != "" {
+		pem, err := os.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", p.CAFile)
+		}
+		config.RootCAs = pool
+	} else {
+		config.InsecureSkipVerify = p.Insecure != nil && *p.Insecure
+	}
+	if p.Pin != "" {
+		if err := chatclient.PinTLS(config, p.Pin); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+// testFlags returns the settings flags of run, parsed from args.
+func testFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.String("host", SERVER_HOST, "")
+	flags.String("port", SERVER_PORT, "")
+	flags.String("user", "", "")
+	flags.String("cafile", "", "")
+	flags.Bool("insecure", true, "")
+	flags.String("join", "", "")
+	flags.String("pin", "", "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestResolveSettingsPrecedence(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name    string
+		profile Profile
+		env     map[string]string
+		args    []string
+		want    Profile
+	}{
+		{
+			name: "defaults",
+			want: Profile{Host: SERVER_HOST, Port: SERVER_PORT, Insecure: &yes},
+		},
+		{
+			name:    "profile over default",
+			profile: Profile{Host: "profile.example", Username: "pat", Insecure: &no, Join: []string{"a"}},
+			want:    Profile{Host: "profile.example", Port: SERVER_PORT, Username: "pat", Insecure: &no, Join: []string{"a"}},
+		},
+		{
+			name:    "env over profile",
+			profile: Profile{Host: "profile.example", Port: "1111", Username: "pat"},
+			env:     map[string]string{"GOCHAT_HOST": "env.example", "GOCHAT_USER": "eve"},
+			want:    Profile{Host: "env.example", Port: "1111", Username: "eve", Insecure: &yes},
+		},
+		{
+			name:    "flag over env",
+			profile: Profile{Host: "profile.example", CAFile: "profile.pem"},
+			env:     map[string]string{"GOCHAT_HOST": "env.example", "GOCHAT_PORT": "2222", "GOCHAT_CAFILE": "env.pem"},
+			args:    []string{"-host", "flag.example", "-cafile", "flag.pem"},
+			want:    Profile{Host: "flag.example", Port: "2222", CAFile: "flag.pem", Insecure: &yes},
+		},
+		{
+			name:    "flag set to its default",
+			profile: Profile{Port: "1111", Insecure: &no},
+			env:     map[string]string{"GOCHAT_PORT": "2222"},
+			args:    []string{"-port", SERVER_PORT, "-insecure=true"},
+			want:    Profile{Host: SERVER_HOST, Port: SERVER_PORT, Insecure: &yes},
+		},
+		{
+			name:    "flags the environment has no variable for",
+			profile: Profile{Pin: "profile-pin", Join: []string{"a"}},
+			args:    []string{"-pin", "flag-pin", "-join", "b,c"},
+			want:    Profile{Host: SERVER_HOST, Port: SERVER_PORT, Insecure: &yes, Pin: "flag-pin", Join: []string{"b", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(name string) string { return tt.env[name] }
+			got := resolveSettings(tt.profile, getenv, testFlags(t, tt.args...))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v (insecure %v), want %+v (insecure %v)", got, *got.Insecure, tt.want, *tt.want.Insecure)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+
+	"final_project/chatclient"
+)
+
+const (
+	HISTORY_SIZE = 500
+	// DEFAULT_SCROLLBACK is how many messages /last can show again until
+	// /buffer changes it.
+	DEFAULT_SCROLLBACK = 1000
+)
+
+var COLORS = map[string]string{
+	"black": "30", "red": "31", "green": "32", "yellow": "33",
+	"blue": "34", "magenta": "35", "cyan": "36", "white": "37",
+}
+
+// COLOR_KEYS are the kinds of message a color can be configured for.
+var COLOR_KEYS = map[string]bool{"notice": true, "chat": true, "server": true, "mention": true, "pm": true}
+
+// console reads user input and prints output. On a terminal it runs in raw
+// mode with line editing and history; otherwise it falls back to a plain
+// line scanner.
+type console struct {
+	terminal *term.Terminal
+	state    *term.State
+	history  *history
+	scanner  *bufio.Scanner
+	colors   map[string]string
+	// width is the terminal's width in columns, kept up to date as the
+	// window is resized.
+	width atomic.Int32
+	// day is the date of the last message printed; a marker line is shown
+	// when it changes.
+	day string
+	// scrollback holds the most recent messages for /last.
+	scrollback scrollback
+	// keywords highlight messages like mentions do.
+	keywords *keywords
+}
+
+func newConsole() *console {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return &console{scanner: bufio.NewScanner(os.Stdin), scrollback: scrollback{size: DEFAULT_SCROLLBACK}}
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return &console{scanner: bufio.NewScanner(os.Stdin), scrollback: scrollback{size: DEFAULT_SCROLLBACK}}
+	}
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "> ")
+	h := loadHistory(historyPath())
+	terminal.History = h
+	c := &console{terminal: terminal, state: state, history: h, scrollback: scrollback{size: DEFAULT_SCROLLBACK}}
+	c.resize()
+	watchResize(c.resize)
+	return c
+}
+
+// resize picks up the terminal's current size.
+func (c *console) resize() {
+	width, height, err := term.GetSize(int(os.Stdin.Fd()))
+	if err != nil || width <= 0 {
+		return
+	}
+	c.terminal.SetSize(width, height)
+	c.width.Store(int32(width))
+}
+
+// ReadLine returns the next line of input. On a terminal, Ctrl-C and Ctrl-D
+// on an empty line are reported as io.EOF.
+func (c *console) ReadLine() (string, error) {
+	if c.terminal != nil {
+		return c.terminal.ReadLine()
+	}
+	if c.scanner.Scan() {
+		return c.scanner.Text(), nil
+	}
+	if err := c.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// SetCompleter installs a tab completion callback on terminals.
+func (c *console) SetCompleter(complete func(line string, pos int, key rune) (string, int, bool)) {
+	if c.terminal != nil {
+		c.terminal.AutoCompleteCallback = complete
+	}
+}
+
+func (c *console) Interactive() bool {
+	return c.terminal != nil
+}
+
+func (c *console) Println(a ...any) {
+	if c.terminal != nil {
+		fmt.Fprintln(c.terminal, a...)
+		return
+	}
+	fmt.Println(a...)
+}
+
+// PrintMessage prints a server message, colored by kind and wrapped to the
+// window on terminals, and keeps it for /last. Messages mentioning
+// username or containing a /notify keyword are highlighted with the
+// "mention" color.
+func (c *console) PrintMessage(msg chatclient.Message, username string) {
+	c.markDay(msg)
+	c.scrollback.add(msg)
+	c.show(msg, username)
+}
+
+// Reprint prints the last n messages again, as /last does.
+func (c *console) Reprint(n int, username string) {
+	for _, msg := range c.scrollback.last(n) {
+		c.show(msg, username)
+	}
+}
+
+// Clear clears the screen on terminals.
+func (c *console) Clear() {
+	if c.terminal != nil {
+		c.terminal.Write([]byte("\x1b[H\x1b[2J"))
+	}
+}
+
+func (c *console) show(msg chatclient.Message, username string) {
+	kind := "server"
+	switch {
+	case msg.PM:
+		kind = "pm"
+	case msg.Notice:
+		kind = "notice"
+	case msg.User != "" && msg.User != username && mentions(msg.Text, username):
+		kind = "mention"
+	case c.keywords.matchMessage(msg, username) != "":
+		kind = "mention"
+	case msg.User != "":
+		kind = "chat"
+	}
+	text := msg.Raw
+	if width := int(c.width.Load()); width > 0 {
+		text = wrapText(text, width, messageIndent(msg))
+	}
+	code, ok := COLORS[c.colors[kind]]
+	if c.terminal == nil || !ok {
+		c.Println(text)
+		return
+	}
+	c.Println("\x1b[" + code + "m" + text + "\x1b[0m")
+}
+
+// markDay prints a date line before the first message of a new day, so
+// times such as 3:04PM stay unambiguous when the session spans midnight.
+// Messages with an RFC3339 time, as in JSON mode, are dated by it.
+func (c *console) markDay(msg chatclient.Message) {
+	stamp := time.Now()
+	if t, err := time.Parse(time.RFC3339, msg.Time); err == nil {
+		stamp = t.Local()
+	}
+	day := stamp.Format("Monday, January 2, 2006")
+	if c.day != "" && day != c.day {
+		c.Println("--- " + day + " ---")
+	}
+	c.day = day
+}
+
+func checkColors(colors map[string]string) []string {
+	var warnings []string
+	for kind, color := range colors {
+		if !COLOR_KEYS[kind] {
+			warnings = append(warnings, fmt.Sprintf("unknown color key %q", kind))
+		} else if _, ok := COLORS[color]; !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown color %q for %s", color, kind))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// Close restores the terminal state and saves the input history.
+func (c *console) Close() {
+	if c.terminal == nil {
+		return
+	}
+	term.Restore(int(os.Stdin.Fd()), c.state)
+	c.history.save(historyPath())
+}
+
+func historyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gochat", "history")
+}
+
+// history keeps the most recent HISTORY_SIZE lines, oldest first.
+type history struct {
+	entries []string
+}
+
+func loadHistory(path string) *history {
+	h := &history{}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.Add(line)
+		}
+	}
+	return h
+}
+
+func (h *history) Add(entry string) {
+	if strings.TrimSpace(entry) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == entry {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > HISTORY_SIZE {
+		h.entries = h.entries[len(h.entries)-HISTORY_SIZE:]
+	}
+}
+
+func (h *history) Len() int {
+	return len(h.entries)
+}
+
+func (h *history) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+func (h *history) save(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+}
+
+// scrollback keeps the most recent size messages, oldest first. Up to as
+// many again are left in messages before the old ones are copied out.
+type scrollback struct {
+	messages []chatclient.Message
+	size     int
+}
+
+func (s *scrollback) add(msg chatclient.Message) {
+	s.messages = append(s.messages, msg)
+	if len(s.messages) >= 2*s.size {
+		s.trim()
+	}
+}
+
+// resize changes how many messages are kept, dropping the oldest if there
+// are too many.
+func (s *scrollback) resize(size int) {
+	s.size = size
+	s.trim()
+}
+
+func (s *scrollback) trim() {
+	s.messages = append([]chatclient.Message(nil), s.last(s.size)...)
+}
+
+// last returns up to n of the most recent messages, oldest first.
+func (s *scrollback) last(n int) []chatclient.Message {
+	n = min(n, s.size)
+	return s.messages[max(len(s.messages)-n, 0):]
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CLIENT_CHILD is set in the environment of the test binary that
+// TestHangupExits starts, which then runs the client against the address
+// in it.
+const CLIENT_CHILD = "GOCHAT_CLIENT_CHILD"
+
+// MAX_HANGUP_CPU is the processor time the client may use from start to
+// exit. A read loop that spins on the closed connection burns a whole core
+// until it is killed.
+const MAX_HANGUP_CPU = 500 * time.Millisecond
+
+// TestClientChild runs only in the child: it is the client, connecting
+// over the Unix socket named by CLIENT_CHILD.
+func TestClientChild(t *testing.T) {
+	addr := os.Getenv(CLIENT_CHILD)
+	if addr == "" {
+		t.Skip("only run by TestHangupExits")
+	}
+	os.Args = []string{"client", "-host", addr, "-heartbeat", "0"}
+	os.Exit(run())
+}
+
+func TestHangupExits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestClientChild$")
+	cmd.Env = append(os.Environ(), CLIENT_CHILD+"=unix://"+path, "XDG_CONFIG_HOME="+t.TempDir())
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.HasPrefix(line, "HELLO ") {
+		t.Fatalf("client sent %q, %v, want its HELLO", line, err)
+	}
+	conn.Write([]byte("Server is shutting down\n"))
+	conn.Close()
+
+	select {
+	case err = <-exited:
+	case <-time.After(3 * time.Second):
+		cmd.Process.Kill()
+		err = <-exited
+		t.Errorf("client still running after the server hung up")
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("client ended with %v, want exit status 1", err)
+	}
+	state := cmd.ProcessState
+	if used := state.UserTime() + state.SystemTime(); used > MAX_HANGUP_CPU {
+		t.Errorf("client used %v of processor time, want at most %v; its read loop spins", used, MAX_HANGUP_CPU)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// warnInsecure prints the one-line warning about -insecure, in yellow on a
+// terminal.
+func warnInsecure() {
+	line := "Warning: the server's certificate is not checked (-insecure); use -cafile or -pin."
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		line = "\x1b[33m" + line + "\x1b[0m"
+	}
+	fmt.Println(line)
+}
+
+// checkInsecure decides about connecting to host with tlsConfig. It
+// refuses when the certificate would go unchecked and host is not this
+// machine, unless yesIKnow, and otherwise reports whether to warnInsecure.
+// A pinned key counts as checked.
+func checkInsecure(host string, tlsConfig *tls.Config, pin string, yesIKnow bool) (bool, error) {
+	if tlsConfig == nil || !tlsConfig.InsecureSkipVerify || pin != "" {
+		return false, nil
+	}
+	if !localHost(host) && !yesIKnow {
+		return false, fmt.Errorf("Refusing to connect to %s without checking its certificate. Use -cafile or -pin, or add -yes-i-know to connect anyway.", host)
+	}
+	return true, nil
+}
+
+// localHost reports whether host names this machine, where skipping the
+// certificate check cannot let anyone in between.
+func localHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestLocalHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"example.com", false},
+		{"localhost.example.com", false},
+		{"10.0.0.1", false},
+		{"::", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := localHost(tt.host); got != tt.want {
+			t.Errorf("localHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCheckInsecure(t *testing.T) {
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	tests := []struct {
+		name     string
+		host     string
+		tls      *tls.Config
+		pin      string
+		yesIKnow bool
+		warn     bool
+		refused  bool
+	}{
+		{"localhost", "localhost", insecure, "", false, true, false},
+		{"loopback address", "127.0.0.1", insecure, "", false, true, false},
+		{"remote refused", "chat.example.com", insecure, "", false, false, true},
+		{"remote with -yes-i-know", "chat.example.com", insecure, "", true, true, false},
+		{"remote with -pin", "chat.example.com", insecure, "ab:cd", false, false, false},
+		{"remote with -cafile", "chat.example.com", &tls.Config{}, "", false, false, false},
+		{"unix socket", "unix:///tmp/chat.sock", nil, "", false, false, false},
+	}
+	for _, tt := range tests {
+		warn, err := checkInsecure(tt.host, tt.tls, tt.pin, tt.yesIKnow)
+		if warn != tt.warn || (err != nil) != tt.refused {
+			t.Errorf("%s: got %v, %v, want warn %v, refused %v", tt.name, warn, err, tt.warn, tt.refused)
+		}
+	}
+}
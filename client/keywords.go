@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"final_project/chatclient"
+)
+
+// MAX_KEYWORDS caps the words /notify add keeps.
+const MAX_KEYWORDS = 20
+
+// keywords are the words that highlight and notify like a mention of the
+// user, set with /notify and kept in the config file. Every message is
+// matched against them, so they are compiled into a single pattern.
+type keywords struct {
+	words   []string
+	pattern *regexp.Regexp
+}
+
+func newKeywords(words []string) *keywords {
+	k := &keywords{}
+	for _, word := range words {
+		if word = cleanKeyword(word); word != "" && !slices.Contains(k.words, word) && len(k.words) < MAX_KEYWORDS {
+			k.words = append(k.words, word)
+		}
+	}
+	k.compile()
+	return k
+}
+
+// compile builds the pattern matching any of the words as a whole word,
+// ignoring case. Word boundaries are the same as for @mentions.
+func (k *keywords) compile() {
+	if len(k.words) == 0 {
+		k.pattern = nil
+		return
+	}
+	quoted := make([]string, len(k.words))
+	for i, word := range k.words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	k.pattern = regexp.MustCompile(`(?i)(?:^|[^\pL\pN_-])(` + strings.Join(quoted, "|") + `)(?:$|[^\pL\pN_-])`)
+}
+
+// Match returns the keyword text contains, or "" if there is none.
+func (k *keywords) Match(text string) string {
+	if k == nil || k.pattern == nil {
+		return ""
+	}
+	m := k.pattern.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// matchMessage returns the keyword in a chat message from someone else.
+func (k *keywords) matchMessage(msg chatclient.Message, username string) string {
+	if msg.PM || msg.Notice || msg.User == "" || msg.User == username {
+		return ""
+	}
+	return k.Match(msg.Text)
+}
+
+// cleanKeyword lowercases word, which must consist of letters, digits,
+// underscores and dashes only.
+func cleanKeyword(word string) string {
+	for _, r := range word {
+		if !isNameRune(r) {
+			return ""
+		}
+	}
+	return strings.ToLower(word)
+}
+
+func runNotify(env localEnv, args []string) error {
+	k := env.keywords
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if len(k.words) == 0 {
+			env.con.Println("You have no notification keywords. Add one with /notify add [word].")
+		} else {
+			env.con.Println("Notification keywords: " + strings.Join(k.words, ", "))
+		}
+		return nil
+	case args[0] == "add" && len(args) == 2:
+		word := cleanKeyword(args[1])
+		if word == "" {
+			return errors.New("A keyword may only contain letters, digits, _ and -.")
+		}
+		if slices.Contains(k.words, word) {
+			return fmt.Errorf("%s is already a notification keyword.", word)
+		}
+		if len(k.words) >= MAX_KEYWORDS {
+			return fmt.Errorf("You can have at most %d notification keywords.", MAX_KEYWORDS)
+		}
+		k.words = append(k.words, word)
+		k.compile()
+		env.con.Println("Messages containing " + word + " will notify you.")
+	case args[0] == "remove" && len(args) == 2:
+		word := strings.ToLower(args[1])
+		i := slices.Index(k.words, word)
+		if i < 0 {
+			return fmt.Errorf("%s is not a notification keyword.", word)
+		}
+		k.words = slices.Delete(k.words, i, i+1)
+		k.compile()
+		env.con.Println("Removed the notification keyword " + word + ".")
+	default:
+		return errUsage
+	}
+	if err := saveKeywords(configPath(), k.words); err != nil {
+		return fmt.Errorf("The keywords could not be saved: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// MAX_SCROLLBACK caps /buffer.
+const MAX_SCROLLBACK = 100000
+
+// localCommands run in the client and are never sent to the server. Other
+// commands, including ones the client does not know, go to the server.
+var localCommands = map[string]localCommand{
+	"/buffer": {"/buffer [lines]", runBuffer},
+	"/clear":  {"/clear", runClear},
+	"/last":   {"/last [count]", runLast},
+	"/log":    {"/log [on|off]", runLog},
+	"/notify": {"/notify add|remove [word] or /notify list", runNotify},
+}
+
+type localCommand struct {
+	usage string
+	run   func(env localEnv, args []string) error
+}
+
+// localEnv is what local commands act on.
+type localEnv struct {
+	con      *console
+	chatLog  *chatLog
+	keywords *keywords
+	username string
+}
+
+// runLocal runs the local command named by fields[0], reporting whether
+// there was one.
+func runLocal(env localEnv, fields []string) bool {
+	command, ok := localCommands[fields[0]]
+	if !ok {
+		return false
+	}
+	if err := command.run(env, fields[1:]); err == errUsage {
+		env.con.Println("Usage:", command.usage)
+	} else if err != nil {
+		env.con.Println(err)
+	}
+	return true
+}
+
+// errUsage makes runLocal show the command's usage.
+var errUsage = errors.New("usage")
+
+func runClear(env localEnv, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	env.con.Clear()
+	return nil
+}
+
+func runLast(env localEnv, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return errUsage
+	}
+	env.con.Reprint(n, env.username)
+	return nil
+}
+
+func runBuffer(env localEnv, args []string) error {
+	if len(args) == 0 {
+		env.con.Println(fmt.Sprintf("The scrollback keeps %d messages.", env.con.scrollback.size))
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if len(args) != 1 || err != nil || n < 1 || n > MAX_SCROLLBACK {
+		return fmt.Errorf("The scrollback size must be a number from 1 to %d.", MAX_SCROLLBACK)
+	}
+	env.con.scrollback.resize(n)
+	env.con.Println(fmt.Sprintf("The scrollback keeps %d messages now.", n))
+	return nil
+}
+
+func runLog(env localEnv, args []string) error {
+	env.con.Println(env.chatLog.Command(args))
+	return nil
+}
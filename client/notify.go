@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+
+	"final_project/chatclient"
+)
+
+const NOTIFY_INTERVAL = 3 * time.Second
+
+// mentions reports whether text contains @username as a whole word,
+// ignoring case.
+func mentions(text, username string) bool {
+	if username == "" {
+		return false
+	}
+	target := "@" + strings.ToLower(username)
+	lower := strings.ToLower(text)
+	for i := strings.Index(lower, target); i >= 0; {
+		end := i + len(target)
+		before := i == 0 || !isNameRune(lastRune(lower[:i]))
+		after := end == len(lower) || !isNameRune(firstRune(lower[end:]))
+		if before && after {
+			return true
+		}
+		next := strings.Index(lower[i+1:], target)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+func firstRune(s string) rune {
+	for _, r := range s {
+		return r
+	}
+	return 0
+}
+
+func lastRune(s string) rune {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return 0
+	}
+	return runes[len(runes)-1]
+}
+
+// notifier runs an external command and/or rings the terminal bell for
+// private messages, mentions and keywords, at most once per
+// NOTIFY_INTERVAL.
+type notifier struct {
+	command  []string
+	bell     bool
+	keywords *keywords
+	last     time.Time
+}
+
+func newNotifier(command string, bell bool, keywords *keywords) *notifier {
+	return &notifier{command: strings.Fields(command), bell: bell, keywords: keywords}
+}
+
+// Notify is called for every incoming message; it never blocks on the
+// notification command. For a keyword the command gets the message text
+// after the keyword in brackets, e.g. "[deploy] deploy is done".
+func (n *notifier) Notify(msg chatclient.Message, username string) {
+	if len(n.command) == 0 && !n.bell {
+		return
+	}
+	text := msg.Text
+	if !msg.PM && (msg.User == "" || msg.User == username || !mentions(msg.Text, username)) {
+		keyword := n.keywords.matchMessage(msg, username)
+		if keyword == "" {
+			return
+		}
+		text = "[" + keyword + "] " + text
+	}
+	if time.Since(n.last) < NOTIFY_INTERVAL {
+		return
+	}
+	n.last = time.Now()
+	if n.bell {
+		os.Stdout.Write([]byte("\a"))
+	}
+	if len(n.command) > 0 {
+		args := append(append([]string{}, n.command[1:]...), msg.User, text)
+		cmd := exec.Command(n.command[0], args...)
+		go func() {
+			if err := cmd.Run(); err != nil {
+				os.Stderr.WriteString("notify command failed: " + err.Error() + "\n")
+			}
+		}()
+	}
+}
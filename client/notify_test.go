@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"final_project/chatclient"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"@alice hi", true},
+		{"hi @alice", true},
+		{"hi @alice, how are you?", true},
+		{"(@alice)", true},
+		{"hi @ALICE", true},
+		{"hi @Alice!", true},
+		{"hi alice", false},
+		{"hi @alicex", false},
+		{"hi @alice_2", false},
+		{"hi @alice-bob", false},
+		{"mail bob@alice", false},
+		{"x@alice", false},
+		{"@alicex then @alice", true},
+		{"@ali", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := mentions(tt.text, "alice"); got != tt.want {
+			t.Errorf("mentions(%q, alice) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+	if !mentions("hi @ÄSA", "äsa") {
+		t.Error("a mention in other letters is not case folded")
+	}
+	if mentions("hi @", "") {
+		t.Error("an empty username is mentioned")
+	}
+}
+
+// notifyScript returns a notify command that appends its arguments to a
+// file, and a function that reads the lines written so far.
+func notifyScript(t *testing.T) (string, func() []string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "notify")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1|$2\" >> "+out+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return script, func() []string {
+		data, _ := os.ReadFile(out)
+		if len(data) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+}
+
+func TestNotifyMentionsFromOthers(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  chatclient.Message
+		want bool
+	}{
+		{"mention", chatclient.Message{Room: "r", User: "bob", Text: "hi @alice"}, true},
+		{"mention other case", chatclient.Message{Room: "r", User: "bob", Text: "hi @Alice"}, true},
+		{"no mention", chatclient.Message{Room: "r", User: "bob", Text: "hi alice"}, false},
+		{"own message", chatclient.Message{Room: "r", User: "alice", Text: "I am @alice"}, false},
+		{"notice", chatclient.Message{Room: "r", Notice: true, Text: `"@alice" joined`}, false},
+		{"private message", chatclient.Message{PM: true, User: "bob", Text: "psst"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, lines := notifyScript(t)
+			n := newNotifier(script, false, newKeywords(nil))
+			n.Notify(tt.msg, "alice")
+			if !tt.want {
+				time.Sleep(100 * time.Millisecond)
+				if got := lines(); len(got) != 0 {
+					t.Errorf("notified %q", got)
+				}
+				return
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for len(lines()) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("not notified")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			want := tt.msg.User + "|" + tt.msg.Text
+			if got := lines(); len(got) != 1 || got[0] != want {
+				t.Errorf("notified %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+const (
+	SETUP_TIMEOUT = 10 * time.Second
+	DRAIN_TIMEOUT = 5 * time.Second
+)
+
+// setup waits until the username was accepted (when one was requested)
+// and every auto-join room was tried, passing each message to observe.
+func setup(client *chatclient.Client, username string, joiner *autoJoiner, observe func(chatclient.Message)) error {
+	if command := joiner.Next(); command != "" {
+		client.Send(command)
+	}
+	nickPending := username != ""
+	timeout := time.After(SETUP_TIMEOUT)
+	for nickPending || joiner.Pending() {
+		select {
+		case msg, ok := <-client.Messages():
+			if !ok {
+				return fmt.Errorf("connection closed: %v", client.Err())
+			}
+			observe(msg)
+			switch {
+			case strings.HasPrefix(msg.Raw, "Username set to "):
+				nickPending = false
+			case msg.Code == codes.ERR_BAD_NAME, msg.Code == codes.ERR_NAME_TAKEN:
+				return errors.New(msg.Text)
+			}
+			if command := joiner.Observe(msg); command != "" {
+				client.Send(command)
+			}
+		case <-timeout:
+			return errors.New("timed out waiting for the server")
+		}
+	}
+	return nil
+}
+
+// runOneshot sends every line of stdin to the room and exits. Lines are
+// spaced by pace, and the session ends with /quit so that the server's
+// goodbye confirms everything before it was handled.
+func runOneshot(client *chatclient.Client, username string, joiner *autoJoiner, room string, pace time.Duration) int {
+	if err := setup(client, username, joiner, func(chatclient.Message) {}); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if room != "" && !joiner.Joined(room) {
+		fmt.Fprintf(os.Stderr, "Error: could not join room %s\n", room)
+		return 1
+	}
+	if len(joiner.joined) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no room joined; use -room or -join")
+		return 1
+	}
+
+	goodbye := make(chan bool, 1)
+	go func() {
+		for msg := range client.Messages() {
+			if msg.Raw == "Goodbye!" {
+				goodbye <- true
+				return
+			}
+		}
+		goodbye <- false
+	}()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := client.Send(line); err != nil {
+			fmt.Fprintln(os.Stderr, "Error sending message:", err)
+			return 1
+		}
+		time.Sleep(pace)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading input:", err)
+		return 1
+	}
+
+	if err := client.Quit(""); err != nil {
+		fmt.Fprintln(os.Stderr, "Error sending message:", err)
+		return 1
+	}
+	select {
+	case ok := <-goodbye:
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Error: connection closed before the server confirmed")
+			return 1
+		}
+		return 0
+	case <-time.After(DRAIN_TIMEOUT):
+		fmt.Fprintln(os.Stderr, "Error: timed out waiting for the server")
+		return 1
+	}
+}
+
+// runListenOnly prints every incoming message to stdout as one JSON object
+// per line until the connection ends.
+func runListenOnly(client *chatclient.Client, username string, joiner *autoJoiner) int {
+	encoder := json.NewEncoder(os.Stdout)
+	emit := func(msg chatclient.Message) { encoder.Encode(msg) }
+	if err := setup(client, username, joiner, emit); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	for msg := range client.Messages() {
+		emit(msg)
+	}
+	fmt.Fprintln(os.Stderr, "Connection to server lost:", client.Err())
+	return 1
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+// pinger times the user's /ping commands. The server answers pings in
+// order, so each reply belongs to the oldest outstanding one.
+type pinger struct {
+	sent []time.Time
+}
+
+// Sent records that a /ping was just sent.
+func (p *pinger) Sent() {
+	p.sent = append(p.sent, time.Now())
+}
+
+// Observe returns the line to show for a reply to a /ping, or "" when msg
+// is not one.
+func (p *pinger) Observe(msg chatclient.Message) string {
+	if len(p.sent) == 0 || msg.Room != "" {
+		return ""
+	}
+	pong := strings.HasPrefix(msg.Raw, "PONG ")
+	if !pong && msg.Code != codes.ERR_RATE_LIMITED {
+		return ""
+	}
+	rtt := time.Since(p.sent[0])
+	p.sent = p.sent[1:]
+	if !pong {
+		return msg.Text
+	}
+	return fmt.Sprintf("Pong from server in %d ms", rtt.Milliseconds())
+}
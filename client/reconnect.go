@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"final_project/chatclient"
+)
+
+const (
+	// RECONNECT_DELAY is the wait before the first attempt to reconnect.
+	// It doubles after every attempt that fails, up to
+	// RECONNECT_MAX_DELAY.
+	RECONNECT_DELAY     = time.Second
+	RECONNECT_MAX_DELAY = time.Minute
+)
+
+// backoff returns the wait before reconnect attempt n, counting from 0.
+func backoff(attempt int) time.Duration {
+	delay := RECONNECT_DELAY
+	for range attempt {
+		delay *= 2
+		if delay >= RECONNECT_MAX_DELAY {
+			return RECONNECT_MAX_DELAY
+		}
+	}
+	return delay
+}
+
+// lostConnection reports whether err, from a client whose messages ended,
+// means the connection broke or stalled (see chatclient.ErrStalled) rather
+// than that the server hung up, which it does after /quit, a kick or a
+// ban.
+func lostConnection(err error) bool {
+	return err != nil && !errors.Is(err, io.EOF)
+}
+
+// reconnect dials addr again with the bounded dial of the first connection
+// until an attempt succeeds, waiting longer after each failure. It returns
+// nil when the user gives up with /quit or Ctrl-C. Anything else typed
+// meanwhile is dropped, there being no connection to send it on.
+func reconnect(con *console, addr string, config chatclient.Config, input <-chan string) *chatclient.Client {
+	for attempt := 0; ; attempt++ {
+		delay := backoff(attempt)
+		con.Println(fmt.Sprintf("Reconnecting in %v (/quit to give up)...", delay))
+		if !waitToReconnect(con, delay, input) {
+			return nil
+		}
+		client, err := dial(addr, config)
+		if err == nil {
+			return client
+		}
+		con.Println("Error reconnecting:", err)
+		if errors.Is(err, errAborted) {
+			return nil
+		}
+	}
+}
+
+// waitToReconnect waits delay, reporting false if the user quit meanwhile.
+func waitToReconnect(con *console, delay time.Duration, input <-chan string) bool {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		case line := <-input:
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "/quit" {
+				return false
+			}
+			con.Println("Not connected; not sent:", line)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"final_project/chatclient"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, RECONNECT_DELAY},
+		{1, 2 * RECONNECT_DELAY},
+		{3, 8 * RECONNECT_DELAY},
+		{5, 32 * RECONNECT_DELAY},
+		{6, RECONNECT_MAX_DELAY},
+		{1000, RECONNECT_MAX_DELAY},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestLostConnection(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{io.EOF, false},
+		{fmt.Errorf("read: %w", io.EOF), false},
+		{fmt.Errorf("%w: nothing received", chatclient.ErrStalled), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+	}
+	for _, tt := range tests {
+		if got := lostConnection(tt.err); got != tt.want {
+			t.Errorf("lostConnection(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReconnectGivesUpOnQuit(t *testing.T) {
+	input := make(chan string, 2)
+	input <- "hello"
+	input <- "/quit"
+	done := make(chan *chatclient.Client)
+	go func() {
+		// Nothing listens there, but /quit comes before the first dial.
+		done <- reconnect(&console{}, "127.0.0.1:1", chatclient.Config{}, input)
+	}()
+	select {
+	case client := <-done:
+		if client != nil {
+			t.Error("reconnect returned a client after /quit")
+		}
+	case <-time.After(RECONNECT_DELAY / 2):
+		t.Fatal("reconnect did not give up on /quit")
+	}
+}
+
+func TestReconnectDialsAgain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	hello := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		hello <- line
+	}()
+
+	config := chatclient.Config{Timeout: time.Second}
+	client := reconnect(&console{}, listener.Addr().String(), config, make(chan string))
+	if client == nil {
+		t.Fatal("reconnect gave up")
+	}
+	defer client.Close()
+	select {
+	case line := <-hello:
+		if !strings.HasPrefix(line, "HELLO ") {
+			t.Errorf("server got %q, want a HELLO", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server got nothing from the new connection")
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+// watchResize does nothing on Windows, which has no SIGWINCH. The width
+// read at startup is kept.
+func watchResize(resize func()) {}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"final_project/chatclient"
+)
+
+// inputChecker catches input the server would turn away before it is
+// sent. Entering the same line again right after a warning sends it
+// anyway.
+type inputChecker struct {
+	warned string
+}
+
+// Check returns a warning about line, or "" if it may be sent.
+func (v *inputChecker) Check(client *chatclient.Client, line string) string {
+	if line == v.warned {
+		v.warned = ""
+		return ""
+	}
+	v.warned = ""
+	problem := inputProblem(client, line)
+	if problem == "" {
+		return ""
+	}
+	v.warned = line
+	return problem + " Enter it again to send it anyway."
+}
+
+// inputProblem returns what is wrong with line, judged by what the server
+// announced when we connected.
+func inputProblem(client *chatclient.Client, line string) string {
+	if limit := client.MaxLength(); limit > 0 {
+		size := len(line)
+		if strings.Contains(line, "\n") {
+			// SendBlock escapes line breaks and backslashes for /paste.
+			size += len("/paste ") + strings.Count(line, "\n") + strings.Count(line, `\`)
+		}
+		if size > limit && (strings.HasPrefix(line, "/") || strings.Contains(line, "\n")) {
+			return fmt.Sprintf("That is %d bytes; the server accepts at most %d.", size, limit)
+		}
+	}
+	if !strings.HasPrefix(line, "/") {
+		return ""
+	}
+	fields := strings.Fields(line)
+	command := fields[0]
+	if known := client.Commands(); known != nil && !slices.Contains(known, command) {
+		return fmt.Sprintf("The server has no command %s; see /help.", command)
+	}
+	switch command {
+	case "/join", "/j", "/create":
+		if len(fields) < 2 {
+			return ""
+		}
+		if len(fields) > 2 {
+			return "Room names cannot contain spaces."
+		}
+		if limit := client.MaxName(); limit > 0 && utf8.RuneCountInString(fields[1]) > limit {
+			return fmt.Sprintf("Room names can be at most %d characters.", limit)
+		}
+		if strings.IndexFunc(fields[1], unicode.IsControl) >= 0 || !utf8.ValidString(fields[1]) {
+			return "Room names cannot contain control characters."
+		}
+	case "/msg", "/m":
+		if len(fields) < 3 {
+			return "A private message needs a username and the message: /msg [username] [message]."
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"final_project/chatclient"
+	"final_project/textwidth"
+)
+
+// SPLIT_MARGIN leaves room for the part number added to each piece of a
+// split message, such as "(12/15) ".
+const SPLIT_MARGIN = 16
+
+// wrapText soft-wraps each line of text to columns, breaking at spaces
+// where it can. Wrapped lines are indented by indent columns so they line
+// up under the message text.
+func wrapText(text string, columns, indent int) string {
+	if indent > columns/2 {
+		indent = 0
+	}
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		out = append(out, wrapLine(line, columns, indent)...)
+	}
+	return strings.Join(out, "\n")
+}
+
+func wrapLine(line string, columns, indent int) []string {
+	var lines []string
+	pad := ""
+	for textwidth.String(pad+line) > columns {
+		cut, used := 0, textwidth.String(pad)
+		lastSpace := -1
+		for i, r := range line {
+			if used+textwidth.Rune(r) > columns {
+				break
+			}
+			used += textwidth.Rune(r)
+			cut = i + utf8.RuneLen(r)
+			if r == ' ' {
+				lastSpace = i
+			}
+		}
+		if lastSpace > 0 {
+			cut = lastSpace
+		}
+		if cut == 0 {
+			// Not even one rune fits after the indentation.
+			_, size := utf8.DecodeRuneInString(line)
+			cut = size
+		}
+		lines = append(lines, pad+strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+		pad = strings.Repeat(" ", indent)
+	}
+	return append(lines, pad+line)
+}
+
+// messageIndent returns the width of the part of msg's text before what
+// its sender wrote, e.g. "[room] 3:04PM - alice: ", or 0 when there is no
+// sender.
+func messageIndent(msg chatclient.Message) int {
+	if msg.User == "" {
+		return 0
+	}
+	i := strings.Index(msg.Raw, msg.User+": ")
+	if i < 0 {
+		return 0
+	}
+	return textwidth.String(msg.Raw[:i+len(msg.User)+2])
+}
+
+// splitMessage splits text into parts of at most limit bytes, numbered
+// "(1/3) ", "(2/3) " and so on, breaking at spaces where it can and never
+// inside a UTF-8 sequence. Text that fits is returned as is.
+func splitMessage(text string, limit int) []string {
+	if limit <= 0 || len(text) <= limit {
+		return []string{text}
+	}
+	size := max(limit-SPLIT_MARGIN, 1)
+	var parts []string
+	for len(text) > size {
+		cut := size
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if space := strings.LastIndexByte(text[:cut], ' '); space > size/2 {
+			cut = space
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		parts = append(parts, strings.TrimRight(text[:cut], " "))
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
@@ -0,0 +1,43 @@
+// Package codes lists the error codes of the chat server. Every request
+// the server rejects is answered with one: in text mode as a prefix of the
+// line, as in "ERR_NO_ROOM: You must join a room first.", and in JSON mode
+// in the "code" field of the error event. The text after the code is for
+// people and may change or be translated; the codes do not change.
+package codes
+
+const (
+	// ERR_BAD_REQUEST is a malformed command or request, such as one
+	// with missing arguments, invalid JSON or an unknown request type.
+	ERR_BAD_REQUEST = "ERR_BAD_REQUEST"
+	// ERR_UNKNOWN_COMMAND is a slash command the server does not have.
+	ERR_UNKNOWN_COMMAND = "ERR_UNKNOWN_COMMAND"
+	// ERR_NO_NAME is a request that needs a username chosen first.
+	ERR_NO_NAME = "ERR_NO_NAME"
+	// ERR_NO_ROOM is a request that needs the client in a room.
+	ERR_NO_ROOM = "ERR_NO_ROOM"
+	// ERR_NO_SUCH_ROOM and ERR_NO_SUCH_USER name a room or user that
+	// does not exist.
+	ERR_NO_SUCH_ROOM = "ERR_NO_SUCH_ROOM"
+	ERR_NO_SUCH_USER = "ERR_NO_SUCH_USER"
+	// ERR_ROOM_EXISTS is a room created under a name already in use.
+	ERR_ROOM_EXISTS = "ERR_ROOM_EXISTS"
+	// ERR_BAD_NAME is an invalid username, room name or tag.
+	ERR_BAD_NAME = "ERR_BAD_NAME"
+	// ERR_NAME_TAKEN is a username in use or reserved.
+	ERR_NAME_TAKEN = "ERR_NAME_TAKEN"
+	// ERR_BANNED is a client banned from the server or the room.
+	ERR_BANNED = "ERR_BANNED"
+	// ERR_NOT_ALLOWED is a request the client lacks permission for, such
+	// as an owner-only command or joining an invite-only room.
+	ERR_NOT_ALLOWED = "ERR_NOT_ALLOWED"
+	// ERR_SERVER_FULL is a connection refused at max-clients.
+	ERR_SERVER_FULL = "ERR_SERVER_FULL"
+	// ERR_LIMIT is a request over a count limit, such as max-rooms.
+	ERR_LIMIT = "ERR_LIMIT"
+	// ERR_RATE_LIMITED is a request over a rate limit or in slow mode.
+	ERR_RATE_LIMITED = "ERR_RATE_LIMITED"
+	// ERR_TOO_LONG is a message or setting over its length limit.
+	ERR_TOO_LONG = "ERR_TOO_LONG"
+	// ERR_REJECTED is any other rejection.
+	ERR_REJECTED = "ERR_REJECTED"
+)
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// chatCommand is a client command added with registerCommand instead of a
+// case in handleCommand.
+type chatCommand struct {
+	// usage and help make up the command's line in /help.
+	usage string
+	help  string
+	// needsRoom turns the command away from clients that are not in a
+	// room; run is then given the client's room.
+	needsRoom bool
+	run       func(client *Client, room, args string)
+}
+
+var commands = map[string]chatCommand{}
+
+// coreCommands are the commands handled by the cases of handleCommand.
+var coreCommands = []string{"/join", "/create", "/nick", "/msg", "/who", "/list", "/quit", "/ping", "/stats", "/json", "/help"}
+
+// commandNames returns every command and alias clients may send, sorted.
+func commandNames() []string {
+	names := slices.Clone(coreCommands)
+	for name := range commands {
+		names = append(names, name)
+	}
+	for alias := range commandAliases {
+		names = append(names, alias)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// commandAliases are short names for commands. They name commands, never
+// other aliases, so expanding one cannot loop.
+var commandAliases = map[string]string{
+	"/j": "/join",
+	"/m": "/msg",
+	"/w": "/who",
+}
+
+// expandCommandAlias replaces an alias at the start of a command line with
+// the command it stands for. The line is split into words as handleCommand
+// splits it; the rest of the line is left alone.
+func expandCommandAlias(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return line
+	}
+	command, ok := commandAliases[fields[0]]
+	if !ok {
+		return line
+	}
+	if rest := restOfLine(line, 1); rest != "" {
+		return command + " " + rest
+	}
+	return command
+}
+
+// aliasNote returns " (/j)" for a command with aliases, for /help.
+func aliasNote(command string) string {
+	var names []string
+	for alias, target := range commandAliases {
+		if target == command {
+			names = append(names, alias)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	slices.Sort(names)
+	return " (" + strings.Join(names, ", ") + ")"
+}
+
+// registerCommand adds a command under name, which includes the slash.
+func registerCommand(name string, command chatCommand) {
+	if _, ok := commands[name]; ok {
+		panic("command registered twice: " + name)
+	}
+	commands[name] = command
+}
+
+// runCommand runs the registered command named by the first word of line,
+// as handleCommand splits it, reporting whether there was one.
+func runCommand(client *Client, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	command, ok := commands[fields[0]]
+	if !ok {
+		return false
+	}
+	mutex.Lock()
+	room := client.room
+	mutex.Unlock()
+	if command.needsRoom && room == "" {
+		client.say("room.join_first_short")
+		return true
+	}
+	command.run(client, room, restOfLine(line, 1))
+	return true
+}
+
+// commandsHelp returns the /help lines of the registered commands.
+func commandsHelp() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s%s - %s\n", commands[name].usage, aliasNote(name), commands[name].help)
+	}
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestExpandCommandAlias(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"/j lobby", "/join lobby"},
+		{"/j\tlobby", "/join lobby"},
+		{"/j   lobby", "/join lobby"},
+		{"/j", "/join"},
+		{"/j ", "/join"},
+		{"/m bob hi  there", "/msg bob hi  there"},
+		{"/join lobby", "/join lobby"},
+		{"/jx lobby", "/jx lobby"},
+		{"hello /j", "hello /j"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := expandCommandAlias(test.line); got != test.want {
+			t.Errorf("expandCommandAlias(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestRunCommandSplitsLikeHandleCommand(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	room := uniqueName("split")
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	// A tab after a registered command's name must reach it, as it
+	// reaches the commands handleCommand runs itself.
+	c.send("/8ball\twill  tabs work?")
+	c.expectLine(`asked "will  tabs work?"`)
+	c.send("/roll\t2d6")
+	c.expectLine("rolled 2d6")
+}
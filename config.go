@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// settings are the values that can change while the server runs. Flags
+// give their startup values, the -config file overrides them, and SIGHUP
+// or the admin /reload replaces the whole set. Code reads them per
+// operation through config() instead of keeping copies. Everything else,
+// such as listen addresses and TLS, is fixed at startup.
+type settings struct {
+	slowGrace         time.Duration
+	maxPasteSize      int
+	maxFrameSize      int
+	retentionMaxAge   time.Duration
+	retentionMaxCount int
+	requireNick       bool
+	unfurl            bool
+	goroutineWarn     int
+	reservedFile      string
+	lang              string
+	// maxClients, maxRooms, maxRoomsPerUser and messageRate are the
+	// limits of /limits; 0 means no limit.
+	maxClients      int
+	maxRooms        int
+	maxRoomsPerUser int
+	messageRate     int
+	// roomRate is the messages a second a room may average before it
+	// goes into slow mode, 0 for no limit.
+	roomRate int
+	// botMessageRate is message-rate for bots, -1 for the same as
+	// everyone else.
+	botMessageRate int
+	// latencyWarn is the broadcast latency above which a warning is
+	// logged, 0 for none.
+	latencyWarn time.Duration
+	// awayAfter is how long a client may send nothing before /who shows
+	// it away, 0 for never.
+	awayAfter time.Duration
+	// fanoutThreshold is the number of recipients above which a message
+	// is paced over fanoutWindow, 0 for never; see fanout.go.
+	fanoutThreshold int
+	fanoutWindow    time.Duration
+	// motdFile is the message of the day sent on connect; see motd.go.
+	motdFile string
+	motd     *motd
+	// reserved is built from reservedFile; it is never modified.
+	reserved map[string]bool
+}
+
+var (
+	// configFile is the -config file, read at startup and on reload.
+	configFile    string
+	flagSettings  settings
+	reloadMutex   sync.Mutex
+	currentConfig atomic.Pointer[settings]
+)
+
+// config returns the current settings. They must not be modified.
+func config() *settings {
+	return currentConfig.Load()
+}
+
+// settingKeys are the keys of the config file, named like the flags they
+// override.
+var settingKeys = []struct {
+	name string
+	set  func(s *settings, value string) error
+	get  func(s *settings) string
+}{
+	{"slow-grace", func(s *settings, v string) (err error) { s.slowGrace, err = time.ParseDuration(v); return },
+		func(s *settings) string { return s.slowGrace.String() }},
+	{"max-paste-size", func(s *settings, v string) (err error) { s.maxPasteSize, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.maxPasteSize) }},
+	{"max-frame-size", func(s *settings, v string) (err error) { s.maxFrameSize, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.maxFrameSize) }},
+	{"retention-max-age", func(s *settings, v string) (err error) { s.retentionMaxAge, err = time.ParseDuration(v); return },
+		func(s *settings) string { return s.retentionMaxAge.String() }},
+	{"retention-max-count", func(s *settings, v string) (err error) { s.retentionMaxCount, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.retentionMaxCount) }},
+	{"require-nick", func(s *settings, v string) (err error) { s.requireNick, err = strconv.ParseBool(v); return },
+		func(s *settings) string { return strconv.FormatBool(s.requireNick) }},
+	{"unfurl", func(s *settings, v string) (err error) { s.unfurl, err = strconv.ParseBool(v); return },
+		func(s *settings) string { return strconv.FormatBool(s.unfurl) }},
+	{"goroutine-warn", func(s *settings, v string) (err error) { s.goroutineWarn, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.goroutineWarn) }},
+	{"reserved-names", func(s *settings, v string) error { s.reservedFile = v; return nil },
+		func(s *settings) string { return s.reservedFile }},
+	{"motd", func(s *settings, v string) error { s.motdFile = v; return nil },
+		func(s *settings) string { return s.motdFile }},
+	{"lang", func(s *settings, v string) error { s.lang = v; return nil },
+		func(s *settings) string { return s.lang }},
+	{"max-clients", func(s *settings, v string) (err error) { s.maxClients, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.maxClients) }},
+	{"max-rooms", func(s *settings, v string) (err error) { s.maxRooms, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.maxRooms) }},
+	{"max-rooms-per-user", func(s *settings, v string) (err error) { s.maxRoomsPerUser, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.maxRoomsPerUser) }},
+	{"message-rate", func(s *settings, v string) (err error) { s.messageRate, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.messageRate) }},
+	{"room-rate", func(s *settings, v string) (err error) { s.roomRate, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.roomRate) }},
+	{"bot-message-rate", func(s *settings, v string) (err error) { s.botMessageRate, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.botMessageRate) }},
+	{"latency-warn", func(s *settings, v string) (err error) { s.latencyWarn, err = time.ParseDuration(v); return },
+		func(s *settings) string { return s.latencyWarn.String() }},
+	{"away-after", func(s *settings, v string) (err error) { s.awayAfter, err = time.ParseDuration(v); return },
+		func(s *settings) string { return s.awayAfter.String() }},
+	{"fanout-threshold", func(s *settings, v string) (err error) { s.fanoutThreshold, err = strconv.Atoi(v); return },
+		func(s *settings) string { return strconv.Itoa(s.fanoutThreshold) }},
+	{"fanout-window", func(s *settings, v string) (err error) { s.fanoutWindow, err = time.ParseDuration(v); return },
+		func(s *settings) string { return s.fanoutWindow.String() }},
+}
+
+// loadSettings builds settings from the flags and the config file.
+func loadSettings() (*settings, error) {
+	s := flagSettings
+	if configFile != "" {
+		if err := readConfigFile(&s, configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	reserved, err := loadReservedNames(s.reservedFile)
+	if err != nil {
+		return nil, fmt.Errorf("reserved-names: %w", err)
+	}
+	s.reserved = reserved
+	if s.motd, err = loadMOTD(s.motdFile); err != nil {
+		return nil, fmt.Errorf("motd: %w", err)
+	}
+	return &s, nil
+}
+
+func (s *settings) validate() error {
+	switch {
+	case s.slowGrace <= 0:
+		return fmt.Errorf("slow-grace must be positive")
+	case s.maxPasteSize < 1:
+		return fmt.Errorf("max-paste-size must be at least 1")
+	case s.maxFrameSize < 1:
+		return fmt.Errorf("max-frame-size must be at least 1")
+	case s.retentionMaxAge < 0:
+		return fmt.Errorf("retention-max-age must not be negative")
+	case s.retentionMaxCount < 1:
+		return fmt.Errorf("retention-max-count must be at least 1")
+	case catalogs[s.lang] == nil:
+		return fmt.Errorf("lang must be one of %s", strings.Join(languages(), ", "))
+	case s.maxClients < 0, s.maxRooms < 0, s.maxRoomsPerUser < 0, s.messageRate < 0, s.roomRate < 0:
+		return fmt.Errorf("limits must not be negative")
+	case s.botMessageRate < -1:
+		return fmt.Errorf("bot-message-rate must be -1 or more")
+	case s.latencyWarn < 0:
+		return fmt.Errorf("latency-warn must not be negative")
+	case s.awayAfter < 0:
+		return fmt.Errorf("away-after must not be negative")
+	case s.fanoutThreshold < 0:
+		return fmt.Errorf("fanout-threshold must not be negative")
+	case s.fanoutWindow < FANOUT_TICK:
+		return fmt.Errorf("fanout-window must be at least %v", FANOUT_TICK)
+	}
+	return nil
+}
+
+// readConfigFile applies the "key = value" lines of path to s. Blank lines
+// and lines starting with # are skipped.
+func readConfigFile(s *settings, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		known := false
+		for _, setting := range settingKeys {
+			if setting.name == key {
+				if err := setting.set(s, value); err != nil {
+					return fmt.Errorf("%s:%d: invalid %s %q", path, n, key, value)
+				}
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("%s:%d: unknown key %q", path, n, key)
+		}
+	}
+	return scanner.Err()
+}
+
+// reloadConfig reads the settings again and switches to them, logging what
+// changed. Invalid settings are reported and the old ones kept.
+func reloadConfig() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	next, err := loadSettings()
+	if err != nil {
+		log.Println("Reload failed, keeping the current configuration:", err)
+		return err
+	}
+	old := currentConfig.Swap(next)
+	changed := 0
+	for _, setting := range settingKeys {
+		if before, after := setting.get(old), setting.get(next); before != after {
+			log.Printf("Config: %s changed from %q to %q", setting.name, before, after)
+			changed++
+		}
+	}
+	log.Printf("Configuration reloaded, %d settings changed", changed)
+	return nil
+}
+
+// changeSetting sets the setting name to value in a copy of the current
+// settings and switches to it if it is valid. The next reload replaces it
+// with what the flags and config file say.
+func changeSetting(name, value string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	next := *config()
+	for _, setting := range settingKeys {
+		if setting.name != name {
+			continue
+		}
+		if err := setting.set(&next, value); err != nil {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+		if err := next.validate(); err != nil {
+			return err
+		}
+		old := currentConfig.Swap(&next)
+		log.Printf("Config: %s changed from %q to %q on the admin console", name, setting.get(old), setting.get(&next))
+		return nil
+	}
+	return fmt.Errorf("unknown setting %q", name)
+}
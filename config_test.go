@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestLimitsChangeForConnectedClients changes limits while a client is
+// connected, as a reload does, and checks that the client's next messages
+// are held to them, and no longer once they are restored.
+func TestLimitsChangeForConnectedClients(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	room := uniqueName("limits")
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	long := "/paste " + strings.Repeat("x", 20)
+	c.send(long)
+	c.expectLine(": " + strings.Repeat("x", 20))
+
+	// withSettings restores the limits when the subtest ends.
+	t.Run("changed", func(t *testing.T) {
+		parent := c.t
+		c.t = t
+		defer func() { c.t = parent }()
+		withSettings(t, func(s *settings) {
+			s.messageRate = 2
+			s.maxPasteSize = 10
+		})
+		c.send(long)
+		c.expectLine(translate(DEFAULT_LANGUAGE, "paste.too_long", 10))
+		for i := range 2 {
+			c.send(fmt.Sprint("ok ", i))
+			c.expectLine(fmt.Sprint(": ok ", i))
+		}
+		c.send("too many")
+		c.expectLine(translate(DEFAULT_LANGUAGE, "chat.too_fast", 2))
+	})
+
+	c.send("rate restored")
+	c.expectLine(": rate restored")
+	c.send(long)
+	c.expectLine(": " + strings.Repeat("x", 20))
+}
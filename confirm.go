@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// UNDO_WINDOW is how long /undo can reverse a console action.
+const UNDO_WINDOW = 60 * time.Second
+
+// undoAction reverses a ban or unban made in the admin console. Kicks and
+// the disconnects that come with bans cannot be undone.
+type undoAction struct {
+	what string
+	at   time.Time
+	undo func()
+}
+
+// undoStack holds the console's recent actions, newest last. Only the
+// console goroutine uses it.
+var undoStack []undoAction
+
+// pushUndo records how to reverse the action just taken, described by
+// what, e.g. "ban of 203.0.113.7".
+func pushUndo(what string, undo func()) {
+	now := time.Now()
+	dropExpiredUndo(now)
+	undoStack = append(undoStack, undoAction{what: what, at: now, undo: undo})
+}
+
+func dropExpiredUndo(now time.Time) {
+	for len(undoStack) > 0 && now.Sub(undoStack[0].at) > UNDO_WINDOW {
+		undoStack = undoStack[1:]
+	}
+}
+
+// undoLast reverses the newest action taken within UNDO_WINDOW.
+func undoLast() {
+	dropExpiredUndo(time.Now())
+	if len(undoStack) == 0 {
+		fmt.Printf("Nothing to undo from the last %v.\n", UNDO_WINDOW)
+		return
+	}
+	action := undoStack[len(undoStack)-1]
+	undoStack = undoStack[:len(undoStack)-1]
+	action.undo()
+	log.Printf("Admin undid the %s", action.what)
+	fmt.Printf("Undid the %s.\n", action.what)
+}
+
+// confirmAction shows what an action would hit, one item per line, and
+// asks whether to go ahead unless yes is set. Only the console goroutine
+// waits for the answer; the caller must not hold mutex. A refusal is
+// logged.
+func confirmAction(reader *bufio.Reader, yes bool, what string, targets []string) bool {
+	for _, target := range targets {
+		fmt.Println("  " + target)
+	}
+	if yes {
+		return true
+	}
+	fmt.Printf("Go ahead with the %s? [y/N] ", what)
+	answer, _ := reader.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	log.Printf("Admin cancelled the %s", what)
+	fmt.Println("Cancelled.")
+	return false
+}
+
+// confirmedCommands are the console commands confirmAction asks about;
+// typing them with -y, as in "/ban -y", skips the question.
+var confirmedCommands = map[string]bool{"/kick": true, "/ban": true, "/rban": true}
+
+// cutYes removes the -y of a confirmed command, reporting whether it was
+// there.
+func cutYes(command string) (string, bool) {
+	if base, ok := strings.CutSuffix(command, " -y"); ok && confirmedCommands[strings.TrimSpace(base)] {
+		return strings.TrimSpace(base), true
+	}
+	return command, false
+}
+
+// describeClient is the line confirmAction shows for client. The caller
+// must hold mutex.
+func describeClient(client *Client) string {
+	return fmt.Sprintf("%s (%s), room %s, connected %v ago", client.username, client.address, orNone(client.room), time.Since(client.connected).Round(time.Second))
+}
+
+// banTargets describes the connected clients ban would disconnect.
+func banTargets(ban BannedUser) []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var list []string
+	for _, client := range clients {
+		if banCovers(ban, client.address) {
+			list = append(list, describeClient(client))
+		}
+	}
+	if len(list) == 0 {
+		list = append(list, "Nobody connected is covered by "+ban.Address+".")
+	}
+	return list
+}
+
+// banCovers reports whether ban applies to addr, as returned by
+// clientAddress.
+func banCovers(ban BannedUser, addr string) bool {
+	if addr == UNIX_ANONYMOUS {
+		return false
+	}
+	if ban.Address == addr {
+		return true
+	}
+	ip := net.ParseIP(addressHost(addr))
+	if ip == nil {
+		return false
+	}
+	return ban.Address == ip.String() || (ban.Net != nil && ban.Net.Contains(ip))
+}
+
+// serverBan returns the server ban on addr, written in any form parseBan
+// accepts.
+func serverBan(addr string) (BannedUser, bool) {
+	if ban, err := parseBan(addr, true); err == nil {
+		addr = ban.Address
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	ban, ok := bannedUsers[addr]
+	return ban, ok
+}
+
+// restoreBan puts back a ban that was lifted or replaced, keeping when it
+// was first made.
+func restoreBan(ban BannedUser) {
+	mutex.Lock()
+	bannedUsers[ban.Address] = ban
+	mutex.Unlock()
+	log.Printf("Banned %s again%s", ban.Address, reasonSuffix(ban.Reason))
+}
+
+// roomBanTarget describes the connected user name for the /rban
+// confirmation and returns the host a room ban would go on, or "" if name
+// cannot be banned.
+func roomBanTarget(name string) (string, []string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	target := findClient(name)
+	if target == nil || target.address == UNIX_ANONYMOUS {
+		return "", nil
+	}
+	return addressHost(target.address), []string{describeClient(target)}
+}
+
+// roomBanOn returns the ban of room on host, if there is one.
+func roomBanOn(room, host string) (roomBan, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if meta := roomMetas[room]; meta != nil {
+		ban, ok := meta.bans[host]
+		return ban, ok
+	}
+	return roomBan{}, false
+}
+
+// setRoomBan puts ban on host in room, or lifts the ban on host if ok is
+// not set.
+func setRoomBan(room, host string, ban roomBan, ok bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if ok {
+		metaFor(room).bans[host] = ban
+	} else if meta := roomMetas[room]; meta != nil {
+		delete(meta.bans, host)
+	}
+}
+
+// roomBansOf returns the bans of room made against the user name.
+func roomBansOf(room, name string) map[string]roomBan {
+	mutex.Lock()
+	defer mutex.Unlock()
+	found := make(map[string]roomBan)
+	if meta := roomMetas[room]; meta != nil {
+		for host, ban := range meta.bans {
+			if ban.username == name {
+				found[host] = ban
+			}
+		}
+	}
+	return found
+}
+
+// restoreRoomBans puts back room bans returned by roomBansOf.
+func restoreRoomBans(room string, bans map[string]roomBan) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	meta := metaFor(room)
+	for host, ban := range bans {
+		meta.bans[host] = ban
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestBanCovers(t *testing.T) {
+	ban := func(target string) BannedUser {
+		t.Helper()
+		b, err := parseBan(target, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		ban  BannedUser
+		addr string
+		want bool
+	}{
+		{ban("203.0.113.7"), "203.0.113.7:4000", true},
+		{ban("203.0.113.7"), "203.0.113.8:4000", false},
+		{ban("203.0.113.7:4000"), "203.0.113.7:4000", true},
+		{ban("203.0.113.7:4000"), "203.0.113.7:4001", false},
+		{ban("203.0.113.0/24"), "203.0.113.200:1", true},
+		{ban("203.0.113.0/24"), "203.0.114.1:1", false},
+		{ban("203.0.113.*"), "203.0.113.9:1", true},
+		{ban("2001:db8::/48"), "[2001:db8:0:1::5]:4000", true},
+		{ban("2001:db8::/48"), "[2001:db9::5]:4000", false},
+		{ban("2001:db8::1"), "[2001:db8::1]:4000", true},
+		{ban("*"), "198.51.100.1:1", true},
+		{ban("*"), UNIX_ANONYMOUS, false},
+		{ban("unix:uid=1000"), "unix:uid=1000", true},
+		{ban("unix:uid=1000"), "unix:uid=1001", false},
+	}
+	for _, test := range tests {
+		if got := banCovers(test.ban, test.addr); got != test.want {
+			t.Errorf("banCovers(%s, %s) = %t, want %t", test.ban.Address, test.addr, got, test.want)
+		}
+	}
+}
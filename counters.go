@@ -0,0 +1,75 @@
+package main
+
+import "sync/atomic"
+
+// clientCounters are a client's traffic counters. They are updated with
+// atomic operations, so hot paths need not take mutex; read them through
+// snapshot.
+type clientCounters struct {
+	messagesSent     atomic.Int64
+	messagesReceived atomic.Int64
+	bytesIn          atomic.Int64
+	bytesOut         atomic.Int64
+	commands         atomic.Int64
+	rateLimited      atomic.Int64
+	// queueHighWater is the most lines the send queue has held.
+	queueHighWater atomic.Int64
+}
+
+// counterSnapshot is a copy of clientCounters, or their sum over clients.
+type counterSnapshot struct {
+	MessagesSent     int64 `json:"messages_sent"`
+	MessagesReceived int64 `json:"messages_received"`
+	BytesIn          int64 `json:"bytes_in"`
+	BytesOut         int64 `json:"bytes_out"`
+	Commands         int64 `json:"commands"`
+	RateLimited      int64 `json:"rate_limited"`
+	QueueHighWater   int64 `json:"queue_high_water"`
+}
+
+// departedCounters sums the counters of clients that have disconnected,
+// for the server totals. It is guarded by mutex.
+var departedCounters counterSnapshot
+
+func (c *clientCounters) snapshot() counterSnapshot {
+	return counterSnapshot{
+		MessagesSent:     c.messagesSent.Load(),
+		MessagesReceived: c.messagesReceived.Load(),
+		BytesIn:          c.bytesIn.Load(),
+		BytesOut:         c.bytesOut.Load(),
+		Commands:         c.commands.Load(),
+		RateLimited:      c.rateLimited.Load(),
+		QueueHighWater:   c.queueHighWater.Load(),
+	}
+}
+
+// add sums other into s. The high-water mark is the larger of the two.
+func (s *counterSnapshot) add(other counterSnapshot) {
+	s.MessagesSent += other.MessagesSent
+	s.MessagesReceived += other.MessagesReceived
+	s.BytesIn += other.BytesIn
+	s.BytesOut += other.BytesOut
+	s.Commands += other.Commands
+	s.RateLimited += other.RateLimited
+	s.QueueHighWater = max(s.QueueHighWater, other.QueueHighWater)
+}
+
+// noteQueueLength raises the high-water mark to n if it is higher.
+func (c *clientCounters) noteQueueLength(n int) {
+	for {
+		high := c.queueHighWater.Load()
+		if int64(n) <= high || c.queueHighWater.CompareAndSwap(high, int64(n)) {
+			return
+		}
+	}
+}
+
+// serverCounters sums the counters of all clients, connected or not. The
+// caller must hold mutex.
+func serverCounters() counterSnapshot {
+	total := departedCounters
+	for _, client := range clients {
+		total.add(client.counters.snapshot())
+	}
+	return total
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_COOKIE   = "gochat_admin"
+	SESSION_LIFETIME = 12 * time.Hour
+	// RATE_WINDOW is the window room message rates are counted over.
+	RATE_WINDOW = time.Minute
+)
+
+// adminSession is a logged-in dashboard browser. csrf must accompany every
+// form it posts.
+type adminSession struct {
+	csrf    string
+	expires time.Time
+}
+
+var (
+	sessionsMutex sync.Mutex
+	sessions      = map[string]adminSession{}
+)
+
+// dashboardClient, dashboardRoom and dashboardBan are the rows of the
+// dashboard's tables.
+type dashboardClient struct {
+	Username string
+	Address  string
+	Room     string
+	Software string
+	Idle     time.Duration
+}
+
+type dashboardRoom struct {
+	Name    string
+	Members []string
+	Rate    int
+}
+
+type dashboardBan struct {
+	Address string
+	Network bool
+	Reason  string
+	Since   time.Time
+}
+
+type dashboardPage struct {
+	Title   string
+	CSRF    string
+	Error   string
+	Stats   ServerStats
+	Clients []dashboardClient
+	Rooms   []dashboardRoom
+	Bans    []dashboardBan
+}
+
+// mountDashboard serves the admin dashboard under /admin/, logging in with
+// auth, which has the form user:password.
+func mountDashboard(auth string) {
+	wantUser, wantPassword, _ := strings.Cut(auth, ":")
+	httpMux.HandleFunc("GET /admin/login", func(w http.ResponseWriter, r *http.Request) {
+		renderDashboard(w, "login", dashboardPage{Title: "Log in"})
+	})
+	httpMux.HandleFunc("POST /admin/login", func(w http.ResponseWriter, r *http.Request) {
+		user, password := r.PostFormValue("user"), r.PostFormValue("password")
+		if subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			renderDashboard(w, "login", dashboardPage{Title: "Log in", Error: "Wrong user or password."})
+			return
+		}
+		token := randomToken()
+		sessionsMutex.Lock()
+		for id, session := range sessions {
+			if time.Now().After(session.expires) {
+				delete(sessions, id)
+			}
+		}
+		sessions[token] = adminSession{csrf: randomToken(), expires: time.Now().Add(SESSION_LIFETIME)}
+		sessionsMutex.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: token, Path: "/admin/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode, MaxAge: int(SESSION_LIFETIME.Seconds())})
+		http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+	})
+
+	page := func(name, title string, fill func(*dashboardPage)) http.Handler {
+		return requireSession(func(w http.ResponseWriter, r *http.Request, session adminSession) {
+			p := dashboardPage{Title: title, CSRF: session.csrf, Stats: serverStats()}
+			fill(&p)
+			renderDashboard(w, name, p)
+		})
+	}
+	httpMux.Handle("GET /admin/{$}", page("overview", "Overview", func(p *dashboardPage) {}))
+	httpMux.Handle("GET /admin/clients", page("clients", "Clients", func(p *dashboardPage) { p.Clients = dashboardClients() }))
+	httpMux.Handle("GET /admin/rooms", page("rooms", "Rooms", func(p *dashboardPage) { p.Rooms = dashboardRooms() }))
+	httpMux.Handle("GET /admin/bans", page("bans", "Bans", func(p *dashboardPage) { p.Bans = dashboardBans() }))
+	httpMux.Handle("GET /admin/stats.json", requireSession(func(w http.ResponseWriter, r *http.Request, session adminSession) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(serverStats())
+	}))
+
+	// Mutations reuse what the admin console does.
+	action := func(back string, run func(address, reason string)) http.Handler {
+		return requireSession(func(w http.ResponseWriter, r *http.Request, session adminSession) {
+			if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(session.csrf)) != 1 {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			run(r.PostFormValue("address"), strings.TrimSpace(r.PostFormValue("reason")))
+			http.Redirect(w, r, back, http.StatusSeeOther)
+		})
+	}
+	httpMux.Handle("POST /admin/kick", action("/admin/clients", func(address, reason string) {
+		if client := clientByAddress(address); client != nil {
+			kickUser(client, reason)
+		}
+	}))
+	httpMux.Handle("POST /admin/ban", action("/admin/clients", func(address, reason string) {
+		if client := clientByAddress(address); client != nil {
+			banUser(client, reason)
+		}
+	}))
+	httpMux.Handle("POST /admin/unban", action("/admin/bans", func(address, reason string) {
+		unbanAddress(address)
+	}))
+	httpMux.Handle("POST /admin/logout", requireSession(func(w http.ResponseWriter, r *http.Request, session adminSession) {
+		if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+			sessionsMutex.Lock()
+			delete(sessions, cookie.Value)
+			sessionsMutex.Unlock()
+		}
+		http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Path: "/admin/", MaxAge: -1})
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+	}))
+}
+
+// requireSession runs handler for requests with a live session cookie and
+// sends everyone else to the login page.
+func requireSession(handler func(http.ResponseWriter, *http.Request, adminSession)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var session adminSession
+		ok := false
+		if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+			sessionsMutex.Lock()
+			session, ok = sessions[cookie.Value]
+			sessionsMutex.Unlock()
+		}
+		if !ok || time.Now().After(session.expires) {
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+		handler(w, r, session)
+	})
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func dashboardClients() []dashboardClient {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := make([]dashboardClient, 0, len(clients))
+	for _, client := range clients {
+		idle := time.Since(time.Unix(0, client.lastActive.Load())).Round(time.Second)
+		list = append(list, dashboardClient{Username: client.username, Address: client.address, Room: client.room, Software: client.softwareLabel(), Idle: idle})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+func dashboardRooms() []dashboardRoom {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := []dashboardRoom{}
+	for _, name := range roomNames() {
+		rate := 0
+		events, _ := historySince(name, 0)
+		for i := len(events) - 1; i >= 0 && time.Since(events[i].time) < RATE_WINDOW; i-- {
+			if events[i].kind == MESSAGE_CHAT {
+				rate++
+			}
+		}
+		list = append(list, dashboardRoom{Name: name, Members: roomMembers(name), Rate: rate})
+	}
+	return list
+}
+
+func dashboardBans() []dashboardBan {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var list []dashboardBan
+	for _, ban := range sortedBans() {
+		list = append(list, dashboardBan{Address: ban.Address, Network: ban.Net != nil, Reason: ban.Reason, Since: ban.Time})
+	}
+	return list
+}
+
+func renderDashboard(w http.ResponseWriter, name string, page dashboardPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	dashboardTemplates.ExecuteTemplate(w, name, page)
+}
+
+var dashboardTemplates = template.Must(template.New("dashboard").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}} - gochat admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+nav a { margin-right: 1em; }
+form.inline { display: inline; }
+.error { color: #b00; }
+</style></head><body>
+{{if .CSRF}}<nav><a href="/admin/">Overview</a><a href="/admin/clients">Clients</a><a href="/admin/rooms">Rooms</a><a href="/admin/bans">Bans</a>
+<form class="inline" method="post" action="/admin/logout"><button>Log out</button></form></nav>{{end}}
+<h1>{{.Title}}</h1>
+{{end}}
+
+{{define "footer"}}</body></html>{{end}}
+
+{{define "stats"}}<table>
+<tr><th>Started</th><td>{{.Stats.Started.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Clients</th><td id="clients">{{.Stats.Clients}}</td></tr>
+<tr><th>Rooms</th><td id="rooms">{{.Stats.Rooms}}</td></tr>
+<tr><th>Dropped messages</th><td id="dropped_messages">{{.Stats.DroppedMessages}}</td></tr>
+<tr><th>Slow disconnects</th><td id="slow_disconnects">{{.Stats.SlowDisconnects}}</td></tr>
+<tr><th>Throttled connections</th><td id="throttled_connections">{{.Stats.ThrottledConnections}}</td></tr>
+<tr><th>Handshake timeouts</th><td id="handshake_timeouts">{{.Stats.HandshakeTimeouts}}</td></tr>
+<tr><th>Broadcast queue</th><td id="broadcast_queue">{{.Stats.BroadcastQueue}}</td></tr>
+<tr><th>Backlog warnings</th><td id="broadcast_backlog_warnings">{{.Stats.BacklogWarnings}}</td></tr>
+<tr><th>Goroutines</th><td id="goroutines">{{.Stats.Goroutines}}</td></tr>
+</table>
+<script>
+setInterval(async () => {
+  const response = await fetch("/admin/stats.json");
+  if (!response.ok) return;
+  const stats = await response.json();
+  for (const key of ["clients", "rooms", "dropped_messages", "slow_disconnects", "throttled_connections", "handshake_timeouts", "broadcast_queue", "broadcast_backlog_warnings", "goroutines"]) {
+    document.getElementById(key).textContent = stats[key];
+  }
+}, 5000);
+</script>{{end}}
+
+{{define "login"}}{{template "header" .}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/admin/login">
+<p><label>User <input name="user" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<p><button>Log in</button></p>
+</form>
+{{template "footer"}}{{end}}
+
+{{define "overview"}}{{template "header" .}}{{template "stats" .}}{{template "footer"}}{{end}}
+
+{{define "clients"}}{{template "header" .}}
+{{if .Clients}}<table>
+<tr><th>Username</th><th>Address</th><th>Room</th><th>Software</th><th>Idle</th><th></th></tr>
+{{range .Clients}}<tr><td>{{.Username}}</td><td>{{.Address}}</td><td>{{.Room}}</td><td>{{.Software}}</td><td>{{.Idle}}</td><td>
+<form class="inline" method="post" action="/admin/kick"><input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="address" value="{{.Address}}"><input name="reason" placeholder="Reason (optional)"><button>Kick</button><button formaction="/admin/ban">Ban</button></form>
+</td></tr>
+{{end}}</table>{{else}}<p>No clients connected.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "rooms"}}{{template "header" .}}
+{{if .Rooms}}<table>
+<tr><th>Room</th><th>Members</th><th>Messages in the last minute</th></tr>
+{{range .Rooms}}<tr><td>{{.Name}}</td><td>{{range $i, $m := .Members}}{{if $i}}, {{end}}{{$m}}{{end}}</td><td>{{.Rate}}</td></tr>
+{{end}}</table>{{else}}<p>No active rooms.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "bans"}}{{template "header" .}}
+{{if .Bans}}<table>
+<tr><th>Address</th><th>Reason</th><th>Since</th><th></th></tr>
+{{range .Bans}}<tr><td>{{.Address}}{{if .Network}} (network){{end}}</td><td>{{.Reason}}</td><td>{{.Since.Format "2006-01-02 15:04:05"}}</td><td>
+<form class="inline" method="post" action="/admin/unban"><input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="address" value="{{.Address}}"><button>Unban</button></form>
+</td></tr>
+{{end}}</table>{{else}}<p>Nobody is banned.</p>{{end}}
+{{template "footer"}}{{end}}
+`))
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var dashboardOnce sync.Once
+
+// dashboardPost posts form to path on the dashboard as a logged-in admin.
+func dashboardPost(t *testing.T, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	dashboardOnce.Do(func() { mountDashboard("admin:secret") })
+	token, csrf := randomToken(), randomToken()
+	sessionsMutex.Lock()
+	sessions[token] = adminSession{csrf: csrf, expires: time.Now().Add(time.Minute)}
+	sessionsMutex.Unlock()
+	t.Cleanup(func() {
+		sessionsMutex.Lock()
+		delete(sessions, token)
+		sessionsMutex.Unlock()
+	})
+	form.Set("csrf", csrf)
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: token})
+	w := httptest.NewRecorder()
+	httpMux.ServeHTTP(w, r)
+	return w
+}
+
+func TestDashboardBanCoversTheHost(t *testing.T) {
+	addr := newTestServer(t)
+	t.Cleanup(func() { unbanAddress("127.0.0.1") })
+	target := newTestClient(t, addr)
+	other := newTestClient(t, addr)
+	mutex.Lock()
+	client := findClient(target.name)
+	mutex.Unlock()
+	if client == nil {
+		t.Fatalf("%s is not in clients", target.name)
+	}
+
+	w := dashboardPost(t, "/admin/ban", url.Values{"address": {client.address}, "reason": {"spam"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /admin/ban: %d %s", w.Code, w.Body)
+	}
+	for _, c := range []*testClient{target, other} {
+		c.expectLine("You have been banned from the chat: spam")
+		c.expectClosed()
+	}
+	mutex.Lock()
+	_, ok := bannedUsers["127.0.0.1"]
+	_, exact := bannedUsers[client.address]
+	mutex.Unlock()
+	if !ok || exact {
+		t.Errorf("banned the address %s rather than its host", client.address)
+	}
+	// Another port of the same host is turned away.
+	expectBanned(t, addr, "spam")
+}
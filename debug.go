@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// httpMux serves the optional HTTP listener.
+var httpMux = http.NewServeMux()
+
+func serveHTTP(listener net.Listener) {
+	if err := http.Serve(listener, httpMux); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println("Error: ", err)
+	}
+}
+
+// mountDebug adds the pprof profiles under /debug/pprof/ and the server
+// counters under /debug/vars. A non-empty auth of the form user:password
+// requires HTTP basic authentication for both.
+func mountDebug(auth string) {
+	expvar.Publish("chat", expvar.Func(func() any { return serverStats() }))
+	handle := func(pattern string, handler http.Handler) {
+		httpMux.Handle(pattern, requireAuth(auth, handler))
+	}
+	handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	handle("/debug/vars", expvar.Handler())
+}
+
+func requireAuth(auth string, handler http.Handler) http.Handler {
+	if auth == "" {
+		return handler
+	}
+	wantUser, wantPassword, _ := strings.Cut(auth, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chat debug"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
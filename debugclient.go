@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// clientDebug is everything the server keeps about a client, for the admin
+// /debugclient command and GET /api/debug/clients/{who}.
+type clientDebug struct {
+	Username       string          `json:"username"`
+	DisplayName    string          `json:"display_name,omitempty"`
+	Address        string          `json:"address"`
+	Protocol       string          `json:"protocol"`
+	Software       string          `json:"software"`
+	Bot            bool            `json:"bot"`
+	Features       []string        `json:"features"`
+	Framed         bool            `json:"framed"`
+	Language       string          `json:"language"`
+	Room           string          `json:"room,omitempty"`
+	OwnedRooms     []string        `json:"owned_rooms"`
+	Connected      time.Time       `json:"connected"`
+	LastActive     time.Time       `json:"last_active"`
+	Counters       counterSnapshot `json:"counters"`
+	QueueLength    int             `json:"queue_length"`
+	QueueDepth     int             `json:"queue_depth"`
+	Dropped        int             `json:"dropped"`
+	SkippedNotices int             `json:"skipped_notices"`
+	QueueFull      string          `json:"queue_full_for,omitempty"`
+	PingsLeft      int             `json:"pings_left"`
+	Blocked        []string        `json:"blocked"`
+	Reminders      int             `json:"reminders"`
+	KeyFingerprint string          `json:"key_fingerprint,omitempty"`
+}
+
+// findDebugClients returns the clients named who, or connected from the
+// IP address or address who.
+func findDebugClients(who string) []*Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var found []*Client
+	for _, client := range clients {
+		host, _, err := net.SplitHostPort(client.address)
+		if client.username == who || client.address == who || (err == nil && host == who) {
+			found = append(found, client)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].address < found[j].address })
+	return found
+}
+
+func debugClient(client *Client) clientDebug {
+	mutex.Lock()
+	defer mutex.Unlock()
+	d := clientDebug{
+		Username:    client.username,
+		DisplayName: client.displayName,
+		Address:     client.address,
+		Protocol:    "text",
+		Software:    client.softwareLabel(),
+		Bot:         client.bot,
+		Features:    []string{},
+		Language:    client.language(),
+		Room:        client.room,
+		OwnedRooms:  []string{},
+		Connected:   client.connected,
+		LastActive:  time.Unix(0, client.lastActive.Load()),
+		Counters:    client.counters.snapshot(),
+		QueueLength: len(client.queue.lines),
+		QueueDepth:  cap(client.queue.lines),
+		Dropped:     client.queue.dropped,
+		Blocked:     []string{},
+		Reminders:   len(client.reminders),
+	}
+	switch {
+	case client.irc:
+		d.Protocol = "irc"
+	case client.json:
+		d.Protocol = "json"
+	}
+	for feature := range client.features {
+		d.Features = append(d.Features, feature)
+	}
+	sort.Strings(d.Features)
+	if wire, ok := client.conn.(*wireConn); ok {
+		d.Framed = wire.framed()
+	}
+	for name, meta := range roomMetas {
+		if meta.owner == client {
+			d.OwnedRooms = append(d.OwnedRooms, name)
+		}
+	}
+	sort.Strings(d.OwnedRooms)
+	d.SkippedNotices = client.queue.skippedNotices
+	if !client.queue.fullSince.IsZero() {
+		d.QueueFull = time.Since(client.queue.fullSince).Round(time.Millisecond).String()
+	}
+	d.PingsLeft = PING_LIMIT
+	if time.Since(client.pingWindow) < PING_WINDOW {
+		d.PingsLeft -= client.pings
+	}
+	for name := range client.blocked {
+		d.Blocked = append(d.Blocked, name)
+	}
+	sort.Strings(d.Blocked)
+	if client.pubkey != nil {
+		d.KeyFingerprint = keyFingerprint(client.pubkey)
+	}
+	return d
+}
+
+// printDebugClient is the admin /debugclient command.
+func printDebugClient(who string) {
+	found := findDebugClients(who)
+	if len(found) == 0 {
+		fmt.Printf("%s is not connected.\n", who)
+		return
+	}
+	for _, client := range found {
+		d := debugClient(client)
+		fmt.Printf("Client %s (%s):\n", d.Username, d.Address)
+		if d.DisplayName != "" {
+			fmt.Printf("  Display name: %s\n", d.DisplayName)
+		}
+		fmt.Printf("  Protocol: %s, software: %s, framed: %v\n", d.Protocol, d.Software, d.Framed)
+		fmt.Printf("  Features: %s\n", orNone(strings.Join(d.Features, ", ")))
+		fmt.Printf("  Language: %s\n", d.Language)
+		fmt.Printf("  Room: %s, owns: %s\n", orNone(d.Room), orNone(strings.Join(d.OwnedRooms, ", ")))
+		fmt.Printf("  Connected %v ago, last active %v ago\n", time.Since(d.Connected).Round(time.Second), time.Since(d.LastActive).Round(time.Second))
+		fmt.Printf("  Messages sent: %d, received: %d, commands: %d\n", d.Counters.MessagesSent, d.Counters.MessagesReceived, d.Counters.Commands)
+		fmt.Printf("  Bytes in: %d, out: %d\n", d.Counters.BytesIn, d.Counters.BytesOut)
+		fmt.Printf("  Queue: %d/%d (high-water %d), dropped: %d, skipped notices: %d, full for: %s\n", d.QueueLength, d.QueueDepth, d.Counters.QueueHighWater, d.Dropped, d.SkippedNotices, orNone(d.QueueFull))
+		fmt.Printf("  Pings left in window: %d/%d, rate limit hits: %d\n", d.PingsLeft, PING_LIMIT, d.Counters.RateLimited)
+		fmt.Printf("  Blocked: %s\n", orNone(strings.Join(d.Blocked, ", ")))
+		fmt.Printf("  Reminders: %d, key: %s\n", d.Reminders, orNone(d.KeyFingerprint))
+	}
+}
+
+// printWhois shows who is behind the clients named who or connected from
+// the address who, for the admin console.
+func printWhois(who string) {
+	found := findDebugClients(who)
+	if len(found) == 0 {
+		fmt.Printf("%s is not connected.\n", who)
+		return
+	}
+	for _, client := range found {
+		d := debugClient(client)
+		name := d.Username
+		if d.Bot {
+			name += " " + BOT_TAG
+		}
+		fmt.Printf("%s (%s): room %s, protocol %s, software %s, connected %v ago\n", name, d.Address, orNone(d.Room), d.Protocol, d.Software, time.Since(d.Connected).Round(time.Second))
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func apiDebugClient(w http.ResponseWriter, r *http.Request) {
+	who := r.PathValue("who")
+	found := findDebugClients(who)
+	if len(found) == 0 {
+		apiError(w, http.StatusNotFound, who+" is not connected.")
+		return
+	}
+	list := make([]clientDebug, len(found))
+	for i, client := range found {
+		list[i] = debugClient(client)
+	}
+	apiJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /list shows DIRECTORY_PAGE_SIZE rooms a page, and a directory event
+// holds up to DIRECTORY_EVENT_ROOMS with a cursor for the rest. A client
+// may list the rooms LIST_LIMIT times per LIST_WINDOW.
+const (
+	DIRECTORY_PAGE_SIZE   = 20
+	DIRECTORY_EVENT_ROOMS = 100
+	LIST_LIMIT            = 10
+	LIST_WINDOW           = 10 * time.Second
+)
+
+// directoryEntry describes a room in the directory sent to clients that
+// asked for the directory feature and in /list.
+type directoryEntry struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	// InviteOnly marks a whitelisted room. Such rooms are only listed for
+	// clients that may join them.
+	InviteOnly bool `json:"invite_only"`
+	// MessagesPerHour counts the chat messages of the last hour, and
+	// LastMessage is the time of the latest one, unset if there is none.
+	MessagesPerHour int        `json:"messages_per_hour"`
+	LastMessage     *time.Time `json:"last_message,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
+}
+
+// roomDirectory returns the rooms client may see, sorted by name; see
+// sortByActivity. The caller must hold mutex.
+func roomDirectory(client *Client) []directoryEntry {
+	entries := []directoryEntry{}
+	now := time.Now()
+	for _, name := range roomNames() {
+		if client.room != name && whitelistError(client, name) != nil {
+			continue
+		}
+		entry := directoryEntry{Name: name, Members: humanCount(rooms[name])}
+		if meta := roomMetas[name]; meta != nil {
+			entry.InviteOnly = meta.whitelist
+			entry.MessagesPerHour = meta.activity.perHour(now)
+			entry.Tags = slices.Clone(meta.tags)
+			if last := meta.activity.last; !last.IsZero() {
+				entry.LastMessage = &last
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// directoryText renders the directory as the text reply to /list, in the
+// language of client.
+func directoryText(client *Client, entries []directoryEntry) string {
+	if len(entries) == 0 {
+		return client.tr("rooms.none") + "\n"
+	}
+	items := make([]string, len(entries))
+	for i, entry := range entries {
+		if entry.LastMessage == nil {
+			items[i] = client.tr("rooms.entry_quiet", shortName(entry.Name), entry.Members)
+		} else {
+			items[i] = client.tr("rooms.entry", shortName(entry.Name), entry.Members, entry.MessagesPerHour, agoText(time.Since(*entry.LastMessage)))
+		}
+		if entry.InviteOnly {
+			items[i] += " " + client.tr("rooms.invite_only")
+		}
+		for _, tag := range entry.Tags {
+			items[i] += " #" + tag
+		}
+	}
+	return client.tr("rooms.list", strings.Join(items, ", ")) + "\n"
+}
+
+// directoryPage returns page, counted from 1, of entries and the number of
+// pages there are.
+func directoryPage(entries []directoryEntry, page int) ([]directoryEntry, int) {
+	pages := (len(entries) + DIRECTORY_PAGE_SIZE - 1) / DIRECTORY_PAGE_SIZE
+	start := min((page-1)*DIRECTORY_PAGE_SIZE, len(entries))
+	return entries[start:min(start+DIRECTORY_PAGE_SIZE, len(entries))], pages
+}
+
+// sendDirectory sends client the directory event, most active rooms
+// first, as a reply to req when that is set. The event starts at cursor,
+// which is "" for the first rooms, and carries the cursor of the next
+// event if there are more rooms.
+func sendDirectory(client *Client, id, req, cursor string) error {
+	start := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return localErrorf("rooms.bad_cursor")
+		}
+		start = n
+	}
+	mutex.Lock()
+	entries := roomDirectory(client)
+	mutex.Unlock()
+	sortByActivity(entries)
+	start = min(start, len(entries))
+	end := min(start+DIRECTORY_EVENT_ROOMS, len(entries))
+	event := jsonEvent{Type: "directory", ID: id, Request: req, Rooms: entries[start:end]}
+	if end < len(entries) {
+		event.Cursor = strconv.Itoa(end)
+	}
+	jsonWrite(client, event)
+	return nil
+}
+
+// allowList counts a listing of the rooms by the client and reports
+// whether it is within LIST_LIMIT. The caller must not hold mutex.
+func (c *Client) allowList() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := time.Now()
+	if now.Sub(c.listWindow) >= LIST_WINDOW {
+		c.listWindow = now
+		c.lists = 0
+	}
+	if c.lists >= LIST_LIMIT {
+		c.counters.rateLimited.Add(1)
+		return localErrorf("rooms.too_many")
+	}
+	c.lists++
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MAX_DISPLAY_LENGTH = 40
+
+// Display names are shown in chat lines and /who but identify nobody:
+// /msg, bans and everything else keep using the username. Two users may
+// share a display name, so /who and /whois always show the username next
+// to it. Chat lines show only the display name, so no display name may be
+// another user's username, nor the other way round.
+
+func init() {
+	registerCommand("/displayname", chatCommand{usage: "/displayname [name]", help: "Set the name shown in your chat lines, or clear it", run: setDisplayName})
+	registerCommand("/whois", chatCommand{usage: "/whois [username]", help: "Show a user's display name and room", run: whois})
+}
+
+func setDisplayName(client *Client, room, args string) {
+	name, err := cleanDisplayName(args)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	if isReserved(name) {
+		client.say("display.reserved")
+		return
+	}
+	mutex.Lock()
+	for _, other := range clients {
+		if other != client && strings.EqualFold(other.username, name) {
+			mutex.Unlock()
+			client.say("display.is_username")
+			return
+		}
+	}
+	client.displayName = name
+	mutex.Unlock()
+	if name == "" {
+		client.say("display.cleared")
+		return
+	}
+	client.say("display.set", name)
+}
+
+// displayNameTaken reports whether a client other than except shows name,
+// in any case, as its display name. The caller must hold mutex.
+func displayNameTaken(name string, except *Client) bool {
+	for _, other := range clients {
+		if other != except && other.displayName != "" && strings.EqualFold(other.displayName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanDisplayName drops control and formatting characters from name and
+// collapses its white space. Brackets and colons are refused so that a
+// display name cannot imitate the parts of a chat line.
+func cleanDisplayName(name string) (string, error) {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	if utf8.RuneCountInString(name) > MAX_DISPLAY_LENGTH {
+		return "", localErrorf("display.too_long", MAX_DISPLAY_LENGTH)
+	}
+	if strings.ContainsAny(name, "[]:") {
+		return "", localErrorf("display.bad_chars")
+	}
+	return name, nil
+}
+
+func whois(client *Client, room, args string) {
+	if args == "" {
+		client.say("whois.usage")
+		return
+	}
+	mutex.Lock()
+	target := findClient(args)
+	var line string
+	if target != nil {
+		if target.room != "" {
+			line = client.tr("whois.in_room", target.label(), target.room) + "\n"
+		} else {
+			line = client.tr("whois.no_room", target.label()) + "\n"
+		}
+		if target == client {
+			line += countersLine(client.counters.snapshot())
+		}
+	}
+	mutex.Unlock()
+	if target == nil {
+		client.say("user.missing", args)
+		return
+	}
+	client.conn.Write([]byte(line))
+}
+
+// label is the display name with the username in parentheses, or just the
+// username. The caller must hold mutex.
+func (c *Client) label() string {
+	label := c.username
+	if c.displayName != "" {
+		label = fmt.Sprintf("%s (%s)", c.displayName, c.username)
+	}
+	if c.bot {
+		label += " " + BOT_TAG
+	}
+	return label
+}
+
+// shortLabel is label with each name cut to NAME_COLUMNS, for lists.
+// The caller must hold mutex.
+func (c *Client) shortLabel() string {
+	label := shortName(c.username)
+	if c.displayName != "" {
+		label = fmt.Sprintf("%s (%s)", shortName(c.displayName), shortName(c.username))
+	}
+	if c.bot {
+		label += " " + BOT_TAG
+	}
+	return label
+}
+
+// roomLabels returns the labels of the users in room sorted by username,
+// marking those who are away in the language of viewer. The caller must
+// hold mutex.
+func roomLabels(viewer *Client, room string) []string {
+	members := append([]*Client(nil), rooms[room]...)
+	sort.Slice(members, func(i, j int) bool { return members[i].username < members[j].username })
+	labels := make([]string, len(members))
+	now := time.Now()
+	for i, c := range members {
+		labels[i] = c.awayLabel(viewer, c.shortLabel(), now)
+	}
+	return labels
+}
+
+// userChat is a chat message from client to room, shown under its display
+// name.
+func userChat(client *Client, room, body string) Message {
+	mutex.Lock()
+	from, display, bot := client.username, client.displayName, client.bot
+	mutex.Unlock()
+	message := chatMessage(room, from, body)
+	message.author, message.bot = client, bot
+	name := from
+	if display != "" {
+		message.display = display
+		name = display
+	}
+	if display != "" || bot {
+		message.text = chatLineAt(room, time.Now().Format("3:04PM"), name, bot, body)
+	}
+	return message
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestCleanDisplayName(t *testing.T) {
+	tests := []struct {
+		name, want string
+		wantErr    bool
+	}{
+		{"", "", false},
+		{"Alice", "Alice", false},
+		{"  Alice   Smith ", "Alice Smith", false},
+		{"Tab\tSeparated", "TabSeparated", false},
+		{"Bell\a", "Bell", false},
+		{"\x1b[31mred", "[31mred", true},
+		{"zero​width", "zerowidth", false},
+		{"bad\xffbyte", "badbyte", false},
+		{"Алия", "Алия", false},
+		{"[lobby]", "", true},
+		{"Ops: admin", "", true},
+		{"0123456789012345678901234567890123456789", "0123456789012345678901234567890123456789", false},
+		{"01234567890123456789012345678901234567890", "", true},
+	}
+	for _, test := range tests {
+		got, err := cleanDisplayName(test.name)
+		if (err != nil) != test.wantErr {
+			t.Errorf("cleanDisplayName(%q) error = %v, want error %t", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("cleanDisplayName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDisplayNamesAndUsernamesDoNotCollide(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	name := uniqueName("Mallory")
+	alice.send("/displayname " + name)
+	alice.expectLine("Display name set to " + name)
+	bob.send("/nick " + name)
+	bob.expectLine("ERR_NAME_TAKEN")
+	bob.send("/displayname " + alice.name)
+	bob.expectLine("ERR_NAME_TAKEN")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// PUBKEY_SIZE is the size of the X25519 public keys clients publish for
+// encrypted private messages. The server only relays those messages and
+// never sees their text.
+const PUBKEY_SIZE = 32
+
+func init() {
+	registerCommand("/key", chatCommand{usage: "/key [username]", help: "Show a user's public key for encrypted messages", run: showKey})
+}
+
+// keyFingerprint is the form in which keys are shown for comparing.
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// publishKey stores the base64 public key of client.
+func publishKey(client *Client, encoded string) error {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != PUBKEY_SIZE {
+		return localErrorf("key.invalid", PUBKEY_SIZE)
+	}
+	mutex.Lock()
+	client.pubkey = key
+	mutex.Unlock()
+	return nil
+}
+
+// lookupKey returns the public key of the user name.
+func lookupKey(name string) ([]byte, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	target := findClient(name)
+	if target == nil {
+		return nil, localErrorf("user.missing", name)
+	}
+	if target.pubkey == nil {
+		return nil, localErrorf("key.none", name)
+	}
+	return target.pubkey, nil
+}
+
+func showKey(client *Client, room, name string) {
+	if name == "" {
+		client.say("key.usage")
+		return
+	}
+	key, err := lookupKey(name)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	client.say("key.show", name, base64.StdEncoding.EncodeToString(key), keyFingerprint(key))
+}
+
+// sendEncryptedPM relays an encrypted private message as it is, along with
+// the sender's key so the recipient can open it. Both ends need a key and
+// the recipient has to use the JSON protocol.
+func sendEncryptedPM(client *Client, req jsonRequest) error {
+	if req.Nonce == "" || req.Ciphertext == "" {
+		return localErrorf("e2e.incomplete")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if client.pubkey == nil {
+		return localErrorf("e2e.no_key")
+	}
+	target := findClient(req.To)
+	if target == nil {
+		return localErrorf("user.missing", req.To)
+	}
+	if err := blockError(client, target); err != nil {
+		return err
+	}
+	if target.pubkey == nil || !target.json || !target.supports("e2e") {
+		return localErrorf("key.none", req.To)
+	}
+	if !target.subscribed("", CHANNEL_PM) {
+		return nil
+	}
+	event := jsonEvent{Type: "encrypted_pm", From: client.username, Key: base64.StdEncoding.EncodeToString(client.pubkey), Nonce: req.Nonce, Ciphertext: req.Ciphertext}
+	return target.enqueue(jsonLine(event), QUEUE_CHAT)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+// newLibraryClient connects to addr with the chatclient library in JSON
+// mode as name. Its messages after the name was set are passed on to the
+// returned channel, so that the client never waits on the test.
+func newLibraryClient(t *testing.T, addr, name string) (*chatclient.Client, <-chan chatclient.Message) {
+	t.Helper()
+	_, roots := testCertificate(t)
+	client, err := chatclient.Dial(addr, chatclient.Config{TLS: &tls.Config{RootCAs: roots}, JSON: true, Username: name, Timeout: LINE_TIMEOUT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	awaitMessage(t, client.Messages(), func(msg chatclient.Message) bool { return msg.Request == "nick" && msg.Type == "ok" })
+	messages := make(chan chatclient.Message, 100)
+	go func() {
+		defer close(messages)
+		for msg := range client.Messages() {
+			messages <- msg
+		}
+	}()
+	return client, messages
+}
+
+// awaitMessage reads messages until match accepts one and returns it.
+func awaitMessage(t *testing.T, messages <-chan chatclient.Message, match func(chatclient.Message) bool) chatclient.Message {
+	t.Helper()
+	timeout := time.After(LINE_TIMEOUT)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+}
+
+func TestEncryptedPMRelay(t *testing.T) {
+	addr := newTestServer(t)
+	aliceName, bobName, carolName := uniqueName("alice"), uniqueName("bob"), uniqueName("carol")
+	alice, aliceMessages := newLibraryClient(t, addr, aliceName)
+	bob, bobMessages := newLibraryClient(t, addr, bobName)
+	newLibraryClient(t, addr, carolName)
+	published := func(msg chatclient.Message) bool { return msg.Request == "pubkey" && msg.Type == "ok" }
+	for _, c := range []struct {
+		client   *chatclient.Client
+		messages <-chan chatclient.Message
+	}{{alice, aliceMessages}, {bob, bobMessages}} {
+		if err := c.client.EnableEncryption(); err != nil {
+			t.Fatal(err)
+		}
+		awaitMessage(t, c.messages, published)
+	}
+
+	if err := alice.SendEncrypted(bobName, "meet at noon", LINE_TIMEOUT); err != nil {
+		t.Fatal(err)
+	}
+	msg := awaitMessage(t, bobMessages, func(msg chatclient.Message) bool { return msg.Encrypted })
+	if msg.User != aliceName || msg.Text != "meet at noon" {
+		t.Errorf("bob got %+v, want the decrypted message from %s", msg, aliceName)
+	}
+
+	err := alice.SendEncrypted(carolName, "hi", LINE_TIMEOUT)
+	if !chatclient.IsCode(err, codes.ERR_REJECTED) || !strings.Contains(err.Error(), carolName+" has no key") {
+		t.Errorf("SendEncrypted to a user without a key = %v, want that they have none", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+
+	"final_project/codes"
+)
+
+// errorCodes gives the code of each message key that rejects a request;
+// say and sayError put it in front of the text.
+var errorCodes = map[string]string{
+	"welcome.banned":        codes.ERR_BANNED,
+	"welcome.banned_reason": codes.ERR_BANNED,
+	"welcome.full":          codes.ERR_SERVER_FULL,
+	"command.unknown":       codes.ERR_UNKNOWN_COMMAND,
+
+	"room.join_first":       codes.ERR_NO_ROOM,
+	"room.join_first_short": codes.ERR_NO_ROOM,
+	"room.not_in":           codes.ERR_NO_ROOM,
+	"room.invalid_name":     codes.ERR_BAD_NAME,
+	"room.missing":          codes.ERR_NO_SUCH_ROOM,
+	"room.exists":           codes.ERR_ROOM_EXISTS,
+	"room.limit":            codes.ERR_LIMIT,
+	"room.owner_limit":      codes.ERR_LIMIT,
+	"rooms.usage":           codes.ERR_BAD_REQUEST,
+	"rooms.no_page":         codes.ERR_BAD_REQUEST,
+	"rooms.bad_cursor":      codes.ERR_BAD_REQUEST,
+	"rooms.too_many":        codes.ERR_RATE_LIMITED,
+
+	"join.usage":         codes.ERR_BAD_REQUEST,
+	"create.usage":       codes.ERR_BAD_REQUEST,
+	"nick.usage":         codes.ERR_BAD_REQUEST,
+	"nick.invalid":       codes.ERR_BAD_NAME,
+	"nick.reserved":      codes.ERR_NAME_TAKEN,
+	"nick.taken":         codes.ERR_NAME_TAKEN,
+	"nick.display_taken": codes.ERR_NAME_TAKEN,
+	"nick.required":      codes.ERR_NO_NAME,
+
+	"msg.usage":      codes.ERR_BAD_REQUEST,
+	"user.missing":   codes.ERR_NO_SUCH_USER,
+	"paste.usage":    codes.ERR_BAD_REQUEST,
+	"paste.too_long": codes.ERR_TOO_LONG,
+	"chat.too_fast":  codes.ERR_RATE_LIMITED,
+	"chat.slow_mode": codes.ERR_RATE_LIMITED,
+	"ping.too_many":  codes.ERR_RATE_LIMITED,
+
+	"whitelist.usage":       codes.ERR_BAD_REQUEST,
+	"whitelist.owner_only":  codes.ERR_NOT_ALLOWED,
+	"whitelist.invite_only": codes.ERR_NOT_ALLOWED,
+	"allow.usage":           codes.ERR_BAD_REQUEST,
+	"allow.owner_only":      codes.ERR_NOT_ALLOWED,
+	"greeting.owner_only":   codes.ERR_NOT_ALLOWED,
+	"greeting.too_long":     codes.ERR_TOO_LONG,
+	"tags.owner_only":       codes.ERR_NOT_ALLOWED,
+	"tags.invalid":          codes.ERR_BAD_NAME,
+	"tags.too_many":         codes.ERR_LIMIT,
+	"invite.usage":          codes.ERR_BAD_REQUEST,
+	"block.usage":           codes.ERR_BAD_REQUEST,
+	"block.self":            codes.ERR_BAD_REQUEST,
+	"block.refused":         codes.ERR_NOT_ALLOWED,
+	"unblock.usage":         codes.ERR_BAD_REQUEST,
+	"unblock.not_blocked":   codes.ERR_BAD_REQUEST,
+	"watch.usage":           codes.ERR_BAD_REQUEST,
+	"watch.full":            codes.ERR_LIMIT,
+	"unwatch.usage":         codes.ERR_BAD_REQUEST,
+	"unwatch.not_watched":   codes.ERR_BAD_REQUEST,
+	"history.off":           codes.ERR_NOT_ALLOWED,
+	"sethistory.usage":      codes.ERR_BAD_REQUEST,
+	"sethistory.owner_only": codes.ERR_NOT_ALLOWED,
+	"history.usage":         codes.ERR_BAD_REQUEST,
+	"export.usage":          codes.ERR_BAD_REQUEST,
+	"export.too_soon":       codes.ERR_RATE_LIMITED,
+	"json.unsupported":      codes.ERR_NOT_ALLOWED,
+	"json.empty_text":       codes.ERR_BAD_REQUEST,
+	"json.unknown_request":  codes.ERR_BAD_REQUEST,
+	"json.unknown_channel":  codes.ERR_BAD_REQUEST,
+	"json.bad_request":      codes.ERR_BAD_REQUEST,
+	"json.not_in_room":      codes.ERR_NO_ROOM,
+	"lang.unknown":          codes.ERR_BAD_REQUEST,
+	"prefs.usage":           codes.ERR_BAD_REQUEST,
+	"prefs.unknown":         codes.ERR_BAD_REQUEST,
+	"prefs.bad_echo":        codes.ERR_BAD_REQUEST,
+	"prefs.bad_timezone":    codes.ERR_BAD_REQUEST,
+	"prefs.bad_newlines":    codes.ERR_BAD_REQUEST,
+
+	"hello.usage":            codes.ERR_BAD_REQUEST,
+	"display.reserved":       codes.ERR_NAME_TAKEN,
+	"display.is_username":    codes.ERR_NAME_TAKEN,
+	"display.too_long":       codes.ERR_TOO_LONG,
+	"away.too_long":          codes.ERR_TOO_LONG,
+	"display.bad_chars":      codes.ERR_BAD_NAME,
+	"whois.usage":            codes.ERR_BAD_REQUEST,
+	"roll.usage":             codes.ERR_BAD_REQUEST,
+	"8ball.usage":            codes.ERR_BAD_REQUEST,
+	"poll.usage":             codes.ERR_BAD_REQUEST,
+	"poll.too_many":          codes.ERR_LIMIT,
+	"poll.open":              codes.ERR_REJECTED,
+	"poll.none":              codes.ERR_REJECTED,
+	"poll.owner_only":        codes.ERR_NOT_ALLOWED,
+	"vote.usage":             codes.ERR_BAD_REQUEST,
+	"remind.usage":           codes.ERR_BAD_REQUEST,
+	"remind.too_far":         codes.ERR_BAD_REQUEST,
+	"remind.too_many":        codes.ERR_LIMIT,
+	"reminders.cancel_usage": codes.ERR_BAD_REQUEST,
+	"search.usage":           codes.ERR_BAD_REQUEST,
+	"rban.usage":             codes.ERR_BAD_REQUEST,
+	"rban.owner_only":        codes.ERR_NOT_ALLOWED,
+	"rban.no_room":           codes.ERR_NO_SUCH_ROOM,
+	"rban.owner":             codes.ERR_NOT_ALLOWED,
+	"rban.refused":           codes.ERR_BANNED,
+	"runban.usage":           codes.ERR_BAD_REQUEST,
+	"runban.owner_only":      codes.ERR_NOT_ALLOWED,
+	"retention.usage":        codes.ERR_BAD_REQUEST,
+	"retention.owner_only":   codes.ERR_NOT_ALLOWED,
+	"key.usage":              codes.ERR_BAD_REQUEST,
+	"key.invalid":            codes.ERR_BAD_REQUEST,
+	"e2e.incomplete":         codes.ERR_BAD_REQUEST,
+}
+
+// errorCode returns the code err is reported with.
+func errorCode(err error) string {
+	var local *localError
+	if errors.As(err, &local) && errorCodes[local.key] != "" {
+		return errorCodes[local.key]
+	}
+	return codes.ERR_REJECTED
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"final_project/chatclient"
+	"final_project/codes"
+)
+
+func TestLibraryServerErrors(t *testing.T) {
+	addr := newTestServer(t)
+	taken := uniqueName("taken")
+	newLibraryClient(t, addr, taken)
+	client, messages := newLibraryClient(t, addr, uniqueName("bot"))
+
+	_, err := client.SendWait("hello", LINE_TIMEOUT)
+	var serverErr *chatclient.ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("SendWait outside a room returned %v, want a ServerError", err)
+	}
+	if serverErr.Code != codes.ERR_NO_ROOM || serverErr.Text == "" || strings.HasPrefix(serverErr.Text, "ERR_") {
+		t.Errorf("got code %q and text %q, want ERR_NO_ROOM and its explanation", serverErr.Code, serverErr.Text)
+	}
+	wrapped := fmt.Errorf("sending: %w", err)
+	if !chatclient.IsCode(wrapped, codes.ERR_NO_ROOM) || chatclient.IsCode(wrapped, codes.ERR_REJECTED) {
+		t.Errorf("IsCode does not see ERR_NO_ROOM through %v", wrapped)
+	}
+
+	if err := client.Nick(taken); err != nil {
+		t.Fatal(err)
+	}
+	reply := awaitMessage(t, messages, func(msg chatclient.Message) bool { return msg.Type == "error" })
+	if reply.Code != codes.ERR_NAME_TAKEN {
+		t.Errorf("taking a used name got %+v, want ERR_NAME_TAKEN", reply)
+	}
+
+	client.Close()
+	<-client.Done()
+	if _, err := client.SendWait("hello", LINE_TIMEOUT); !errors.Is(err, chatclient.ErrClosed) {
+		t.Errorf("SendWait after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestTextModeErrorCodes(t *testing.T) {
+	addr := newTestServer(t)
+	_, roots := testCertificate(t)
+	client, err := chatclient.Dial(addr, chatclient.Config{TLS: &tls.Config{RootCAs: roots}, Username: uniqueName("text"), Timeout: LINE_TIMEOUT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Send("hello"); err != nil {
+		t.Fatal(err)
+	}
+	msg := awaitMessage(t, client.Messages(), func(msg chatclient.Message) bool { return msg.Code != "" })
+	if msg.Code != codes.ERR_NO_ROOM || strings.HasPrefix(msg.Text, "ERR_") {
+		t.Errorf("got code %q and text %q, want ERR_NO_ROOM and its explanation", msg.Code, msg.Text)
+	}
+
+	// The code stays the same when the explanation is translated.
+	if err := client.Send("/lang ru"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send("hello"); err != nil {
+		t.Fatal(err)
+	}
+	translated := awaitMessage(t, client.Messages(), func(msg chatclient.Message) bool { return msg.Code != "" })
+	if translated.Code != codes.ERR_NO_ROOM || translated.Text == msg.Text {
+		t.Errorf("in Russian got code %q and text %q, want ERR_NO_ROOM with translated text", translated.Code, translated.Text)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban evasion detection is a best-effort heuristic, off by default. When a
+// client is banned its fingerprint is remembered for -evasion-window; a
+// later connection presenting the same TLS client certificate, or the same
+// HELLO and then the same username, is logged as a possible evasion. In
+// strict mode it is also banned. Clients that did not say HELLO never match
+// on the HELLO rule, so a plain connection reusing a name is not flagged.
+const (
+	EVASION_OFF    = "off"
+	EVASION_FLAG   = "flag"
+	EVASION_STRICT = "strict"
+
+	DEFAULT_EVASION_WINDOW = 24 * time.Hour
+	// MAX_EVASION_CACHE bounds the remembered fingerprints; the oldest go
+	// first.
+	MAX_EVASION_CACHE = 256
+)
+
+var (
+	evasionMode   = EVASION_OFF
+	evasionWindow = DEFAULT_EVASION_WINDOW
+
+	evasionMutex sync.Mutex
+	recentBans   []banFingerprint
+)
+
+// banFingerprint is what is remembered about a banned client.
+type banFingerprint struct {
+	address  string
+	username string
+	hello    string
+	cert     string
+	reason   string
+	time     time.Time
+}
+
+// rememberBan keeps the fingerprint of client, which is being banned for
+// reason. The caller must not hold mutex.
+func rememberBan(client *Client, reason string) {
+	if evasionMode == EVASION_OFF {
+		return
+	}
+	mutex.Lock()
+	fp := banFingerprint{address: client.address, username: client.username, hello: helloFingerprint(client), cert: certFingerprint(client), reason: reason, time: time.Now()}
+	mutex.Unlock()
+	evasionMutex.Lock()
+	pruneBanFingerprints(fp.time)
+	recentBans = append(recentBans, fp)
+	if len(recentBans) > MAX_EVASION_CACHE {
+		recentBans = recentBans[len(recentBans)-MAX_EVASION_CACHE:]
+	}
+	evasionMutex.Unlock()
+	log.Printf("Evasion: remembering %s (%s), HELLO %q, certificate %s", fp.address, fp.username, fp.hello, orNone(fp.cert))
+}
+
+// pruneBanFingerprints drops the fingerprints older than evasionWindow.
+// The caller must hold evasionMutex.
+func pruneBanFingerprints(now time.Time) {
+	i := 0
+	for i < len(recentBans) && now.Sub(recentBans[i].time) > evasionWindow {
+		i++
+	}
+	recentBans = recentBans[i:]
+}
+
+// checkEvasion compares client, which is trying to use username ("" when it
+// has not tried one yet), with the recently banned clients. A match is
+// logged, and in strict mode the client is banned and disconnected, which
+// checkEvasion reports. The caller must not hold mutex.
+func checkEvasion(client *Client, username string) bool {
+	if evasionMode == EVASION_OFF {
+		return false
+	}
+	mutex.Lock()
+	hello, cert, address := helloFingerprint(client), certFingerprint(client), client.address
+	mutex.Unlock()
+	var match banFingerprint
+	why := ""
+	evasionMutex.Lock()
+	pruneBanFingerprints(time.Now())
+	for i := len(recentBans) - 1; i >= 0 && why == ""; i-- {
+		fp := recentBans[i]
+		switch {
+		case cert != "" && fp.cert == cert:
+			why = "same TLS client certificate " + cert
+		case hello != "" && username != "" && fp.hello == hello && strings.EqualFold(fp.username, username):
+			why = fmt.Sprintf("same HELLO %q and username %q", hello, username)
+		}
+		match = fp
+	}
+	evasionMutex.Unlock()
+	if why == "" {
+		return false
+	}
+	log.Printf("Possible ban evasion: %s matches %s (%s), banned %v ago%s: %s", address, match.address, match.username, time.Since(match.time).Round(time.Second), reasonSuffix(match.reason), why)
+	if evasionMode != EVASION_STRICT {
+		return false
+	}
+	reason := "ban evasion" + reasonSuffix(match.reason)
+	if banned := banHost(address, reason); banned != "" {
+		log.Printf("Evasion: banning %s automatically (strict mode)", banned)
+		evictUser(client, "banned", reason)
+	} else {
+		log.Printf("Evasion: cannot ban %s, kicking it instead (strict mode)", address)
+		evictUser(client, "kicked", reason)
+	}
+	return true
+}
+
+// helloFingerprint is the client's HELLO as the server understood it, or
+// "" if it did not say one. The caller must hold mutex.
+func helloFingerprint(client *Client) string {
+	if client.software == "" {
+		return ""
+	}
+	features := make([]string, 0, len(client.features))
+	for feature := range client.features {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+	return client.softwareLabel() + " " + strings.Join(features, ",")
+}
+
+// certFingerprint is the SHA-256 of the TLS client certificate, if the
+// client presented one.
+func certFingerprint(client *Client) string {
+	wire, ok := client.conn.(*wireConn)
+	if !ok {
+		return ""
+	}
+	tlsConn, ok := wire.Conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(certs[0].Raw))
+}
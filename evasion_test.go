@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBanHost(t *testing.T) {
+	tests := []struct {
+		addr, banned string
+	}{
+		{"203.0.113.7:4000", "203.0.113.7"},
+		{"[2001:db8::1]:4000", "2001:db8::1"},
+		{"unix:uid=1000", "unix:uid=1000"},
+		{UNIX_ANONYMOUS, ""},
+	}
+	for _, test := range tests {
+		banned := banHost(test.addr, "test")
+		if banned != "" {
+			unbanAddress(banned)
+		}
+		if banned != test.banned {
+			t.Errorf("banHost(%q) = %q, want %q", test.addr, banned, test.banned)
+		}
+	}
+}
+
+func TestStrictEvasionBansTheHost(t *testing.T) {
+	// Set before the server starts, which reads it in other goroutines.
+	previous := evasionMode
+	evasionMode = EVASION_STRICT
+	t.Cleanup(func() {
+		evasionMode = previous
+		evasionMutex.Lock()
+		recentBans = nil
+		evasionMutex.Unlock()
+		unbanAddress("127.0.0.1")
+	})
+	addr := newTestServer(t)
+	name := uniqueName("evader")
+	connect := func() *testClient {
+		c := newTestClient(t, addr)
+		c.send("HELLO evader 1 features=ping")
+		c.expectLine("WELCOME features=ping")
+		c.send("/nick " + name)
+		return c
+	}
+
+	banned := connect()
+	banned.expectLine("Username set to " + name)
+	mutex.Lock()
+	client := findClient(name)
+	mutex.Unlock()
+	if client == nil {
+		t.Fatalf("%s is not in clients", name)
+	}
+	rememberBan(client, "spam")
+	banned.send("/quit")
+	banned.expectClosed()
+
+	evader := connect()
+	evader.expectLine("You have been banned from the chat: ban evasion")
+	evader.expectClosed()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := bannedUsers["127.0.0.1"]; !ok {
+		t.Errorf("127.0.0.1 is not banned; bans: %v", bannedUsers)
+	}
+	for address := range bannedUsers {
+		if strings.HasPrefix(address, "127.0.0.1:") {
+			t.Errorf("banned %s rather than the host", address)
+		}
+	}
+}
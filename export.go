@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EXPORT_MAX is the most messages one /export returns, and how many
+	// it returns without a count.
+	EXPORT_MAX = 500
+	// EXPORT_INTERVAL is how long a client waits between exports.
+	EXPORT_INTERVAL = time.Minute
+	// EXPORT_TIME is the format of export timestamps, which always carry
+	// the date.
+	EXPORT_TIME = "2006-01-02 15:04:05"
+)
+
+func init() {
+	registerCommand("/export", chatCommand{usage: "/export [count]", help: "Save your room's recent messages as one block", needsRoom: true, run: exportCommand})
+}
+
+// exportEvents returns the last count chat messages of room that client
+// may read under the room's /sethistory, and counts the export against
+// EXPORT_INTERVAL.
+func exportEvents(client *Client, room string, count int) ([]Message, error) {
+	if count < 1 || count > EXPORT_MAX {
+		return nil, localErrorf("export.usage", EXPORT_MAX)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := time.Now()
+	if wait := EXPORT_INTERVAL - now.Sub(client.lastExport); wait > 0 {
+		client.counters.rateLimited.Add(1)
+		return nil, localErrorf("export.too_soon", int(wait.Round(time.Second).Seconds()))
+	}
+	if roomHistoryVisibility(room) == HISTORY_OFF {
+		return nil, localErrorf("history.off", room)
+	}
+	kept, _ := historySince(room, historyStart(client, room))
+	var events []Message
+	for _, message := range kept {
+		if message.kind == MESSAGE_CHAT {
+			events = append(events, message)
+		}
+	}
+	if len(events) == 0 {
+		return nil, localErrorf("history.none", room)
+	}
+	client.lastExport = now
+	return events[max(len(events)-count, 0):], nil
+}
+
+func exportCommand(client *Client, room, args string) {
+	count := EXPORT_MAX
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil {
+			client.say("export.usage", EXPORT_MAX)
+			return
+		}
+		count = n
+	}
+	events, err := exportEvents(client, room, count)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	client.conn.Write([]byte(exportText(client, room, events)))
+}
+
+// exportText renders events as a plain text block between delimiter
+// lines, in the client's time zone.
+func exportText(client *Client, room string, events []Message) string {
+	loc := client.timeZone()
+	first, last := events[0].time.In(loc), events[len(events)-1].time.In(loc)
+	var b strings.Builder
+	b.WriteString(client.tr("export.begin", room, len(events), first.Format(EXPORT_TIME), last.Format(EXPORT_TIME), first.Format("MST")) + "\n")
+	for _, message := range events {
+		name := message.from
+		if message.display != "" {
+			name = message.display
+		}
+		b.WriteString(chatLineAt(room, message.time.In(loc).Format(EXPORT_TIME), name, message.bot, message.body))
+	}
+	b.WriteString(client.tr("export.end", room) + "\n")
+	return b.String()
+}
+
+// jsonExport serves the export request: one export event holding the
+// messages as chat events. The room, if given, must be the client's.
+func jsonExport(client *Client, req jsonRequest) (jsonEvent, error) {
+	mutex.Lock()
+	room := client.room
+	mutex.Unlock()
+	if room == "" || (req.Room != "" && req.Room != room) {
+		return jsonEvent{}, localErrorf("room.not_in")
+	}
+	count := req.Count
+	if count == 0 {
+		count = EXPORT_MAX
+	}
+	events, err := exportEvents(client, room, count)
+	if err != nil {
+		return jsonEvent{}, err
+	}
+	export := jsonEvent{Type: "export", Room: room}
+	for _, message := range events {
+		export.Messages = append(export.Messages, toJSONEvent(message))
+	}
+	return export, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Messages to more than fanout-threshold clients at once, such as
+// announcements to everyone, are paced: every FANOUT_TICK a share of the
+// recipients gets the message, so that all of them have it within
+// fanout-window. Room chat keeps the mutex in between instead of waiting
+// for thousands of writes. Room events, local or from peers, are not paced,
+// since a later message in the room could then overtake them.
+const FANOUT_TICK = 50 * time.Millisecond
+
+type fanout struct {
+	members []*Client
+	message Message
+}
+
+// fanouts are handled one at a time, so paced messages stay in order.
+var fanouts = make(chan fanout, 16)
+
+// fanOut delivers message to members, pacing it if there are more than
+// fanout-threshold of them. The caller must not hold mutex.
+func fanOut(members []*Client, message Message) {
+	threshold := config().fanoutThreshold
+	if threshold == 0 || len(members) <= threshold {
+		mutex.Lock()
+		deliverAll(members, message)
+		mutex.Unlock()
+		return
+	}
+	fanouts <- fanout{members, message}
+}
+
+func runFanouts() {
+	for f := range fanouts {
+		window := config().fanoutWindow
+		ticks := max(int(window/FANOUT_TICK), 1)
+		share := (len(f.members) + ticks - 1) / ticks
+		log.Printf("Pacing a message to %d clients over %v", len(f.members), window)
+		for start := 0; start < len(f.members); start += share {
+			if start > 0 {
+				time.Sleep(FANOUT_TICK)
+			}
+			mutex.Lock()
+			var live []*Client
+			for _, client := range f.members[start:min(start+share, len(f.members))] {
+				// Skip clients that left since the message was sent.
+				if clients[client.conn] == client {
+					live = append(live, client)
+				}
+			}
+			deliverAll(live, f.message)
+			mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sinkConn is a connection whose writes succeed at once, counting those
+// that contain match. Unlike a net.Pipe it needs no reading goroutine, so
+// thousands of them stay under the race detector's goroutine limit.
+type sinkConn struct {
+	addr   net.Addr
+	match  []byte
+	count  *atomic.Int64
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *sinkConn) Read([]byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *sinkConn) Write(b []byte) (int, error) {
+	if len(c.match) > 0 && bytes.Contains(b, c.match) {
+		c.count.Add(1)
+	}
+	return len(b), nil
+}
+
+func (c *sinkConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *sinkConn) LocalAddr() net.Addr              { return c.addr }
+func (c *sinkConn) RemoteAddr() net.Addr             { return c.addr }
+func (c *sinkConn) SetDeadline(time.Time) error      { return nil }
+func (c *sinkConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *sinkConn) SetWriteDeadline(time.Time) error { return nil }
+
+// simulatedClients registers n clients on sinkConns, in room unless it is
+// "", counting the writes to them that contain match in count. They are
+// removed when the test ends.
+func simulatedClients(tb testing.TB, n int, room, match string, count *atomic.Int64) []*Client {
+	tb.Helper()
+	startServer()
+	simulated := make([]*Client, n)
+	for i := range simulated {
+		conn := &sinkConn{
+			addr:   &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: i},
+			match:  []byte(match),
+			count:  count,
+			closed: make(chan struct{}),
+		}
+		simulated[i] = newClient(conn)
+		simulated[i].username = uniqueName("sim")
+	}
+	mutex.Lock()
+	for _, client := range simulated {
+		clients[client.conn] = client
+		if room != "" {
+			if _, ok := rooms[room]; !ok {
+				rooms[room] = []*Client{}
+			}
+			client.room = room
+			rooms[room] = append(rooms[room], client)
+		}
+	}
+	mutex.Unlock()
+	tb.Cleanup(func() {
+		mutex.Lock()
+		for _, client := range simulated {
+			delete(clients, client.conn)
+			if room != "" {
+				removeMember(room, client)
+			}
+		}
+		mutex.Unlock()
+		for _, client := range simulated {
+			client.stop()
+			client.conn.Close()
+		}
+	})
+	return simulated
+}
+
+const (
+	FANOUT_RECIPIENTS = 5000
+	// FANOUT_MAX_LATENCY is the longest room chat may take to arrive while
+	// the announcement is paced out.
+	FANOUT_MAX_LATENCY = 500 * time.Millisecond
+)
+
+func TestChatDuringLargeAnnouncement(t *testing.T) {
+	if testing.Short() {
+		t.Skip("registers 5,000 clients")
+	}
+	withSettings(t, func(s *settings) {
+		s.fanoutThreshold = 1000
+		s.fanoutWindow = 2 * time.Second
+	})
+	addr := newTestServer(t)
+	var announced atomic.Int64
+	simulatedClients(t, FANOUT_RECIPIENTS, "", "Announcement: big news", &announced)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("fanout")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	bob.expectLine("Joined room " + room)
+
+	start := time.Now()
+	announce("all", "big news")
+	var slowest time.Duration
+	for i := range 10 {
+		sent := time.Now()
+		bob.send(fmt.Sprint("during the announcement ", i))
+		alice.expectLine(fmt.Sprint("during the announcement ", i))
+		slowest = max(slowest, time.Since(sent))
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := announced.Load(); n == FANOUT_RECIPIENTS {
+		t.Fatalf("the announcement reached everyone in %v, before the chat ended", time.Since(start))
+	}
+	if slowest > FANOUT_MAX_LATENCY {
+		t.Errorf("room chat took up to %v during the announcement, want at most %v", slowest, FANOUT_MAX_LATENCY)
+	}
+	waitFor(t, "the announcement to reach every client", func() bool {
+		return announced.Load() == FANOUT_RECIPIENTS
+	})
+}
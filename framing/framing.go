@@ -0,0 +1,77 @@
+// Package framing reads and writes length-prefixed frames: a 4-byte
+// big-endian payload length followed by that many bytes of payload. It is
+// the optional wire format of the chat server and chatclient, negotiated
+// with the "framing" feature in the HELLO handshake.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	HEADER_SIZE      = 4
+	DEFAULT_MAX_SIZE = 64 * 1024
+)
+
+// ErrFrameTooLarge is returned for frames bigger than the maximum size.
+// The stream cannot be read further after it.
+var ErrFrameTooLarge = errors.New("framing: frame too large")
+
+// Reader reads frames from an underlying reader.
+type Reader struct {
+	r      io.Reader
+	max    int
+	header [HEADER_SIZE]byte
+}
+
+// NewReader returns a Reader refusing frames larger than max bytes.
+func NewReader(r io.Reader, max int) *Reader {
+	return &Reader{r: r, max: max}
+}
+
+// ReadFrame returns the payload of the next frame. It returns io.EOF only
+// when the stream ends between frames and io.ErrUnexpectedEOF when it ends
+// inside one.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(r.header[:])
+	if uint64(size) > uint64(r.max) {
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Writer writes frames to an underlying writer.
+type Writer struct {
+	w   io.Writer
+	max int
+}
+
+// NewWriter returns a Writer refusing payloads larger than max bytes.
+func NewWriter(w io.Writer, max int) *Writer {
+	return &Writer{w: w, max: max}
+}
+
+// WriteFrame writes payload as one frame with a single Write call, so
+// frames from concurrent writers on a connection do not interleave.
+func (w *Writer) WriteFrame(payload []byte) error {
+	if len(payload) > w.max {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, HEADER_SIZE+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[HEADER_SIZE:], payload)
+	_, err := w.w.Write(frame)
+	return err
+}
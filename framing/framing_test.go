@@ -0,0 +1,100 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// frame returns payload with its header, as WriteFrame writes it.
+func frame(payload string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{}, 16)
+	f.Add(frame(""), 16)
+	f.Add(frame("HELLO gochat-cli 1 features=framing"), 64)
+	f.Add(frame(`{"type":"send","room":"lobby","text":"hi"}`), DEFAULT_MAX_SIZE)
+	f.Add(append(frame("/join lobby"), frame("hello\nworld")...), 64)
+	f.Add(frame("too large for the limit"), 4)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 'x'}, DEFAULT_MAX_SIZE)
+	f.Add([]byte{0, 0, 0, 9, 's', 'h', 'o', 'r', 't'}, 64)
+	f.Add([]byte{0, 0}, 64)
+	f.Fuzz(func(t *testing.T, data []byte, max int) {
+		if max < 0 || max > DEFAULT_MAX_SIZE {
+			return
+		}
+		reader := NewReader(bytes.NewReader(data), max)
+		var rewritten bytes.Buffer
+		writer := NewWriter(&rewritten, max)
+		for {
+			payload, err := reader.ReadFrame()
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrFrameTooLarge) {
+					t.Fatalf("ReadFrame: unexpected error %v", err)
+				}
+				if errors.Is(err, io.EOF) && rewritten.Len() != len(data) {
+					t.Fatalf("EOF after %d of %d bytes", rewritten.Len(), len(data))
+				}
+				break
+			}
+			if len(payload) > max {
+				t.Fatalf("ReadFrame returned %d bytes, more than the limit %d", len(payload), max)
+			}
+			if err := writer.WriteFrame(payload); err != nil {
+				t.Fatalf("WriteFrame of a frame just read: %v", err)
+			}
+		}
+		// Every frame read writes back to the bytes it was read from.
+		if !bytes.HasPrefix(data, rewritten.Bytes()) {
+			t.Fatalf("frames written back as %x, not a prefix of %x", rewritten.Bytes(), data)
+		}
+	})
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		max   int
+		want  []string
+		final error
+	}{
+		{"empty stream", nil, 16, nil, io.EOF},
+		{"one frame", frame("hi"), 16, []string{"hi"}, io.EOF},
+		{"empty frame", frame(""), 16, []string{""}, io.EOF},
+		{"two frames", append(frame("a"), frame("bc")...), 16, []string{"a", "bc"}, io.EOF},
+		{"at the limit", frame("1234"), 4, []string{"1234"}, io.EOF},
+		{"over the limit", frame("12345"), 4, nil, ErrFrameTooLarge},
+		{"cut in the header", []byte{0, 0}, 16, nil, io.ErrUnexpectedEOF},
+		{"cut in the payload", frame("hello")[:6], 16, nil, io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewReader(bytes.NewReader(test.data), test.max)
+			var got []string
+			for {
+				payload, err := reader.ReadFrame()
+				if err != nil {
+					if err != test.final {
+						t.Errorf("ended with %v, want %v", err, test.final)
+					}
+					break
+				}
+				got = append(got, string(payload))
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("read %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("frame %d = %q, want %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
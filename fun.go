@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_DICE  = 20
+	MAX_SIDES = 1000
+)
+
+// random is where /roll, /flip and /8ball get their randomness.
+var random io.Reader = rand.Reader
+
+// eightBallAnswers are the catalog keys of the magic 8-ball's answers.
+var eightBallAnswers = []catalogKey{
+	"8ball.certain",
+	"8ball.doubtless",
+	"8ball.rely",
+	"8ball.likely",
+	"8ball.signs_yes",
+	"8ball.hazy",
+	"8ball.later",
+	"8ball.cannot_predict",
+	"8ball.dont_count",
+	"8ball.sources_no",
+	"8ball.outlook_bad",
+	"8ball.doubtful",
+}
+
+func init() {
+	registerCommand("/roll", chatCommand{usage: "/roll [NdM]", help: "Roll N dice with M sides, 1d6 by default", needsRoom: true, run: rollDice})
+	registerCommand("/flip", chatCommand{usage: "/flip", help: "Flip a coin", needsRoom: true, run: flipCoin})
+	registerCommand("/8ball", chatCommand{usage: "/8ball [question]", help: "Ask the magic 8-ball", needsRoom: true, run: askEightBall})
+}
+
+// rollDice posts a roll of the dice to room. Like /flip and /8ball it
+// counts as a chat message against message-rate and the room's slow mode.
+func rollDice(client *Client, room, args string) {
+	dice, sides, err := parseDice(args)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	rolls := make([]string, dice)
+	total := 0
+	for i := range rolls {
+		roll, err := randomInt(sides)
+		if err != nil {
+			client.say("roll.failed")
+			return
+		}
+		total += roll + 1
+		rolls[i] = strconv.Itoa(roll + 1)
+	}
+	result := rolls[0]
+	if dice > 1 {
+		result = fmt.Sprintf("%s = %d", strings.Join(rolls, " + "), total)
+	}
+	if err := client.allowChat(); err != nil {
+		client.sayError(err)
+		return
+	}
+	broadcast <- funNotice(room, client.username, "roll.done", client.username, dice, sides, result)
+}
+
+// parseDice parses a dice spec such as "2d6". An empty spec is one six-sided
+// die.
+func parseDice(spec string) (dice, sides int, err error) {
+	if spec == "" {
+		return 1, 6, nil
+	}
+	n, m, ok := strings.Cut(strings.ToLower(spec), "d")
+	if n == "" {
+		n = "1"
+	}
+	dice, err1 := strconv.Atoi(n)
+	sides, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, localErrorf("roll.usage")
+	}
+	if dice < 1 || dice > MAX_DICE || sides < 1 || sides > MAX_SIDES {
+		return 0, 0, localErrorf("roll.limits", MAX_DICE, MAX_SIDES)
+	}
+	return dice, sides, nil
+}
+
+func flipCoin(client *Client, room, args string) {
+	side, err := randomInt(2)
+	if err != nil {
+		client.say("flip.failed")
+		return
+	}
+	result := catalogKey("flip.heads")
+	if side == 1 {
+		result = "flip.tails"
+	}
+	if err := client.allowChat(); err != nil {
+		client.sayError(err)
+		return
+	}
+	broadcast <- funNotice(room, client.username, "flip.done", client.username, result)
+}
+
+func askEightBall(client *Client, room, question string) {
+	if question == "" {
+		client.say("8ball.usage")
+		return
+	}
+	answer, err := randomInt(len(eightBallAnswers))
+	if err != nil {
+		client.say("8ball.cloudy")
+		return
+	}
+	if err := client.allowChat(); err != nil {
+		client.sayError(err)
+		return
+	}
+	broadcast <- funNotice(room, client.username, "8ball.done", client.username, question, eightBallAnswers[answer])
+}
+
+// randomInt returns a uniform random number in [0, n).
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(random, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// funNotice is the notice of username's fun command in room, whose text
+// is key with the room and args. Its body leaves out the room for
+// protocols that show it separately.
+func funNotice(room, username, key string, args ...any) Message {
+	message := localMessage(room, MESSAGE_NOTICE, key, append([]any{room}, args...)...)
+	message.from = username
+	message.body = strings.TrimPrefix(strings.TrimSuffix(message.text, "\n"), "["+room+"] ")
+	return message
+}
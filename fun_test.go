@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		spec        string
+		dice, sides int
+		wantErr     bool
+	}{
+		{"", 1, 6, false},
+		{"2d6", 2, 6, false},
+		{"d20", 1, 20, false},
+		{"3D8", 3, 8, false},
+		{"1d1", 1, 1, false},
+		{"20d1000", MAX_DICE, MAX_SIDES, false},
+		{"21d6", 0, 0, true},
+		{"1d1001", 0, 0, true},
+		{"0d6", 0, 0, true},
+		{"2d0", 0, 0, true},
+		{"-1d6", 0, 0, true},
+		{"6", 0, 0, true},
+		{"d", 0, 0, true},
+		{"2d", 0, 0, true},
+		{"xdy", 0, 0, true},
+		{"2d6d6", 0, 0, true},
+		{" 2d6", 0, 0, true},
+	}
+	for _, test := range tests {
+		dice, sides, err := parseDice(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseDice(%q) error = %v, want error %t", test.spec, err, test.wantErr)
+			continue
+		}
+		if dice != test.dice || sides != test.sides {
+			t.Errorf("parseDice(%q) = %d, %d, want %d, %d", test.spec, dice, sides, test.dice, test.sides)
+		}
+	}
+}
+
+func TestFunCommandsAreRateLimited(t *testing.T) {
+	withSettings(t, func(s *settings) { s.messageRate = 1 })
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	room := uniqueName("fun")
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	for _, command := range []string{"/roll", "/flip", "/8ball will it work?"} {
+		// Each command gets a fresh window: one result, then the limit.
+		mutex.Lock()
+		findClient(c.name).chatWindow = time.Time{}
+		mutex.Unlock()
+		c.send(command)
+		c.expectLine(c.name)
+		c.send(command)
+		c.expectLine("ERR_RATE_LIMITED")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// trickyLines are inputs that have broken parsers before.
+var trickyLines = []string{
+	"",
+	" ",
+	"/",
+	"//",
+	"/ join",
+	"/join  two  spaces",
+	"/join a b c",
+	"/create has\ttab",
+	"/nick \xff\xfe",
+	"/msg",
+	"/msg nobody",
+	"/m  x  y",
+	"/paste a\\nb\\n\\n",
+	"/remind 0s",
+	"/roll 999999999d999999999",
+	"/poll \"unclosed",
+	"\x1b[2J\x1b[Hcleared",
+	"caf\xc3",
+	"\r\r\r",
+	strings.Repeat("x", MAX_LINE_LENGTH+10),
+	strings.Repeat("/", 300),
+	strings.Repeat("é", 2000),
+}
+
+// fuzzSkipped reports whether line would switch the connection to a
+// protocol the session below cannot follow, or use up its pings. The line
+// is cleaned as handleConnection cleans it.
+func fuzzSkipped(line string) bool {
+	fields := strings.Fields(cleanBody(line))
+	return len(fields) > 0 && (fields[0] == "/json" || fields[0] == "/ping")
+}
+
+// fuzzSession runs handleConnection over an in-memory pipe, sends input
+// after joining a fresh room, and returns every line the server sent. It
+// fails the test if the server stops answering or does not finish after
+// the client hangs up.
+func fuzzSession(t *testing.T, input string) []string {
+	startServer()
+	server, conn := net.Pipe()
+	finished := make(chan struct{})
+	go func() {
+		handleConnection(server)
+		close(finished)
+	}()
+	received := make(chan string, 64)
+	go func() {
+		defer close(received)
+		reader := bufio.NewReader(conn)
+		for {
+			// A line without its newline was cut off by the hangup.
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+	}()
+	var lines []string
+	// await collects lines until one contains want, reporting false if
+	// the server hung up first.
+	await := func(want string) bool {
+		timeout := time.After(LINE_TIMEOUT)
+		for {
+			select {
+			case line, ok := <-received:
+				if !ok {
+					return false
+				}
+				lines = append(lines, line)
+				if strings.Contains(line, want) {
+					return true
+				}
+			case <-timeout:
+				t.Fatalf("no %q from the server within %v after %q; got %q", want, LINE_TIMEOUT, input, lines)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("/create " + uniqueName("fuzz") + "\n")
+	for _, line := range strings.Split(input, "\n") {
+		if !fuzzSkipped(line) {
+			b.WriteString(line + "\n")
+		}
+	}
+	b.WriteString("/ping\n")
+	if !await("Welcome") {
+		t.Fatalf("server hung up before the welcome; got %q", lines)
+	}
+	// Whatever the input did, the server must still answer the ping,
+	// unless the input quit. The write blocks until the server has read
+	// it all, so it runs alongside the reading.
+	go conn.Write([]byte(b.String()))
+	await("PONG ")
+	conn.Close()
+	select {
+	case <-finished:
+	case <-time.After(LINE_TIMEOUT):
+		t.Fatalf("handleConnection still running %v after the client hung up on %q", LINE_TIMEOUT, input)
+	}
+	for line := range received {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func FuzzCommand(f *testing.F) {
+	for _, name := range commandNames() {
+		f.Add(name)
+		f.Add(name + " x")
+		f.Add(name + "  a  b ")
+		f.Add(name + " \xff")
+	}
+	for _, line := range trickyLines {
+		f.Add(line)
+	}
+	f.Add("hello\n/who\n/list\n/quit bye")
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, line := range fuzzSession(t, input) {
+			if !utf8.ValidString(line) {
+				t.Errorf("input %q: server sent invalid UTF-8 %q", input, line)
+			}
+		}
+	})
+}
+
+func FuzzChatLine(f *testing.F) {
+	for _, line := range trickyLines {
+		f.Add("lobby", "guest-1", line)
+	}
+	f.Add("a room", "\x1b[31mred", "two\nlines")
+	f.Add("", "", "")
+	f.Fuzz(func(t *testing.T, room, name, body string) {
+		line := chatLineAt(room, "3:04PM", name, false, cleanBody(body))
+		if !utf8.ValidString(line) && utf8.ValidString(room) {
+			t.Errorf("chatLineAt(%q, %q, %q) = %q, not valid UTF-8", room, name, body, line)
+		}
+		if !strings.HasSuffix(line, "\n") {
+			t.Errorf("chatLineAt(%q, %q, %q) = %q, not a line", room, name, body, line)
+		}
+		for _, continuation := range strings.Split(strings.TrimSuffix(line, "\n"), "\n")[1:] {
+			if !strings.HasPrefix(continuation, PASTE_INDENT) {
+				t.Errorf("chatLineAt(%q, %q, %q): continuation %q is not indented", room, name, body, continuation)
+			}
+		}
+		if strings.ContainsRune(line, '\r') && !strings.ContainsRune(room, '\r') {
+			t.Errorf("chatLineAt(%q, %q, %q) = %q keeps a carriage return", room, name, body, line)
+		}
+	})
+}
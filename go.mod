@@ -1,3 +1,11 @@
 module final_project
 
-go 1.23
+go 1.23.0
+
+require (
+	golang.org/x/crypto v0.41.0
+	golang.org/x/net v0.43.0
+	golang.org/x/sys v0.35.0
+	golang.org/x/term v0.34.0
+	golang.org/x/text v0.28.0
+)
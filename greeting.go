@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MAX_GREETING_LENGTH = 300
+
+func init() {
+	registerCommand("/setgreeting", chatCommand{usage: "/setgreeting [text]", help: "Set the greeting newcomers to your room see, or clear it (room owner)", needsRoom: true, run: setGreeting})
+	registerCommand("/greeting", chatCommand{usage: "/greeting", help: "Show your room's greeting", needsRoom: true, run: showGreeting})
+}
+
+func setGreeting(client *Client, room, args string) {
+	greeting, err := cleanGreeting(args)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("greeting.owner_only")
+		return
+	}
+	meta.greeting = greeting
+	mutex.Unlock()
+	if greeting == "" {
+		client.say("greeting.cleared", room)
+	} else {
+		client.say("greeting.set", room)
+	}
+}
+
+func showGreeting(client *Client, room, args string) {
+	mutex.Lock()
+	greeting := roomGreeting(room)
+	mutex.Unlock()
+	if greeting == "" {
+		client.say("greeting.none", room)
+		return
+	}
+	client.say("greeting.show", room, greeting)
+}
+
+// roomGreeting returns the greeting of room, if it has one. The caller must
+// hold mutex.
+func roomGreeting(room string) string {
+	if meta := roomMetas[room]; meta != nil {
+		return meta.greeting
+	}
+	return ""
+}
+
+// cleanGreeting drops control and formatting characters from a greeting
+// and collapses its white space, so it stays on one line.
+func cleanGreeting(text string) (string, error) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError {
+			return ' '
+		}
+		return r
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > MAX_GREETING_LENGTH {
+		return "", localErrorf("greeting.too_long", MAX_GREETING_LENGTH)
+	}
+	return text, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	ANONYMOUS_NAME = "Anonymous"
+	GUEST_PREFIX   = "guest-"
+)
+
+var errNickRequired = localErrorf("nick.required")
+
+// assignGuestName gives client an unused name such as guest-4821. The
+// caller must hold mutex.
+func assignGuestName(client *Client) {
+	limit := 10000
+	for tries := 0; ; tries++ {
+		// Widen the range if the server is crowded with guests.
+		if tries > 0 && tries%20 == 0 {
+			limit *= 10
+		}
+		n, err := randomInt(limit - 1000)
+		if err != nil {
+			n = tries
+		}
+		name := fmt.Sprintf("%s%d", GUEST_PREFIX, n+1000)
+		if findClient(name) == nil && !displayNameTaken(name, nil) && !isReserved(name) {
+			client.username = name
+			return
+		}
+	}
+}
+
+// checkNamed returns errNickRequired if require-nick is set and client
+// has not picked a name yet. With require-nick, new clients are left
+// without a name until they pick one with /nick instead of getting a guest
+// name.
+func checkNamed(client *Client) error {
+	if !config().requireNick {
+		return nil
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if client.username == ANONYMOUS_NAME {
+		return errNickRequired
+	}
+	return nil
+}
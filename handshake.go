@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+var (
+	handshakeTimeout = DEFAULT_HANDSHAKE_TIMEOUT
+
+	// Total for /stats.
+	handshakeTimeouts atomic.Int64
+)
+
+// startHandshake gives a new connection handshake-timeout to say HELLO or
+// take a name (for IRC, to register) before it is dropped. Until then it
+// holds a slot but is not counted in the stats.
+func startHandshake(client *Client) {
+	if handshakeTimeout > 0 {
+		client.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	}
+}
+
+// finishHandshake lifts the deadline of startHandshake.
+func finishHandshake(client *Client) {
+	client.conn.SetReadDeadline(time.Time{})
+	mutex.Lock()
+	client.handshaken = true
+	mutex.Unlock()
+}
+
+// handshakeExpired reports whether err ended a connection that did not
+// finish its handshake in time, logging and counting it if so. The client
+// has not been told yet.
+func handshakeExpired(client *Client, err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	mutex.Lock()
+	handshaken := client.handshaken
+	mutex.Unlock()
+	if handshaken {
+		return false
+	}
+	handshakeTimeouts.Add(1)
+	log.Printf("Disconnecting client %v: no handshake within %v", client.address, handshakeTimeout)
+	client.conn.SetWriteDeadline(time.Now().Add(EVICT_WRITE_TIMEOUT))
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TEST_HANDSHAKE_TIMEOUT is short so that silent connections are evicted
+// quickly.
+const TEST_HANDSHAKE_TIMEOUT = 200 * time.Millisecond
+
+func TestSilentConnectionIsEvicted(t *testing.T) {
+	previous := handshakeTimeout
+	handshakeTimeout = TEST_HANDSHAKE_TIMEOUT
+	t.Cleanup(func() { handshakeTimeout = previous })
+	addr := newTestServer(t)
+	timeouts := handshakeTimeouts.Load()
+
+	silent := newTestClient(t, addr)
+	talker := newTestClient(t, addr)
+	talker.send("/ping")
+	talker.expectLine("PONG ")
+
+	started := time.Now()
+	silent.expectLine(translate(DEFAULT_LANGUAGE, "welcome.timeout"))
+	silent.expectClosed()
+	if waited := time.Since(started); waited > LINE_TIMEOUT/2 {
+		t.Errorf("evicted after %v with a handshake timeout of %v", waited, TEST_HANDSHAKE_TIMEOUT)
+	}
+	if n := handshakeTimeouts.Load() - timeouts; n != 1 {
+		t.Errorf("%d handshake timeouts counted, want 1", n)
+	}
+	waitFor(t, silent.name+" to be removed", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return findClient(silent.name) == nil
+	})
+
+	// The client that finished its handshake stays past the timeout.
+	time.Sleep(2 * TEST_HANDSHAKE_TIMEOUT)
+	talker.send("/ping")
+	talker.expectLine("PONG ")
+}
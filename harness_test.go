@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"final_project/framing"
+)
+
+// LINE_TIMEOUT is how long expectLine waits for a line.
+const LINE_TIMEOUT = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+var (
+	serverOnce sync.Once
+	nameCount  atomic.Int64
+
+	certOnce  sync.Once
+	testCert  tls.Certificate
+	testRoots *x509.CertPool
+	certErr   error
+)
+
+// testSettings are the settings main gets from its flag defaults.
+func testSettings() *settings {
+	return &settings{
+		slowGrace:         DEFAULT_SLOW_GRACE,
+		maxPasteSize:      DEFAULT_MAX_PASTE_SIZE,
+		maxFrameSize:      framing.DEFAULT_MAX_SIZE,
+		retentionMaxCount: HISTORY_SIZE,
+		goroutineWarn:     DEFAULT_GOROUTINE_WARN,
+		lang:              DEFAULT_LANGUAGE,
+		botMessageRate:    -1,
+		fanoutThreshold:   1000,
+		fanoutWindow:      2 * time.Second,
+	}
+}
+
+// TEST_SERVER_ID and TEST_FEDERATED are the bridge setup of the test
+// server, which federates one room with peers that tests play.
+const (
+	TEST_SERVER_ID = "alpha"
+	TEST_FEDERATED = "federated"
+)
+
+// startServer sets up what main does before it accepts clients, once per
+// test binary: the settings, the bridge and the broadcast goroutines. It
+// leaves out the admin console and everything else that needs a flag.
+func startServer() {
+	serverOnce.Do(func() {
+		currentConfig.Store(testSettings())
+		if err := setupBridge(TEST_SERVER_ID, "secret", TEST_FEDERATED, ""); err != nil {
+			panic(err)
+		}
+		broadcast = make(chan Message, broadcastBuffer)
+		startBroadcastWorkers(2)
+		go runFanouts()
+		go handleBroadcast()
+	})
+}
+
+// withSettings runs the rest of a test under settings changed by edit,
+// restoring the previous ones when it ends.
+func withSettings(t testing.TB, edit func(s *settings)) {
+	t.Helper()
+	startServer()
+	previous := config()
+	changed := *previous
+	edit(&changed)
+	currentConfig.Store(&changed)
+	t.Cleanup(func() { currentConfig.Store(previous) })
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1, made
+// once per test binary, and a pool of roots that trusts it.
+func testCertificate(t testing.TB) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	certOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			certErr = err
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			certErr = err
+			return
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			certErr = err
+			return
+		}
+		testCert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+		testRoots = x509.NewCertPool()
+		testRoots.AddCert(leaf)
+	})
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+	return testCert, testRoots
+}
+
+// newTestServer starts a TLS listener on a loopback port with the test
+// certificate, as a tls:// listener is set up, and returns its address.
+// The listener closes when the test ends; clients still connected are
+// left to their own tests to close.
+func newTestServer(t testing.TB) string {
+	t.Helper()
+	startServer()
+	cert, _ := testCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptClients(tls.NewListener(listener, serverTLSConfig(cert)))
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// newPlainTestServer is newTestServer for a plaintext tcp:// listener.
+func newPlainTestServer(t testing.TB) string {
+	t.Helper()
+	startServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptClients(plainListener{listener})
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// dialTest connects to the test server at addr over TLS, checking its
+// certificate. The connection closes when the test ends.
+func dialTest(t testing.TB, addr string) net.Conn {
+	t.Helper()
+	_, roots := testCertificate(t)
+	dialer := &net.Dialer{Timeout: LINE_TIMEOUT}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// uniqueName returns a room or user name starting with base that no other
+// test uses, since names outlive a test until its connections close.
+func uniqueName(base string) string {
+	return fmt.Sprintf("%s-%d", base, nameCount.Add(1))
+}
+
+// testClient is one connection to a test server, read a line at a time.
+type testClient struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+	name   string
+}
+
+// newTestClient connects to addr and waits for the welcome, which gives
+// the client its guest name. The connection closes when the test ends.
+func newTestClient(t testing.TB, addr string) *testClient {
+	t.Helper()
+	conn := dialTest(t, addr)
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	c.expectLine("GOCHAT/")
+	welcome := c.expectLine("Welcome! You are ")
+	c.name, _, _ = strings.Cut(strings.TrimPrefix(welcome, "Welcome! You are "), ".")
+	return c
+}
+
+// send writes line to the server.
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatalf("send %q: %v", line, err)
+	}
+}
+
+// expectLine reads lines until one contains want and returns it, failing
+// the test if none does within LINE_TIMEOUT.
+func (c *testClient) expectLine(want string) string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	defer c.conn.SetReadDeadline(time.Time{})
+	var seen []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v; got %q", want, err, seen)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.Contains(line, want) {
+			return line
+		}
+		seen = append(seen, line)
+	}
+}
+
+// expectClosed reads until the server closes the connection.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	if _, err := io.Copy(io.Discard, c.reader); err != nil {
+		c.t.Fatalf("waiting for the server to hang up: %v", err)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after LINE_TIMEOUT.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(LINE_TIMEOUT)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHarnessChat(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("harness")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	alice.expectLine(bob.name)
+	bob.send("hello there")
+	alice.expectLine(bob.name + ": hello there")
+	bob.send("/quit")
+	bob.expectClosed()
+}
+
+// expectLineNot is expectLine, also failing the test if a line read before
+// the one containing want contains unwanted.
+func (c *testClient) expectLineNot(want, unwanted string) string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v", want, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.Contains(line, unwanted) {
+			c.t.Fatalf("got %q before %q", line, want)
+		}
+		if strings.Contains(line, want) {
+			return line
+		}
+	}
+}
+
+func TestHarnessRoomsAreIsolated(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room, other := uniqueName("mine"), uniqueName("theirs")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/create " + other)
+	bob.expectLine("Created and joined room " + other)
+
+	bob.send("not for alice")
+	bob.expectLine(bob.name + ": not for alice")
+	alice.send("marker")
+	alice.expectLineNot(alice.name+": marker", "not for alice")
+}
+
+func TestHarnessRoomOrder(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("order")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	alice.expectLine(`"` + bob.name + `" joined`)
+
+	const count = 50
+	for i := range count {
+		bob.send(fmt.Sprint("message ", i))
+	}
+	for i := range count {
+		want := fmt.Sprintf("%s: message %d", bob.name, i)
+		if i+1 < count {
+			alice.expectLineNot(want, fmt.Sprintf("%s: message %d", bob.name, i+1))
+		} else {
+			alice.expectLine(want)
+		}
+	}
+}
+
+func TestHarnessKick(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("kick")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	alice.expectLine(`"` + bob.name + `" joined`)
+
+	mutex.Lock()
+	client := findClient(bob.name)
+	mutex.Unlock()
+	kickUser(client, "flooding")
+	bob.expectLine("You have been kicked from the chat: flooding")
+	bob.expectClosed()
+	alice.expectLine(`"` + bob.name + `" left the chat room (kicked: flooding)`)
+}
+
+func TestHarnessBan(t *testing.T) {
+	addr := newTestServer(t)
+	t.Cleanup(func() { unbanAddress("127.0.0.1") })
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	mutex.Lock()
+	client := findClient(bob.name)
+	mutex.Unlock()
+
+	// Both are on loopback, so banning bob's host drops alice too.
+	banUser(client, "spam")
+	bob.expectLine("You have been banned from the chat: spam")
+	bob.expectClosed()
+	alice.expectLine("You have been banned from the chat: spam")
+	alice.expectClosed()
+	expectBanned(t, addr, "spam")
+
+	unbanAddress("127.0.0.1")
+	newTestClient(t, addr)
+}
+
+func TestHarnessCleanupAfterDisconnect(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room := uniqueName("cleanup")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	alice.expectLine(`"` + bob.name + `" joined`)
+
+	// Hang up without /quit, as a client that crashed does.
+	bob.conn.Close()
+	alice.expectLine(`"` + bob.name + `" left the chat room`)
+	waitFor(t, bob.name+" to be removed", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return findClient(bob.name) == nil
+	})
+	mutex.Lock()
+	defer mutex.Unlock()
+	if members := roomMembers(room); len(members) != 1 || members[0] != alice.name {
+		t.Errorf("%s has members %q, want only %s", room, members, alice.name)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PROTOCOL_VERSION and serverFeatures make up the banner sent to text
+// clients when they connect, e.g.
+// "GOCHAT/1 features=json,ping max-length=4096 max-name=32". max-length is
+// the longest line the server reads and max-name the longest username or
+// room name, in characters.
+const PROTOCOL_VERSION = 1
+
+// MAX_SOFTWARE_LENGTH caps the client name and version kept from HELLO.
+const MAX_SOFTWARE_LENGTH = 32
+
+var serverFeatures = []string{"json", "ping", "history", "e2e", "framing", "directory", "no-self-echo", "commands", "crlf"}
+
+// optInFeatures change what the server sends unasked, so clients only get
+// them by listing them in HELLO. Clients with no-self-echo show their own
+// chat messages themselves; in JSON mode the ack to a message sent with an
+// ID carries what they need to. Clients with commands get the list of
+// commands in the WELCOME. Clients with crlf get lines ending in "\r\n";
+// others get "\n", or "\r\n" if they sent their first line with one.
+var optInFeatures = []string{"framing", "directory", "no-self-echo", "commands", "crlf"}
+
+func banner() string {
+	return fmt.Sprintf("GOCHAT/%d features=%s max-length=%d max-name=%d\n", PROTOCOL_VERSION, strings.Join(serverFeatures, ","), MAX_LINE_LENGTH, MAX_NAME_LENGTH)
+}
+
+// handleHello reads "HELLO <client> <version> [features=a,b] [bot]", which
+// a client may send as its first line; see bot.go for bots. The features both sides know become
+// the client's; without a features list the client gets them all, except
+// optInFeatures. With the commands feature the WELCOME also lists the
+// commands the server knows, so clients can catch mistyped ones before
+// sending them. Clients with the directory feature get a directory event
+// after the WELCOME. It reports whether the connection switched to frames.
+func handleHello(client *Client, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		client.say("hello.usage")
+		return false
+	}
+	features := defaultFeatures()
+	bot := false
+	for _, field := range fields[3:] {
+		if field == "bot" {
+			bot = true
+		} else if list, ok := strings.CutPrefix(field, "features="); ok {
+			features = nil
+			for _, feature := range strings.Split(list, ",") {
+				for _, known := range serverFeatures {
+					if feature == known {
+						features = append(features, feature)
+					}
+				}
+			}
+		}
+	}
+	mutex.Lock()
+	client.software, client.softwareVersion = cleanSoftware(fields[1]), cleanSoftware(fields[2])
+	client.bot = bot
+	client.features = make(map[string]bool)
+	for _, feature := range features {
+		client.features[feature] = true
+	}
+	framed, directory, listCommands := client.features["framing"], client.features["directory"], client.features["commands"]
+	crlf := client.features["crlf"]
+	mutex.Unlock()
+	if crlf {
+		client.conn.(*wireConn).setCRLF(true)
+	}
+	welcome := "WELCOME features=" + strings.Join(features, ",")
+	if listCommands {
+		welcome += " commands=" + strings.Join(commandNames(), ",")
+	}
+	welcome += "\n"
+	if framed {
+		client.conn.(*wireConn).startFraming([]byte(welcome))
+	} else {
+		client.conn.Write([]byte(welcome))
+	}
+	if directory && !bot {
+		sendDirectory(client, "", "", "")
+	}
+	return framed
+}
+
+// defaultFeatures are the features of a client that did not list any.
+func defaultFeatures() []string {
+	features := []string{}
+	for _, feature := range serverFeatures {
+		if !slices.Contains(optInFeatures, feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// supports reports whether client may be sent output of feature. Clients
+// that did not say HELLO get everything, as before. The caller must hold
+// mutex.
+func (c *Client) supports(feature string) bool {
+	return c.features == nil || c.features[feature]
+}
+
+// cleanSoftware keeps the printable part of a client name or version from
+// HELLO, cut to MAX_SOFTWARE_LENGTH, so it is safe to show on the console.
+func cleanSoftware(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > MAX_SOFTWARE_LENGTH {
+		s = strings.ToValidUTF8(s[:MAX_SOFTWARE_LENGTH], "")
+	}
+	return s
+}
+
+// softwareName is the client software from HELLO, or "unknown" for
+// clients that did not say HELLO. The caller must hold mutex.
+func (c *Client) softwareName() string {
+	if c.software == "" {
+		return "unknown"
+	}
+	return c.software
+}
+
+// softwareLabel is softwareName with the version, if there is one. The
+// caller must hold mutex.
+func (c *Client) softwareLabel() string {
+	if c.software == "" {
+		return "unknown"
+	}
+	return c.software + "/" + c.softwareVersion
+}
+
+// softwareSummary formats client counts per software, most used first,
+// e.g. "12 gochat-cli, 3 irc-bridge, 5 unknown".
+func softwareSummary(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	names := slices.Collect(maps.Keys(counts))
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", counts[name], name)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HISTORY_SIZE = 200
+	// HISTORY_REPLAY is how many events /history shows by default.
+	HISTORY_REPLAY = 20
+	// HISTORY_DATED_AGE is the age from which replayed chat lines carry
+	// their date, since 3:04PM alone repeats every 12 hours.
+	HISTORY_DATED_AGE = 12 * time.Hour
+)
+
+// The values of /sethistory: what /history, /search and backfill show of
+// a room's kept events.
+const (
+	HISTORY_ALL        = "all"
+	HISTORY_SINCE_JOIN = "members-since-join"
+	HISTORY_OFF        = "off"
+)
+
+func init() {
+	registerCommand("/history", chatCommand{usage: "/history [count]", help: "Show your room's recent messages", needsRoom: true, run: historyCommand})
+	registerCommand("/sethistory", chatCommand{usage: "/sethistory [all|members-since-join|off]", help: "Show or set how much history members can read (room owner)", needsRoom: true, run: setHistoryCommand})
+}
+
+// roomLog holds the most recent events of a room for backfill, along with
+// the room's sequence counter.
+type roomLog struct {
+	lastSeq uint64
+	events  []Message
+}
+
+var histories = make(map[string]*roomLog)
+
+// recordHistory stamps message with the next sequence number and the
+// current time for its room and keeps it for backfill. It is the only
+// place sequence numbers are assigned. The caller must hold mutex.
+func recordHistory(message Message) Message {
+	log := histories[message.room]
+	if log == nil {
+		log = &roomLog{}
+		histories[message.room] = log
+	}
+	log.lastSeq++
+	message.seq = log.lastSeq
+	message.time = time.Now()
+	log.events = append(log.events, message)
+	if _, count := roomRetention(message.room); len(log.events) > count {
+		log.events = log.events[len(log.events)-count:]
+	}
+	return message
+}
+
+// lastSeq returns the sequence number of the newest event in room, or 0.
+// The caller must hold mutex.
+func lastSeq(room string) uint64 {
+	if log := histories[room]; log != nil {
+		return log.lastSeq
+	}
+	return 0
+}
+
+// historySince returns the kept events of room with a sequence number of at
+// least fromSeq, and the oldest sequence number still available (lastSeq+1
+// when nothing is kept). The caller must hold mutex.
+func historySince(room string, fromSeq uint64) ([]Message, uint64) {
+	log := histories[room]
+	if log == nil {
+		return nil, 1
+	}
+	oldest := log.lastSeq + 1
+	if len(log.events) > 0 {
+		oldest = log.events[0].seq
+	}
+	for i, message := range log.events {
+		if message.seq >= fromSeq {
+			return log.events[i:], oldest
+		}
+	}
+	return nil, oldest
+}
+
+func historyCommand(client *Client, room, args string) {
+	count := HISTORY_REPLAY
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 {
+			client.say("history.usage")
+			return
+		}
+		count = n
+	}
+	mutex.Lock()
+	visibility := roomHistoryVisibility(room)
+	events, _ := historySince(room, historyStart(client, room))
+	events = append([]Message(nil), events[max(len(events)-count, 0):]...)
+	mutex.Unlock()
+	if visibility == HISTORY_OFF {
+		client.say("history.off", room)
+		return
+	}
+	if len(events) == 0 {
+		client.say("history.none", room)
+		return
+	}
+	client.conn.Write([]byte(replayLines(client, events, time.Now())))
+}
+
+// replayLines renders events for a text client, with a separator line
+// before the first event of each day. Chat lines older than
+// HISTORY_DATED_AGE show their date along with the time.
+func replayLines(client *Client, events []Message, now time.Time) string {
+	var b strings.Builder
+	day := ""
+	loc := client.timeZone()
+	for _, message := range events {
+		message.time = message.time.In(loc)
+		if d := message.time.Format("Monday, January 2, 2006"); d != day {
+			day = d
+			b.WriteString(client.tr("history.day", day) + "\n")
+		}
+		if message.kind != MESSAGE_CHAT {
+			b.Write(client.render(message))
+			continue
+		}
+		stamp := message.time.Format("3:04PM")
+		if now.Sub(message.time) >= HISTORY_DATED_AGE {
+			stamp = message.time.Format("Jan 2 3:04PM")
+		}
+		name := message.from
+		if message.display != "" {
+			name = message.display
+		}
+		b.WriteString(chatLineAt(message.room, stamp, name, message.bot, message.body))
+	}
+	return b.String()
+}
+
+// roomHistoryVisibility returns the /sethistory value of room. The caller
+// must hold mutex.
+func roomHistoryVisibility(room string) string {
+	if meta := roomMetas[room]; meta != nil && meta.historyVisibility != "" {
+		return meta.historyVisibility
+	}
+	return HISTORY_ALL
+}
+
+// historyStart returns the first sequence number of room client may read,
+// which is past the newest event when the room's history is off. The
+// caller must hold mutex.
+func historyStart(client *Client, room string) uint64 {
+	switch roomHistoryVisibility(room) {
+	case HISTORY_SINCE_JOIN:
+		return client.joinedSeq + 1
+	case HISTORY_OFF:
+		return lastSeq(room) + 1
+	}
+	return 1
+}
+
+func setHistoryCommand(client *Client, room, args string) {
+	if args == "" {
+		mutex.Lock()
+		visibility := roomHistoryVisibility(room)
+		mutex.Unlock()
+		client.say("sethistory.current", room, visibility)
+		return
+	}
+	if args != HISTORY_ALL && args != HISTORY_SINCE_JOIN && args != HISTORY_OFF {
+		client.say("sethistory.usage")
+		return
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("sethistory.owner_only")
+		return
+	}
+	changed := roomHistoryVisibility(room) != args
+	meta.historyVisibility = args
+	mutex.Unlock()
+	if !changed {
+		client.say("sethistory.current", room, args)
+		return
+	}
+	broadcast <- localMessage(room, MESSAGE_NOTICE, "notice.history_"+strings.ReplaceAll(args, "-", "_"), room)
+}
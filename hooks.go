@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hooks let code built into the server follow what happens on it, for
+// example to keep an external directory of rooms in step. A file added to
+// this package installs them from its init function with setHooks. Each
+// hook is optional and gets copies of the data, never the server's own
+// values.
+//
+// Hooks are called one at a time on a goroutine of their own, in the order
+// the events happened, and never while the server holds mutex. A slow hook
+// delays the hooks after it but not the server. A hook that panics is
+// logged, and the events after it are delivered as usual.
+//
+// A client's events come in the order it caused them: OnClientConnect,
+// then an OnJoin and a matching OnLeave for each room it was in, moving
+// between rooms being a leave and then a join, then OnClientDisconnect.
+// A client's OnMessage calls come after its OnJoin for the room. Rooms
+// last as long as the server, so there is no hook for deleting one.
+type Hooks struct {
+	OnClientConnect    func(client ClientInfo)
+	OnClientDisconnect func(client ClientInfo)
+	// OnRename is called when a client changes its name with /nick,
+	// including the first name picked under require-nick, where oldName
+	// is "". The client's events after it carry the new name.
+	OnRename func(oldName string, client ClientInfo)
+	// OnRoomCreated is called before the creator's OnJoin.
+	OnRoomCreated func(room string, owner ClientInfo)
+	OnJoin        func(room string, client ClientInfo)
+	OnLeave       func(room string, client ClientInfo)
+	// OnMessage is called for every chat message in a room, including
+	// those from federated peers, before it is delivered.
+	OnMessage func(message MessageInfo)
+}
+
+// ClientInfo describes a client to hooks as it was when the event
+// happened; it is not updated afterwards. Name is empty until a client
+// that has to pick one with /nick does.
+type ClientInfo struct {
+	Name    string
+	Address string
+}
+
+// MessageInfo describes a chat message to hooks.
+type MessageInfo struct {
+	Room string
+	From string
+	Text string
+	Seq  uint64
+	Time time.Time
+}
+
+var (
+	currentHooks atomic.Pointer[Hooks]
+	// hookQueue holds the calls runHooks has yet to make; hookReady
+	// wakes it when there are some.
+	hookMutex sync.Mutex
+	hookQueue []func()
+	hookReady = make(chan struct{}, 1)
+	hookOnce  sync.Once
+)
+
+// setHooks installs h, replacing the hooks set before.
+func setHooks(h Hooks) {
+	currentHooks.Store(&h)
+}
+
+// hooks returns the hooks installed, none by default.
+func hooks() *Hooks {
+	if h := currentHooks.Load(); h != nil {
+		return h
+	}
+	return &Hooks{}
+}
+
+func clientInfo(client *Client) ClientInfo {
+	return ClientInfo{Name: client.username, Address: client.address}
+}
+
+// queueHook has call made by runHooks, starting it the first time.
+// Callers hold mutex, so calls are queued in the order the events
+// happened.
+func queueHook(call func()) {
+	hookOnce.Do(func() { go runHooks() })
+	hookMutex.Lock()
+	hookQueue = append(hookQueue, call)
+	hookMutex.Unlock()
+	select {
+	case hookReady <- struct{}{}:
+	default:
+	}
+}
+
+func runHooks() {
+	for range hookReady {
+		hookMutex.Lock()
+		calls := hookQueue
+		hookQueue = nil
+		hookMutex.Unlock()
+		for _, call := range calls {
+			callHook(call)
+		}
+	}
+}
+
+// callHook makes call, logging a panic instead of letting it end the
+// server.
+func callHook(call func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("hook panicked: %v", r)
+		}
+	}()
+	call()
+}
+
+// The hook functions below queue a call to their hook, if it is set. The
+// caller must hold mutex.
+
+func hookConnect(client *Client) {
+	if hook := hooks().OnClientConnect; hook != nil {
+		info := clientInfo(client)
+		queueHook(func() { hook(info) })
+	}
+}
+
+func hookDisconnect(client *Client) {
+	if hook := hooks().OnClientDisconnect; hook != nil {
+		info := clientInfo(client)
+		queueHook(func() { hook(info) })
+	}
+}
+
+func hookRename(oldName string, client *Client) {
+	if hook := hooks().OnRename; hook != nil {
+		info := clientInfo(client)
+		queueHook(func() { hook(oldName, info) })
+	}
+}
+
+func hookRoomCreated(room string, owner *Client) {
+	if hook := hooks().OnRoomCreated; hook != nil {
+		info := clientInfo(owner)
+		queueHook(func() { hook(room, info) })
+	}
+}
+
+func hookJoin(room string, client *Client) {
+	if hook := hooks().OnJoin; hook != nil {
+		info := clientInfo(client)
+		queueHook(func() { hook(room, info) })
+	}
+}
+
+func hookLeave(room string, client *Client) {
+	if hook := hooks().OnLeave; hook != nil {
+		info := clientInfo(client)
+		queueHook(func() { hook(room, info) })
+	}
+}
+
+func hookMessage(message Message) {
+	if hook := hooks().OnMessage; hook != nil && message.kind == MESSAGE_CHAT {
+		info := MessageInfo{Room: message.room, From: message.from, Text: message.body, Seq: message.seq, Time: message.time}
+		queueHook(func() { hook(info) })
+	}
+}
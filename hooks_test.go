@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hookRecorder installs hooks that record every event as a line such as
+// "join room alice", for the rest of the test.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func recordHooks(t *testing.T) *hookRecorder {
+	t.Helper()
+	r := &hookRecorder{}
+	setHooks(Hooks{
+		OnClientConnect:    func(c ClientInfo) { r.add("connect", c.Name) },
+		OnClientDisconnect: func(c ClientInfo) { r.add("disconnect", c.Name) },
+		OnRename:           func(oldName string, c ClientInfo) { r.add("rename", oldName, c.Name) },
+		OnRoomCreated:      func(room string, owner ClientInfo) { r.add("create", room, owner.Name) },
+		OnJoin:             func(room string, c ClientInfo) { r.add("join", room, c.Name) },
+		OnLeave:            func(room string, c ClientInfo) { r.add("leave", room, c.Name) },
+		OnMessage:          func(m MessageInfo) { r.add("message", m.Room, m.From, m.Text) },
+	})
+	t.Cleanup(func() { setHooks(Hooks{}) })
+	return r
+}
+
+func (r *hookRecorder) add(fields ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, strings.Join(fields, " "))
+}
+
+// about returns the events that mention one of names, leaving out those
+// of clients from other tests.
+func (r *hookRecorder) about(names ...string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []string
+	for _, event := range r.events {
+		for _, field := range strings.Fields(event) {
+			if slices.Contains(names, field) {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+	return events
+}
+
+// expect waits until the events mentioning names are want.
+func (r *hookRecorder) expect(t *testing.T, want []string, names ...string) {
+	t.Helper()
+	var got []string
+	waitFor(t, fmt.Sprintf("hook events %q", want), func() bool {
+		got = r.about(names...)
+		return len(got) >= len(want)
+	})
+	if !slices.Equal(got, want) {
+		t.Errorf("hook events:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestHooksFollowSession(t *testing.T) {
+	recorder := recordHooks(t)
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	room, other := uniqueName("hooks"), uniqueName("hooks")
+
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob.send("/join " + room)
+	bob.expectLine("Joined room " + room)
+	bob.send("hi")
+	alice.expectLine(bob.name + ": hi")
+	alice.send("/create " + other)
+	alice.expectLine("Created and joined room " + other)
+	bob.send("/quit")
+	bob.expectClosed()
+
+	recorder.expect(t, []string{
+		"connect " + alice.name,
+		"connect " + bob.name,
+		"create " + room + " " + alice.name,
+		"join " + room + " " + alice.name,
+		"join " + room + " " + bob.name,
+		"message " + room + " " + bob.name + " hi",
+		"leave " + room + " " + alice.name,
+		"create " + other + " " + alice.name,
+		"join " + other + " " + alice.name,
+		"leave " + room + " " + bob.name,
+		"disconnect " + bob.name,
+	}, alice.name, bob.name)
+}
+
+func TestHooksFollowRename(t *testing.T) {
+	recorder := recordHooks(t)
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	guest, name, room := c.name, uniqueName("renamed"), uniqueName("hooks")
+
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	c.send("/nick " + name)
+	c.expectLine("Username set to " + name)
+	c.send("hi")
+	c.expectLine(name + ": hi")
+	c.send("/quit")
+	c.expectClosed()
+
+	recorder.expect(t, []string{
+		"connect " + guest,
+		"create " + room + " " + guest,
+		"join " + room + " " + guest,
+		"rename " + guest + " " + name,
+		"message " + room + " " + name + " hi",
+		"leave " + room + " " + name,
+		"disconnect " + name,
+	}, guest, name)
+}
+
+func TestHookPanicIsRecovered(t *testing.T) {
+	recorder := recordHooks(t)
+	h := *hooks()
+	h.OnJoin = func(string, ClientInfo) { panic("hook failed") }
+	setHooks(h)
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	room := uniqueName("panic")
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	c.send("still here")
+	c.expectLine(c.name + ": still here")
+
+	recorder.expect(t, []string{
+		"connect " + c.name,
+		"create " + room + " " + c.name,
+		"message " + room + " " + c.name + " still here",
+	}, c.name)
+}
+
+func TestSlowHookDoesNotBlockServer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	setHooks(Hooks{OnMessage: func(MessageInfo) { <-release }})
+	t.Cleanup(func() { setHooks(Hooks{}) })
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	room := uniqueName("slow")
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	for i := range 3 {
+		c.send(fmt.Sprintf("message %d", i))
+		c.expectLine(fmt.Sprintf("message %d", i))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HOT_ROOM_WINDOW is how many one-second buckets a room's message
+	// rate is averaged over.
+	HOT_ROOM_WINDOW = 10
+	// SLOW_MODE_INTERVAL is how long a member of a room in slow mode
+	// waits between messages.
+	SLOW_MODE_INTERVAL = 5 * time.Second
+	// A room stays in slow mode for at least HOT_ROOM_MIN_SLOW, and until
+	// its rate is down to half of room-rate, so it does not flap.
+	HOT_ROOM_MIN_SLOW = 30 * time.Second
+)
+
+// Total for /stats.
+var hotRoomEvents atomic.Int64
+
+// roomRate counts the chat messages of a room per second over the last
+// HOT_ROOM_WINDOW seconds.
+type roomRate struct {
+	seconds [HOT_ROOM_WINDOW]int64
+	counts  [HOT_ROOM_WINDOW]int
+}
+
+// record counts a message sent at now. The caller must hold mutex.
+func (r *roomRate) record(now time.Time) {
+	second := now.Unix()
+	i := second % HOT_ROOM_WINDOW
+	if r.seconds[i] != second {
+		r.seconds[i], r.counts[i] = second, 0
+	}
+	r.counts[i]++
+}
+
+// perSecond returns the average rate over the window. The caller must hold
+// mutex.
+func (r *roomRate) perSecond(now time.Time) float64 {
+	second := now.Unix()
+	total := 0
+	for i, count := range r.counts {
+		if second-r.seconds[i] < HOT_ROOM_WINDOW {
+			total += count
+		}
+	}
+	return float64(total) / HOT_ROOM_WINDOW
+}
+
+// allowRoomChat applies room-rate to a chat message from client to its
+// room at now: a room sending more than room-rate messages a second goes
+// into slow mode, in which each member may send one message every
+// SLOW_MODE_INTERVAL. It returns the notice to broadcast when the room
+// went into or out of slow mode. The caller must hold mutex.
+func (c *Client) allowRoomChat(now time.Time) (*Message, error) {
+	ceiling := config().roomRate
+	room := c.room
+	if room == "" {
+		return nil, nil
+	}
+	if ceiling == 0 {
+		// room-rate was turned off since the room went into slow mode.
+		if meta := roomMetas[room]; meta != nil && !meta.slowSince.IsZero() {
+			return endSlowMode(room, meta), nil
+		}
+		return nil, nil
+	}
+	meta := metaFor(room)
+	var notice *Message
+	rate := meta.rate.perSecond(now)
+	if !meta.slowSince.IsZero() && (meta.rateExempt || now.Sub(meta.slowSince) >= HOT_ROOM_MIN_SLOW && rate <= float64(ceiling)/2) {
+		notice = endSlowMode(room, meta)
+	}
+	if !meta.slowSince.IsZero() && c.lastChatRoom == room {
+		if wait := c.lastChat.Add(SLOW_MODE_INTERVAL).Sub(now); wait > 0 {
+			c.counters.rateLimited.Add(1)
+			return notice, localErrorf("chat.slow_mode", room, int(wait.Seconds())+1)
+		}
+	}
+	meta.rate.record(now)
+	c.lastChat, c.lastChatRoom = now, room
+	if meta.slowSince.IsZero() && !meta.rateExempt && meta.rate.perSecond(now) > float64(ceiling) {
+		meta.slowSince = now
+		hotRoomEvents.Add(1)
+		log.Printf("Room %s is sending %.1f messages a second, over room-rate %d; slow mode on", room, meta.rate.perSecond(now), ceiling)
+		message := localMessage(room, MESSAGE_NOTICE, "notice.slow_on", room, int(SLOW_MODE_INTERVAL.Seconds()))
+		notice = &message
+	}
+	return notice, nil
+}
+
+// endSlowMode takes room out of slow mode and returns the notice saying
+// so. The caller must hold mutex.
+func endSlowMode(room string, meta *roomMeta) *Message {
+	log.Printf("Room %s is out of slow mode at %.1f messages a second", room, meta.rate.perSecond(time.Now()))
+	meta.slowSince = time.Time{}
+	message := localMessage(room, MESSAGE_NOTICE, "notice.slow_off", room)
+	return &message
+}
+
+// slowRooms returns the rooms in slow mode, sorted. The caller must hold
+// mutex.
+func slowRooms() []string {
+	var names []string
+	for _, name := range roomNames() {
+		if meta := roomMetas[name]; meta != nil && !meta.slowSince.IsZero() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exemptRoom handles the admin /exempt, which turns room-rate off and on
+// for a room. Without a room it lists the exempt rooms.
+func exemptRoom(room string) {
+	mutex.Lock()
+	if room == "" {
+		var exempt []string
+		for _, name := range roomNames() {
+			if meta := roomMetas[name]; meta != nil && meta.rateExempt {
+				exempt = append(exempt, name)
+			}
+		}
+		mutex.Unlock()
+		if len(exempt) == 0 {
+			fmt.Println("No rooms are exempt from room-rate.")
+		} else {
+			fmt.Println("Exempt from room-rate: " + strings.Join(exempt, ", "))
+		}
+		return
+	}
+	if _, ok := rooms[room]; !ok {
+		mutex.Unlock()
+		fmt.Printf("Room %s does not exist.\n", room)
+		return
+	}
+	meta := metaFor(room)
+	meta.rateExempt = !meta.rateExempt
+	exempt := meta.rateExempt
+	var notice *Message
+	if exempt && !meta.slowSince.IsZero() {
+		notice = endSlowMode(room, meta)
+	}
+	mutex.Unlock()
+	if exempt {
+		fmt.Printf("Room %s is exempt from room-rate now.\n", room)
+	} else {
+		fmt.Printf("Room %s is subject to room-rate again.\n", room)
+	}
+	if notice != nil {
+		broadcast <- *notice
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_LANGUAGE is the language every catalog falls back to. Its file
+// must have every key.
+const DEFAULT_LANGUAGE = "en"
+
+// The catalogs are locales/<code>.txt files of "key = text" lines, where
+// text is a format string. A language is added by adding its file.
+//
+//go:embed locales/*.txt
+var localeFiles embed.FS
+
+var catalogs = mustLoadCatalogs(localeFiles)
+
+func init() {
+	registerCommand("/lang", chatCommand{usage: "/lang [code]", help: "Show or set the language of server messages", run: langCommand})
+}
+
+func mustLoadCatalogs(fsys fs.FS) map[string]map[string]string {
+	loaded, err := loadCatalogs(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return loaded
+}
+
+// loadCatalogs reads the catalog of each language in the locales directory
+// of fsys. Blank lines and lines starting with # are skipped.
+func loadCatalogs(fsys fs.FS) (map[string]map[string]string, error) {
+	names, err := fs.Glob(fsys, "locales/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]map[string]string)
+	for _, name := range names {
+		file, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		catalog := make(map[string]string)
+		scanner := bufio.NewScanner(file)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, text, ok := strings.Cut(line, "=")
+			if !ok {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: expected key = text", name, n)
+			}
+			catalog[strings.TrimSpace(key)] = strings.TrimSpace(text)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		loaded[strings.TrimSuffix(path.Base(name), ".txt")] = catalog
+	}
+	if loaded[DEFAULT_LANGUAGE] == nil {
+		return nil, fmt.Errorf("locales/%s.txt is missing", DEFAULT_LANGUAGE)
+	}
+	// A key only a translation has is a typo; nothing would look it up.
+	for lang, catalog := range loaded {
+		for key := range catalog {
+			if _, ok := loaded[DEFAULT_LANGUAGE][key]; !ok {
+				return nil, fmt.Errorf("locales/%s.txt: unknown key %q", lang, key)
+			}
+			if protocolKeys[key] && lang != DEFAULT_LANGUAGE {
+				return nil, fmt.Errorf("locales/%s.txt: %q is parsed by clients and cannot be translated", lang, key)
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// protocolKeys are the texts clients parse, such as the bundled client
+// watching for "Joined room " or "Goodbye!". They are always sent in
+// DEFAULT_LANGUAGE, so translations may not have them.
+var protocolKeys = map[string]bool{
+	"welcome.guest":      true,
+	"nick.done":          true,
+	"join.done":          true,
+	"create.done":        true,
+	"quit.goodbye":       true,
+	"who.list":           true,
+	"rooms.list":         true,
+	"notice.left":        true,
+	"notice.left_reason": true,
+	"notice.renamed":     true,
+}
+
+// translate formats the text of key in lang. Keys lang has no text for, or
+// whose text takes a different number of arguments, fall back to DEFAULT_LANGUAGE, as
+// protocolKeys always do.
+func translate(lang, key string, args ...any) string {
+	if protocolKeys[key] {
+		lang = DEFAULT_LANGUAGE
+	}
+	if text, ok := catalogs[lang][key]; ok && lang != DEFAULT_LANGUAGE && formatArgs(text) == len(args) {
+		return fmt.Sprintf(text, localArgs(lang, args)...)
+	}
+	if text, ok := catalogs[DEFAULT_LANGUAGE][key]; ok {
+		return fmt.Sprintf(text, localArgs(DEFAULT_LANGUAGE, args)...)
+	}
+	return key
+}
+
+// formatArgs returns the number of arguments format takes: one per verb
+// other than %%, or up to the highest explicit [n] index.
+func formatArgs(format string) int {
+	n, next := 0, 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.[", format[i]) >= 0; i++ {
+			if format[i] != '[' {
+				continue
+			}
+			end := strings.IndexByte(format[i:], ']')
+			index, err := strconv.Atoi(format[i+1 : i+max(end, 1)])
+			if end < 0 || err != nil {
+				return -1
+			}
+			next = index - 1
+			i += end
+		}
+		if i < len(format) && format[i] != '%' {
+			next++
+			n = max(n, next)
+		}
+	}
+	return n
+}
+
+// catalogKey is an argument of translate that is itself translated, for
+// words picked at random such as /flip's heads or tails.
+type catalogKey string
+
+// localArgs returns args with each catalogKey translated into lang.
+func localArgs(lang string, args []any) []any {
+	var out []any
+	for i, arg := range args {
+		key, ok := arg.(catalogKey)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(args)
+		}
+		out[i] = translate(lang, string(key))
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
+
+func languages() []string {
+	codes := make([]string, 0, len(catalogs))
+	for code := range catalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// language returns the language client chose with /lang, or the server's.
+func (c *Client) language() string {
+	if lang, _ := c.lang.Load().(string); lang != "" {
+		return lang
+	}
+	return config().lang
+}
+
+// tr returns the text of key in the client's language.
+func (c *Client) tr(key string, args ...any) string {
+	return translate(c.language(), key, args...)
+}
+
+// say writes the text of key in the client's language as a line, after
+// its code if it is a rejection; see errorCodes.
+func (c *Client) say(key string, args ...any) {
+	text := c.tr(key, args...)
+	if code := errorCodes[key]; code != "" {
+		text = code + ": " + text
+	}
+	c.conn.Write([]byte(text + "\n"))
+}
+
+// sayError writes err as a line after its code, in the client's language
+// if it came from localErrorf.
+func (c *Client) sayError(err error) {
+	c.conn.Write([]byte(errorCode(err) + ": " + c.errorText(err) + "\n"))
+}
+
+func (c *Client) errorText(err error) string {
+	var local *localError
+	if errors.As(err, &local) {
+		return c.tr(local.key, local.args...)
+	}
+	return err.Error()
+}
+
+// localError is an error shown to clients in their language. Error
+// returns the text in DEFAULT_LANGUAGE.
+type localError struct {
+	key  string
+	args []any
+}
+
+func localErrorf(key string, args ...any) error {
+	return &localError{key: key, args: args}
+}
+
+func (e *localError) Error() string {
+	return translate(DEFAULT_LANGUAGE, e.key, e.args...)
+}
+
+// localMessage returns a room event whose text is key, rendered for each
+// text client in its language.
+func localMessage(room, kind, key string, args ...any) Message {
+	return Message{room: room, text: translate(DEFAULT_LANGUAGE, key, args...) + "\n", kind: kind, key: key, args: args}
+}
+
+func langCommand(client *Client, room, code string) {
+	if code == "" {
+		client.say("lang.current", client.language(), strings.Join(languages(), ", "))
+		return
+	}
+	if catalogs[code] == nil {
+		client.say("lang.unknown", code, strings.Join(languages(), ", "))
+		return
+	}
+	client.lang.Store(code)
+	client.say("lang.set", code)
+}
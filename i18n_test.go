@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// formatVerb matches the verbs of a catalog text.
+var formatVerb = regexp.MustCompile(`%(?:\[\d+\])?[-+# 0]*\d*(?:\.\d+)?[a-zA-Z%]`)
+
+// sampleArgs returns arguments that fit the verbs of the English text of
+// key, which takes them in order.
+func sampleArgs(key string) []any {
+	var args []any
+	for _, verb := range formatVerb.FindAllString(catalogs[DEFAULT_LANGUAGE][key], -1) {
+		switch verb[len(verb)-1] {
+		case '%':
+		case 'd':
+			args = append(args, 7)
+		default:
+			args = append(args, "x")
+		}
+	}
+	return args
+}
+
+func TestCatalogsFitTheEnglishArguments(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for key, text := range catalog {
+			args := sampleArgs(key)
+			if s := fmt.Sprintf(text, args...); strings.Contains(s, "%!") {
+				t.Errorf("locales/%s.txt: %s = %s does not fit %d arguments: %s", lang, key, text, len(args), s)
+			}
+		}
+	}
+}
+
+// catalogUse matches the catalog keys the server's source looks up, other
+// than those it builds from parts.
+var catalogUse = regexp.MustCompile(`(?:\bsay|\btr|localErrorf|translate\([^,()]+,|localMessage\([^,()]+, \w+,|funNotice\([^,()]+, [^,()]+,|catalogKey\()\s*\(?"([^"]+)"[,)]|^\s*"(8ball\.[a-z_]+)",$`)
+
+func TestCatalogHasEveryKeyUsed(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, line := range strings.Split(string(data), "\n") {
+			for _, match := range catalogUse.FindAllStringSubmatch(line, -1) {
+				key := match[1] + match[2]
+				used++
+				if _, ok := catalogs[DEFAULT_LANGUAGE][key]; !ok {
+					t.Errorf("%s:%d: %q is not in locales/%s.txt", file, n+1, key, DEFAULT_LANGUAGE)
+				}
+			}
+		}
+	}
+	if used == 0 {
+		t.Fatal("found no catalog lookups")
+	}
+	for key := range errorCodes {
+		if _, ok := catalogs[DEFAULT_LANGUAGE][key]; !ok {
+			t.Errorf("errorCodes has %q, which is not in locales/%s.txt", key, DEFAULT_LANGUAGE)
+		}
+	}
+}
+
+func TestTranslateCatalogKeyArguments(t *testing.T) {
+	tests := []struct {
+		lang, want string
+	}{
+		{"en", "[lobby] 🪙 ana flipped a coin: tails"},
+		{"ru", "[lobby] 🪙 ana подбрасывает монету: решка"},
+		{"xx", "[lobby] 🪙 ana flipped a coin: tails"},
+	}
+	for _, test := range tests {
+		if got := translate(test.lang, "flip.done", "lobby", "ana", catalogKey("flip.tails")); got != test.want {
+			t.Errorf("translate(%q) = %q, want %q", test.lang, got, test.want)
+		}
+	}
+}
+
+func TestFunNoticeFollowsTheClientsLanguage(t *testing.T) {
+	addr := newTestServer(t)
+	english := newTestClient(t, addr)
+	kazakh := newTestClient(t, addr)
+	room := uniqueName("lang")
+	english.send("/create " + room)
+	english.expectLine("Created and joined room " + room)
+	kazakh.send("/join " + room)
+	kazakh.expectLine("Joined room " + room)
+	kazakh.send("/lang kk")
+	kazakh.expectLine("kk")
+	english.send("/poll \"tea?\" yes no")
+	english.expectLine(english.name + " started a poll: tea? 1) yes 2) no")
+	kazakh.expectLine(english.name + " сауалнама бастады: tea? 1) yes 2) no")
+	kazakh.send("/vote 9")
+	kazakh.expectLine("ERR_BAD_REQUEST: Қолданылуы: /vote [нөмір]")
+}
+
+func TestProtocolLinesStayEnglish(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	c.send("/lang kk")
+	c.expectLine("kk")
+	name, room := uniqueName("kazakh"), uniqueName("protocol")
+	c.send("/nick " + name)
+	c.expectLine("Username set to " + name)
+	c.send("/create " + room)
+	c.expectLine("Created and joined room " + room)
+	c.send("/who")
+	c.expectLine("Users in " + room + ": " + name)
+	c.send("/quit")
+	c.expectLine("Goodbye!")
+	c.expectClosed()
+}
+
+func TestLoadCatalogsRefusesTranslatedProtocolKeys(t *testing.T) {
+	fsys := fstest.MapFS{
+		"locales/en.txt": {Data: []byte("join.done = Joined room %s\n")},
+		"locales/ru.txt": {Data: []byte("join.done = Вход в комнату %s\n")},
+	}
+	if _, err := loadCatalogs(fsys); err == nil {
+		t.Error("loadCatalogs accepted a translation of join.done")
+	}
+}
+
+func TestFormatArgs(t *testing.T) {
+	tests := []struct {
+		format string
+		want   int
+	}{
+		{"no verbs", 0},
+		{"100%% done", 0},
+		{"%s joined %q", 2},
+		{"%-10s|%5.2f|%+d", 3},
+		{"%[2]s under %[3]q: %[1]d", 3},
+		{"%[1]s and %s", 2},
+		{"%[x]s", -1},
+		{"trailing %", 0},
+	}
+	for _, tt := range tests {
+		if got := formatArgs(tt.format); got != tt.want {
+			t.Errorf("formatArgs(%q) = %d, want %d", tt.format, got, tt.want)
+		}
+	}
+}
+
+// TestTranslateChecksArgumentCount checks that a translation is used when
+// it takes the arguments given, even if they look like a formatting
+// error, and that one given a different number falls back to English.
+func TestTranslateChecksArgumentCount(t *testing.T) {
+	tests := []struct {
+		args []any
+		want string
+	}{
+		{[]any{"%!d(MISSING)"}, "Вы не в комнате %!d(MISSING)."},
+		{[]any{"a", "b"}, "You are not in room a.%!(EXTRA string=b)"},
+	}
+	for _, tt := range tests {
+		if got := translate("ru", "json.not_in_room", tt.args...); got != tt.want {
+			t.Errorf("translate(ru, json.not_in_room, %q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// A certificate this close to expiring is warned about at startup.
+const CERT_EXPIRY_WARNING = 14 * 24 * time.Hour
+
+// insecureWarnings lists the settings a server should not be deployed
+// with: plain TCP listeners reachable from other hosts, weak certificates
+// among certs, which maps certificate files to their keys, and debug
+// endpoints served on debugHTTP without a password.
+func insecureWarnings(specs []listenSpec, certs map[string]string, debugHTTP string, now time.Time) []string {
+	var warnings []string
+	for _, spec := range specs {
+		if spec.scheme == "tcp" && !loopbackAddr(spec.addr) {
+			warnings = append(warnings, fmt.Sprintf("%s accepts clients without TLS; their chat crosses the network unencrypted.", spec.raw))
+		}
+	}
+	files := make([]string, 0, len(certs))
+	for file := range certs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		pair, err := tls.LoadX509KeyPair(file, certs[file])
+		if err != nil {
+			// Loading it for the listener reports the error.
+			continue
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			continue
+		}
+		warnings = append(warnings, certWarnings(file, leaf, now)...)
+	}
+	if debugHTTP != "" {
+		warnings = append(warnings, fmt.Sprintf("-debug serves pprof and counters on %s to anyone; set -debug-auth.", debugHTTP))
+	}
+	return warnings
+}
+
+// certWarnings returns what makes cert, loaded from file, unfit for
+// clients that verify it.
+func certWarnings(file string, cert *x509.Certificate, now time.Time) []string {
+	var warnings []string
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+		warnings = append(warnings, fmt.Sprintf("%s is self-signed; clients can only check it with -cafile or -pin.", file))
+	}
+	switch left := cert.NotAfter.Sub(now); {
+	case left <= 0:
+		warnings = append(warnings, fmt.Sprintf("%s expired on %s.", file, cert.NotAfter.Format(time.DateOnly)))
+	case left < CERT_EXPIRY_WARNING:
+		warnings = append(warnings, fmt.Sprintf("%s expires in %d days, on %s.", file, int(left.Hours()/24), cert.NotAfter.Format(time.DateOnly)))
+	}
+	return warnings
+}
+
+// loopbackAddr reports whether a host:port only accepts connections from
+// this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// logInsecureWarnings logs warnings in a block that stands out from the
+// rest of the startup log.
+func logInsecureWarnings(warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	log.Println("************************************************************")
+	log.Println("WARNING: this server runs with insecure settings:")
+	for _, warning := range warnings {
+		log.Println("  * " + warning)
+	}
+	log.Println("************************************************************")
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const IRC_SERVER_NAME = "gochat"
+
+// ircMessage is a parsed RFC 1459 line: [:prefix] COMMAND params [:trailing].
+// The trailing parameter, when present, is the last element of params.
+type ircMessage struct {
+	command string
+	params  []string
+}
+
+func parseIRC(line string) ircMessage {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, ":") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		} else {
+			line = ""
+		}
+	}
+	var trailing *string
+	if i := strings.Index(line, " :"); i >= 0 {
+		t := line[i+2:]
+		trailing = &t
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	msg := ircMessage{}
+	if len(fields) > 0 {
+		msg.command = strings.ToUpper(fields[0])
+		msg.params = fields[1:]
+	}
+	if trailing != nil {
+		msg.params = append(msg.params, *trailing)
+	}
+	return msg
+}
+
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+func acceptIRC(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("Error: ", err)
+			continue
+		}
+		log.Printf("IRC client connected: %v", clientAddress(conn))
+		go handleIRCConnection(conn)
+	}
+}
+
+// handleIRCConnection serves a client speaking a subset of RFC 1459,
+// translating its commands into the same room operations text clients use.
+func handleIRCConnection(conn net.Conn) {
+	defer conn.Close()
+	client := newClient(conn)
+	reader := bufio.NewReader(client.conn)
+	client.irc = true
+	defer client.stop()
+
+	mutex.Lock()
+	ban, banned := isBanned(client.address)
+	full := !banned && serverFull()
+	if !banned && !full {
+		clients[client.conn] = client
+		hookConnect(client)
+	}
+	mutex.Unlock()
+	if full {
+		ircWrite(client, "ERROR :The server is full.")
+		return
+	}
+	if banned {
+		ircWrite(client, "ERROR :You are banned from the chat."+reasonSuffix(ban.Reason))
+		return
+	}
+	if checkEvasion(client, "") {
+		return
+	}
+
+	nickSet, userSet, registered := false, false, false
+	startHandshake(client)
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			if handshakeExpired(client, err) {
+				ircWrite(client, "ERROR :Registration timed out")
+			} else {
+				log.Printf("IRC client disconnected: %v", client.address)
+			}
+			disconnectClient(client, "")
+			return
+		}
+		client.lastActive.Store(time.Now().UnixNano())
+		msg := parseIRC(line)
+		switch msg.command {
+		case "":
+			continue
+		case "CAP":
+			if strings.ToUpper(msg.param(0)) == "LS" {
+				ircWrite(client, fmt.Sprintf(":%s CAP * LS :", IRC_SERVER_NAME))
+			}
+			continue
+		case "PASS", "PONG":
+			continue
+		case "PING":
+			ircWrite(client, fmt.Sprintf(":%s PONG %s :%s", IRC_SERVER_NAME, IRC_SERVER_NAME, msg.param(0)))
+			continue
+		case "QUIT":
+			ircWrite(client, "ERROR :Closing Link")
+			disconnectClient(client, msg.param(0))
+			return
+		case "NICK":
+			ircNick(client, msg.param(0), registered)
+			nickSet = client.username != ANONYMOUS_NAME
+		case "USER":
+			if registered {
+				ircReply(client, "462", ":You may not reregister")
+			}
+			userSet = true
+		default:
+			if !registered {
+				ircReply(client, "451", ":You have not registered")
+				continue
+			}
+			handleIRCCommand(client, msg)
+		}
+		if !registered && nickSet && userSet {
+			registered = true
+			finishHandshake(client)
+			ircReply(client, "001", ":Welcome to the chat, "+client.username)
+			ircReply(client, "002", ":Your host is "+IRC_SERVER_NAME)
+			ircReply(client, "003", ":This server speaks a subset of IRC")
+			ircReply(client, "004", IRC_SERVER_NAME+" gochat o o")
+			ircReply(client, "422", ":MOTD File is missing")
+		}
+	}
+}
+
+func handleIRCCommand(client *Client, msg ircMessage) {
+	switch msg.command {
+	case "JOIN":
+		if msg.param(0) == "" {
+			ircReply(client, "461", "JOIN :Not enough parameters")
+			return
+		}
+		for _, channel := range strings.Split(msg.param(0), ",") {
+			ircJoin(client, channel)
+		}
+
+	case "PART":
+		mutex.Lock()
+		room := client.room
+		mutex.Unlock()
+		channel := msg.param(0)
+		if room == "" || strings.TrimPrefix(channel, "#") != room {
+			ircReply(client, "442", channel+" :You're not on that channel")
+			return
+		}
+		leaveRoom(client, msg.param(1))
+		ircWrite(client, fmt.Sprintf("%s PART #%s :%s", ircPrefix(client.username), room, msg.param(1)))
+
+	case "PRIVMSG", "NOTICE":
+		target, text := msg.param(0), msg.param(1)
+		if target == "" || text == "" {
+			if msg.command == "PRIVMSG" {
+				ircReply(client, "412", ":No text to send")
+			}
+			return
+		}
+		if strings.HasPrefix(target, "#") {
+			mutex.Lock()
+			room := client.room
+			mutex.Unlock()
+			if room != strings.TrimPrefix(target, "#") {
+				if msg.command == "PRIVMSG" {
+					ircReply(client, "404", target+" :Cannot send to channel")
+				}
+				return
+			}
+			if err := client.allowChat(); err != nil {
+				ircReply(client, "404", target+" :"+err.Error())
+				return
+			}
+			client.counters.messagesSent.Add(1)
+			broadcast <- userChat(client, room, text)
+			return
+		}
+		if err := sendPrivateMessage(client, target, text); err != nil && msg.command == "PRIVMSG" {
+			ircReply(client, "401", target+" :No such nick/channel")
+		}
+
+	case "NAMES":
+		ircNames(client, strings.TrimPrefix(msg.param(0), "#"))
+
+	case "WHO":
+		mask := msg.param(0)
+		room := strings.TrimPrefix(mask, "#")
+		mutex.Lock()
+		names := []string{}
+		if strings.HasPrefix(mask, "#") {
+			names = roomMembers(room)
+		}
+		mutex.Unlock()
+		for _, name := range names {
+			ircReply(client, "352", fmt.Sprintf("%s %s %s %s %s H :0 %s", mask, name, IRC_SERVER_NAME, IRC_SERVER_NAME, name, name))
+		}
+		ircReply(client, "315", mask+" :End of /WHO list")
+
+	case "LIST":
+		mutex.Lock()
+		entries := []string{}
+		for _, name := range roomNames() {
+			entries = append(entries, fmt.Sprintf("#%s %d :", name, len(rooms[name])))
+		}
+		mutex.Unlock()
+		ircReply(client, "321", "Channel :Users  Name")
+		for _, entry := range entries {
+			ircReply(client, "322", entry)
+		}
+		ircReply(client, "323", ":End of /LIST")
+
+	case "TOPIC":
+		ircReply(client, "331", msg.param(0)+" :No topic is set")
+
+	case "MODE":
+		target := msg.param(0)
+		if strings.HasPrefix(target, "#") {
+			ircReply(client, "324", target+" +")
+		} else {
+			ircReply(client, "221", "+")
+		}
+
+	default:
+		ircReply(client, "421", msg.command+" :Unknown command")
+	}
+}
+
+func ircNick(client *Client, name string, registered bool) {
+	if name == "" {
+		ircReply(client, "431", ":No nickname given")
+		return
+	}
+	if !validUsername(name) || strings.HasPrefix(name, "#") || isReserved(name) {
+		ircReply(client, "432", name+" :Erroneous nickname")
+		return
+	}
+	oldName := client.username
+	if err := setUsername(client, name); err != nil {
+		ircReply(client, "433", name+" :Nickname is already in use")
+		return
+	}
+	mutex.Lock()
+	inRoom := client.room != ""
+	mutex.Unlock()
+	// Members of a room see the change through the room broadcast.
+	if registered && !inRoom {
+		ircWrite(client, fmt.Sprintf("%s NICK :%s", ircPrefix(oldName), name))
+	}
+}
+
+func ircJoin(client *Client, channel string) {
+	if !strings.HasPrefix(channel, "#") {
+		ircReply(client, "403", channel+" :No such channel")
+		return
+	}
+	mutex.Lock()
+	oldRoom := client.room
+	mutex.Unlock()
+	room := strings.TrimPrefix(channel, "#")
+	if room == oldRoom {
+		return
+	}
+	created, err := joinRoom(client, room, JOIN_OR_CREATE)
+	if err != nil {
+		ircReply(client, "403", channel+" :"+err.Error())
+		return
+	}
+	if oldRoom != "" {
+		ircWrite(client, fmt.Sprintf("%s PART #%s", ircPrefix(client.username), oldRoom))
+	}
+	ircWrite(client, fmt.Sprintf("%s JOIN #%s", ircPrefix(client.username), room))
+	broadcast <- joinNotice(room, client.username, created)
+	ircReply(client, "331", channel+" :No topic is set")
+	ircNames(client, room)
+	mutex.Lock()
+	greeting := roomGreeting(room)
+	mutex.Unlock()
+	if greeting != "" {
+		ircWrite(client, fmt.Sprintf(":%s NOTICE #%s :%s", IRC_SERVER_NAME, room, greeting))
+	}
+}
+
+func ircNames(client *Client, room string) {
+	mutex.Lock()
+	names := roomMembers(room)
+	mutex.Unlock()
+	if len(names) > 0 {
+		ircReply(client, "353", fmt.Sprintf("= #%s :%s", room, strings.Join(names, " ")))
+	}
+	ircReply(client, "366", "#"+room+" :End of /NAMES list")
+}
+
+func ircPrefix(username string) string {
+	return fmt.Sprintf(":%s!%s@%s", username, username, IRC_SERVER_NAME)
+}
+
+func ircReply(client *Client, numeric, text string) {
+	ircWrite(client, fmt.Sprintf(":%s %s %s %s", IRC_SERVER_NAME, numeric, client.username, text))
+}
+
+func ircWrite(client *Client, line string) error {
+	_, err := client.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// ircRender renders a room event or private message for an IRC client,
+// returning "" for events it should not see. IRC clients echo their own
+// messages and joins, so those are skipped.
+func ircRender(client *Client, message Message) string {
+	switch message.kind {
+	case MESSAGE_CHAT:
+		target := client.username
+		if message.room != "" {
+			if message.from == client.username {
+				return ""
+			}
+			target = "#" + message.room
+		}
+		// IRC has no multi-line messages, so each line is its own PRIVMSG.
+		lines := strings.Split(message.body, "\n")
+		for i, line := range lines {
+			lines[i] = fmt.Sprintf("%s PRIVMSG %s :%s", ircPrefix(message.from), target, line)
+		}
+		return strings.Join(lines, "\r\n")
+	case MESSAGE_JOIN:
+		if message.from == client.username {
+			return ""
+		}
+		return fmt.Sprintf("%s JOIN #%s", ircPrefix(message.from), message.room)
+	case MESSAGE_LEAVE:
+		return fmt.Sprintf("%s PART #%s :%s", ircPrefix(message.from), message.room, message.body)
+	case MESSAGE_NICK:
+		return fmt.Sprintf("%s NICK :%s", ircPrefix(message.from), message.body)
+	}
+	if message.room == "" {
+		return fmt.Sprintf(":%s NOTICE %s :%s", IRC_SERVER_NAME, client.username, noticeText(message))
+	}
+	return fmt.Sprintf(":%s NOTICE #%s :%s", IRC_SERVER_NAME, message.room, noticeText(message))
+}
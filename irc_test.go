@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// newIRCServer starts an IRC listener with TLS, as main does for -irc, and
+// returns its address. The listener closes when the test ends.
+func newIRCServer(t *testing.T) string {
+	t.Helper()
+	startServer()
+	cert, _ := testCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptIRC(tls.NewListener(listener, serverTLSConfig(cert)))
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// newIRCClient connects to an IRC listener without registering.
+func newIRCClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn := dialTest(t, addr)
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// register completes registration as name.
+func (c *testClient) register(name string) {
+	c.t.Helper()
+	c.name = name
+	c.send("NICK " + name)
+	c.send("USER " + name + " 0 * :Test User")
+	c.expectLine(" 001 " + name + " :Welcome")
+}
+
+func TestIRCRegistration(t *testing.T) {
+	addr := newIRCServer(t)
+	textAddr := newTestServer(t)
+	taken := newTestClient(t, textAddr)
+	name := uniqueName("irc")
+
+	c := newIRCClient(t, addr)
+	c.send("JOIN #early")
+	c.expectLine(" 451 ")
+	c.send("NICK")
+	c.expectLine(" 431 ")
+	c.send("NICK [bad")
+	c.expectLine(" 432 ")
+	c.send("NICK " + taken.name)
+	c.expectLine(" 433 ")
+	c.send("NICK " + name)
+	c.send("PING :check")
+	// No welcome until USER, so the PONG comes first.
+	if line := c.expectLine("PONG"); line != ":gochat PONG gochat :check" {
+		t.Errorf("got %q", line)
+	}
+	c.send("USER " + name + " 0 * :Test User")
+	c.expectLine(" 001 " + name + " :Welcome to the chat, " + name)
+	c.expectLine(" 422 ")
+	c.send("USER again 0 * :Again")
+	c.expectLine(" 462 ")
+	c.send("NICK " + name + "-2")
+	c.expectLine(":" + name + "!" + name + "@gochat NICK :" + name + "-2")
+}
+
+func TestIRCRelaysToTextClients(t *testing.T) {
+	addr := newIRCServer(t)
+	alice := newTestClient(t, newTestServer(t))
+	room := uniqueName("irc")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+
+	c := newIRCClient(t, addr)
+	c.register(uniqueName("irc"))
+	c.send("JOIN #" + room)
+	c.expectLine(c.name + "@gochat JOIN #" + room)
+	c.expectLine(" 353 " + c.name + " = #" + room + " :")
+	c.expectLine(" 366 ")
+	alice.expectLine(`[` + room + `] Notice: "` + c.name + `" joined the chat room.`)
+
+	c.send("PRIVMSG #" + room + " :hello from irc")
+	alice.expectLine(c.name + ": hello from irc")
+	alice.send("hello from text")
+	if line := c.expectLine("PRIVMSG"); line != ":"+alice.name+"!"+alice.name+"@gochat PRIVMSG #"+room+" :hello from text" {
+		t.Errorf("got %q", line)
+	}
+	c.send("PRIVMSG " + alice.name + " :psst")
+	alice.expectLine("[PM from " + c.name + "] psst")
+	alice.send("/msg " + c.name + " psst back")
+	c.expectLine(":" + alice.name + "!" + alice.name + "@gochat PRIVMSG " + c.name + " :psst back")
+
+	c.send("PRIVMSG #elsewhere :lost")
+	c.expectLine(" 404 " + c.name + " #elsewhere :Cannot send to channel")
+	c.send("PRIVMSG nobody-here :lost")
+	c.expectLine(" 401 ")
+}
+
+func TestIRCPartAndQuit(t *testing.T) {
+	addr := newIRCServer(t)
+	alice := newTestClient(t, newTestServer(t))
+	room := uniqueName("irc")
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+
+	c := newIRCClient(t, addr)
+	c.register(uniqueName("irc"))
+	c.send("PART #" + room)
+	c.expectLine(" 442 ")
+	c.send("JOIN #" + room)
+	c.expectLine(" 366 ")
+	c.send("PART #" + room + " :lunch")
+	c.expectLine(c.name + "@gochat PART #" + room + " :lunch")
+	alice.expectLine(`"` + c.name + `" left the chat room (lunch).`)
+
+	c.send("JOIN #" + room)
+	c.expectLine(" 366 ")
+	alice.expectLine(`"` + c.name + `" joined`)
+	alice.send("/who")
+	alice.expectLine(c.name)
+	c.send("QUIT :gone")
+	c.expectLine("ERROR :Closing Link")
+	c.expectClosed()
+	alice.expectLine(`"` + c.name + `" left the chat room (gone).`)
+	waitFor(t, c.name+" to be removed", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return findClient(c.name) == nil
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// jsonRequest is a line sent by a client in structured mode. Which fields
+// are used depends on Type. ID is an optional correlation ID echoed in the
+// reply.
+type jsonRequest struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Room    string `json:"room"`
+	Text    string `json:"text"`
+	Name    string `json:"name"`
+	To      string `json:"to"`
+	FromSeq uint64 `json:"from_seq"`
+	Nonce   string `json:"nonce"`
+	// Key and Ciphertext are base64, for pubkey and encrypted_pm.
+	Key        string `json:"key"`
+	Ciphertext string `json:"ciphertext"`
+	// Channels are for subscribe and unsubscribe.
+	Channels []string `json:"channels"`
+	// Cursor continues a list request from a directory event.
+	Cursor string `json:"cursor"`
+	// Count is how many messages an export request asks for.
+	Count int `json:"count"`
+}
+
+// jsonEvent is a line sent to a client in structured mode. Room events
+// carry the room's sequence number; replies to requests name the request
+// they answer along with the request's ID.
+type jsonEvent struct {
+	Type    string  `json:"type"`
+	Channel string  `json:"channel"`
+	Code    string  `json:"code,omitempty"`
+	ID      string  `json:"id,omitempty"`
+	Request string  `json:"request,omitempty"`
+	Room    string  `json:"room,omitempty"`
+	Seq     uint64  `json:"seq,omitempty"`
+	Time    string  `json:"time,omitempty"`
+	From    string  `json:"from,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Display string  `json:"display,omitempty"`
+	Bot     bool    `json:"bot,omitempty"`
+	Text    string  `json:"text,omitempty"`
+	Created bool    `json:"created,omitempty"`
+	LastSeq *uint64 `json:"last_seq,omitempty"`
+	FromSeq uint64  `json:"from_seq,omitempty"`
+	ToSeq   uint64  `json:"to_seq,omitempty"`
+	Replay  bool    `json:"replay,omitempty"`
+	Nonce   string  `json:"nonce,omitempty"`
+	// Key and Ciphertext carry keys and encrypted private messages.
+	Key        string `json:"key,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	// Members lists the room's users in a joined reply.
+	Members []string `json:"members,omitempty"`
+	// Greeting is the room's greeting in a joined reply.
+	Greeting string `json:"greeting,omitempty"`
+	// Recipients is the number of other room members an acked chat
+	// message was delivered to.
+	Recipients *int `json:"recipients,omitempty"`
+	// Rooms is the room directory in a directory event.
+	Rooms []directoryEntry `json:"rooms,omitempty"`
+	// Cursor, in a directory event that does not hold every room, is
+	// passed to the list request for the next rooms.
+	Cursor string `json:"cursor,omitempty"`
+	// Channels are the channels left subscribed in the reply to
+	// subscribe and unsubscribe.
+	Channels []string `json:"channels,omitempty"`
+	// Messages are the chat events of an export event.
+	Messages []jsonEvent `json:"messages,omitempty"`
+}
+
+// handleJSONRequest serves one line from a client that switched to
+// structured mode with /json.
+func handleJSONRequest(client *Client, line string) {
+	var req jsonRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		err := localErrorf("json.bad_request")
+		jsonWrite(client, jsonEvent{Type: "error", Code: errorCode(err), Text: client.errorText(err)})
+		return
+	}
+	reply := func(event jsonEvent) {
+		event.ID = req.ID
+		event.Request = req.Type
+		jsonWrite(client, event)
+	}
+	fail := func(err error) {
+		reply(jsonEvent{Type: "error", Code: errorCode(err), Text: client.errorText(err)})
+	}
+
+	if req.Type != "chat" {
+		client.counters.commands.Add(1)
+	}
+	switch req.Type {
+	case "join", "create", "chat", "msg", "encrypted_pm":
+		if err := checkNamed(client); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	switch req.Type {
+	case "join", "create":
+		mode := JOIN_EXISTING
+		if req.Type == "create" {
+			mode = CREATE_NEW
+		}
+		created, err := joinRoom(client, req.Room, mode)
+		if err != nil {
+			fail(err)
+			return
+		}
+		// The reply goes through the queue, behind any event it already
+		// counts in last_seq and members and ahead of any it does not.
+		mutex.Lock()
+		last := lastSeq(req.Room)
+		joined := jsonEvent{Type: "joined", ID: req.ID, Request: req.Type, Room: req.Room, Created: created, LastSeq: &last, Members: roomMembers(req.Room)}
+		if !client.bot {
+			joined.Greeting = roomGreeting(req.Room)
+		}
+		client.enqueue(jsonLine(joined), QUEUE_CHAT)
+		mutex.Unlock()
+		broadcast <- joinNotice(req.Room, client.username, created)
+
+	case "chat":
+		if strings.TrimSpace(req.Text) == "" {
+			fail(localErrorf("json.empty_text"))
+			return
+		}
+		text, err := pasteBody(req.Text)
+		if err != nil {
+			fail(err)
+			return
+		}
+		mutex.Lock()
+		room := client.room
+		mutex.Unlock()
+		if room == "" {
+			fail(localErrorf("room.join_first_short"))
+			return
+		}
+		if err := client.allowChat(); err != nil {
+			fail(err)
+			return
+		}
+		client.counters.messagesSent.Add(1)
+		message := userChat(client, room, text)
+		if req.ID != "" {
+			message.sender = client
+			message.ackID = req.ID
+		}
+		broadcast <- message
+
+	case "nick":
+		if err := setUsername(client, req.Name); err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "ok", Name: req.Name})
+
+	case "msg":
+		if err := sendPrivateMessage(client, req.To, req.Text); err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "ok", Name: req.To})
+
+	case "pubkey":
+		if err := publishKey(client, req.Key); err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "ok"})
+
+	case "key":
+		key, err := lookupKey(req.Name)
+		if err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "key", Name: req.Name, Key: base64.StdEncoding.EncodeToString(key), Text: keyFingerprint(key)})
+
+	case "encrypted_pm":
+		if err := sendEncryptedPM(client, req); err != nil {
+			fail(err)
+			return
+		}
+		client.counters.messagesSent.Add(1)
+		reply(jsonEvent{Type: "ok", Name: req.To})
+
+	case "leave":
+		room := leaveRoom(client, req.Text)
+		if room == "" {
+			fail(localErrorf("room.not_in"))
+			return
+		}
+		reply(jsonEvent{Type: "ok", Room: room})
+
+	case "backfill":
+		jsonBackfill(client, req)
+
+	case "export":
+		export, err := jsonExport(client, req)
+		if err != nil {
+			fail(err)
+			return
+		}
+		reply(export)
+
+	case "list":
+		if err := client.allowList(); err != nil {
+			fail(err)
+			return
+		}
+		if err := sendDirectory(client, req.ID, req.Type, req.Cursor); err != nil {
+			fail(err)
+		}
+
+	case "ping":
+		if err := client.allowPing(); err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "pong", Nonce: req.Nonce, Time: serverTime()})
+
+	case "subscribe", "unsubscribe":
+		channels, err := subscribe(client, req.Room, req.Channels, req.Type == "subscribe")
+		if err != nil {
+			fail(err)
+			return
+		}
+		reply(jsonEvent{Type: "ok", Room: req.Room, Channels: channels})
+
+	case "quit":
+		// Written rather than queued, as /quit's goodbye is, since the
+		// connection closes before the writer would get to it.
+		client.conn.Write(jsonLine(jsonEvent{Type: "ok", ID: req.ID, Request: req.Type}))
+		disconnectClient(client, req.Text)
+
+	default:
+		fail(localErrorf("json.unknown_request", req.Type))
+	}
+}
+
+// jsonBackfill replays the events of room from fromSeq on. Events that are
+// no longer kept are reported with a single gap event before the replay.
+func jsonBackfill(client *Client, req jsonRequest) {
+	room, fromSeq := req.Room, req.FromSeq
+	mutex.Lock()
+	if room == "" || client.room != room {
+		mutex.Unlock()
+		err := localErrorf("json.not_in_room", room)
+		jsonWrite(client, jsonEvent{Type: "error", Code: errorCode(err), ID: req.ID, Request: "backfill", Room: room, Text: client.errorText(err)})
+		return
+	}
+	// Events the room's /sethistory hides are skipped without a gap.
+	fromSeq = max(fromSeq, historyStart(client, room))
+	last := lastSeq(room)
+	events, oldest := historySince(room, fromSeq)
+	var lines []byte
+	if fromSeq < oldest {
+		lines = append(lines, jsonLine(jsonEvent{Type: "gap", Room: room, FromSeq: fromSeq, ToSeq: min(oldest-1, last)})...)
+	}
+	for _, message := range events {
+		event := toJSONEvent(message)
+		event.Replay = true
+		lines = append(lines, jsonLine(event)...)
+	}
+	lines = append(lines, jsonLine(jsonEvent{Type: "ok", ID: req.ID, Request: "backfill", Room: room, LastSeq: &last})...)
+	// Queued as one entry before mutex is let go, so live events of the
+	// room follow the replay rather than cutting into it.
+	client.enqueue(lines, QUEUE_CHAT)
+	mutex.Unlock()
+}
+
+// jsonPresence names the presence events sent for room membership changes.
+var jsonPresence = map[string]string{
+	MESSAGE_JOIN:  "user_joined_room",
+	MESSAGE_LEAVE: "user_left_room",
+	MESSAGE_NICK:  "user_renamed",
+}
+
+// toJSONEvent describes a room event or private message for structured
+// clients.
+func toJSONEvent(message Message) jsonEvent {
+	stamp := message.time
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	event := jsonEvent{Type: message.kind, Channel: messageChannel(message), Room: message.room, Seq: message.seq, Time: stamp.Format(time.RFC3339), From: message.from}
+	if presence, ok := jsonPresence[message.kind]; ok {
+		event.Type = presence
+	}
+	switch message.kind {
+	case MESSAGE_CHAT:
+		event.Text = message.body
+		event.Display = message.display
+		event.Bot = message.bot
+		if message.room == "" {
+			event.Type = "pm"
+		}
+	case MESSAGE_LEAVE:
+		event.Text = message.body
+	case MESSAGE_NICK:
+		event.Name = message.body
+	case MESSAGE_JOIN:
+	default:
+		event.Text = noticeText(message)
+	}
+	return event
+}
+
+func jsonLine(event jsonEvent) []byte {
+	if event.Channel == "" {
+		event.Channel = eventChannel(event)
+	}
+	data, _ := json.Marshal(event)
+	return append(data, '\n')
+}
+
+// jsonWrite queues event for client unless it is unasked for and on a
+// channel the client unsubscribed from. The caller must not hold mutex.
+func jsonWrite(client *Client, event jsonEvent) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if event.Request == "" && !client.subscribed(event.Room, eventChannel(event)) {
+		return nil
+	}
+	return client.enqueue(jsonLine(event), QUEUE_CHAT)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"final_project/codes"
+)
+
+// readEvent reads the next structured event from c.
+func (c *testClient) readEvent() jsonEvent {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	defer c.conn.SetReadDeadline(time.Time{})
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("waiting for an event: %v", err)
+	}
+	var event jsonEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		c.t.Fatalf("%q is not an event: %v", line, err)
+	}
+	return event
+}
+
+// newJSONClient connects to addr, switches to structured mode and joins
+// room.
+func newJSONClient(t *testing.T, addr, room string) *testClient {
+	c := newTestClient(t, addr)
+	c.send("/json")
+	c.expectLine(`"request":"json"`)
+	c.send(fmt.Sprintf(`{"type":"join","room":%q}`, room))
+	c.expectLine(`"request":"join"`)
+	return c
+}
+
+func TestJSONBackfillIsNotInterleaved(t *testing.T) {
+	addr := newTestServer(t)
+	sender := newTestClient(t, addr)
+	room := uniqueName("backfill")
+	sender.send("/create " + room)
+	sender.expectLine("Created and joined room " + room)
+	reader := newJSONClient(t, addr, room)
+	for i := range 10 {
+		sender.send(fmt.Sprint("before ", i))
+	}
+	reader.expectLine("before 9")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 50 {
+			sender.send(fmt.Sprint("during ", i))
+		}
+	}()
+	reader.send(`{"type":"backfill","id":"b","room":"` + room + `"}`)
+	// Live events may come before the replay, but only those it covers.
+	var live []jsonEvent
+	replaying := false
+	for {
+		event := reader.readEvent()
+		if event.Request == "backfill" {
+			if event.Type != "ok" {
+				t.Fatalf("backfill failed: %+v", event)
+			}
+			for _, early := range live {
+				if early.Seq > *event.LastSeq {
+					t.Errorf("live event %d %q came before the replay up to %d", early.Seq, early.Text, *event.LastSeq)
+				}
+			}
+			break
+		}
+		switch {
+		case event.Replay:
+			replaying = true
+		case event.Seq == 0:
+		case replaying:
+			t.Errorf("live event %q cut into the replay", event.Text)
+		default:
+			live = append(live, event)
+		}
+	}
+	if !replaying {
+		t.Error("nothing was replayed")
+	}
+	<-done
+}
+
+func TestJSONQuitIsAnswered(t *testing.T) {
+	addr := newTestServer(t)
+	c := newJSONClient(t, addr, uniqueName("quit"))
+	c.send(`{"type":"quit","id":"q"}`)
+	c.expectLine(`"request":"quit"`)
+	c.expectClosed()
+}
+
+// TestJSONMembersThroughRenamesAndDisconnects joins a library client to a
+// room while other members rename themselves and leave, and checks that
+// its member list ends up the server's.
+func TestJSONMembersThroughRenamesAndDisconnects(t *testing.T) {
+	addr := newTestServer(t)
+	owner := newTestClient(t, addr)
+	room := uniqueName("members")
+	owner.send("/create " + room)
+	owner.expectLine("Created and joined room " + room)
+	watcher, messages := newLibraryClient(t, addr, uniqueName("watcher"))
+	go func() {
+		for range messages {
+		}
+	}()
+
+	t.Run("group", func(t *testing.T) {
+		t.Run("watcher", func(t *testing.T) {
+			t.Parallel()
+			if err := watcher.Join(room); err != nil {
+				t.Error(err)
+			}
+		})
+		for i := range 30 {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				t.Parallel()
+				c := newTestClient(t, addr)
+				c.send("/join " + room)
+				c.expectLine("Joined room " + room)
+				name := uniqueName("renamed")
+				c.send("/nick " + name)
+				c.expectLine("Username set to " + name)
+				switch i % 3 {
+				case 0:
+					c.send("/quit")
+					c.expectClosed()
+				case 1:
+					c.conn.Close()
+				}
+			})
+		}
+	})
+
+	waitFor(t, "the member lists to agree", func() bool {
+		mutex.Lock()
+		want := roomMembers(room)
+		mutex.Unlock()
+		return slices.Equal(watcher.Members(room), want)
+	})
+}
+
+func TestJSONErrorsAreTranslated(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	c.send("/lang ru")
+	c.expectLine("ru")
+	c.send("/json")
+	c.expectLine(`"request":"json"`)
+	tests := []struct {
+		request string
+		code    string
+		text    string
+	}{
+		{"not json", codes.ERR_BAD_REQUEST, translate("ru", "json.bad_request")},
+		{`{"type":"backfill","room":"nowhere"}`, codes.ERR_NO_ROOM, translate("ru", "json.not_in_room", "nowhere")},
+	}
+	for _, tt := range tests {
+		c.send(tt.request)
+		event := c.readEvent()
+		if event.Type != "error" || event.Code != tt.code || event.Text != tt.text {
+			t.Errorf("%s: got %+v, want %s %q", tt.request, event, tt.code, tt.text)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LATENCY_SAMPLES is how many of the latest broadcasts the percentiles of
+// /stats are taken over.
+const LATENCY_SAMPLES = 1024
+
+// LATENCY_BUCKETS are the upper bounds of the broadcast latency histogram;
+// a last bucket counts the rest.
+var LATENCY_BUCKETS = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyMetrics turns on the broadcast latency histogram; see
+// broadcastTimer.
+var latencyMetrics bool
+
+// broadcastLatency is the time handleBroadcast takes from taking a message
+// off the broadcast channel to having it queued for every member. Time
+// spent waiting in the channel is not included; see watchBacklog.
+var broadcastLatency latencyRecorder
+
+type latencyRecorder struct {
+	mu      sync.Mutex
+	counts  []int64
+	samples [LATENCY_SAMPLES]time.Duration
+	n       int
+}
+
+// LatencyStats is the broadcast latency in ServerStats, in nanoseconds.
+// Buckets[i] counts the broadcasts that took at most LATENCY_BUCKETS[i];
+// the last one counts the slower ones. The percentiles are over the latest
+// LATENCY_SAMPLES.
+type LatencyStats struct {
+	Count   int64   `json:"count"`
+	Bounds  []int64 `json:"bucket_bounds_ns"`
+	Buckets []int64 `json:"buckets"`
+	P50     int64   `json:"p50_ns"`
+	P95     int64   `json:"p95_ns"`
+	P99     int64   `json:"p99_ns"`
+}
+
+func (r *latencyRecorder) record(d time.Duration) {
+	i, _ := slices.BinarySearch(LATENCY_BUCKETS, d)
+	r.mu.Lock()
+	if r.counts == nil {
+		r.counts = make([]int64, len(LATENCY_BUCKETS)+1)
+	}
+	r.counts[i]++
+	r.samples[r.n%LATENCY_SAMPLES] = d
+	r.n++
+	r.mu.Unlock()
+}
+
+func (r *latencyRecorder) stats() *LatencyStats {
+	r.mu.Lock()
+	samples := slices.Clone(r.samples[:min(r.n, LATENCY_SAMPLES)])
+	counts := slices.Clone(r.counts)
+	r.mu.Unlock()
+	if len(samples) == 0 {
+		return nil
+	}
+	stats := &LatencyStats{Buckets: counts}
+	for _, bound := range LATENCY_BUCKETS {
+		stats.Bounds = append(stats.Bounds, int64(bound))
+	}
+	for _, count := range counts {
+		stats.Count += count
+	}
+	slices.Sort(samples)
+	percentile := func(p int) int64 {
+		return int64(samples[(len(samples)-1)*p/100])
+	}
+	stats.P50, stats.P95, stats.P99 = percentile(50), percentile(95), percentile(99)
+	return stats
+}
+
+// broadcastTimer returns the time handleBroadcast starts on a message, or
+// the zero time when neither the metrics, latency-warn nor watchBacklog
+// need it.
+func broadcastTimer() time.Time {
+	if !latencyMetrics && config().latencyWarn == 0 && broadcastBuffer == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// recordBroadcast notes the latency of a broadcast started at start and
+// warns when it took longer than latency-warn, naming the member with the
+// most lines queued as the likely culprit. The caller must hold mutex.
+func recordBroadcast(start time.Time, message Message, members []*Client) {
+	if start.IsZero() {
+		return
+	}
+	elapsed := time.Since(start)
+	if latencyMetrics {
+		broadcastLatency.record(elapsed)
+	}
+	if broadcastBuffer > 0 {
+		noteBroadcast(elapsed, message, members)
+	}
+	if limit := config().latencyWarn; limit == 0 || elapsed < limit {
+		return
+	}
+	slowest, queued := slowestMember(members)
+	log.Printf("Slow broadcast: room=%q kind=%s latency=%v recipients=%d slowest=%q queued=%d", message.room, message.kind, elapsed.Round(time.Microsecond), len(members), slowest, queued)
+}
+
+// slowestMember names the member with the most lines queued and how many.
+// The caller must hold mutex.
+func slowestMember(members []*Client) (string, int) {
+	slowest, queued := "none", 0
+	for _, client := range members {
+		if n := len(client.queue.lines); n >= queued {
+			slowest, queued = fmt.Sprintf("%s (%s)", client.username, client.address), n
+		}
+	}
+	return slowest, queued
+}
+
+// latencyText formats the percentiles for /stats.
+func latencyText(stats *LatencyStats) string {
+	if stats == nil {
+		return "no broadcasts yet"
+	}
+	return fmt.Sprintf("p50 %v, p95 %v, p99 %v over the last %d of %d", time.Duration(stats.P50), time.Duration(stats.P95), time.Duration(stats.P99), min(stats.Count, LATENCY_SAMPLES), stats.Count)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MESSAGE_RATE_WINDOW is the period message-rate counts a client's chat
+// over.
+const MESSAGE_RATE_WINDOW = time.Minute
+
+// limitNames are the settings /limits shows and /setlimit changes.
+var limitNames = []string{"max-clients", "max-rooms", "max-rooms-per-user", "message-rate", "room-rate"}
+
+// allowChat counts a chat message from the client and reports whether it
+// is within message-rate and, in a room in slow mode, room-rate. The caller
+// must not hold mutex.
+func (c *Client) allowChat() error {
+	mutex.Lock()
+	limit := c.chatRate()
+	if limit == 0 && config().roomRate == 0 {
+		mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(c.chatWindow) >= MESSAGE_RATE_WINDOW {
+		c.chatWindow = now
+		c.chats = 0
+	}
+	if limit > 0 && c.chats >= limit {
+		mutex.Unlock()
+		c.counters.rateLimited.Add(1)
+		return localErrorf("chat.too_fast", limit)
+	}
+	notice, err := c.allowRoomChat(now)
+	if err == nil {
+		c.chats++
+	}
+	mutex.Unlock()
+	if notice != nil {
+		broadcast <- *notice
+	}
+	return err
+}
+
+// serverFull reports whether max-clients are connected. The caller must
+// hold mutex.
+func serverFull() bool {
+	limit := config().maxClients
+	return limit > 0 && len(clients) >= limit
+}
+
+// roomLimitError returns why client may not create another room, if it may
+// not. The caller must hold mutex.
+func roomLimitError(client *Client) error {
+	if limit := config().maxRooms; limit > 0 && len(rooms) >= limit {
+		return localErrorf("room.limit", limit)
+	}
+	limit := config().maxRoomsPerUser
+	if limit == 0 {
+		return nil
+	}
+	owned := 0
+	for name := range rooms {
+		if meta := roomMetas[name]; meta != nil && meta.owner == client {
+			owned++
+		}
+	}
+	if owned >= limit {
+		return localErrorf("room.owner_limit", limit)
+	}
+	return nil
+}
+
+func printLimits() {
+	mutex.Lock()
+	usage := map[string]string{
+		"max-clients": fmt.Sprintf("%d connected", len(clients)),
+		"max-rooms":   fmt.Sprintf("%d rooms", len(rooms)),
+	}
+	mutex.Unlock()
+	usage["message-rate"] = "messages per minute per client"
+	if rate := config().botMessageRate; rate >= 0 {
+		usage["message-rate"] += fmt.Sprintf(", %d for bots", rate)
+	}
+	usage["room-rate"] = "messages per second per room before slow mode"
+	for _, name := range limitNames {
+		value := limitValue(name)
+		if value == "0" {
+			value = "unlimited"
+		}
+		if note := usage[name]; note != "" {
+			value += " (" + note + ")"
+		}
+		fmt.Printf("%s: %s\n", name, value)
+	}
+}
+
+func limitValue(name string) string {
+	for _, setting := range settingKeys {
+		if setting.name == name {
+			return setting.get(config())
+		}
+	}
+	return ""
+}
+
+// setLimit handles the admin /setlimit. The change lasts until the next
+// reload.
+func setLimit(args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 || !slices.Contains(limitNames, fields[0]) {
+		fmt.Printf("Usage: /setlimit %s <value>, 0 for no limit\n", strings.Join(limitNames, "|"))
+		return
+	}
+	name := fields[0]
+	value, err := strconv.Atoi(fields[1])
+	if err != nil || value < 0 {
+		fmt.Println("The value must be a number of at least 0.")
+		return
+	}
+	if err := changeSetting(name, fields[1]); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%s set to %d until the next reload.\n", name, value)
+	mutex.Lock()
+	connected, created := len(clients), len(rooms)
+	mutex.Unlock()
+	switch {
+	case name == "max-clients" && value > 0 && connected > value:
+		fmt.Printf("Warning: %d clients are connected; they stay, but nobody new can connect until fewer than %d are.\n", connected, value)
+	case name == "max-rooms" && value > 0 && created > value:
+		fmt.Printf("Warning: there are %d rooms; they stay, but no new ones can be created.\n", created)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_CERT_FILE   = "cert.pem"
+	DEFAULT_KEY_FILE    = "key.pem"
+	DEFAULT_SOCKET_MODE = 0600
+)
+
+// listenFlags collects repeated -listen flags.
+type listenFlags []string
+
+func (f *listenFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *listenFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// listenSpec is a parsed -listen value such as tls://0.0.0.0:3334,
+// tcp://127.0.0.1:3335 or unix:///var/run/chat.sock. TLS listeners may name
+// their own key pair with ?cert=...&key=..., and Unix sockets their file
+// mode with ?mode=0660.
+type listenSpec struct {
+	raw      string
+	scheme   string
+	addr     string
+	certFile string
+	keyFile  string
+	mode     os.FileMode
+}
+
+func parseListen(value string) (listenSpec, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return listenSpec{}, fmt.Errorf("invalid listen address %q: %v", value, err)
+	}
+	spec := listenSpec{raw: value, scheme: u.Scheme, addr: u.Host}
+	switch u.Scheme {
+	case "tls":
+		spec.certFile = u.Query().Get("cert")
+		spec.keyFile = u.Query().Get("key")
+		if spec.certFile == "" {
+			spec.certFile = DEFAULT_CERT_FILE
+		}
+		if spec.keyFile == "" {
+			spec.keyFile = DEFAULT_KEY_FILE
+		}
+	case "tcp":
+	case "unix":
+		spec.addr = u.Host + u.Path
+		spec.mode = DEFAULT_SOCKET_MODE
+		if mode := u.Query().Get("mode"); mode != "" {
+			parsed, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || parsed > 0777 {
+				return listenSpec{}, fmt.Errorf("invalid listen address %q: mode must be octal permissions like 0660", value)
+			}
+			spec.mode = os.FileMode(parsed)
+		}
+	default:
+		return listenSpec{}, fmt.Errorf("invalid listen address %q: scheme must be tls, tcp or unix", value)
+	}
+	if spec.addr == "" {
+		return listenSpec{}, fmt.Errorf("invalid listen address %q: missing address", value)
+	}
+	return spec, nil
+}
+
+// openListeners binds every spec. If one fails, the ones already bound
+// are closed and the error names the failing listener.
+func openListeners(specs []listenSpec) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, spec := range specs {
+		listener, err := spec.listen()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("cannot listen on %s: %v", spec.raw, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func (spec listenSpec) listen() (net.Listener, error) {
+	if spec.scheme == "unix" {
+		return listenUnix(spec.addr, spec.mode)
+	}
+	var config *tls.Config
+	if spec.scheme == "tls" {
+		var err error
+		if config, err = loadTLSConfig(spec.certFile, spec.keyFile); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen(CONN_TYPE, spec.addr)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return plainListener{throttle(listener)}, nil
+	}
+	return tls.NewListener(throttle(listener), config), nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a stale socket
+// file that nothing listens on. The file is removed when the listener is
+// closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		log.Printf("Removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func loadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return serverTLSConfig(cert), nil
+}
+
+// serverTLSConfig is the TLS configuration of a client listener serving
+// cert.
+func serverTLSConfig(cert tls.Certificate) *tls.Config {
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if evasionMode != EVASION_OFF {
+		// Client certificates are asked for, never required, so that
+		// checkEvasion can recognize one.
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return config
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// acceptClients hands connections from listener to handleConnection until
+// the listener is closed.
+func acceptClients(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("Error: ", err)
+			continue
+		}
+		log.Printf("Client connected: %v", clientAddress(conn))
+		go handleConnection(conn)
+	}
+}
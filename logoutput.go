@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	DEFAULT_LOG_MAX_SIZE = 10 * 1024 * 1024
+	DEFAULT_LOG_BACKUPS  = 5
+)
+
+// setupLogOutput sends the log to output: "stderr", "syslog" or the path
+// of a file. Files are rotated once they grow past maxSize bytes, keeping
+// backups old files, and are reopened on SIGUSR1 where that exists.
+func setupLogOutput(output string, maxSize int64, backups int) error {
+	switch output {
+	case "", "stderr":
+		return nil
+	case "syslog":
+		sink, err := openSyslog()
+		if err != nil {
+			return err
+		}
+		log.SetOutput(&fallbackWriter{sink: sink, name: "syslog"})
+		// syslog stamps entries itself.
+		log.SetFlags(0)
+		return nil
+	}
+	file, err := openRotatingFile(output, maxSize, backups)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(&fallbackWriter{sink: file, name: output})
+	watchReopen(file)
+	return nil
+}
+
+// fallbackWriter writes to stderr whatever its sink fails to take, with a
+// warning when the sink starts failing. It keeps trying the sink.
+type fallbackWriter struct {
+	sink    io.Writer
+	name    string
+	mu      sync.Mutex
+	failing bool
+}
+
+func (w *fallbackWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.sink.Write(p); err != nil {
+		if !w.failing {
+			fmt.Fprintf(os.Stderr, "Warning: cannot write the log to %s, writing to stderr instead: %v\n", w.name, err)
+			w.failing = true
+		}
+		return os.Stderr.Write(p)
+	}
+	w.failing = false
+	return len(p), nil
+}
+
+// rotatingFile is a log file that is renamed to path.1 once it reaches
+// maxSize, shifting older backups up to path.<backups>.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending. The caller must hold f.mu, or own f.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		// A failed rotation or reopen left no file; try again.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and starts a new file. The caller must hold
+// f.mu.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	if f.backups > 0 {
+		for i := f.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Reopen closes and reopens the file, for after logrotate moved it away.
+func (f *rotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
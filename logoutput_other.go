@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this system")
+}
+
+func watchReopen(file *rotatingFile) {}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func openSyslog() (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "gochat")
+}
+
+// watchReopen reopens file on SIGUSR1, for logrotate's copy-free rotation.
+func watchReopen(file *rotatingFile) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			if err := file.Reopen(); err != nil {
+				log.Println("Error reopening the log file:", err)
+			} else {
+				log.Println("Reopened the log file")
+			}
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The -motd file is sent to every client after the welcome line. It is a
+// text/template run against a motdSnapshot of the server when it is sent,
+// so it can say things like "{{.UserCount}} people online, busiest rooms:
+// {{.TopRooms 5}}". Templates get no functions beyond text/template's
+// built-ins. A file that does not parse, or fails when run, is sent as it
+// is.
+type motd struct {
+	raw  string
+	tmpl *template.Template
+}
+
+// motdSnapshot is what MOTD templates can refer to.
+type motdSnapshot struct {
+	UserCount  int
+	Uptime     time.Duration
+	ServerName string
+	rooms      []directoryEntry
+}
+
+// TopRooms lists up to n rooms anyone may join, busiest in the last hour
+// first, as "lobby (12/h), dev (3/h)".
+func (s motdSnapshot) TopRooms(n int) string {
+	var names []string
+	for _, entry := range s.rooms[:min(max(n, 0), len(s.rooms))] {
+		names = append(names, fmt.Sprintf("%s (%d/h)", entry.Name, entry.MessagesPerHour))
+	}
+	if len(names) == 0 {
+		return "none yet"
+	}
+	return strings.Join(names, ", ")
+}
+
+// loadMOTD reads the MOTD file at path, or returns nil for no path. A
+// template that fails to parse or to run on an empty snapshot is logged
+// rather than returned, since the raw file is still worth sending.
+func loadMOTD(path string) (*motd, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &motd{raw: strings.TrimRight(string(data), "\n") + "\n"}
+	tmpl, err := template.New(path).Parse(m.raw)
+	if err == nil {
+		// Catch unknown fields and bad arguments now, not per client.
+		err = tmpl.Execute(io.Discard, motdSnapshot{})
+	}
+	if err != nil {
+		log.Printf("motd: %v; sending %s without filling it in", err, path)
+		return m, nil
+	}
+	m.tmpl = tmpl
+	return m, nil
+}
+
+// takeMOTDSnapshot gathers the figures MOTD templates show. The caller
+// must hold mutex.
+func takeMOTDSnapshot() motdSnapshot {
+	snapshot := motdSnapshot{Uptime: time.Since(startTime).Round(time.Second), ServerName: serverName()}
+	for _, client := range clients {
+		if !client.bot {
+			snapshot.UserCount++
+		}
+	}
+	now := time.Now()
+	for _, name := range roomNames() {
+		meta := roomMetas[name]
+		if meta != nil && meta.whitelist {
+			continue
+		}
+		entry := directoryEntry{Name: name}
+		if meta != nil {
+			entry.MessagesPerHour = meta.activity.perHour(now)
+		}
+		snapshot.rooms = append(snapshot.rooms, entry)
+	}
+	sort.SliceStable(snapshot.rooms, func(i, j int) bool {
+		return snapshot.rooms[i].MessagesPerHour > snapshot.rooms[j].MessagesPerHour
+	})
+	return snapshot
+}
+
+// sendMOTD sends the message of the day, if there is one, to client.
+func sendMOTD(client *Client) {
+	m := config().motd
+	if m == nil {
+		return
+	}
+	text := m.raw
+	if m.tmpl != nil {
+		mutex.Lock()
+		snapshot := takeMOTDSnapshot()
+		mutex.Unlock()
+		var b strings.Builder
+		if err := m.tmpl.Execute(&b, snapshot); err != nil {
+			log.Printf("motd: %v; sending it without filling it in", err)
+		} else {
+			text = strings.TrimRight(b.String(), "\n") + "\n"
+		}
+	}
+	client.conn.Write([]byte(text))
+}
+
+// serverName is the bridge's server ID, or the host name.
+func serverName() string {
+	if bridge != nil {
+		return bridge.serverID
+	}
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopRooms(t *testing.T) {
+	snapshot := motdSnapshot{rooms: []directoryEntry{
+		{Name: "lobby", MessagesPerHour: 12},
+		{Name: "dev", MessagesPerHour: 3},
+		{Name: "quiet"},
+	}}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{2, "lobby (12/h), dev (3/h)"},
+		{3, "lobby (12/h), dev (3/h), quiet (0/h)"},
+		{10, "lobby (12/h), dev (3/h), quiet (0/h)"},
+		{0, "none yet"},
+		{-1, "none yet"},
+	}
+	for _, test := range tests {
+		if got := snapshot.TopRooms(test.n); got != test.want {
+			t.Errorf("TopRooms(%d) = %q, want %q", test.n, got, test.want)
+		}
+	}
+	if got := (motdSnapshot{}).TopRooms(5); got != "none yet" {
+		t.Errorf("TopRooms without rooms = %q, want %q", got, "none yet")
+	}
+}
+
+// writeMOTD writes text to a file in a temporary directory and loads it.
+func writeMOTD(t *testing.T, text string) *motd {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "motd.txt")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMOTD(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMOTDTemplate(t *testing.T) {
+	snapshot := motdSnapshot{UserCount: 3, Uptime: 90 * time.Second, ServerName: "alpha", rooms: []directoryEntry{{Name: "lobby", MessagesPerHour: 7}}}
+	tests := []struct {
+		name, file string
+		filled     bool
+		want       string
+	}{
+		{"plain text", "Be nice.\n\n\n", true, "Be nice.\n"},
+		{"fields", "{{.UserCount}} online on {{.ServerName}}, up {{.Uptime}}", true, "3 online on alpha, up 1m30s\n"},
+		{"method", "Busiest: {{.TopRooms 5}}", true, "Busiest: lobby (7/h)\n"},
+		{"built-ins", "{{if gt .UserCount 1}}{{.UserCount}} people{{end}}", true, "3 people\n"},
+		{"parse error", "{{.UserCount", false, "{{.UserCount\n"},
+		{"unknown field", "{{.Nobody}} here", false, "{{.Nobody}} here\n"},
+		{"unexported field", "{{.rooms}}", false, "{{.rooms}}\n"},
+		{"bad argument", "{{.TopRooms}}", false, "{{.TopRooms}}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := writeMOTD(t, test.file)
+			if (m.tmpl != nil) != test.filled {
+				t.Fatalf("template kept = %t, want %t", m.tmpl != nil, test.filled)
+			}
+			got := m.raw
+			if m.tmpl != nil {
+				var b strings.Builder
+				if err := m.tmpl.Execute(&b, snapshot); err != nil {
+					t.Fatal(err)
+				}
+				got = strings.TrimRight(b.String(), "\n") + "\n"
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadMOTDWithoutFile(t *testing.T) {
+	if m, err := loadMOTD(""); m != nil || err != nil {
+		t.Errorf("loadMOTD(\"\") = %v, %v; want nil, nil", m, err)
+	}
+	if _, err := loadMOTD(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadMOTD of a missing file succeeded")
+	}
+}
+
+func TestMOTDSentAfterWelcome(t *testing.T) {
+	m := writeMOTD(t, "Welcome to {{.ServerName}}: {{.TopRooms 0}}")
+	withSettings(t, func(s *settings) { s.motd = m })
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	c.expectLine("Welcome to " + serverName() + ": none yet")
+}
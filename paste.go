@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	DEFAULT_MAX_PASTE_SIZE = 16 * 1024
+	PASTE_INDENT           = "    "
+)
+
+func init() {
+	registerCommand("/paste", chatCommand{usage: "/paste [text]", help: `Send a multi-line message, writing line breaks as \n`, needsRoom: true, run: pasteCommand})
+}
+
+// pasteCommand sends a multi-line message that a client without framing
+// escaped into a single line.
+func pasteCommand(client *Client, room, args string) {
+	if err := checkNamed(client); err != nil {
+		client.sayError(err)
+		return
+	}
+	body, err := pasteBody(unescapePaste(args))
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	if body == "" {
+		client.say("paste.usage")
+		return
+	}
+	if err := client.allowChat(); err != nil {
+		client.sayError(err)
+		return
+	}
+	client.counters.messagesSent.Add(1)
+	broadcast <- userChat(client, room, body)
+}
+
+// pasteBody normalizes the line breaks of a chat message and checks it
+// against max-paste-size. A multi-line message is one message however many
+// lines it has.
+func pasteBody(text string) (string, error) {
+	text = cleanBody(strings.ReplaceAll(text, "\r\n", "\n"))
+	text = strings.TrimRight(text, "\n")
+	if limit := config().maxPasteSize; len(text) > limit {
+		return "", localErrorf("paste.too_long", limit)
+	}
+	return text, nil
+}
+
+// unescapePaste turns \n into a line break and \\ into a backslash.
+func unescapePaste(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			switch text[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// indentContinuation indents every line of body after the first, so a
+// multi-line message reads as one block under its chat line prefix.
+func indentContinuation(body string) string {
+	return strings.ReplaceAll(body, "\n", "\n"+PASTE_INDENT)
+}
+
+// cleanBody drops carriage returns and the other control characters, such
+// as terminal escapes, from user text, keeping line breaks and tabs. A
+// carriage return would let the rest of a line overwrite its chat line
+// prefix; line breaks are safe once indentContinuation has indented them.
+func cleanBody(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// oneLine is cleanBody for text shown inside a single line, such as a quit
+// message, with line breaks and tabs turned into spaces.
+func oneLine(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
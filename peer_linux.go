@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixPeer returns "unix:uid=N" for the user on the other end of conn,
+// read with SO_PEERCRED.
+func unixPeer(conn *net.UnixConn) string {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return UNIX_ANONYMOUS
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return UNIX_ANONYMOUS
+	}
+	return fmt.Sprintf("unix:uid=%d", cred.Uid)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// unixPeer cannot read peer credentials on this platform.
+func unixPeer(conn *net.UnixConn) string {
+	return UNIX_ANONYMOUS
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+)
+
+// Pings skip the room checks and are cheap to answer, but a client may
+// only send PING_LIMIT of them per PING_WINDOW.
+const (
+	PING_LIMIT  = 5
+	PING_WINDOW = 10 * time.Second
+)
+
+var errTooManyPings = localErrorf("ping.too_many")
+
+// allowPing counts a ping from the client and reports whether it is within
+// the limit. The caller must not hold mutex.
+func (c *Client) allowPing() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := time.Now()
+	if now.Sub(c.pingWindow) >= PING_WINDOW {
+		c.pingWindow = now
+		c.pings = 0
+	}
+	if c.pings >= PING_LIMIT {
+		c.counters.rateLimited.Add(1)
+		return errTooManyPings
+	}
+	c.pings++
+	return nil
+}
+
+// serverTime is the timestamp sent in a pong.
+func serverTime() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
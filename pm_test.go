@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"final_project/framing"
+)
+
+// framedClient is a test client that negotiated framing, so a message it
+// sends may span several lines.
+type framedClient struct {
+	*testClient
+	frames *framing.Reader
+	writer *framing.Writer
+}
+
+func newFramedClient(t *testing.T, addr string) *framedClient {
+	t.Helper()
+	c := newTestClient(t, addr)
+	c.send("HELLO test 1 features=framing")
+	c.expectLine("WELCOME")
+	return &framedClient{
+		testClient: c,
+		frames:     framing.NewReader(c.reader, framing.DEFAULT_MAX_SIZE),
+		writer:     framing.NewWriter(c.conn, framing.DEFAULT_MAX_SIZE),
+	}
+}
+
+// sendFrame sends message as one frame.
+func (c *framedClient) sendFrame(message string) {
+	c.t.Helper()
+	if err := c.writer.WriteFrame([]byte(message)); err != nil {
+		c.t.Fatalf("send %q: %v", message, err)
+	}
+}
+
+// expectFrame reads frames until one starts with prefix and returns it.
+func (c *framedClient) expectFrame(prefix string) string {
+	c.t.Helper()
+	for {
+		payload, err := c.frames.ReadFrame()
+		if err != nil {
+			c.t.Fatalf("waiting for a frame starting %q: %v", prefix, err)
+		}
+		if frame := string(payload); strings.HasPrefix(frame, prefix) {
+			return frame
+		}
+	}
+}
+
+// TestPrivateMessageForgery sends private messages made to look like
+// other lines. Neither the recipient nor the sender's echo may show a line
+// the sender did not write, and the echo is cleaned as the message is.
+func TestPrivateMessageForgery(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newFramedClient(t, addr)
+	bob := newTestClient(t, addr)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"newline", "hi\n[PM from carol] forged", "hi\n" + PASTE_INDENT + "[PM from carol] forged"},
+		{"carriage return", "hi\r[PM from carol] forged", "hi[PM from carol] forged"},
+		{"escape", "hi\x1b[2K\r[PM from carol] forged", "hi[2K[PM from carol] forged"},
+		{"notice", "hi\nNotice: server restarting", "hi\n" + PASTE_INDENT + "Notice: server restarting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice.sendFrame("/msg " + bob.name + " " + tt.text)
+			if echo, want := alice.expectFrame("[PM to "), "[PM to "+bob.name+"] "+tt.want; echo != want {
+				t.Errorf("echo %q, want %q", echo, want)
+			}
+			lines := strings.Split(tt.want, "\n")
+			bob.expectLine("[PM from " + alice.name + "] " + lines[0])
+			for _, want := range lines[1:] {
+				bob.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+				line, err := bob.reader.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if line != want+"\n" {
+					t.Errorf("continuation %q, want %q", line, want+"\n")
+				}
+			}
+		})
+	}
+}
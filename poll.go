@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	POLL_TIMEOUT     = 10 * time.Minute
+	MAX_POLL_OPTIONS = 10
+)
+
+// poll is the open poll of a room. Votes are kept by client so that a
+// member who leaves before the poll closes still counts, and so that
+// changing names does not allow voting twice.
+type poll struct {
+	room     string
+	owner    *Client
+	question string
+	options  []string
+	votes    map[*Client]int
+	timer    *time.Timer
+}
+
+// polls holds the open poll of each room. It is guarded by mutex.
+var polls = make(map[string]*poll)
+
+func init() {
+	registerCommand("/poll", chatCommand{usage: "/poll \"question\" option1 option2 ... | /poll close", help: "Start or close a poll in your room", needsRoom: true, run: startPoll})
+	registerCommand("/vote", chatCommand{usage: "/vote [number]", help: "Vote in your room's poll", needsRoom: true, run: vote})
+}
+
+func startPoll(client *Client, room, args string) {
+	if args == "close" {
+		if err := closePollBy(client, room); err != nil {
+			client.sayError(err)
+		}
+		return
+	}
+	words, err := splitQuoted(args)
+	if err != nil || len(words) < 3 {
+		client.say("poll.usage")
+		return
+	}
+	if len(words)-1 > MAX_POLL_OPTIONS {
+		client.say("poll.too_many", MAX_POLL_OPTIONS)
+		return
+	}
+	p := &poll{room: room, owner: client, question: words[0], options: words[1:], votes: make(map[*Client]int)}
+	mutex.Lock()
+	if polls[room] != nil {
+		mutex.Unlock()
+		client.say("poll.open")
+		return
+	}
+	polls[room] = p
+	p.timer = time.AfterFunc(POLL_TIMEOUT, func() { closePoll(p) })
+	mutex.Unlock()
+
+	choices := make([]string, len(p.options))
+	for i, option := range p.options {
+		choices[i] = fmt.Sprintf("%d) %s", i+1, option)
+	}
+	broadcast <- funNotice(room, client.username, "poll.started", client.username, p.question, strings.Join(choices, " "))
+}
+
+func vote(client *Client, room, args string) {
+	n, err := strconv.Atoi(args)
+	mutex.Lock()
+	p := polls[room]
+	if p == nil {
+		mutex.Unlock()
+		client.say("poll.none")
+		return
+	}
+	if err != nil || n < 1 || n > len(p.options) {
+		mutex.Unlock()
+		client.say("vote.usage", len(p.options))
+		return
+	}
+	p.votes[client] = n - 1
+	mutex.Unlock()
+	client.say("vote.done", n, p.options[n-1])
+}
+
+// closePollBy closes the poll of room on behalf of its owner.
+func closePollBy(client *Client, room string) error {
+	mutex.Lock()
+	p := polls[room]
+	mutex.Unlock()
+	if p == nil {
+		return localErrorf("poll.none")
+	}
+	if p.owner != client {
+		return localErrorf("poll.owner_only")
+	}
+	closePoll(p)
+	return nil
+}
+
+// closePoll announces the tallies of p unless it was already closed.
+func closePoll(p *poll) {
+	mutex.Lock()
+	if polls[p.room] != p {
+		mutex.Unlock()
+		return
+	}
+	delete(polls, p.room)
+	p.timer.Stop()
+	counts := make([]int, len(p.options))
+	for _, choice := range p.votes {
+		counts[choice]++
+	}
+	owner := p.owner.username
+	mutex.Unlock()
+
+	tallies := make([]string, len(p.options))
+	for i, option := range p.options {
+		tallies[i] = fmt.Sprintf("%d) %s: %d", i+1, option, counts[i])
+	}
+	broadcast <- funNotice(p.room, owner, "poll.closed", p.question, strings.Join(tallies, ", "))
+}
+
+// dropPoll discards the poll of a room that emptied. The caller must hold
+// mutex.
+func dropPoll(room string) {
+	if p := polls[room]; p != nil {
+		p.timer.Stop()
+		delete(polls, room)
+	}
+}
+
+// splitQuoted splits s into words, keeping text in double quotes together.
+func splitQuoted(s string) ([]string, error) {
+	var words []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return words, nil
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			words = append(words, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		words = append(words, s[:end])
+		s = s[end:]
+	}
+}
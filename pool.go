@@ -0,0 +1,69 @@
+package main
+
+import "sync"
+
+// Rooms larger than BROADCAST_CHUNK members are delivered to in chunks of
+// that size by the broadcast workers.
+const BROADCAST_CHUNK = 256
+
+var (
+	broadcastWorkers = 1
+	broadcastJobs    = make(chan func())
+)
+
+func startBroadcastWorkers(n int) {
+	broadcastWorkers = n
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range broadcastJobs {
+				job()
+			}
+		}()
+	}
+}
+
+// deliverAll queues message for every client in members, splitting large
+// rooms across the broadcast workers. It returns once every member has the
+// message queued, so messages stay in order per client. It reports the
+// number of members other than the sender that were reached and the
+// clients that failed. The caller must hold mutex; each client is only
+// touched by one worker.
+func deliverAll(members []*Client, message Message) (int, []*Client) {
+	if len(members) <= BROADCAST_CHUNK || broadcastWorkers < 2 {
+		return deliverChunk(members, message)
+	}
+	type result struct {
+		recipients int
+		failed     []*Client
+	}
+	results := make([]result, (len(members)+BROADCAST_CHUNK-1)/BROADCAST_CHUNK)
+	var wg sync.WaitGroup
+	for i := range results {
+		chunk := members[i*BROADCAST_CHUNK : min((i+1)*BROADCAST_CHUNK, len(members))]
+		wg.Add(1)
+		broadcastJobs <- func() {
+			defer wg.Done()
+			results[i].recipients, results[i].failed = deliverChunk(chunk, message)
+		}
+	}
+	wg.Wait()
+	recipients, failed := 0, []*Client{}
+	for _, r := range results {
+		recipients += r.recipients
+		failed = append(failed, r.failed...)
+	}
+	return recipients, failed
+}
+
+func deliverChunk(members []*Client, message Message) (int, []*Client) {
+	recipients := 0
+	var failed []*Client
+	for _, client := range members {
+		if err := client.deliver(message); err != nil {
+			failed = append(failed, client)
+		} else if client != message.sender {
+			recipients++
+		}
+	}
+	return recipients, failed
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// BenchmarkBroadcast measures queueing one chat message for every member
+// of a room, as handleBroadcast does, with the members on sinkConns.
+func BenchmarkBroadcast(b *testing.B) {
+	withSettings(b, func(s *settings) { s.slowGrace = time.Hour })
+	for _, n := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("members=%d", n), func(b *testing.B) {
+			room := uniqueName("bench")
+			members := simulatedClients(b, n, room, "", nil)
+			message := chatMessage(room, "bench", "hello")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				mutex.Lock()
+				_, failed := deliverAll(members, message)
+				mutex.Unlock()
+				if len(failed) > 0 {
+					b.Fatalf("%d members failed", len(failed))
+				}
+			}
+		})
+	}
+}
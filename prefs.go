@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("/prefs", chatCommand{usage: "/prefs [set key value]", help: "Show or change your preferences", run: prefsCommand})
+}
+
+// prefKeys are the preferences /prefs shows and sets. They last as long
+// as the connection.
+var prefKeys = []struct {
+	name string
+	set  func(c *Client, value string) error
+	get  func(c *Client) string
+}{
+	{"echo", setEcho, func(c *Client) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if c.features["no-self-echo"] {
+			return "off"
+		}
+		return "on"
+	}},
+	{"language", func(c *Client, v string) error {
+		if catalogs[v] == nil {
+			return localErrorf("lang.unknown", v, strings.Join(languages(), ", "))
+		}
+		c.lang.Store(v)
+		return nil
+	}, (*Client).language},
+	{"timezone", func(c *Client, v string) error {
+		if v == "default" {
+			c.location.Store(nil)
+			return nil
+		}
+		loc, err := time.LoadLocation(v)
+		if err != nil || v == "" || v == "Local" {
+			return localErrorf("prefs.bad_timezone", v)
+		}
+		c.location.Store(loc)
+		return nil
+	}, func(c *Client) string {
+		if loc := c.location.Load(); loc != nil {
+			return loc.String()
+		}
+		return "default"
+	}},
+	{"newlines", func(c *Client, v string) error {
+		conn, ok := c.conn.(*wireConn)
+		if !ok || (v != "crlf" && v != "lf") {
+			return localErrorf("prefs.bad_newlines", v)
+		}
+		conn.setCRLF(v == "crlf")
+		return nil
+	}, func(c *Client) string {
+		if conn, ok := c.conn.(*wireConn); ok && conn.crlf.Load() {
+			return "crlf"
+		}
+		return "lf"
+	}},
+}
+
+func prefsCommand(client *Client, room, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		lines := []string{client.tr("prefs.list")}
+		for _, pref := range prefKeys {
+			lines = append(lines, "  "+pref.name+" = "+pref.get(client))
+		}
+		client.conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+		return
+	}
+	if len(fields) != 3 || fields[0] != "set" {
+		client.say("prefs.usage")
+		return
+	}
+	for _, pref := range prefKeys {
+		if pref.name == fields[1] {
+			if err := pref.set(client, fields[2]); err != nil {
+				client.sayError(err)
+				return
+			}
+			client.say("prefs.set", pref.name, pref.get(client))
+			return
+		}
+	}
+	names := make([]string, len(prefKeys))
+	for i, pref := range prefKeys {
+		names[i] = pref.name
+	}
+	client.say("prefs.unknown", fields[1], strings.Join(names, ", "))
+}
+
+// timeZone returns the time zone chat times are shown in to c.
+func (c *Client) timeZone() *time.Location {
+	if loc := c.location.Load(); loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// setEcho turns the no-self-echo feature off for "on" and on for "off".
+func setEcho(c *Client, value string) error {
+	if value != "on" && value != "off" {
+		return localErrorf("prefs.bad_echo")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if c.features == nil {
+		c.features = make(map[string]bool)
+		for _, feature := range defaultFeatures() {
+			c.features[feature] = true
+		}
+	}
+	c.features["no-self-echo"] = value == "off"
+	return nil
+}
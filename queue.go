@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_QUEUE_DEPTH = 256
+	DEFAULT_SLOW_GRACE  = 10 * time.Second
+	EVICT_WRITE_TIMEOUT = 2 * time.Second
+)
+
+// Traffic classes for enqueue, from least to most important. Presence is
+// dropped once the queue is three quarters full, notices are coalesced when
+// it is full, and chat that cannot be queued for longer than slow-grace gets
+// the client disconnected.
+const (
+	QUEUE_PRESENCE = iota
+	QUEUE_NOTICE
+	QUEUE_CHAT
+)
+
+var (
+	queueDepth = DEFAULT_QUEUE_DEPTH
+
+	// Totals for /stats.
+	droppedMessages atomic.Int64
+	slowDisconnects atomic.Int64
+)
+
+var errSlowConsumer = errors.New("client is reading too slowly")
+
+// sendQueue buffers outgoing lines for a client so one slow reader cannot
+// hold up the broadcast loop. Its fields other than lines are guarded by
+// mutex.
+type sendQueue struct {
+	lines          chan []byte
+	evicted        chan struct{}
+	stopped        chan struct{}
+	stopOnce       sync.Once
+	fullSince      time.Time
+	dropped        int
+	skippedNotices int
+	goodbye        []byte
+	// heldJoins are the join notices put off while the queue was three
+	// quarters full, by room and username; see enqueueMessage.
+	heldJoins map[heldJoin][]byte
+}
+
+type heldJoin struct {
+	room, username string
+}
+
+// newClient creates a client for conn and starts its writer.
+func newClient(conn net.Conn) *Client {
+	client := &Client{username: ANONYMOUS_NAME, address: clientAddress(conn), connected: time.Now(), queue: &sendQueue{
+		lines:   make(chan []byte, queueDepth),
+		evicted: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}}
+	client.conn = &wireConn{Conn: conn, counters: &client.counters}
+	client.lastActive.Store(client.connected.UnixNano())
+	go client.writeLoop()
+	return client
+}
+
+// writeLoop writes queued lines until the client is stopped or evicted.
+// An evicted client is sent a last line and disconnected.
+func (c *Client) writeLoop() {
+	q := c.queue
+	for {
+		select {
+		case <-q.evicted:
+			c.conn.Write(q.goodbye)
+			c.conn.Close()
+			return
+		case <-q.stopped:
+			return
+		case line := <-q.lines:
+			select {
+			case <-q.evicted:
+				continue
+			default:
+			}
+			if _, err := c.conn.Write(line); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// stop ends the writer once the connection is done with.
+func (c *Client) stop() {
+	c.queue.stopOnce.Do(func() { close(c.queue.stopped) })
+}
+
+// enqueue queues line for the client following the overflow policy of its
+// traffic class. It returns errSlowConsumer once the client was evicted.
+// The caller must hold mutex.
+func (c *Client) enqueue(line []byte, class int) error {
+	q := c.queue
+	select {
+	case <-q.evicted:
+		return errSlowConsumer
+	default:
+	}
+	if class == QUEUE_PRESENCE && q.presenceFull() {
+		c.drop()
+		return nil
+	}
+	if q.skippedNotices > 0 && len(q.lines) < cap(q.lines)-1 {
+		q.lines <- c.render(localMessage(c.room, MESSAGE_NOTICE, "queue.skipped", c.room, q.skippedNotices))
+		q.skippedNotices = 0
+	}
+	queued := len(q.lines) + 1
+	select {
+	case q.lines <- line:
+		q.fullSince = time.Time{}
+		c.counters.noteQueueLength(queued)
+		return nil
+	default:
+	}
+	return c.overflow(class)
+}
+
+// overflow drops a line of class that found the queue full. The caller
+// must hold mutex.
+func (c *Client) overflow(class int) error {
+	q := c.queue
+	c.drop()
+	switch {
+	case class == QUEUE_NOTICE:
+		q.skippedNotices++
+	case q.fullSince.IsZero():
+		q.fullSince = time.Now()
+	case time.Since(q.fullSince) >= config().slowGrace:
+		c.evict()
+		return errSlowConsumer
+	}
+	return nil
+}
+
+// enqueueMessage queues line, rendered from message, so that the client
+// never sees anything a user sends to a room before that user's join
+// notice. A join notice that would be dropped as presence is held instead
+// and queued as chat ahead of the user's next message to the room; if the
+// user leaves first, neither notice is shown. A queue of one line has no
+// room to hold joins and drops them. The caller must hold mutex.
+func (c *Client) enqueueMessage(message Message, line []byte) error {
+	q := c.queue
+	key := heldJoin{message.room, message.from}
+	if message.from == "" {
+		return c.enqueue(line, queueClass(message))
+	}
+	switch held, ok := q.heldJoins[key]; {
+	case message.kind == MESSAGE_JOIN && q.presenceFull() && cap(q.lines) > 1:
+		if q.heldJoins == nil {
+			q.heldJoins = make(map[heldJoin][]byte)
+		}
+		q.heldJoins[key] = line
+		return nil
+	case !ok:
+	case message.kind == MESSAGE_LEAVE:
+		delete(q.heldJoins, key)
+		c.drop()
+		c.drop()
+		return nil
+	case message.kind == MESSAGE_NICK:
+		delete(q.heldJoins, key)
+		q.heldJoins[heldJoin{message.room, message.body}] = held
+	case message.kind != MESSAGE_JOIN:
+		if len(q.lines) > cap(q.lines)-2 {
+			// No room for both; the join waits for the next message.
+			return c.overflow(QUEUE_CHAT)
+		}
+		delete(q.heldJoins, key)
+		if err := c.enqueue(held, QUEUE_CHAT); err != nil {
+			return err
+		}
+	}
+	return c.enqueue(line, queueClass(message))
+}
+
+// presenceFull reports whether presence lines are being dropped. The
+// caller must hold mutex.
+func (q *sendQueue) presenceFull() bool {
+	return len(q.lines) >= cap(q.lines)*3/4
+}
+
+func (c *Client) drop() {
+	c.queue.dropped++
+	droppedMessages.Add(1)
+}
+
+// evict disconnects a client whose queue stayed full for slow-grace. The
+// write deadline unblocks a writer stuck on the full connection, so the
+// goodbye line only arrives if the connection drains in time.
+func (c *Client) evict() {
+	q := c.queue
+	slowDisconnects.Add(1)
+	log.Printf("Disconnecting slow client %v (%s): queue full for %v, %d messages dropped", c.address, c.username, time.Since(q.fullSince).Round(time.Second), q.dropped)
+	q.goodbye = c.render(localMessage("", MESSAGE_NOTICE, "queue.too_slow"))
+	c.conn.SetWriteDeadline(time.Now().Add(EVICT_WRITE_TIMEOUT))
+	close(q.evicted)
+}
+
+// queueClass returns the traffic class of message.
+func queueClass(message Message) int {
+	switch message.kind {
+	case MESSAGE_JOIN, MESSAGE_LEAVE, MESSAGE_NICK:
+		return QUEUE_PRESENCE
+	case MESSAGE_NOTICE:
+		return QUEUE_NOTICE
+	}
+	return QUEUE_CHAT
+}
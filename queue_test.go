@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	ORDER_JOINERS   = 100
+	ORDER_OBSERVERS = 5
+	// ORDER_QUEUE_DEPTH is small so that presence lines are dropped and
+	// join notices held back during the burst.
+	ORDER_QUEUE_DEPTH = 16
+)
+
+// TestJoinNoticeBeforeMessages has many clients join a room and chat at
+// once. No member may see a user's message before that user's join
+// notice, and no joining client a room message before its join reply.
+func TestJoinNoticeBeforeMessages(t *testing.T) {
+	withSettings(t, func(s *settings) { s.slowGrace = time.Minute })
+	previous := queueDepth
+	queueDepth = ORDER_QUEUE_DEPTH
+	t.Cleanup(func() { queueDepth = previous })
+	addr := newTestServer(t)
+
+	room := uniqueName("order")
+	owner := newTestClient(t, addr)
+	owner.send("/create " + room)
+	owner.expectLine("Created and joined room " + room)
+	var observers []*testClient
+	for range ORDER_OBSERVERS {
+		c := newTestClient(t, addr)
+		c.send("/join " + room)
+		c.expectLine("Joined room " + room)
+		observers = append(observers, c)
+	}
+
+	// The observers read alongside the joiners; as parallel subtests they
+	// could take every slot and leave the joiners waiting.
+	prefix := "[" + room + "] "
+	results := make(chan error, len(observers))
+	for _, observer := range observers {
+		go func() { results <- checkJoinOrder(observer, prefix) }()
+	}
+	t.Run("group", func(t *testing.T) {
+		for i := range ORDER_JOINERS {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				t.Parallel()
+				c := newTestClient(t, addr)
+				c.send("/join " + room + "\nhello from " + c.name)
+				c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+				for {
+					line, err := c.reader.ReadString('\n')
+					if err != nil {
+						t.Fatalf("waiting for the join reply: %v", err)
+					}
+					if strings.HasPrefix(line, "Joined room "+room) {
+						break
+					}
+					if strings.HasPrefix(line, prefix) {
+						t.Fatalf("got %q before the join reply", line)
+					}
+				}
+				c.expectLine(c.name + ": hello from " + c.name)
+			})
+		}
+	})
+	for range observers {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// checkJoinOrder reads room messages until observer has seen every
+// joiner's greeting, returning an error if one came before the joiner's
+// notice.
+func checkJoinOrder(observer *testClient, prefix string) error {
+	joined := make(map[string]bool)
+	observer.conn.SetReadDeadline(time.Now().Add(4 * LINE_TIMEOUT))
+	for greetings := 0; greetings < ORDER_JOINERS; {
+		line, err := observer.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("%s after %d greetings: %v", observer.name, greetings, err)
+		}
+		rest, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), prefix)
+		if !ok {
+			continue
+		}
+		if notice, ok := strings.CutPrefix(rest, `Notice: "`); ok {
+			if name, _, ok := strings.Cut(notice, `" joined`); ok {
+				joined[name] = true
+			}
+			continue
+		}
+		_, chat, ok := strings.Cut(rest, " - ")
+		if !ok {
+			continue
+		}
+		name, text, _ := strings.Cut(chat, ": ")
+		if !strings.HasPrefix(text, "hello from ") {
+			continue
+		}
+		greetings++
+		if !joined[name] {
+			return fmt.Errorf("%s saw %q before %s's join notice", observer.name, line, name)
+		}
+	}
+	return nil
+}
+
+const (
+	STALL_QUEUE_DEPTH = 8
+	STALL_GRACE       = 300 * time.Millisecond
+	STALL_MESSAGES    = 40
+	// STALL_MAX_LATENCY is the longest a message may take to reach a
+	// reading member while another member has stopped reading.
+	STALL_MAX_LATENCY = 500 * time.Millisecond
+)
+
+// TestStalledReaderDoesNotDelayRoom has a member stop reading from a
+// connection that buffers nothing. The others must get every message on
+// time, and the stalled member must be disconnected once its queue has
+// been full for slow-grace.
+func TestStalledReaderDoesNotDelayRoom(t *testing.T) {
+	withSettings(t, func(s *settings) { s.slowGrace = STALL_GRACE })
+	previous := queueDepth
+	queueDepth = STALL_QUEUE_DEPTH
+	t.Cleanup(func() { queueDepth = previous })
+	addr := newTestServer(t)
+
+	room := uniqueName("stall")
+	alice := newTestClient(t, addr)
+	alice.send("/create " + room)
+	alice.expectLine("Created and joined room " + room)
+	bob := newTestClient(t, addr)
+	bob.send("/join " + room)
+	bob.expectLine("Joined room " + room)
+	stalled := stalledMember(t, room)
+	alice.expectLine(stalled + `" joined`)
+
+	for i := range STALL_MESSAGES {
+		sent := time.Now()
+		alice.send(fmt.Sprintf("message %d", i))
+		bob.expectLine(fmt.Sprintf(": message %d", i))
+		if latency := time.Since(sent); latency > STALL_MAX_LATENCY {
+			t.Errorf("message %d took %v to arrive", i, latency)
+		}
+		time.Sleep(STALL_GRACE / 10)
+	}
+	waitFor(t, stalled+" to be disconnected", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return !slices.Contains(roomMembers(room), stalled)
+	})
+}
+
+// stalledMember runs handleConnection over an in-memory pipe, joins room
+// and then stops reading, returning the member's name. Once it stops, the
+// server can write nothing more to it.
+func stalledMember(t *testing.T, room string) string {
+	t.Helper()
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go handleConnection(server)
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(LINE_TIMEOUT))
+	var name string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stalled member: %v", err)
+		}
+		if welcome, ok := strings.CutPrefix(line, "Welcome! You are "); ok {
+			name, _, _ = strings.Cut(welcome, ".")
+			if _, err := conn.Write([]byte("/join " + room + "\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if strings.HasPrefix(line, "Joined room "+room) {
+			return name
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"a\nb\n", []string{"a", "b"}},
+		{"a\r\nb\r\n", []string{"a", "b"}},
+		{"a\r\r\n", []string{"a"}},
+		{"a\rb\r\n", []string{"a\rb"}},
+		{"\r\n\n", []string{"", ""}},
+		{"last", []string{"last"}},
+		{strings.Repeat("x", MAX_LINE_LENGTH+10) + "\r\nnext\r\n", []string{strings.Repeat("x", MAX_LINE_LENGTH), "next"}},
+	}
+	for _, tt := range tests {
+		reader := bufio.NewReaderSize(strings.NewReader(tt.input), 16)
+		var got []string
+		for {
+			line, err := readLine(reader)
+			if err != nil {
+				break
+			}
+			got = append(got, line)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("readLine(%q) gave %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestCommandsWithCRLF sends each command ending in "\r\n", as telnet and
+// Windows clients do. No reply may carry the "\r" in an argument.
+func TestCommandsWithCRLF(t *testing.T) {
+	addr := newTestServer(t)
+	c := newTestClient(t, addr)
+	bob := newTestClient(t, addr)
+	name, room, other := uniqueName("crlf"), uniqueName("crlf"), uniqueName("crlf")
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"/nick " + name, "Username set to " + name},
+		{"/create " + room, "Created and joined room " + room},
+		{"hello", name + ": hello"},
+		{"/who", "Users in " + room + ": " + name},
+		{"/create " + other, "Created and joined room " + other},
+		{"/join " + room, "Joined room " + room},
+		{"/msg " + bob.name + " hi", "[PM to " + bob.name + "] hi"},
+		{"/away lunch", "You are marked away: lunch"},
+		{"/quit bye", "Goodbye!"},
+	}
+	for _, tt := range tests {
+		if _, err := c.conn.Write([]byte(tt.line + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if line := expectRawLine(c, tt.want); !strings.HasSuffix(line, tt.want) {
+			t.Errorf("%q: got %q, want it to end in %q", tt.line, line, tt.want)
+		}
+	}
+	if line := expectRawLine(bob, "[PM from "); line != "[PM from "+name+"] hi" {
+		t.Errorf("bob got %q", line)
+	}
+	c.expectClosed()
+}
+
+// expectRawLine reads lines until one contains want and returns it
+// without its line ending, failing the test if a "\r" is left in it.
+func expectRawLine(c *testClient, want string) string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v", want, err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if strings.Contains(line, want) {
+			if strings.Contains(line, "\r") {
+				c.t.Errorf("%q has a carriage return", line)
+			}
+			return line
+		}
+	}
+}
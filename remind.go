@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_REMINDERS       = 10
+	MAX_REMIND_DURATION = 7 * 24 * time.Hour
+)
+
+// reminder is a note sent back to a client once its timer fires. A
+// client's reminders are guarded by mutex and end with its connection.
+type reminder struct {
+	due   time.Time
+	text  string
+	timer *time.Timer
+}
+
+func init() {
+	registerCommand("/remind", chatCommand{usage: "/remind [duration] [message]", help: "Remind yourself after a duration such as 15m or 1d", run: remind})
+	registerCommand("/reminders", chatCommand{usage: "/reminders [cancel number]", help: "List or cancel your pending reminders", run: listReminders})
+}
+
+func remind(client *Client, room, args string) {
+	spec, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	d, err := parseRemindDuration(spec)
+	if err != nil || text == "" {
+		client.say("remind.usage")
+		return
+	}
+	if d <= 0 || d > MAX_REMIND_DURATION {
+		client.say("remind.too_far", int(MAX_REMIND_DURATION/(24*time.Hour)))
+		return
+	}
+	r := &reminder{due: time.Now().Add(d), text: text}
+	mutex.Lock()
+	if len(client.reminders) >= MAX_REMINDERS {
+		mutex.Unlock()
+		client.say("remind.too_many", MAX_REMINDERS)
+		return
+	}
+	client.reminders = append(client.reminders, r)
+	r.timer = time.AfterFunc(d, func() { fireReminder(client, r) })
+	mutex.Unlock()
+	client.say("remind.set", d)
+}
+
+// parseRemindDuration parses a time.ParseDuration string that may start
+// with a number of days, such as 1d or 2d12h.
+func parseRemindDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if n, rest, ok := strings.Cut(s, "d"); ok {
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, err
+		}
+		days, s = time.Duration(count)*24*time.Hour, rest
+		if s == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	return days + d, err
+}
+
+func fireReminder(client *Client, r *reminder) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !removeReminder(client, r) {
+		return
+	}
+	client.deliver(localMessage("", MESSAGE_NOTICE, "remind.due", r.text))
+}
+
+func listReminders(client *Client, room, args string) {
+	if n, ok := strings.CutPrefix(args, "cancel"); ok {
+		cancelReminder(client, strings.TrimSpace(n))
+		return
+	}
+	mutex.Lock()
+	var b strings.Builder
+	for i, r := range client.reminders {
+		b.WriteString(client.tr("reminders.entry", i+1, time.Until(r.due).Round(time.Second), r.text) + "\n")
+	}
+	mutex.Unlock()
+	if b.Len() == 0 {
+		client.say("reminders.none")
+		return
+	}
+	client.conn.Write([]byte(b.String()))
+}
+
+func cancelReminder(client *Client, index string) {
+	n, err := strconv.Atoi(index)
+	mutex.Lock()
+	if err != nil || n < 1 || n > len(client.reminders) {
+		mutex.Unlock()
+		client.say("reminders.cancel_usage")
+		return
+	}
+	r := client.reminders[n-1]
+	r.timer.Stop()
+	removeReminder(client, r)
+	mutex.Unlock()
+	client.say("reminders.cancelled", r.text)
+}
+
+// removeReminder reports whether r was still pending for client. The caller
+// must hold mutex.
+func removeReminder(client *Client, r *reminder) bool {
+	for i, pending := range client.reminders {
+		if pending == r {
+			client.reminders = append(client.reminders[:i], client.reminders[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// cancelReminders stops the pending reminders of client. The caller must
+// hold mutex.
+func cancelReminders(client *Client) {
+	for _, r := range client.reminders {
+		r.timer.Stop()
+	}
+	client.reminders = nil
+}
+
+// stopReminders stops every pending reminder on shutdown.
+func stopReminders() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, client := range clients {
+		cancelReminders(client)
+	}
+}
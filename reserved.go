@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"maps"
+	"os"
+	"strings"
+)
+
+// defaultReservedNames are names nobody can take, compared without regard
+// to case. The reserved-names file adds to them.
+var defaultReservedNames = map[string]bool{
+	"admin":     true,
+	"server":    true,
+	"moderator": true,
+	"system":    true,
+}
+
+// loadReservedNames returns the default reserved names along with those
+// in path, one per line. Blank lines and lines starting with # are
+// skipped. An empty path adds nothing.
+func loadReservedNames(path string) (map[string]bool, error) {
+	names := maps.Clone(defaultReservedNames)
+	if path == "" {
+		return names, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names, scanner.Err()
+}
+
+func isReserved(name string) bool {
+	return config().reserved[strings.ToLower(name)]
+}
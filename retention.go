@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RETENTION_SWEEP_INTERVAL = time.Minute
+
+// archiveDir is where the admin /archive command writes.
+var archiveDir = "archives"
+
+func init() {
+	registerCommand("/retention", chatCommand{usage: "/retention [max-age|default] [max-count|default]", help: "Show or tighten how long your room's history is kept (room owner)", needsRoom: true, run: retentionCommand})
+}
+
+// roomRetention returns the max age and count of history kept for room.
+// The server's retention-max-age and retention-max-count are the limits,
+// which room owners may tighten but not loosen; a max age of 0 keeps
+// events until the count pushes them out. The caller must hold mutex.
+func roomRetention(room string) (time.Duration, int) {
+	age, count := config().retentionMaxAge, config().retentionMaxCount
+	if meta := roomMetas[room]; meta != nil {
+		if meta.maxAge > 0 {
+			age = meta.maxAge
+		}
+		if meta.maxCount > 0 {
+			count = meta.maxCount
+		}
+	}
+	return age, count
+}
+
+func retentionCommand(client *Client, room, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		mutex.Lock()
+		age, count := roomRetention(room)
+		mutex.Unlock()
+		sayRetention(client, room, age, count)
+		return
+	}
+	if len(fields) > 2 {
+		client.say("retention.usage")
+		return
+	}
+	age, err := parseRetentionAge(fields[0])
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	count := -1
+	if len(fields) == 2 {
+		if count, err = parseRetentionCount(fields[1]); err != nil {
+			client.sayError(err)
+			return
+		}
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("retention.owner_only")
+		return
+	}
+	meta.maxAge = age
+	if count >= 0 {
+		meta.maxCount = count
+	}
+	age, count = roomRetention(room)
+	pruneHistory(room, time.Now())
+	mutex.Unlock()
+	sayRetention(client, room, age, count)
+}
+
+func sayRetention(client *Client, room string, age time.Duration, count int) {
+	if age == 0 {
+		client.say("retention.no_age", room, count)
+		return
+	}
+	client.say("retention.show", room, age, count)
+}
+
+// parseRetentionAge parses a room's max age, which may not exceed the
+// server's. "default" returns 0, meaning the server's limit.
+func parseRetentionAge(s string) (time.Duration, error) {
+	if s == "default" {
+		return 0, nil
+	}
+	age, err := parseRemindDuration(s)
+	if err != nil || age <= 0 {
+		return 0, localErrorf("retention.bad_age", s)
+	}
+	if limit := config().retentionMaxAge; limit > 0 && age > limit {
+		return 0, localErrorf("retention.age_limit", limit)
+	}
+	return age, nil
+}
+
+// parseRetentionCount parses a room's max count, which may not exceed the
+// server's. "default" returns 0, meaning the server's limit.
+func parseRetentionCount(s string) (int, error) {
+	if s == "default" {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(s)
+	if err != nil || count < 1 {
+		return 0, localErrorf("retention.bad_count", s)
+	}
+	if limit := config().retentionMaxCount; count > limit {
+		return 0, localErrorf("retention.count_limit", limit)
+	}
+	return count, nil
+}
+
+// pruneHistory drops the events of room that are older or more than its
+// retention allows, returning how many were dropped. The caller must hold
+// mutex.
+func pruneHistory(room string, now time.Time) int {
+	log := histories[room]
+	if log == nil {
+		return 0
+	}
+	age, count := roomRetention(room)
+	drop := max(len(log.events)-count, 0)
+	if age > 0 {
+		for drop < len(log.events) && now.Sub(log.events[drop].time) > age {
+			drop++
+		}
+	}
+	log.events = log.events[drop:]
+	return drop
+}
+
+// runRetentionSweeper prunes room histories every RETENTION_SWEEP_INTERVAL.
+// It takes mutex for one room at a time so chat is not held up.
+func runRetentionSweeper() {
+	ticker := time.NewTicker(RETENTION_SWEEP_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		mutex.Lock()
+		names := make([]string, 0, len(histories))
+		for room := range histories {
+			names = append(names, room)
+		}
+		mutex.Unlock()
+		pruned, prunedRooms := 0, 0
+		for _, room := range names {
+			mutex.Lock()
+			n := pruneHistory(room, time.Now())
+			mutex.Unlock()
+			if n > 0 {
+				pruned += n
+				prunedRooms++
+			}
+		}
+		if pruned > 0 {
+			log.Printf("Retention: pruned %d messages from %d rooms", pruned, prunedRooms)
+		}
+	}
+}
+
+// archiveRoom writes the kept history of room to a JSON lines file in
+// archiveDir and then purges it. Events recorded while the file is written
+// are kept.
+func archiveRoom(room string) (string, int, error) {
+	mutex.Lock()
+	var events []Message
+	if log := histories[room]; log != nil {
+		events = append(events, log.events...)
+	}
+	mutex.Unlock()
+	if len(events) == 0 {
+		return "", 0, fmt.Errorf("Room %s has no history to archive.", room)
+	}
+
+	var data []byte
+	for _, message := range events {
+		data = append(data, jsonLine(toJSONEvent(message))...)
+	}
+	if err := os.MkdirAll(archiveDir, 0700); err != nil {
+		return "", 0, err
+	}
+	name := fmt.Sprintf("%s-%s.jsonl", room, time.Now().Format("20060102-150405"))
+	if filepath.Base(name) != name {
+		return "", 0, errors.New("Invalid room name.")
+	}
+	path := filepath.Join(archiveDir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", 0, err
+	}
+
+	last := events[len(events)-1].seq
+	mutex.Lock()
+	if log := histories[room]; log != nil {
+		for len(log.events) > 0 && log.events[0].seq <= last {
+			log.events = log.events[1:]
+		}
+	}
+	mutex.Unlock()
+	return path, len(events), nil
+}
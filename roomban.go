@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// roomMeta is what the server knows about a room besides its members.
+// It is guarded by mutex.
+type roomMeta struct {
+	// owner is the client that created the room, nil once it has
+	// disconnected.
+	owner *Client
+	// bans maps the host of a banned client's address to the ban.
+	bans map[string]roomBan
+	// With whitelist on, only the owner and the allowed usernames may
+	// join. Turning it off keeps the list.
+	whitelist bool
+	allowed   map[string]bool
+	// invitedBy maps allowed usernames to who allowed them in, for the
+	// notice the owner gets when they join.
+	invitedBy map[string]string
+	// maxAge and maxCount tighten the server's history retention for the
+	// room; zero means the server's limit.
+	maxAge   time.Duration
+	maxCount int
+	// activity counts the room's recent chat for /list.
+	activity roomActivity
+	// greeting is sent to everyone who joins the room.
+	greeting string
+	// tags are set by the owner with /settags and filter /list.
+	tags []string
+	// historyVisibility is set by the owner with /sethistory; "" means
+	// HISTORY_ALL.
+	historyVisibility string
+	// rate counts the room's chat for room-rate; slowSince is when the
+	// room went into slow mode, zero when it is not in it. An exempt room
+	// never does.
+	rate       roomRate
+	slowSince  time.Time
+	rateExempt bool
+}
+
+type roomBan struct {
+	username string
+	reason   string
+}
+
+var roomMetas = make(map[string]*roomMeta)
+
+func init() {
+	registerCommand("/rban", chatCommand{usage: "/rban [username] [reason]", help: "Ban a user from your room (room owner)", needsRoom: true, run: roomBanCommand})
+	registerCommand("/runban", chatCommand{usage: "/runban [username]", help: "Lift a ban from your room (room owner)", needsRoom: true, run: roomUnbanCommand})
+	registerCommand("/rbans", chatCommand{usage: "/rbans", help: "List the bans of your room", needsRoom: true, run: roomBansCommand})
+}
+
+// metaFor returns the metadata of room, creating it if needed. The caller
+// must hold mutex.
+func metaFor(room string) *roomMeta {
+	meta := roomMetas[room]
+	if meta == nil {
+		meta = &roomMeta{bans: make(map[string]roomBan), allowed: make(map[string]bool), invitedBy: make(map[string]string)}
+		roomMetas[room] = meta
+	}
+	return meta
+}
+
+// addressHost is the part of a client address room bans apply to: the IP
+// for network clients and the whole address for Unix sockets.
+func addressHost(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		// unix:uid=1000 would split into a host of "unix".
+		return addr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// roomBanError returns why client may not join room, if it is banned
+// there. The caller must hold mutex.
+func roomBanError(client *Client, room string) error {
+	meta := roomMetas[room]
+	if meta == nil || meta.owner == client || client.address == UNIX_ANONYMOUS {
+		return nil
+	}
+	if ban, ok := meta.bans[addressHost(client.address)]; ok {
+		return localErrorf("rban.refused", room, ban.reason)
+	}
+	return nil
+}
+
+func roomBanCommand(client *Client, room, args string) {
+	name, reason, _ := strings.Cut(args, " ")
+	if name == "" {
+		client.say("rban.usage")
+		return
+	}
+	mutex.Lock()
+	isOwner := metaFor(room).owner == client
+	mutex.Unlock()
+	if !isOwner {
+		client.say("rban.owner_only")
+		return
+	}
+	if err := banFromRoom(room, name, strings.TrimSpace(reason)); err != nil {
+		client.sayError(err)
+		return
+	}
+	client.say("rban.done", name, room)
+}
+
+// banFromRoom bans the connected user name from room and removes them from
+// it.
+func banFromRoom(room, name, reason string) error {
+	if reason == "" {
+		reason = "no reason given"
+	}
+	mutex.Lock()
+	if _, ok := rooms[room]; !ok {
+		mutex.Unlock()
+		return localErrorf("rban.no_room", room)
+	}
+	target := findClient(name)
+	if target == nil {
+		mutex.Unlock()
+		return localErrorf("user.missing", name)
+	}
+	if target.address == UNIX_ANONYMOUS {
+		mutex.Unlock()
+		return localErrorf("rban.no_address")
+	}
+	if metaFor(room).owner == target {
+		mutex.Unlock()
+		return localErrorf("rban.owner")
+	}
+	metaFor(room).bans[addressHost(target.address)] = roomBan{username: name, reason: reason}
+	inRoom := target.room == room
+	mutex.Unlock()
+
+	if inRoom {
+		leaveRoom(target, "banned")
+		target.conn.Write(target.render(localMessage("", MESSAGE_NOTICE, "rban.notice", room, reason)))
+	}
+	return nil
+}
+
+func roomUnbanCommand(client *Client, room, name string) {
+	if name == "" {
+		client.say("runban.usage")
+		return
+	}
+	mutex.Lock()
+	isOwner := metaFor(room).owner == client
+	mutex.Unlock()
+	if !isOwner {
+		client.say("runban.owner_only")
+		return
+	}
+	if err := unbanFromRoom(room, name); err != nil {
+		client.sayError(err)
+		return
+	}
+	client.say("runban.done", name, room)
+}
+
+// unbanFromRoom lifts the room bans made against the user name.
+func unbanFromRoom(room, name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	found := false
+	if meta := roomMetas[room]; meta != nil {
+		for host, ban := range meta.bans {
+			if ban.username == name {
+				delete(meta.bans, host)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return localErrorf("runban.not_banned", name, room)
+	}
+	return nil
+}
+
+func roomBansCommand(client *Client, room, args string) {
+	lines := roomBanList(room)
+	if len(lines) == 0 {
+		client.say("rbans.none", room)
+		return
+	}
+	client.conn.Write([]byte(client.tr("rbans.list", room) + "\n" + strings.Join(lines, "\n") + "\n"))
+}
+
+// roomBanList describes the bans of room, sorted by username.
+func roomBanList(room string) []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	lines := []string{}
+	if roomMetas[room] == nil {
+		return lines
+	}
+	for host, ban := range roomMetas[room].bans {
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", ban.username, host, ban.reason))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// dropOwnership gives up the rooms owned by a disconnecting client. The
+// caller must hold mutex.
+func dropOwnership(client *Client) {
+	for _, meta := range roomMetas {
+		if meta.owner == client {
+			meta.owner = nil
+		}
+	}
+}
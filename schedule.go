@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduled is a recurring announcement. It fires either every interval
+// or daily at a time of day, to all clients or to one room.
+type scheduled struct {
+	id       int
+	spec     string
+	interval time.Duration
+	daily    time.Duration
+	target   string
+	text     string
+	next     time.Time
+}
+
+// Schedules are guarded by schedulesMutex. runScheduler is woken through
+// scheduleWake whenever they change.
+var (
+	schedules      []*scheduled
+	schedulesMutex = &sync.Mutex{}
+	nextScheduleID = 1
+	scheduleWake   = make(chan struct{}, 1)
+)
+
+// parseSchedule parses "every <duration> <target> <text>" or
+// "daily <HH:MM> <target> <text>", where target is "all" or "#room".
+func parseSchedule(value string) (*scheduled, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid schedule %q: want every <duration>|daily <HH:MM>, all|#room and the text", value)
+	}
+	s := &scheduled{spec: fields[0] + " " + fields[1], target: fields[2], text: restOfLine(value, 3)}
+	switch fields[0] {
+	case "every":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be a duration of at least 1m", value)
+		}
+		s.interval = d
+	case "daily":
+		at, err := time.Parse("15:04", fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: time must be HH:MM", value)
+		}
+		s.daily = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	default:
+		return nil, fmt.Errorf("invalid schedule %q: must start with every or daily", value)
+	}
+	if s.target != "all" && !(strings.HasPrefix(s.target, "#") && validName(s.target[1:])) {
+		return nil, fmt.Errorf("invalid schedule %q: target must be all or #room", value)
+	}
+	s.next = s.nextAfter(time.Now())
+	return s, nil
+}
+
+// nextAfter returns the first time after now the announcement is due.
+func (s *scheduled) nextAfter(now time.Time) time.Time {
+	if s.interval > 0 {
+		if s.next.IsZero() {
+			return now.Add(s.interval)
+		}
+		next := s.next
+		for !next.After(now) {
+			next = next.Add(s.interval)
+		}
+		return next
+	}
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(s.daily)
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(s.daily)
+	}
+	return next
+}
+
+// addSchedule parses value and starts announcing it, returning its ID.
+func addSchedule(value string) (int, error) {
+	s, err := parseSchedule(value)
+	if err != nil {
+		return 0, err
+	}
+	schedulesMutex.Lock()
+	s.id = nextScheduleID
+	nextScheduleID++
+	schedules = append(schedules, s)
+	schedulesMutex.Unlock()
+	wakeScheduler()
+	return s.id, nil
+}
+
+// removeSchedule stops the announcement with the given ID, reporting
+// whether there was one.
+func removeSchedule(id int) bool {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	for i, s := range schedules {
+		if s.id == id {
+			schedules = append(schedules[:i], schedules[i+1:]...)
+			wakeScheduler()
+			return true
+		}
+	}
+	return false
+}
+
+func wakeScheduler() {
+	select {
+	case scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduler sends due announcements. Each one's next time is moved on
+// before it is sent, so a late or repeated wake-up never sends it twice.
+func runScheduler() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		now := time.Now()
+		var due []*scheduled
+		var next time.Time
+		schedulesMutex.Lock()
+		for _, s := range schedules {
+			if !s.next.After(now) {
+				due = append(due, s)
+				s.next = s.nextAfter(now)
+			}
+			if next.IsZero() || s.next.Before(next) {
+				next = s.next
+			}
+		}
+		schedulesMutex.Unlock()
+		for _, s := range due {
+			announce(s.target, s.text)
+		}
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-scheduleWake:
+		}
+	}
+}
+
+// announce sends a server notice to every client, or to the members of
+// one room when target is #room. Notices to everyone are paced by fanOut.
+func announce(target, text string) {
+	if room, ok := strings.CutPrefix(target, "#"); ok {
+		mutex.Lock()
+		_, exists := rooms[room]
+		mutex.Unlock()
+		if !exists {
+			log.Printf("Not announcing to %s: no such room", target)
+			return
+		}
+		broadcast <- Message{room: room, text: fmt.Sprintf("[%s] Announcement: %s\n", room, text), kind: MESSAGE_NOTICE, body: "Announcement: " + text}
+		return
+	}
+	mutex.Lock()
+	members := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		members = append(members, client)
+	}
+	mutex.Unlock()
+	fanOut(members, Message{text: fmt.Sprintf("Announcement: %s\n", text), kind: MESSAGE_NOTICE})
+}
+
+func printSchedules() {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	if len(schedules) == 0 {
+		fmt.Println("No scheduled announcements.")
+		return
+	}
+	for _, s := range schedules {
+		fmt.Printf("%d: %s to %s, next at %s: %s\n", s.id, s.spec, s.target, s.next.Format("2006-01-02 15:04"), s.text)
+	}
+}
+
+// scheduleCommand runs the admin console's /schedule add|list|remove.
+func announceCommand(args string) {
+	target, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	if (target != "all" && !strings.HasPrefix(target, "#")) || text == "" {
+		fmt.Println("Usage: /announce all|#room <text>")
+		return
+	}
+	announce(target, text)
+	log.Printf("Admin announced to %s: %s", target, text)
+}
+
+func scheduleCommand(args string) {
+	action, rest, _ := strings.Cut(args, " ")
+	switch action {
+	case "add":
+		id, err := addSchedule(strings.TrimSpace(rest))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("Added scheduled announcement %d.\n", id)
+	case "list":
+		printSchedules()
+	case "remove":
+		id, err := strconv.Atoi(strings.TrimSpace(rest))
+		if err != nil || !removeSchedule(id) {
+			fmt.Println("No scheduled announcement with that number. Use /schedule list.")
+			return
+		}
+		fmt.Printf("Removed scheduled announcement %d.\n", id)
+	default:
+		fmt.Println("Usage: /schedule add every <duration>|daily <HH:MM> all|#room <text>, /schedule list, /schedule remove <number>")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const SEARCH_LIMIT = 20
+
+func init() {
+	registerCommand("/search", chatCommand{usage: "/search [terms] [from:user] [before:YYYY-MM-DD]", help: "Search your room's history", needsRoom: true, run: searchCommand})
+}
+
+// searchQuery is a parsed /search: every term must appear in the message,
+// ignoring case.
+type searchQuery struct {
+	terms  []string
+	from   string
+	before time.Time
+}
+
+func parseSearch(args string) (searchQuery, error) {
+	var query searchQuery
+	for _, field := range strings.Fields(args) {
+		if name, ok := strings.CutPrefix(field, "from:"); ok && name != "" {
+			query.from = name
+			continue
+		}
+		if date, ok := strings.CutPrefix(field, "before:"); ok {
+			before, err := time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				return query, localErrorf("search.bad_date", date)
+			}
+			query.before = before
+			continue
+		}
+		query.terms = append(query.terms, strings.ToLower(field))
+	}
+	if len(query.terms) == 0 && query.from == "" && query.before.IsZero() {
+		return query, localErrorf("search.usage")
+	}
+	return query, nil
+}
+
+func (q searchQuery) matches(message Message) bool {
+	if message.kind != MESSAGE_CHAT {
+		return false
+	}
+	if q.from != "" && !strings.EqualFold(message.from, q.from) {
+		return false
+	}
+	if !q.before.IsZero() && !message.time.Before(q.before) {
+		return false
+	}
+	body := strings.ToLower(message.body)
+	for _, term := range q.terms {
+		if !strings.Contains(body, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchCommand scans the kept history of the client's room, newest first.
+// Only the room the client is in can be searched.
+func searchCommand(client *Client, room, args string) {
+	query, err := parseSearch(args)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	var found []Message
+	mutex.Lock()
+	events, _ := historySince(room, historyStart(client, room))
+	for i := len(events) - 1; i >= 0 && len(found) < SEARCH_LIMIT; i-- {
+		if query.matches(events[i]) {
+			found = append(found, events[i])
+		}
+	}
+	mutex.Unlock()
+
+	if len(found) == 0 {
+		client.say("search.none", room, args)
+		return
+	}
+	var b strings.Builder
+	if len(found) == 1 {
+		b.WriteString(client.tr("search.one", room, args) + "\n")
+	} else {
+		b.WriteString(client.tr("search.many", len(found), room, args) + "\n")
+	}
+	for _, message := range found {
+		fmt.Fprintf(&b, "#%d %s %s: %s\n", message.seq, message.time.Format("2006-01-02 15:04"), message.from, indentContinuation(message.body))
+	}
+	client.conn.Write([]byte(b.String()))
+}
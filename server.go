@@ -2,148 +2,771 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"final_project/framing"
+	"final_project/textwidth"
 )
 
 const (
 	CONN_PORT = ":3334"
 	CONN_TYPE = "tcp"
+
+	MAX_LINE_LENGTH = 4096
+	MAX_NAME_LENGTH = 32
+	// NAME_COLUMNS is the most terminal columns a username, display name
+	// or room takes up in chat lines, /who, /list and the admin lists;
+	// /whois shows it in full.
+	NAME_COLUMNS = 24
 )
 
 type Client struct {
 	conn     net.Conn
 	username string
 	room     string
+	irc      bool
+	json     bool
+	queue    *sendQueue
+	// address is clientAddress(conn), read once on connect.
+	address string
+	// connected and counters describe the session for /stats.
+	connected time.Time
+	counters  clientCounters
+	// displayName is shown instead of username in chat lines when set.
+	// It is guarded by mutex.
+	displayName string
+	// software, softwareVersion and features are what the client said in
+	// its HELLO; see supports. The software is only shown to admins and is
+	// never trusted for anything. They are guarded by mutex.
+	software        string
+	softwareVersion string
+	features        map[string]bool
+	// bot is set for the connection when its HELLO ends with "bot"; see
+	// bot.go. It is guarded by mutex.
+	bot bool
+	// channels are the JSON channels the client subscribed to, by room,
+	// with "" for the default; nil means all. See subscribed. They are
+	// guarded by mutex.
+	channels map[string]map[string]bool
+	// pubkey is the key published for encrypted private messages, guarded
+	// by mutex.
+	pubkey []byte
+	// blocked holds the usernames whose private messages and invites the
+	// client refuses, guarded by mutex.
+	blocked map[string]bool
+	// reminders are the client's pending /remind notes, guarded by mutex.
+	reminders []*reminder
+	// pingWindow and pings track the ping limit; see allowPing. They are
+	// guarded by mutex.
+	pingWindow time.Time
+	pings      int
+	// listWindow and lists track the /list limit; see allowList. They are
+	// guarded by mutex.
+	listWindow time.Time
+	lists      int
+	// lastExport is when the client last used /export, guarded by mutex.
+	lastExport time.Time
+	// watching are the usernames the client wants to hear come online, and
+	// announced the names it was announced under; see notifyWatchers.
+	// They are guarded by mutex.
+	watching  map[string]bool
+	announced map[string]bool
+	// chatWindow and chats track message-rate; see allowChat. They are
+	// guarded by mutex.
+	chatWindow time.Time
+	chats      int
+	// lastChat is when the client last chatted and lastChatRoom where,
+	// for slow mode; see allowRoomChat. They are guarded by mutex.
+	lastChat     time.Time
+	lastChatRoom string
+	// awayMessage is set with /away, guarded by mutex.
+	awayMessage string
+	// lastActive is when the client last sent a line, in Unix nanoseconds,
+	// for the dashboard's idle time.
+	lastActive atomic.Int64
+	// joinedSeq is the sequence number of the last event in the room
+	// before the client joined it; see historyStart. It is guarded by
+	// mutex.
+	joinedSeq uint64
+	// handshaken is set once the client said HELLO or took a name; see
+	// startHandshake. It is guarded by mutex.
+	handshaken bool
+	// lang is the language code chosen with /lang, a string; see language.
+	lang atomic.Value
+	// location is the time zone chosen with /prefs, nil for the server's;
+	// see render.
+	location atomic.Pointer[time.Location]
+}
+
+// Message is a room event queued for handleBroadcast. text is the line
+// sent to regular clients; kind, from and body describe the event so it can
+// be rendered for other protocols.
+type Message struct {
+	room string
+	text string
+	kind string
+	from string
+	body string
+	// origin is the ID of the peer server a bridged message came from,
+	// empty for local messages.
+	origin string
+	// seq and time are set by handleBroadcast when the event is recorded.
+	seq  uint64
+	time time.Time
+	// sender, when set, is sent an ack with ackID once the message has
+	// been delivered.
+	sender *Client
+	ackID  string
+	// author is the local client that wrote a chat message. Clients with
+	// the no-self-echo feature are not sent their own messages.
+	author *Client
+	// display is the sender's display name, if it set one.
+	display string
+	// bot marks a chat message from a bot, tagged in chat lines.
+	bot bool
+	// key and args, when set, render text in each text client's language;
+	// see localMessage.
+	key  string
+	args []any
 }
 
+const (
+	MESSAGE_CHAT   = "chat"
+	MESSAGE_JOIN   = "join"
+	MESSAGE_LEAVE  = "leave"
+	MESSAGE_NICK   = "nick"
+	MESSAGE_NOTICE = "notice"
+)
+
+// Modes for joinRoom.
+const (
+	JOIN_EXISTING = iota
+	CREATE_NEW
+	JOIN_OR_CREATE
+)
+
+// BannedUser is a server ban. Address is an exact address, an IP or, when
+// Net is set, a network in CIDR notation.
 type BannedUser struct {
 	Address string
+	Net     *net.IPNet
+	Reason  string
+	Time    time.Time
 }
 
 var (
-	clients     = make(map[net.Conn]*Client)
-	rooms       = make(map[string][]*Client)
-	broadcast   = make(chan string)
+	clients = make(map[net.Conn]*Client)
+	rooms   = make(map[string][]*Client)
+	// broadcast is made in main, with -broadcast-buffer slots.
+	broadcast   chan Message
 	mutex       = &sync.Mutex{}
 	bannedUsers = make(map[string]BannedUser)
 )
 
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
-	client := &Client{conn: conn, username: "Anonymous"}
+	if tlsConn, ok := conn.(*tls.Conn); ok && !tlsHandshake(tlsConn) {
+		return
+	}
+	client := newClient(conn)
+	reader := bufio.NewReader(client.conn)
+	defer client.stop()
+	client.conn.Write([]byte(banner()))
+	requireNick := config().requireNick
 
+	// Banned clients are turned away before they are registered, so
+	// nothing is left behind in clients.
 	mutex.Lock()
-	clients[conn] = client
+	ban, banned := isBanned(client.address)
+	full := !banned && serverFull()
+	if !banned && !full {
+		if !requireNick {
+			assignGuestName(client)
+		}
+		clients[client.conn] = client
+		hookConnect(client)
+	}
+	name := client.username
 	mutex.Unlock()
-
-	if _, banned := bannedUsers[conn.RemoteAddr().String()]; banned {
-		conn.Write([]byte("You are banned from the chat.\n"))
-		conn.Close()
+	if full {
+		client.say("welcome.full")
+		return
+	}
+	if banned {
+		if ban.Reason != "" {
+			client.say("welcome.banned_reason", ban.Reason)
+		} else {
+			client.say("welcome.banned")
+		}
+		return
+	}
+	if checkEvasion(client, "") {
 		return
 	}
+	if requireNick {
+		client.say("welcome.choose_nick")
+	} else {
+		client.say("welcome.guest", name)
+	}
+	sendMOTD(client)
 
-	for {
-		message, err := reader.ReadString('\n')
+	// Until the client negotiates framing, each line is a message.
+	next := func() (string, error) { return readLine(reader) }
+	startHandshake(client)
+	handshaking := true
+	for first := true; ; first = false {
+		message, err := next()
 		if err != nil {
-			log.Printf("Client disconnected: %v", conn.RemoteAddr())
-			mutex.Lock()
-			if client.room != "" {
-				rooms[client.room] = removeClient(rooms[client.room], client)
-				broadcast <- fmt.Sprintf("[%s] Notice: \"%s\" left the chat room.\n", client.room, client.username)
+			if handshakeExpired(client, err) {
+				client.say("welcome.timeout")
+			} else {
+				log.Printf("Client disconnected: %v", client.address)
 			}
-			delete(clients, conn)
-			mutex.Unlock()
+			disconnectClient(client, "")
 			return
 		}
+		client.lastActive.Store(time.Now().UnixNano())
 		message = strings.TrimSpace(message)
-		if strings.HasPrefix(message, "/") {
+		if !client.json {
+			message = cleanBody(message)
+		}
+		if message == "" {
+			continue
+		}
+		if first && strings.HasPrefix(message, "HELLO ") {
+			finishHandshake(client)
+			handshaking = false
+			if handleHello(client, message) {
+				frames := framing.NewReader(reader, config().maxFrameSize)
+				next = func() (string, error) { return readFrame(frames) }
+			}
+			continue
+		}
+		if client.json {
+			handleJSONRequest(client, message)
+		} else if strings.HasPrefix(message, "/") {
 			handleCommand(message, client)
 		} else {
-			if client.room == "" {
-				conn.Write([]byte("You must join a room first using /join [room_name] or create a room using /create [room_name].\n"))
+			if err := checkNamed(client); err != nil {
+				client.sayError(err)
+			} else if client.room == "" {
+				client.say("room.join_first")
+			} else if body, err := pasteBody(message); err != nil {
+				client.sayError(err)
+			} else if err := client.allowChat(); err != nil {
+				client.sayError(err)
 			} else {
-				broadcast <- fmt.Sprintf("[%s] %s - %s: %s\n", client.room, time.Now().Format("3:04PM"), client.username, message)
+				client.counters.messagesSent.Add(1)
+				broadcast <- userChat(client, client.room, body)
 			}
 		}
+		// A guest is named from the start, so any line will do.
+		if handshaking && checkNamed(client) == nil {
+			finishHandshake(client)
+			handshaking = false
+		}
 	}
 }
 
 func handleCommand(message string, client *Client) {
-	parts := strings.Split(message, " ")
+	client.counters.commands.Add(1)
+	message = expandCommandAlias(message)
+	parts := strings.Fields(message)
 	command := parts[0]
 
 	switch command {
 	case "/join":
 		if len(parts) < 2 {
-			client.conn.Write([]byte("Usage: /join [room_name]\n"))
+			client.say("join.usage")
 			return
 		}
-		roomName := parts[1]
-		mutex.Lock()
-		if _, exists := rooms[roomName]; !exists {
-			client.conn.Write([]byte(fmt.Sprintf("Room %s does not exist. Use /create [room_name] to create a new room.\n", roomName)))
-			mutex.Unlock()
+		if err := checkNamed(client); err != nil {
+			client.sayError(err)
 			return
 		}
-		if _, banned := bannedUsers[client.conn.RemoteAddr().String()]; banned {
-			client.conn.Write([]byte("You are banned from the chat.\n"))
-			mutex.Unlock()
+		if _, err := joinRoom(client, parts[1], JOIN_EXISTING); err != nil {
+			client.sayError(err)
 			return
 		}
-		if client.room != "" {
-			rooms[client.room] = removeClient(rooms[client.room], client)
-			broadcast <- fmt.Sprintf("[%s] Notice: \"%s\" left the chat room.\n", client.room, client.username)
+		client.say("join.done", parts[1])
+		mutex.Lock()
+		greeting := ""
+		if !client.bot {
+			greeting = roomGreeting(parts[1])
 		}
-		client.room = roomName
-		rooms[roomName] = append(rooms[roomName], client)
 		mutex.Unlock()
-		client.conn.Write([]byte(fmt.Sprintf("Joined room %s\n", roomName)))
-		broadcast <- fmt.Sprintf("[%s] Notice: \"%s\" joined the chat room.\n", roomName, client.username)
+		if greeting != "" {
+			client.say("greeting.show", parts[1], greeting)
+		}
+		broadcast <- joinNotice(parts[1], client.username, false)
 
 	case "/create":
 		if len(parts) < 2 {
-			client.conn.Write([]byte("Usage: /create [room_name]\n"))
+			client.say("create.usage")
+			return
+		}
+		if err := checkNamed(client); err != nil {
+			client.sayError(err)
+			return
+		}
+		if _, err := joinRoom(client, parts[1], CREATE_NEW); err != nil {
+			client.sayError(err)
+			return
+		}
+		client.say("create.done", parts[1])
+		broadcast <- joinNotice(parts[1], client.username, true)
+
+	case "/nick":
+		if len(parts) < 2 {
+			client.say("nick.usage")
+			return
+		}
+		if err := setUsername(client, parts[1]); err != nil {
+			client.sayError(err)
+			return
+		}
+		client.say("nick.done", parts[1])
+
+	case "/msg":
+		if len(parts) < 3 {
+			client.say("msg.usage")
+			return
+		}
+		text := restOfLine(message, 2)
+		if err := checkNamed(client); err != nil {
+			client.sayError(err)
 			return
 		}
-		roomName := parts[1]
+		if err := sendPrivateMessage(client, parts[1], text); err != nil {
+			client.sayError(err)
+			return
+		}
+		echo := fmt.Sprintf("[PM to %s] %s\n", parts[1], indentContinuation(cleanBody(text)))
 		mutex.Lock()
-		if _, exists := rooms[roomName]; exists {
-			client.conn.Write([]byte(fmt.Sprintf("Room %s already exists. Use /join [room_name] to join the room.\n", roomName)))
-			mutex.Unlock()
+		client.enqueue([]byte(echo), QUEUE_CHAT)
+		mutex.Unlock()
+
+	case "/who":
+		mutex.Lock()
+		room := client.room
+		names := roomLabels(client, room)
+		mutex.Unlock()
+		if room == "" {
+			client.say("room.not_in")
 			return
 		}
-		if _, banned := bannedUsers[client.conn.RemoteAddr().String()]; banned {
-			client.conn.Write([]byte("You are banned from the chat.\n"))
-			mutex.Unlock()
+		client.say("who.list", shortName(room), strings.Join(names, ", "))
+
+	case "/list":
+		alpha, tag, page := false, "", 0
+		for _, arg := range strings.Fields(restOfLine(message, 1)) {
+			if arg == "-alpha" && !alpha {
+				alpha = true
+			} else if name, ok := strings.CutPrefix(arg, "tag:"); ok && name != "" && tag == "" {
+				tag = name
+			} else if n, err := strconv.Atoi(arg); err == nil && n > 0 && page == 0 {
+				page = n
+			} else {
+				client.say("rooms.usage")
+				return
+			}
+		}
+		if err := client.allowList(); err != nil {
+			client.sayError(err)
 			return
 		}
-		rooms[roomName] = []*Client{}
-		if client.room != "" {
-			rooms[client.room] = removeClient(rooms[client.room], client)
-			broadcast <- fmt.Sprintf("[%s] Notice: \"%s\" left the chat room.\n", client.room, client.username)
+		page = max(page, 1)
+		mutex.Lock()
+		entries := roomDirectory(client)
+		mutex.Unlock()
+		if !alpha {
+			sortByActivity(entries)
 		}
-		client.room = roomName
-		rooms[roomName] = append(rooms[roomName], client)
+		if tag != "" {
+			tagged := filterByTag(entries, tag)
+			if len(tagged) == 0 {
+				if tags := directoryTags(entries); len(tags) > 0 {
+					client.say("rooms.no_tag", tag, strings.Join(tags, ", "))
+				} else {
+					client.say("rooms.no_tags", tag)
+				}
+				return
+			}
+			entries = tagged
+		}
+		entries, pages := directoryPage(entries, page)
+		if page > max(pages, 1) {
+			client.say("rooms.no_page", pages)
+			return
+		}
+		text := directoryText(client, entries)
+		if page < pages {
+			text += client.tr("rooms.page_next", page, pages, page+1) + "\n"
+		} else if pages > 1 {
+			text += client.tr("rooms.page_last", page, pages) + "\n"
+		}
+		client.conn.Write([]byte(text))
+
+	case "/quit":
+		client.say("quit.goodbye")
+		disconnectClient(client, restOfLine(message, 1))
+
+	case "/ping":
+		if err := client.allowPing(); err != nil {
+			client.sayError(err)
+			return
+		}
+		client.conn.Write([]byte("PONG " + serverTime() + "\n"))
+
+	case "/stats":
+		client.conn.Write([]byte(clientStats(client)))
+
+	case "/json":
+		mutex.Lock()
+		supported := client.supports("json")
+		client.json = supported
 		mutex.Unlock()
-		client.conn.Write([]byte(fmt.Sprintf("Created and joined room %s\n", roomName)))
-		broadcast <- fmt.Sprintf("[%s] Notice: \"%s\" created and joined the chat room.\n", roomName, client.username)
+		if !supported {
+			client.say("json.unsupported")
+			return
+		}
+		jsonWrite(client, jsonEvent{Type: "ok", Request: "json"})
 
 	case "/help":
-		helpMessage := "/join [room_name] - Join a room\n" +
+		helpMessage := "/join [room_name]" + aliasNote("/join") + " - Join a room\n" +
 			"/create [room_name] - Create a room\n" +
-			"/help - Show this help message\n"
+			"/nick [username] - Set your username\n" +
+			"/msg [username] [message]" + aliasNote("/msg") + " - Send a private message\n" +
+			"/who" + aliasNote("/who") + " - List users in your room\n" +
+			"/list [-alpha] [tag:name] [page] - List rooms, most active first\n" +
+			"/quit [message] - Leave the chat\n" +
+			"/ping - Check that the server is responding\n" +
+			"/stats - Show server and session statistics\n" +
+			"/json - Switch this connection to the JSON protocol\n" +
+			"/help - Show this help message\n" +
+			commandsHelp()
 		client.conn.Write([]byte(helpMessage))
 
 	default:
-		client.conn.Write([]byte("Unknown command. Type /help for a list of commands.\n"))
+		if runCommand(client, message) {
+			return
+		}
+		client.say("command.unknown")
+	}
+}
+
+// joinRoom moves client into roomName, leaving its current room. With
+// JOIN_EXISTING the room must exist, with CREATE_NEW it must not, and
+// JOIN_OR_CREATE accepts both. It reports whether the room was created.
+// The caller announces the join once it has replied to the client.
+func joinRoom(client *Client, roomName string, mode int) (bool, error) {
+	if !validName(roomName) {
+		return false, localErrorf("room.invalid_name", MAX_NAME_LENGTH)
+	}
+	mutex.Lock()
+	_, exists := rooms[roomName]
+	if !exists && mode == JOIN_EXISTING {
+		mutex.Unlock()
+		return false, localErrorf("room.missing", roomName)
+	}
+	if exists && mode == CREATE_NEW {
+		mutex.Unlock()
+		return false, localErrorf("room.exists", roomName)
+	}
+	if _, banned := isBanned(client.address); banned {
+		mutex.Unlock()
+		return false, localErrorf("welcome.banned")
+	}
+	if err := roomBanError(client, roomName); err != nil {
+		mutex.Unlock()
+		return false, err
+	}
+	if err := whitelistError(client, roomName); err != nil {
+		mutex.Unlock()
+		return false, err
+	}
+	if !exists {
+		if err := roomLimitError(client); err != nil {
+			mutex.Unlock()
+			return false, err
+		}
+	}
+	if !exists {
+		rooms[roomName] = []*Client{}
+		metaFor(roomName).owner = client
+	}
+	oldRoom := client.room
+	if oldRoom != "" {
+		removeMember(oldRoom, client)
+		hookLeave(oldRoom, client)
+	}
+	client.room = roomName
+	client.joinedSeq = lastSeq(roomName)
+	rooms[roomName] = append(rooms[roomName], client)
+	if !exists {
+		hookRoomCreated(roomName, client)
+	}
+	hookJoin(roomName, client)
+	noteInviteUsed(client, roomName)
+	mutex.Unlock()
+	if oldRoom != "" {
+		broadcast <- leaveNotice(oldRoom, client.username, "")
+	}
+	return !exists, nil
+}
+
+// setUsername renames client, announcing the change to its room.
+func setUsername(client *Client, name string) error {
+	if !validUsername(name) {
+		return localErrorf("nick.invalid", MAX_NAME_LENGTH)
+	}
+	if isReserved(name) {
+		return localErrorf("nick.reserved", name)
+	}
+	if checkEvasion(client, name) {
+		return localErrorf("welcome.banned")
+	}
+	mutex.Lock()
+	if other := findClient(name); other != nil && other != client {
+		mutex.Unlock()
+		return localErrorf("nick.taken", name)
+	}
+	if displayNameTaken(name, client) {
+		mutex.Unlock()
+		return localErrorf("nick.display_taken", name)
+	}
+	oldName := client.username
+	client.username = name
+	hookRename(oldName, client)
+	renameBlocks(oldName, name)
+	notifyWatchers(client, name)
+	room := client.room
+	mutex.Unlock()
+	if room != "" {
+		message := localMessage(room, MESSAGE_NICK, "notice.renamed", room, oldName, name)
+		message.from, message.body = oldName, name
+		broadcast <- message
+	}
+	return nil
+}
+
+func sendPrivateMessage(client *Client, targetName, text string) error {
+	mutex.Lock()
+	target := findClient(targetName)
+	mutex.Unlock()
+	if target == nil {
+		return localErrorf("user.missing", targetName)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := blockError(client, target); err != nil {
+		return err
+	}
+	text = cleanBody(text)
+	target.deliver(Message{text: fmt.Sprintf("[PM from %s] %s\n", client.username, indentContinuation(text)), kind: MESSAGE_CHAT, from: client.username, body: text})
+	client.counters.messagesSent.Add(1)
+	return nil
+}
+
+// leaveRoom removes client from its room, announcing the departure with
+// the optional reason. It returns the room that was left, if any.
+func leaveRoom(client *Client, reason string) string {
+	mutex.Lock()
+	room := client.room
+	if room != "" {
+		removeMember(room, client)
+		client.room = ""
+		hookLeave(room, client)
+	}
+	mutex.Unlock()
+	if room != "" {
+		broadcast <- leaveNotice(room, client.username, reason)
+	}
+	return room
+}
+
+// disconnectClient is the single exit path for a client: it leaves its
+// room, announcing the departure with the optional reason, is removed from
+// the client list and has its connection closed. An evicted client's
+// connection is left to its writer, which says goodbye first. Calling it
+// again for the same client does nothing. The caller must not hold mutex.
+func disconnectClient(client *Client, reason string) {
+	leaveRoom(client, reason)
+	mutex.Lock()
+	if _, ok := clients[client.conn]; ok {
+		hookDisconnect(client)
+		if client.handshaken {
+			departedCounters.add(client.counters.snapshot())
+		}
+	}
+	delete(clients, client.conn)
+	cancelReminders(client)
+	dropOwnership(client)
+	mutex.Unlock()
+	select {
+	case <-client.queue.evicted:
+	default:
+		client.conn.Close()
+	}
+}
+
+// roomMembers returns the sorted usernames in room. The caller must hold mutex.
+func roomMembers(room string) []string {
+	names := []string{}
+	for _, c := range rooms[room] {
+		names = append(names, c.username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roomNames returns the sorted room names. The caller must hold mutex.
+func roomNames() []string {
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func chatMessage(room, from, body string) Message {
+	return Message{room: room, text: chatLine(room, from, body), kind: MESSAGE_CHAT, from: from, body: body}
+}
+
+func chatLine(room, name, body string) string {
+	return chatLineAt(room, time.Now().Format("3:04PM"), name, false, body)
+}
+
+// shortName cuts name to NAME_COLUMNS for formatted output.
+func shortName(name string) string {
+	return textwidth.Truncate(name, NAME_COLUMNS)
+}
+
+// chatLineAt formats a chat line, tagging the name of a bot with BOT_TAG.
+func chatLineAt(room, stamp, name string, bot bool, body string) string {
+	name = oneLine(shortName(name))
+	if bot {
+		name += " " + BOT_TAG
+	}
+	return fmt.Sprintf("[%s] %s - %s: %s\n", room, stamp, name, indentContinuation(cleanBody(body)))
+}
+
+func joinNotice(room, username string, created bool) Message {
+	if created {
+		message := localMessage(room, MESSAGE_JOIN, "notice.created", room, username)
+		message.from = username
+		return message
+	}
+	message := localMessage(room, MESSAGE_JOIN, "notice.joined", room, username)
+	message.from = username
+	return message
+}
+
+func leaveNotice(room, username, reason string) Message {
+	reason = oneLine(reason)
+	if reason != "" {
+		message := localMessage(room, MESSAGE_LEAVE, "notice.left_reason", room, username, reason)
+		message.from, message.body = username, reason
+		return message
+	}
+	message := localMessage(room, MESSAGE_LEAVE, "notice.left", room, username)
+	message.from = username
+	return message
+}
+
+// noticeText is the text of a notice for protocols that show the room
+// separately: its body when set, otherwise its text line.
+func noticeText(message Message) string {
+	if message.body != "" {
+		return message.body
+	}
+	return strings.TrimSpace(message.text)
+}
+
+// deliver queues message for the client in its protocol. The caller must
+// hold mutex.
+func (c *Client) deliver(message Message) error {
+	if message.author == c && c.features["no-self-echo"] {
+		return nil
+	}
+	if c.json && !c.subscribed(message.room, messageChannel(message)) {
+		return nil
+	}
+	line := c.render(message)
+	if len(line) == 0 {
+		return nil
+	}
+	if message.kind == MESSAGE_CHAT {
+		c.counters.messagesReceived.Add(1)
+	}
+	return c.enqueueMessage(message, line)
+}
+
+// render formats message in the client's protocol.
+func (c *Client) render(message Message) []byte {
+	if c.irc {
+		if line := ircRender(c, message); line != "" {
+			return []byte(line + "\r\n")
+		}
+		return nil
+	}
+	if c.json {
+		return jsonLine(toJSONEvent(message))
+	}
+	if message.key != "" {
+		return []byte(c.tr(message.key, message.args...) + "\n")
+	}
+	if c.location.Load() != nil && message.kind == MESSAGE_CHAT && message.room != "" && !message.time.IsZero() {
+		name := message.from
+		if message.display != "" {
+			name = message.display
+		}
+		return []byte(chatLineAt(message.room, message.time.In(c.timeZone()).Format("3:04PM"), name, message.bot, message.body))
+	}
+	return []byte(message.text)
+}
+
+// findClient returns the connected client with the given username, or nil
+// if there is none or the name is ambiguous. The caller must hold mutex.
+func findClient(username string) *Client {
+	var found *Client
+	for _, c := range clients {
+		if c.username == username {
+			if found != nil {
+				return nil
+			}
+			found = c
+		}
+	}
+	return found
+}
+
+// removeMember takes client out of the members of room, discarding the
+// room's poll once nobody is left. The caller must hold mutex.
+func removeMember(room string, client *Client) {
+	rooms[room] = removeClient(rooms[room], client)
+	if len(rooms[room]) == 0 {
+		dropPoll(room)
 	}
 }
 
@@ -156,22 +779,97 @@ func removeClient(slice []*Client, client *Client) []*Client {
 	return slice
 }
 
+// readLine reads a line from a client, cut to MAX_LINE_LENGTH. Line
+// endings are "\n" or "\r\n"; a "\r" left before the "\n" is dropped here,
+// so commands never see it in their arguments.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if len(line) < MAX_LINE_LENGTH {
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) > MAX_LINE_LENGTH {
+		line = line[:MAX_LINE_LENGTH]
+	}
+	return strings.ToValidUTF8(string(line), string(utf8.RuneError)), nil
+}
+
+// restOfLine returns the text after the first n whitespace-separated fields.
+func restOfLine(message string, n int) string {
+	rest := message
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			return ""
+		}
+		rest = rest[end:]
+	}
+	return strings.TrimSpace(rest)
+}
+
+func validName(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > MAX_NAME_LENGTH {
+		return false
+	}
+	for _, r := range name {
+		if r == utf8.RuneError || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validUsername is validName for usernames, which also may not start with
+// "[" so that a line from a user cannot pass for a room's or a private
+// message's.
+func validUsername(name string) bool {
+	return validName(name) && !strings.HasPrefix(name, "[")
+}
+
+// handleBroadcast delivers room events one at a time in the order they were
+// sent. Each client sends its own events from its connection's goroutine,
+// so everything a user does in a room reaches every member in the order
+// they did it, and their join notice comes before their first message; see
+// enqueueMessage for join notices held back from a full queue.
 func handleBroadcast() {
 	for {
 		message := <-broadcast
-		parts := strings.SplitN(message, " ", 3)
-		room := parts[0][1 : len(parts[0])-1]
+		start := broadcastTimer()
+		room := message.room
 		mutex.Lock()
-		for _, client := range rooms[room] {
-			_, err := client.conn.Write([]byte(message))
-			if err != nil {
-				log.Printf("Error sending message to client %v: %v", client.conn.RemoteAddr(), err)
-				client.conn.Close()
-				delete(clients, client.conn)
-				rooms[room] = removeClient(rooms[room], client)
-			}
+		message = recordHistory(message)
+		hookMessage(message)
+		if message.kind == MESSAGE_CHAT {
+			metaFor(room).activity.record(time.Now())
+		}
+		members := append([]*Client(nil), rooms[room]...)
+		recipients, failed := deliverAll(members, message)
+		recordBroadcast(start, message, members)
+		for _, client := range failed {
+			// Stop delivering to it now; the departure is announced once
+			// the mutex is released.
+			removeMember(room, client)
+		}
+		if message.sender != nil {
+			message.sender.enqueue(jsonLine(jsonEvent{Type: "ack", ID: message.ackID, Room: room, Seq: message.seq, Time: message.time.Format(time.RFC3339), From: message.from, Recipients: &recipients}), QUEUE_CHAT)
 		}
 		mutex.Unlock()
+		for _, client := range failed {
+			log.Printf("Disconnecting client %v: %v", client.address, errSlowConsumer)
+			go disconnectClient(client, "too slow")
+		}
+		forwardToPeers(message)
+		queueUnfurls(message)
 	}
 }
 
@@ -179,8 +877,49 @@ func adminConsole() {
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("Admin Command > ")
-		command, _ := reader.ReadString('\n')
-		command = strings.TrimSpace(command)
+		command, err := reader.ReadString('\n')
+		if err != nil && command == "" {
+			// No console attached, e.g. when run as a service.
+			return
+		}
+		command, yes := cutYes(strings.TrimSpace(command))
+		if name, args, _ := strings.Cut(command, " "); name == "/schedule" {
+			scheduleCommand(strings.TrimSpace(args))
+			continue
+		} else if name == "/setlimit" {
+			setLimit(args)
+			continue
+		} else if name == "/whois" {
+			who := strings.TrimSpace(args)
+			if who == "" {
+				fmt.Print("Enter username or IP address: ")
+				who, _ = reader.ReadString('\n')
+				who = strings.TrimSpace(who)
+			}
+			printWhois(who)
+			continue
+		} else if name == "/debugclient" {
+			who := strings.TrimSpace(args)
+			if who == "" {
+				fmt.Print("Enter username or IP address: ")
+				who, _ = reader.ReadString('\n')
+				who = strings.TrimSpace(who)
+			}
+			printDebugClient(who)
+			continue
+		} else if name == "/banexport" {
+			banExportCommand(args)
+			continue
+		} else if name == "/banimport" {
+			banImportCommand(args)
+			continue
+		} else if name == "/announce" {
+			announceCommand(strings.TrimSpace(args))
+			continue
+		} else if name == "/exempt" {
+			exemptRoom(strings.TrimSpace(args))
+			continue
+		}
 
 		switch command {
 		case "/clients":
@@ -189,31 +928,125 @@ func adminConsole() {
 			printRooms()
 		case "/stats":
 			printStats()
+		case "/goroutines":
+			printGoroutines()
+		case "/greylist":
+			printGreylist()
+		case "/reload":
+			if reloadConfig() == nil {
+				fmt.Println("Configuration reloaded.")
+			}
 		case "/help":
 			printAdminHelp()
 		case "/kick":
 			fmt.Print("Enter IP address to kick: ")
 			ip, _ := reader.ReadString('\n')
 			ip = strings.TrimSpace(ip)
-			for conn := range clients {
-				addr := conn.RemoteAddr().String()
-				if addr == ip {
-					kickUser(conn)
-					fmt.Printf("User %s has been kicked from the chat.\n", ip)
-					break
-				}
+			fmt.Print("Enter reason (optional): ")
+			reason, _ := reader.ReadString('\n')
+			client := clientByAddress(ip)
+			if client == nil {
+				fmt.Printf("%s is not connected.\n", ip)
+				break
+			}
+			mutex.Lock()
+			target := describeClient(client)
+			mutex.Unlock()
+			if !confirmAction(reader, yes, "kick of "+ip, []string{target}) {
+				break
 			}
+			kickUser(client, strings.TrimSpace(reason))
+			fmt.Printf("User %s has been kicked from the chat.\n", ip)
 		case "/ban":
-			fmt.Print("Enter IP address to ban: ")
+			fmt.Print("Enter address or network to ban, then force for a wide network: ")
+			line, _ := reader.ReadString('\n')
+			target, force, _ := strings.Cut(strings.TrimSpace(line), " ")
+			fmt.Print("Enter reason (optional): ")
+			reason, _ := reader.ReadString('\n')
+			ban, err := parseBan(target, strings.TrimSpace(force) == "force")
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			ban.Reason = strings.TrimSpace(reason)
+			if !confirmAction(reader, yes, "ban of "+ban.Address, banTargets(ban)) {
+				break
+			}
+			previous, replaced := serverBan(ban.Address)
+			addBan(ban)
+			pushUndo("ban of "+ban.Address, func() {
+				if replaced {
+					restoreBan(previous)
+				} else {
+					unbanAddress(ban.Address)
+				}
+			})
+			for _, client := range bannedClients() {
+				evictUser(client, "banned", ban.Reason)
+				fmt.Printf("Disconnected %s.\n", client.address)
+			}
+			fmt.Printf("%s has been banned from the chat.\n", ban.Address)
+		case "/undo":
+			undoLast()
+		case "/banned":
+			printBans()
+		case "/limits":
+			printLimits()
+		case "/unban":
+			fmt.Print("Enter address or network to unban: ")
 			ip, _ := reader.ReadString('\n')
 			ip = strings.TrimSpace(ip)
-			for conn := range clients {
-				addr := conn.RemoteAddr().String()
-				if addr == ip {
-					banUser(conn)
-					fmt.Printf("User %s has been banned from the chat.\n", ip)
-					break
-				}
+			lifted, _ := serverBan(ip)
+			if unbanAddress(ip) {
+				pushUndo("unban of "+lifted.Address, func() { restoreBan(lifted) })
+				fmt.Printf("User %s has been unbanned.\n", ip)
+			} else {
+				fmt.Printf("%s is not banned.\n", ip)
+			}
+		case "/rban":
+			fmt.Print("Enter room: ")
+			room, _ := reader.ReadString('\n')
+			fmt.Print("Enter username to ban from the room: ")
+			name, _ := reader.ReadString('\n')
+			room, name = strings.TrimSpace(room), strings.TrimSpace(name)
+			host, target := roomBanTarget(name)
+			if host != "" && !confirmAction(reader, yes, fmt.Sprintf("ban of %s from room %s", name, room), target) {
+				break
+			}
+			previous, replaced := roomBanOn(room, host)
+			if err := banFromRoom(room, name, "banned by the server admin"); err != nil {
+				fmt.Println(err)
+				break
+			}
+			log.Printf("Banned %s from room %s", name, room)
+			pushUndo(fmt.Sprintf("ban of %s from room %s", name, room), func() { setRoomBan(room, host, previous, replaced) })
+		case "/runban":
+			fmt.Print("Enter room: ")
+			room, _ := reader.ReadString('\n')
+			fmt.Print("Enter username to unban from the room: ")
+			name, _ := reader.ReadString('\n')
+			room, name = strings.TrimSpace(room), strings.TrimSpace(name)
+			lifted := roomBansOf(room, name)
+			if err := unbanFromRoom(room, name); err != nil {
+				fmt.Println(err)
+				break
+			}
+			log.Printf("Unbanned %s from room %s", name, room)
+			pushUndo(fmt.Sprintf("room unban of %s from %s", name, room), func() { restoreRoomBans(room, lifted) })
+		case "/archive":
+			fmt.Print("Enter room: ")
+			room, _ := reader.ReadString('\n')
+			path, count, err := archiveRoom(strings.TrimSpace(room))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Archived %d messages to %s.\n", count, path)
+			}
+		case "/rbans":
+			fmt.Print("Enter room: ")
+			room, _ := reader.ReadString('\n')
+			for _, line := range roomBanList(strings.TrimSpace(room)) {
+				fmt.Println(line)
 			}
 		default:
 			fmt.Println("Unknown command. Type /help for a list of commands.")
@@ -221,25 +1054,74 @@ func adminConsole() {
 	}
 }
 
-func kickUser(conn net.Conn) {
-	for _, roomClients := range rooms {
-		for i, client := range roomClients {
-			if client.conn == conn {
-				rooms[client.room] = append(roomClients[:i], roomClients[i+1:]...)
-				client.room = ""
-				conn.Write([]byte("You have been kicked from the chat.\n"))
-				return
-			}
+// clientByAddress returns a connected client with the given address, or nil.
+func clientByAddress(addr string) *Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, client := range clients {
+		if client.address == addr {
+			return client
 		}
 	}
+	return nil
 }
 
-func banUser(conn net.Conn) {
-	connAddr := conn.RemoteAddr().String()
-	bannedUsers[connAddr] = BannedUser{
-		Address: connAddr,
+// kickUser tells client it was kicked, and why if reason is not empty, and
+// disconnects it.
+func kickUser(client *Client, reason string) {
+	log.Printf("Kicked %s (%s)%s", client.address, client.username, reasonSuffix(reason))
+	evictUser(client, "kicked", reason)
+}
+
+// banUser bans the host of client, as /ban of its IP does, and disconnects
+// every client from it. A Unix socket client without credentials cannot be
+// banned, so it is kicked instead.
+func banUser(client *Client, reason string) {
+	if banHost(client.address, reason) == "" {
+		log.Printf("Cannot ban %s; kicking it instead", client.address)
+		kickUser(client, reason)
+		return
+	}
+	for _, banned := range bannedClients() {
+		evictUser(banned, "banned", reason)
+	}
+}
+
+// evictUser sends client the notice for action, "kicked" or "banned", and
+// disconnects it. Its room sees the action and reason in the leave notice.
+func evictUser(client *Client, action, reason string) {
+	message := localMessage("", MESSAGE_NOTICE, action)
+	if reason != "" {
+		message = localMessage("", MESSAGE_NOTICE, action+"_reason", reason)
+	}
+	if action == "banned" {
+		rememberBan(client, reason)
+	}
+	client.conn.SetWriteDeadline(time.Now().Add(EVICT_WRITE_TIMEOUT))
+	client.conn.Write(client.render(message))
+	disconnectClient(client, action+reasonSuffix(reason))
+}
+
+func printBans() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(bannedUsers) == 0 {
+		fmt.Println("Nobody is banned.")
+		return
+	}
+
+	list := sortedBans()
+	for i, ban := range list {
+		if i == 0 || (ban.Net != nil) != (list[i-1].Net != nil) {
+			if ban.Net != nil {
+				fmt.Println("Banned networks:")
+			} else {
+				fmt.Println("Banned addresses:")
+			}
+		}
+		fmt.Printf("%s, since %s%s\n", ban.Address, ban.Time.Format(time.DateTime), reasonSuffix(ban.Reason))
 	}
-	kickUser(conn)
 }
 
 func printClients() {
@@ -253,7 +1135,7 @@ func printClients() {
 
 	fmt.Println("Connected clients:")
 	for _, client := range clients {
-		fmt.Printf("Client: %s, Room: %s\n", client.conn.RemoteAddr(), client.room)
+		fmt.Printf("Client: %s, Username: %s, Room: %s\n", client.address, shortName(client.username), shortName(client.room))
 	}
 }
 
@@ -268,20 +1150,41 @@ func printRooms() {
 
 	fmt.Println("Active rooms:")
 	for roomName, clients := range rooms {
-		fmt.Printf("Room: %s, Members: %d\n", roomName, len(clients))
+		fmt.Printf("Room: %s, Members: %d\n", shortName(roomName), len(clients))
 		for _, client := range clients {
-			fmt.Printf(" - %s\n", client.conn.RemoteAddr())
+			fmt.Printf(" - %s\n", client.address)
 		}
 	}
 }
 
 func printStats() {
-	mutex.Lock()
-	defer mutex.Unlock()
+	stats := serverStats()
 
 	fmt.Printf("Server Stats:\n")
-	fmt.Printf("Total clients connected: %d\n", len(clients))
-	fmt.Printf("Total rooms: %d\n", len(rooms))
+	fmt.Printf("Total clients connected: %d\n", stats.Clients)
+	fmt.Printf("Total rooms: %d\n", stats.Rooms)
+	fmt.Printf("Messages dropped for slow clients: %d\n", stats.DroppedMessages)
+	fmt.Printf("Slow clients disconnected: %d\n", stats.SlowDisconnects)
+	fmt.Printf("Connections refused by throttling: %d\n", stats.ThrottledConnections)
+	fmt.Printf("Connections closed for a handshake timeout: %d\n", stats.HandshakeTimeouts)
+	fmt.Printf("Messages sent: %d, delivered: %d, commands: %d\n", stats.Traffic.MessagesSent, stats.Traffic.MessagesReceived, stats.Traffic.Commands)
+	fmt.Printf("Bytes in: %d, out: %d, rate limit hits: %d\n", stats.Traffic.BytesIn, stats.Traffic.BytesOut, stats.Traffic.RateLimited)
+	fmt.Printf("Client software: %s\n", softwareSummary(stats.Software))
+	fmt.Printf("Broadcast channel: %d of %d queued, backlog warnings: %d\n", stats.BroadcastQueue, broadcastBuffer, stats.BacklogWarnings)
+	if latencyMetrics {
+		fmt.Printf("Broadcast latency: %s\n", latencyText(stats.BroadcastLatency))
+	}
+	if config().roomRate > 0 || stats.HotRooms > 0 {
+		slow := "none"
+		if len(stats.SlowRooms) > 0 {
+			slow = strings.Join(stats.SlowRooms, ", ")
+		}
+		fmt.Printf("Rooms put in slow mode: %d, in it now: %s\n", stats.HotRooms, slow)
+	}
+	fmt.Printf("Uptime: %v\n", time.Since(stats.Started).Round(time.Second))
+	for _, room := range slices.Sorted(maps.Keys(stats.RoomMembers)) {
+		fmt.Printf("Room %s: %d members\n", room, stats.RoomMembers[room])
+	}
 }
 
 func printAdminHelp() {
@@ -289,36 +1192,262 @@ func printAdminHelp() {
 	fmt.Println("  /clients  - List all connected clients")
 	fmt.Println("  /rooms    - List all chat rooms and their members")
 	fmt.Println("  /stats  - Show server statistics")
+	fmt.Println("  /goroutines - Show the goroutine count")
+	fmt.Println("  /greylist - List hosts refused for connecting too often")
 	fmt.Println("  /kick   - Kick a user from the server")
-	fmt.Println("  /ban    - Ban a user from the server")
+	fmt.Println("  /ban    - Ban an address, an IP or a network such as 203.0.113.0/24 or 203.0.113.*")
+	fmt.Println("  /unban  - Lift a server ban")
+	fmt.Println("  /undo   - Reverse the last ban or unban made in the last minute")
+	fmt.Println("  /banned - List server bans and their reasons")
+	fmt.Println("  /banexport [path] - Write the server bans to a file")
+	fmt.Println("  /banimport [path] [merge|replace] - Load server bans from a /banexport file")
+	fmt.Println("  /whois [username|ip] - Show a client's address, room and software")
+	fmt.Println("  /debugclient [username|ip] - Show everything the server keeps about a client")
+	fmt.Println("  /rban /runban /rbans - Manage a room's bans")
+	fmt.Println("  Add -y to /kick, /ban or /rban to skip the confirmation")
+	fmt.Println("  /announce all|#room [text] - Send an announcement now")
+	fmt.Println("  /schedule add|list|remove - Manage scheduled announcements")
+	fmt.Println("  /archive - Export a room's history to a file and purge it")
+	fmt.Println("  /limits - Show the client, room and message rate limits")
+	fmt.Println("  /setlimit [name] [value] - Change a limit until the next reload")
+	fmt.Println("  /exempt [room] - Exempt a room from room-rate or subject it again; without one, list exempt rooms")
+	fmt.Println("  /reload - Reload the configuration")
 	fmt.Println("  /help   - Show this help message")
 }
 
 func main() {
-	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
+	ircAddr := flag.String("irc", "", "address for the IRC listener, e.g. :6697 (disabled when empty)")
+	bridgeAddr := flag.String("bridge", "", "address to accept peer servers on, e.g. :3335 (disabled when empty)")
+	peerAddrs := flag.String("peers", "", "comma-separated peer server addresses to link with")
+	federate := flag.String("federate", "", "comma-separated rooms shared with peers")
+	serverID := flag.String("server-id", "", "name of this server shown to peers (default: hostname)")
+	bridgeSecret := flag.String("bridge-secret", os.Getenv("GOCHAT_BRIDGE_SECRET"), "shared secret peers authenticate with (default $GOCHAT_BRIDGE_SECRET)")
+	bridgeCAFile := flag.String("bridge-cafile", "", "PEM file with the CA that signs peer certificates (when empty, peers are only authenticated by -bridge-secret)")
+	flag.StringVar(&configFile, "config", "", "file of \"key = value\" lines overriding the flags below that can be reloaded with SIGHUP or /reload: slow-grace, max-paste-size, max-frame-size, retention-max-age, retention-max-count, require-nick, unfurl, goroutine-warn, reserved-names and lang")
+	flag.IntVar(&queueDepth, "queue-depth", DEFAULT_QUEUE_DEPTH, "number of outgoing messages buffered per client")
+	flag.DurationVar(&handshakeTimeout, "handshake-timeout", DEFAULT_HANDSHAKE_TIMEOUT, "how long a new connection has to send HELLO or take a name before it is closed (0 waits forever)")
+	flag.DurationVar(&flagSettings.slowGrace, "slow-grace", DEFAULT_SLOW_GRACE, "how long a client's queue may stay full before it is disconnected")
+	flag.IntVar(&broadcastBuffer, "broadcast-buffer", DEFAULT_BROADCAST_BUFFER, "room events that may wait for delivery before senders block; a warning is logged when it stays 80% full (0 for an unbuffered channel)")
+	workers := flag.Int("broadcast-workers", runtime.NumCPU(), "number of goroutines delivering to large rooms")
+	httpAddr := flag.String("http", "", "address for the HTTP listener, e.g. 127.0.0.1:8080 (disabled when empty)")
+	debug := flag.Bool("debug", false, "serve pprof under /debug/pprof and counters under /debug/vars on the HTTP listener")
+	debugAuth := flag.String("debug-auth", os.Getenv("GOCHAT_DEBUG_AUTH"), "user:password required for the debug endpoints (default $GOCHAT_DEBUG_AUTH)")
+	apiToken := flag.String("api-token", os.Getenv("GOCHAT_API_TOKEN"), "bearer token for the REST API at /api/ on the HTTP listener, which is off when empty (default $GOCHAT_API_TOKEN)")
+	adminAuth := flag.String("admin-auth", os.Getenv("GOCHAT_ADMIN_AUTH"), "user:password for the admin dashboard at /admin/ on the HTTP listener, which is off when empty (default $GOCHAT_ADMIN_AUTH)")
+	flag.IntVar(&flagSettings.goroutineWarn, "goroutine-warn", DEFAULT_GOROUTINE_WARN, "goroutine count above which /goroutines warns")
+	flag.Func("announce", "scheduled announcement as \"every <duration>|daily <HH:MM> all|#room <text>\"; repeatable", func(value string) error {
+		_, err := addSchedule(value)
+		return err
+	})
+	flag.DurationVar(&flagSettings.retentionMaxAge, "retention-max-age", 0, "drop room history older than this (0 keeps it until -retention-max-count pushes it out)")
+	flag.IntVar(&flagSettings.retentionMaxCount, "retention-max-count", HISTORY_SIZE, "number of messages of history kept per room")
+	flag.StringVar(&archiveDir, "archive-dir", "archives", "directory the admin /archive command writes to")
+	flag.BoolVar(&flagSettings.unfurl, "unfurl", false, "post the titles of web pages linked in rooms")
+	flag.IntVar(&flagSettings.maxPasteSize, "max-paste-size", DEFAULT_MAX_PASTE_SIZE, "largest multi-line message in bytes")
+	flag.IntVar(&flagSettings.maxFrameSize, "max-frame-size", framing.DEFAULT_MAX_SIZE, "largest frame accepted from clients that negotiated length-prefixed framing")
+	flag.BoolVar(&flagSettings.requireNick, "require-nick", false, "make clients pick a name with /nick before chatting instead of naming them guest-NNNN")
+	flag.StringVar(&flagSettings.lang, "lang", DEFAULT_LANGUAGE, "language of server messages for clients that did not choose one with /lang")
+	flag.StringVar(&flagSettings.reservedFile, "reserved-names", "", "file of extra names nobody may use, one per line")
+	flag.StringVar(&flagSettings.motdFile, "motd", "", "file sent to clients after the welcome line; a text/template that can use {{.UserCount}}, {{.TopRooms 5}}, {{.Uptime}} and {{.ServerName}}")
+	flag.IntVar(&flagSettings.maxClients, "max-clients", 0, "most clients connected at once (0 for no limit)")
+	flag.IntVar(&flagSettings.maxRooms, "max-rooms", 0, "most rooms that may exist (0 for no limit)")
+	flag.IntVar(&flagSettings.maxRoomsPerUser, "max-rooms-per-user", 0, "most rooms one client may own (0 for no limit)")
+	flag.IntVar(&flagSettings.messageRate, "message-rate", 0, "chat messages a client may send per minute (0 for no limit)")
+	flag.IntVar(&flagSettings.botMessageRate, "bot-message-rate", -1, "chat messages a bot may send per minute (0 for no limit, -1 for message-rate)")
+	flag.IntVar(&flagSettings.roomRate, "room-rate", 0, "chat messages a second a room may average before it goes into slow mode (0 for no limit)")
+	flag.BoolVar(&latencyMetrics, "latency-metrics", false, "record broadcast latency for /stats and /debug/vars")
+	flag.DurationVar(&flagSettings.latencyWarn, "latency-warn", 0, "log a warning for broadcasts that take longer than this to queue (0 for none)")
+	flag.DurationVar(&flagSettings.awayAfter, "away-after", 0, "show clients as away in /who after they send nothing for this long (0 for never)")
+	flag.IntVar(&flagSettings.fanoutThreshold, "fanout-threshold", 1000, "pace announcements to more clients than this over -fanout-window (0 never paces)")
+	flag.DurationVar(&flagSettings.fanoutWindow, "fanout-window", 2*time.Second, "time over which a paced announcement reaches every client")
+	var listenAddrs listenFlags
+	flag.Var(&listenAddrs, "listen", "address to accept clients on as tls://host:port[?cert=file&key=file] or tcp://host:port; repeatable (default tls://"+CONN_PORT+")")
+	logOutput := flag.String("log-output", "stderr", "where the log goes: stderr, syslog, or the path of a file, which is reopened on SIGUSR1")
+	logMaxSize := flag.Int64("log-max-size", DEFAULT_LOG_MAX_SIZE, "size in bytes at which the log file is rotated (0 never rotates)")
+	logBackups := flag.Int("log-backups", DEFAULT_LOG_BACKUPS, "number of rotated log files kept")
+	flag.IntVar(&throttleAttempts, "throttle-attempts", DEFAULT_THROTTLE_ATTEMPTS, "connection attempts a host may make per -throttle-window before it is greylisted (0 turns throttling off)")
+	flag.DurationVar(&throttleWindow, "throttle-window", DEFAULT_THROTTLE_WINDOW, "period over which -throttle-attempts is counted")
+	flag.DurationVar(&greylistTime, "greylist-time", DEFAULT_GREYLIST_TIME, "how long connections from a greylisted host are refused")
+	flag.StringVar(&evasionMode, "evasion", EVASION_OFF, "ban evasion detection: off, flag to log possible evasions, or strict to also ban them")
+	flag.DurationVar(&evasionWindow, "evasion-window", DEFAULT_EVASION_WINDOW, "how long banned clients are remembered for -evasion")
+	flag.Parse()
+	if evasionMode != EVASION_OFF && evasionMode != EVASION_FLAG && evasionMode != EVASION_STRICT {
+		log.Println("Error: -evasion must be off, flag or strict")
+		os.Exit(1)
+	}
+	if *logBackups < 0 {
+		log.Println("Error: -log-backups must not be negative")
+		os.Exit(1)
+	}
+	if err := setupLogOutput(*logOutput, *logMaxSize, *logBackups); err != nil {
+		log.Printf("Warning: cannot log to %s, logging to stderr instead: %v", *logOutput, err)
+	}
+	initial, err := loadSettings()
 	if err != nil {
-		log.Fatal(err)
+		log.Println("Error in configuration:", err)
+		os.Exit(1)
 	}
+	currentConfig.Store(initial)
+	if queueDepth < 1 {
+		log.Println("Error: -queue-depth must be at least 1")
+		os.Exit(1)
+	}
+	if broadcastBuffer < 0 {
+		log.Println("Error: -broadcast-buffer must not be negative")
+		os.Exit(1)
+	}
+	broadcast = make(chan Message, broadcastBuffer)
 
-	config := &tls.Config{Certificates: []tls.Certificate{cert}}
-	listener, err := tls.Listen(CONN_TYPE, CONN_PORT, config)
+	listeners, labels, err := activationListeners()
 	if err != nil {
 		log.Println("Error: ", err)
 		os.Exit(1)
 	}
-	defer listener.Close()
-	log.Println("Listening on " + CONN_PORT)
+	if listeners != nil && len(listenAddrs) > 0 {
+		log.Println("Socket-activated by systemd; ignoring -listen")
+	}
+	var specs []listenSpec
+	if listeners == nil {
+		if len(listenAddrs) == 0 {
+			listenAddrs = listenFlags{"tls://" + CONN_PORT}
+		}
+		for _, value := range listenAddrs {
+			spec, err := parseListen(value)
+			if err != nil {
+				log.Println("Error: ", err)
+				os.Exit(1)
+			}
+			specs = append(specs, spec)
+			labels = append(labels, spec.raw)
+		}
+		if listeners, err = openListeners(specs); err != nil {
+			log.Println("Error: ", err)
+			os.Exit(1)
+		}
+	}
+	// The listeners are open, but nothing is accepted from them until the
+	// broadcast workers and the bridge are running; serve holds the loops
+	// to start then.
+	var serve []func()
+	for i, listener := range listeners {
+		log.Println("Listening on " + labels[i])
+		serve = append(serve, func() { acceptClients(listener) })
+	}
 
-	go handleBroadcast()
-	go adminConsole()
+	var config *tls.Config
+	if *ircAddr != "" || *bridgeAddr != "" {
+		config, err = loadTLSConfig(DEFAULT_CERT_FILE, DEFAULT_KEY_FILE)
+		if err != nil {
+			closeListeners(listeners)
+			log.Fatal(err)
+		}
+	}
 
-	for {
-		conn, err := listener.Accept()
+	if *ircAddr != "" {
+		ircListener, err := net.Listen(CONN_TYPE, *ircAddr)
 		if err != nil {
+			closeListeners(listeners)
 			log.Println("Error: ", err)
-			continue
+			os.Exit(1)
+		}
+		ircListener = tls.NewListener(throttle(ircListener), config)
+		listeners = append(listeners, ircListener)
+		log.Println("Listening for IRC clients on " + *ircAddr)
+		serve = append(serve, func() { acceptIRC(ircListener) })
+	}
+
+	if *bridgeAddr != "" || *peerAddrs != "" {
+		if err := setupBridge(*serverID, *bridgeSecret, *federate, *bridgeCAFile); err != nil {
+			closeListeners(listeners)
+			log.Println("Error: ", err)
+			os.Exit(1)
+		}
+		if *bridgeAddr != "" {
+			bridgeListener, err := tls.Listen(CONN_TYPE, *bridgeAddr, config)
+			if err != nil {
+				closeListeners(listeners)
+				log.Println("Error: ", err)
+				os.Exit(1)
+			}
+			listeners = append(listeners, bridgeListener)
+			log.Println("Listening for peer servers on " + *bridgeAddr)
+			serve = append(serve, func() { acceptPeers(bridgeListener) })
+		}
+		for _, addr := range strings.Split(*peerAddrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				serve = append(serve, func() { dialPeer(addr) })
+			}
+		}
+	}
+
+	if *debug && *httpAddr == "" {
+		log.Println("Warning: -debug has no effect without -http")
+	}
+	if *adminAuth != "" && *httpAddr == "" {
+		log.Println("Warning: -admin-auth has no effect without -http")
+	}
+	if *apiToken != "" && *httpAddr == "" {
+		log.Println("Warning: -api-token has no effect without -http")
+	}
+	if *httpAddr != "" {
+		if *debug {
+			mountDebug(*debugAuth)
+		}
+		if *adminAuth != "" {
+			mountDashboard(*adminAuth)
+		}
+		if *apiToken != "" {
+			mountAPI(*apiToken)
 		}
-		log.Printf("Client connected: %v", conn.RemoteAddr())
-		go handleConnection(conn)
+		httpListener, err := net.Listen(CONN_TYPE, *httpAddr)
+		if err != nil {
+			closeListeners(listeners)
+			log.Println("Error: ", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, httpListener)
+		log.Println("Listening for HTTP on " + *httpAddr)
+		serve = append(serve, func() { serveHTTP(httpListener) })
 	}
+
+	certs := make(map[string]string)
+	for _, spec := range specs {
+		if spec.scheme == "tls" {
+			certs[spec.certFile] = spec.keyFile
+		}
+	}
+	if *ircAddr != "" || *bridgeAddr != "" {
+		certs[DEFAULT_CERT_FILE] = DEFAULT_KEY_FILE
+	}
+	debugHTTP := ""
+	if *debug && *httpAddr != "" && *debugAuth == "" {
+		debugHTTP = *httpAddr
+	}
+	logInsecureWarnings(insecureWarnings(specs, certs, debugHTTP, time.Now()))
+
+	startBroadcastWorkers(max(*workers, 1))
+	go runFanouts()
+	go handleBroadcast()
+	go watchBacklog()
+	go adminConsole()
+	go runScheduler()
+	go runRetentionSweeper()
+	for _, start := range serve {
+		go start()
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reloadConfig()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Println("Shutting down")
+	closeListeners(listeners)
+	stopReminders()
 }
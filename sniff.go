@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// PLAINTEXT_HINT is written to a client that connected to a TLS listener
+// without TLS, before it is dropped.
+const PLAINTEXT_HINT = "ERR_BAD_REQUEST: This port only accepts TLS connections. Connect with TLS, or to the server's tcp:// listener.\n"
+
+var errTLSOnPlain = errors.New("TLS client on a plaintext listener")
+
+// tlsRecordStart reports whether b starts like a TLS handshake record,
+// as a ClientHello does: content type 22, protocol major version 3.
+func tlsRecordStart(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x16 && b[1] == 0x03
+}
+
+// plaintextStart reports whether b is printable text, as the start of a
+// line from a plaintext client is.
+func plaintextStart(b []byte) bool {
+	for _, c := range b {
+		if (c < 0x20 && c != '\r' && c != '\n' && c != '\t') || c >= 0x7f {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// plainListener wraps the connections of a tcp:// listener in sniffConn.
+type plainListener struct {
+	net.Listener
+}
+
+func (l plainListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sniffConn{Conn: conn}, nil
+}
+
+// sniffConn looks at the first bytes read from a plaintext connection. A
+// TLS ClientHello there would otherwise be read as a line of garbage, so
+// the read fails with errTLSOnPlain instead. Only the connection's
+// goroutine reads, so sniffed needs no lock.
+type sniffConn struct {
+	net.Conn
+	sniffed bool
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.sniffed && n > 0 {
+		c.sniffed = true
+		if tlsRecordStart(p[:n]) {
+			log.Printf("TLS client attempted connection to a plaintext listener from %v", c.RemoteAddr())
+			return 0, errTLSOnPlain
+		}
+	}
+	return n, err
+}
+
+// tlsHandshake runs the handshake of a new TLS connection within
+// handshake-timeout, reporting whether it succeeded. A client that sent
+// plain text instead of a ClientHello is logged and sent PLAINTEXT_HINT
+// unencrypted, since it could not read anything else.
+func tlsHandshake(conn *tls.Conn) bool {
+	if handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	err := conn.Handshake()
+	if err == nil {
+		return true
+	}
+	var header tls.RecordHeaderError
+	if errors.As(err, &header) && header.Conn != nil && plaintextStart(header.RecordHeader[:]) {
+		log.Printf("Plaintext client attempted connection from %v", conn.RemoteAddr())
+		header.Conn.Write([]byte(PLAINTEXT_HINT))
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSRecordStart(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want bool
+	}{
+		{[]byte{0x16, 0x03, 0x01, 0x02, 0x00}, true},
+		{[]byte{0x16, 0x03}, true},
+		{[]byte{0x16}, false},
+		{[]byte{0x17, 0x03, 0x03}, false},
+		{[]byte("/nick bob\n"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := tlsRecordStart(test.b); got != test.want {
+			t.Errorf("tlsRecordStart(%x) = %t, want %t", test.b, got, test.want)
+		}
+	}
+}
+
+func TestPlaintextStart(t *testing.T) {
+	tests := []struct {
+		b    string
+		want bool
+	}{
+		{"HELLO", true},
+		{"/nic", true},
+		{"hi\r\n", true},
+		{"\tx", true},
+		{"\x16\x03\x01\x02\x00", false},
+		{"caf\xc3", false},
+		{"\x00abc", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := plaintextStart([]byte(test.b)); got != test.want {
+			t.Errorf("plaintextStart(%q) = %t, want %t", test.b, got, test.want)
+		}
+	}
+}
+
+func TestPlaintextClientOnTLSListener(t *testing.T) {
+	startServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	cert, _ := testCertificate(t)
+	go acceptClients(tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("/nick bob\n"))
+	conn.SetReadDeadline(time.Now().Add(LINE_TIMEOUT))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != PLAINTEXT_HINT {
+		t.Errorf("plaintext client got %q, %v; want %q", line, err, PLAINTEXT_HINT)
+	}
+}
+
+func TestTLSClientOnPlainListener(t *testing.T) {
+	addr := newPlainTestServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	client.SetDeadline(time.Now().Add(LINE_TIMEOUT))
+	if err := client.Handshake(); err == nil {
+		t.Fatal("TLS handshake with a plaintext listener succeeded")
+	}
+	// The server hangs up rather than treating the ClientHello as chat.
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("the plaintext listener kept the connection open: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const DEFAULT_GOROUTINE_WARN = 10000
+
+var (
+	startTime = time.Now()
+)
+
+// ServerStats is the snapshot shown by /stats and published at /debug/vars.
+type ServerStats struct {
+	Started         time.Time `json:"started"`
+	Clients         int       `json:"clients"`
+	Rooms           int       `json:"rooms"`
+	DroppedMessages int64     `json:"dropped_messages"`
+	SlowDisconnects int64     `json:"slow_disconnects"`
+	Goroutines      int       `json:"goroutines"`
+	// Bots is the number of Clients that said they are bots.
+	Bots int `json:"bots"`
+	// ThrottledConnections counts connections closed because their host
+	// was greylisted.
+	ThrottledConnections int64 `json:"throttled_connections"`
+	// HandshakeTimeouts counts connections closed for not saying HELLO
+	// or taking a name within handshake-timeout.
+	HandshakeTimeouts int64 `json:"handshake_timeouts"`
+	// HotRooms counts the times a room went over room-rate into slow
+	// mode; SlowRooms are the rooms in it now.
+	HotRooms  int64    `json:"hot_rooms"`
+	SlowRooms []string `json:"slow_rooms"`
+	// BroadcastQueue is the number of room events waiting in the
+	// broadcast channel; BacklogWarnings counts the times it stayed
+	// backed up; see watchBacklog.
+	BroadcastQueue  int   `json:"broadcast_queue"`
+	BacklogWarnings int64 `json:"broadcast_backlog_warnings"`
+	// Traffic sums the counters of every client since the server started.
+	Traffic counterSnapshot `json:"traffic"`
+	// Software counts connected clients by the software they named in
+	// HELLO, "unknown" for the rest.
+	Software map[string]int `json:"software"`
+	// BroadcastLatency is only kept with -latency-metrics.
+	BroadcastLatency *LatencyStats `json:"broadcast_latency,omitempty"`
+	// RoomMembers is the member count per room, for the admin console.
+	RoomMembers map[string]int `json:"room_members"`
+}
+
+func serverStats() ServerStats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	members := make(map[string]int, len(rooms))
+	for room, roomClients := range rooms {
+		members[room] = len(roomClients)
+	}
+	// Connections still in their handshake are not counted.
+	connected, bots := 0, 0
+	software := make(map[string]int)
+	for _, client := range clients {
+		if client.handshaken {
+			connected++
+			if client.bot {
+				bots++
+			}
+			software[client.softwareName()]++
+		}
+	}
+	return ServerStats{
+		Started:              startTime,
+		Clients:              connected,
+		Bots:                 bots,
+		Rooms:                len(rooms),
+		DroppedMessages:      droppedMessages.Load(),
+		SlowDisconnects:      slowDisconnects.Load(),
+		Goroutines:           runtime.NumGoroutine(),
+		ThrottledConnections: throttledConnections.Load(),
+		HandshakeTimeouts:    handshakeTimeouts.Load(),
+		HotRooms:             hotRoomEvents.Load(),
+		SlowRooms:            slowRooms(),
+		BroadcastQueue:       len(broadcast),
+		BacklogWarnings:      backlogWarnings.Load(),
+		Traffic:              serverCounters(),
+		Software:             software,
+		BroadcastLatency:     broadcastLatency.stats(),
+		RoomMembers:          members,
+	}
+}
+
+func printGoroutines() {
+	n := runtime.NumGoroutine()
+	fmt.Printf("Goroutines: %d\n", n)
+	if limit := config().goroutineWarn; n > limit {
+		fmt.Printf("Warning: more than %d goroutines; connections may be leaking.\n", limit)
+	}
+}
+
+// clientStats is the reply to a client's /stats: the public part of
+// serverStats and the client's own session.
+func clientStats(client *Client) string {
+	stats := serverStats()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Server uptime: %v\n", time.Since(stats.Started).Round(time.Second))
+	fmt.Fprintf(&b, "Users online: %d\n", stats.Clients-stats.Bots)
+	fmt.Fprintf(&b, "Rooms: %d\n", stats.Rooms)
+	fmt.Fprintf(&b, "You have been connected for %v.\n", time.Since(client.connected).Round(time.Second))
+	b.WriteString(countersLine(client.counters.snapshot()))
+	return b.String()
+}
+
+// countersLine describes a client's counters for /stats and /whois.
+func countersLine(c counterSnapshot) string {
+	return fmt.Sprintf("Messages sent: %d, received: %d. Commands: %d. Bytes in: %d, out: %d. Rate limit hits: %d. Queue high-water mark: %d.\n",
+		c.MessagesSent, c.MessagesReceived, c.Commands, c.BytesIn, c.BytesOut, c.RateLimited, c.QueueHighWater)
+}
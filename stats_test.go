@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// usersOnline sends /stats as c and returns the number of users it reports.
+func usersOnline(c *testClient) int {
+	c.t.Helper()
+	c.send("/stats")
+	line := c.expectLine("Users online: ")
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "Users online: "))
+	if err != nil {
+		c.t.Fatalf("/stats: %q", line)
+	}
+	return n
+}
+
+// TestUsersOnlineSkipsHandshakesAndBots checks that /stats counts neither
+// connections that have not finished their handshake nor bots.
+func TestUsersOnlineSkipsHandshakesAndBots(t *testing.T) {
+	addr := newTestServer(t)
+	alice := newTestClient(t, addr)
+	usersOnline(alice)
+	base := usersOnline(alice)
+
+	silent := newTestClient(t, addr)
+	if n := usersOnline(alice); n != base {
+		t.Errorf("%d users online with a connection in its handshake, want %d", n, base)
+	}
+	bot := newTestClient(t, addr)
+	bot.send("HELLO testbot 1 bot")
+	bot.expectLine("WELCOME")
+	if n := usersOnline(alice); n != base {
+		t.Errorf("%d users online with a bot connected, want %d", n, base)
+	}
+	silent.send("/stats")
+	silent.expectLine("Users online: ")
+	if n := usersOnline(alice); n != base+1 {
+		t.Errorf("%d users online once the handshake finished, want %d", n, base+1)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+const MAX_ROOM_TAGS = 5
+
+func init() {
+	registerCommand("/settags", chatCommand{usage: "/settags [tag,tag,...]", help: "Tag your room for /list, or clear its tags (room owner)", needsRoom: true, run: setTags})
+}
+
+func setTags(client *Client, room, args string) {
+	tags, err := parseTags(args)
+	if err != nil {
+		client.sayError(err)
+		return
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("tags.owner_only")
+		return
+	}
+	meta.tags = tags
+	mutex.Unlock()
+	if len(tags) == 0 {
+		client.say("tags.cleared", room)
+	} else {
+		client.say("tags.set", room, strings.Join(tags, ", "))
+	}
+}
+
+// parseTags reads a comma-separated tag list. Tags are checked like room
+// names and kept in lower case, without duplicates.
+func parseTags(list string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if !validName(tag) {
+			return nil, localErrorf("tags.invalid", tag, MAX_NAME_LENGTH)
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > MAX_ROOM_TAGS {
+		return nil, localErrorf("tags.too_many", MAX_ROOM_TAGS)
+	}
+	return tags, nil
+}
+
+// filterByTag keeps the entries tagged tag.
+func filterByTag(entries []directoryEntry, tag string) []directoryEntry {
+	tag = strings.ToLower(tag)
+	var kept []directoryEntry
+	for _, entry := range entries {
+		if slices.Contains(entry.Tags, tag) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// directoryTags returns the tags used by entries, sorted.
+func directoryTags(entries []directoryEntry) []string {
+	var tags []string
+	for _, entry := range entries {
+		for _, tag := range entry.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
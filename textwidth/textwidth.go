@@ -0,0 +1,55 @@
+// Package textwidth measures text in terminal columns, counting East Asian
+// wide and fullwidth characters, which include most emoji, as two columns
+// and combining marks as none. The chat server and the terminal client use
+// it to fit names and lines to the space they have.
+package textwidth
+
+import (
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// ELLIPSIS marks text shortened by Truncate.
+const ELLIPSIS = "…"
+
+// Rune returns the number of terminal columns r takes up.
+func Rune(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// String returns the number of terminal columns s takes up.
+func String(s string) int {
+	n := 0
+	for _, r := range s {
+		n += Rune(r)
+	}
+	return n
+}
+
+// Truncate shortens s to at most columns, ending it with ELLIPSIS when
+// anything was cut. It never cuts inside a rune, and drops the combining
+// marks of a rune it drops.
+func Truncate(s string, columns int) string {
+	if String(s) <= columns {
+		return s
+	}
+	if columns < 1 {
+		return ""
+	}
+	used := 0
+	for i, r := range s {
+		if used+Rune(r) > columns-1 {
+			return s[:i] + ELLIPSIS
+		}
+		used += Rune(r)
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package textwidth
+
+import "testing"
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"guest-1234", 10},
+		{"Алия", 4},
+		{"日本語", 6},
+		{"🎲", 2},
+		{"\u00e9", 1},
+		{"e\u0301", 1},
+		{"a\u200bb", 2},
+	}
+	for _, test := range tests {
+		if got := String(test.s); got != test.want {
+			t.Errorf("String(%q) = %d, want %d", test.s, got, test.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s       string
+		columns int
+		want    string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"one too long", 11, "one too lo…"},
+		{"abc", 1, "…"},
+		{"abc", 0, ""},
+		{"abc", -1, ""},
+		{"", 0, ""},
+		{"日本語テキスト", 7, "日本語…"},
+		{"日本語テキスト", 6, "日本…"},
+		{"🎲🎲🎲", 4, "🎲…"},
+		{"caf\u00e9 au lait", 6, "caf\u00e9 …"},
+		{"e\u0301e\u0301e\u0301", 2, "e\u0301…"},
+	}
+	for _, test := range tests {
+		got := Truncate(test.s, test.columns)
+		if got != test.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", test.s, test.columns, got, test.want)
+		}
+		if String(got) > max(test.columns, 0) {
+			t.Errorf("Truncate(%q, %d) = %q, %d columns wide", test.s, test.columns, got, String(got))
+		}
+	}
+}
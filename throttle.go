@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_THROTTLE_ATTEMPTS = 10
+	DEFAULT_THROTTLE_WINDOW   = 10 * time.Second
+	DEFAULT_GREYLIST_TIME     = time.Minute
+)
+
+var (
+	throttleAttempts = DEFAULT_THROTTLE_ATTEMPTS
+	throttleWindow   = DEFAULT_THROTTLE_WINDOW
+	greylistTime     = DEFAULT_GREYLIST_TIME
+
+	throttleMutex sync.Mutex
+	// connectTimes are the recent connection attempts per host, oldest
+	// first. The caller must hold throttleMutex.
+	connectTimes = make(map[string][]time.Time)
+	// greylist maps hosts whose connections are refused to when that
+	// ends. The caller must hold throttleMutex.
+	greylist = make(map[string]time.Time)
+	// lastThrottleSweep is when expired entries were last dropped.
+	lastThrottleSweep time.Time
+
+	throttledConnections atomic.Int64
+)
+
+// throttleListener closes connections from greylisted hosts as soon as
+// they are accepted, before a TLS listener wrapping it starts the
+// handshake.
+type throttleListener struct {
+	net.Listener
+}
+
+// throttle wraps a TCP listener in a throttleListener, unless throttling is
+// off.
+func throttle(listener net.Listener) net.Listener {
+	if throttleAttempts < 1 {
+		return listener
+	}
+	return throttleListener{listener}
+}
+
+func (l throttleListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil || allowConnection(host, time.Now()) {
+			return conn, nil
+		}
+		throttledConnections.Add(1)
+		conn.Close()
+	}
+}
+
+// allowConnection records a connection attempt from host and reports
+// whether it may go ahead. A host making more than throttleAttempts within
+// throttleWindow is greylisted for greylistTime.
+func allowConnection(host string, now time.Time) bool {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	if now.Sub(lastThrottleSweep) > throttleWindow {
+		sweepThrottle(now)
+	}
+	if until, ok := greylist[host]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(greylist, host)
+	}
+	times := connectTimes[host]
+	for len(times) > 0 && now.Sub(times[0]) > throttleWindow {
+		times = times[1:]
+	}
+	times = append(times, now)
+	if len(times) > throttleAttempts {
+		delete(connectTimes, host)
+		greylist[host] = now.Add(greylistTime)
+		log.Printf("Greylisted %s for %v after %d connection attempts in %v", host, greylistTime, len(times), throttleWindow)
+		return false
+	}
+	connectTimes[host] = times
+	return true
+}
+
+// sweepThrottle drops expired greylist entries and hosts with no recent
+// attempts. The caller must hold throttleMutex.
+func sweepThrottle(now time.Time) {
+	for host, until := range greylist {
+		if !now.Before(until) {
+			delete(greylist, host)
+		}
+	}
+	for host, times := range connectTimes {
+		if now.Sub(times[len(times)-1]) > throttleWindow {
+			delete(connectTimes, host)
+		}
+	}
+	lastThrottleSweep = now
+}
+
+// printGreylist shows the hosts whose connections are being refused.
+func printGreylist() {
+	now := time.Now()
+	throttleMutex.Lock()
+	sweepThrottle(now)
+	hosts := make([]string, 0, len(greylist))
+	for host := range greylist {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	lines := make([]string, len(hosts))
+	for i, host := range hosts {
+		lines[i] = fmt.Sprintf("%s: %v left", host, greylist[host].Sub(now).Round(time.Second))
+	}
+	throttleMutex.Unlock()
+
+	if len(lines) == 0 {
+		fmt.Println("No hosts are greylisted.")
+		return
+	}
+	fmt.Println("Greylisted hosts:")
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Printf("Connections refused so far: %d\n", throttledConnections.Load())
+}
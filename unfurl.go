@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	UNFURL_WORKERS      = 4
+	UNFURL_QUEUE        = 64
+	UNFURL_TIMEOUT      = 5 * time.Second
+	UNFURL_MAX_BYTES    = 256 * 1024
+	UNFURL_MAX_URLS     = 3
+	UNFURL_MAX_TITLE    = 200
+	UNFURL_MAX_REDIRECT = 3
+	UNFURL_CACHE_TTL    = time.Hour
+	// UNFURL_SENDER is who titles are posted as. The name is reserved, so
+	// no client can post as it.
+	UNFURL_SENDER = "server"
+)
+
+var (
+	unfurlJobs = make(chan unfurlJob, UNFURL_QUEUE)
+	// unfurlStart starts the workers the first time unfurl is on.
+	unfurlStart sync.Once
+
+	unfurlMutex sync.Mutex
+	unfurlCache = map[string]unfurlEntry{}
+
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+	errBlockedAddress = errors.New("address is not public")
+)
+
+type unfurlJob struct {
+	room string
+	url  string
+}
+
+// unfurlEntry is a cached title. An empty title is cached too, so a page
+// without one is not fetched again.
+type unfurlEntry struct {
+	title   string
+	fetched time.Time
+}
+
+// unfurlClient fetches pages for titles. It only connects to public
+// addresses, checked after DNS resolution, and only follows redirects to
+// http and https URLs.
+var unfurlClient = &http.Client{
+	Timeout: UNFURL_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: UNFURL_TIMEOUT, Control: checkPublicAddress}).DialContext,
+		TLSHandshakeTimeout:   UNFURL_TIMEOUT,
+		ResponseHeaderTimeout: UNFURL_TIMEOUT,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= UNFURL_MAX_REDIRECT {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to %s URL", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+func startUnfurlWorkers() {
+	for i := 0; i < UNFURL_WORKERS; i++ {
+		go func() {
+			for job := range unfurlJobs {
+				if title := unfurl(job.url); title != "" {
+					broadcast <- chatMessage(job.room, UNFURL_SENDER, "↪ "+title)
+				}
+			}
+		}()
+	}
+}
+
+// queueUnfurls queues the URLs in a room chat message for their titles to
+// be posted if unfurl is on. URLs are dropped when the workers are behind.
+func queueUnfurls(message Message) {
+	if !config().unfurl || message.kind != MESSAGE_CHAT || message.room == "" || message.origin != "" || message.from == UNFURL_SENDER {
+		return
+	}
+	unfurlStart.Do(startUnfurlWorkers)
+	for _, url := range findURLs(message.body) {
+		select {
+		case unfurlJobs <- unfurlJob{room: message.room, url: url}:
+		default:
+			log.Printf("Unfurl queue is full, skipping %s", url)
+		}
+	}
+}
+
+// findURLs returns up to UNFURL_MAX_URLS distinct http(s) URLs in text,
+// without trailing punctuation.
+func findURLs(text string) []string {
+	var urls []string
+	for _, url := range urlPattern.FindAllString(text, -1) {
+		url = strings.TrimRight(url, ".,;:!?)]}'")
+		if !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+		if len(urls) == UNFURL_MAX_URLS {
+			break
+		}
+	}
+	return urls
+}
+
+// unfurl returns the title of the page at url, from the cache when it was
+// fetched within the last hour.
+func unfurl(url string) string {
+	unfurlMutex.Lock()
+	entry, ok := unfurlCache[url]
+	unfurlMutex.Unlock()
+	if ok && time.Since(entry.fetched) < UNFURL_CACHE_TTL {
+		return entry.title
+	}
+	title, err := fetchTitle(url)
+	if err != nil {
+		log.Printf("Unfurl %s: %v", url, err)
+	}
+	unfurlMutex.Lock()
+	for key, old := range unfurlCache {
+		if time.Since(old.fetched) >= UNFURL_CACHE_TTL {
+			delete(unfurlCache, key)
+		}
+	}
+	unfurlCache[url] = unfurlEntry{title: title, fetched: time.Now()}
+	unfurlMutex.Unlock()
+	return title
+}
+
+func fetchTitle(url string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), UNFURL_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "gochat-unfurl/1")
+	req.Header.Set("Accept", "text/html")
+	resp, err := unfurlClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return "", nil
+	}
+	return pageTitle(io.LimitReader(resp.Body, UNFURL_MAX_BYTES)), nil
+}
+
+// pageTitle returns the text of the first <title> element in an HTML
+// document, on one line and at most UNFURL_MAX_TITLE characters long.
+func pageTitle(r io.Reader) string {
+	tokens := html.NewTokenizer(r)
+	inTitle := false
+	var title strings.Builder
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return cleanTitle(title.String())
+		case html.StartTagToken:
+			name, _ := tokens.TagName()
+			inTitle = string(name) == "title"
+		case html.EndTagToken:
+			if name, _ := tokens.TagName(); string(name) == "title" {
+				return cleanTitle(title.String())
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(tokens.Text())
+			}
+		}
+	}
+}
+
+func cleanTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) > UNFURL_MAX_TITLE {
+		title = string([]rune(title)[:UNFURL_MAX_TITLE]) + "…"
+	}
+	return title
+}
+
+// checkPublicAddress refuses connections to loopback, private, link-local
+// and other non-public addresses. It runs after DNS resolution, so a name
+// cannot be pointed at an internal address.
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || isSharedAddress(ip) {
+		return fmt.Errorf("%s: %w", host, errBlockedAddress)
+	}
+	return nil
+}
+
+// isSharedAddress reports whether ip is in 100.64.0.0/10, the carrier-grade
+// NAT range, which IsPrivate does not cover.
+func isSharedAddress(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// MAX_WATCHES is how many usernames one client may watch.
+const MAX_WATCHES = 25
+
+func init() {
+	registerCommand("/watch", chatCommand{usage: "/watch [username]", help: "Be told when a user comes online, or list who you watch", run: watchCommand})
+	registerCommand("/unwatch", chatCommand{usage: "/unwatch [username]", help: "Stop watching a user", run: unwatchCommand})
+}
+
+func watchCommand(client *Client, room, name string) {
+	if name == "" {
+		listWatches(client)
+		return
+	}
+	if !validName(name) {
+		client.say("watch.usage")
+		return
+	}
+	mutex.Lock()
+	if !client.watching[name] && len(client.watching) >= MAX_WATCHES {
+		mutex.Unlock()
+		client.say("watch.full", MAX_WATCHES)
+		return
+	}
+	if client.watching == nil {
+		client.watching = make(map[string]bool)
+	}
+	client.watching[name] = true
+	online := findClient(name) != nil
+	mutex.Unlock()
+	if online {
+		client.say("watch.already_online", name)
+		return
+	}
+	client.say("watch.done", name)
+}
+
+func unwatchCommand(client *Client, room, name string) {
+	if name == "" {
+		client.say("unwatch.usage")
+		return
+	}
+	mutex.Lock()
+	watched := client.watching[name]
+	delete(client.watching, name)
+	mutex.Unlock()
+	if !watched {
+		client.say("unwatch.not_watched", name)
+		return
+	}
+	client.say("unwatch.done", name)
+}
+
+func listWatches(client *Client) {
+	mutex.Lock()
+	names := make([]string, 0, len(client.watching))
+	for name := range client.watching {
+		names = append(names, name)
+	}
+	mutex.Unlock()
+	if len(names) == 0 {
+		client.say("watch.none")
+		return
+	}
+	sort.Strings(names)
+	client.say("watch.list", strings.Join(names, ", "))
+}
+
+// notifyWatchers tells the clients watching name that client just took
+// it. Each name is announced once per session of client, however often it
+// renames. It is called in the same critical section that claims the name,
+// so two connections racing for a name cannot both be announced. The
+// caller must hold mutex.
+func notifyWatchers(client *Client, name string) {
+	if client.announced[name] {
+		return
+	}
+	if client.announced == nil {
+		client.announced = make(map[string]bool)
+	}
+	client.announced[name] = true
+	for _, watcher := range clients {
+		if watcher != client && watcher.watching[name] {
+			watcher.deliver(localMessage("", MESSAGE_NOTICE, "watch.online", name))
+		}
+	}
+}
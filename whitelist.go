@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand("/setwhitelist", chatCommand{usage: "/setwhitelist on|off", help: "Only let allowed users join your room (room owner)", needsRoom: true, run: setWhitelist})
+	registerCommand("/allow", chatCommand{usage: "/allow [username]", help: "Allow a user to join your room (room owner)", needsRoom: true, run: allowCommand})
+	registerCommand("/allowed", chatCommand{usage: "/allowed", help: "List the users allowed in your room", needsRoom: true, run: allowedCommand})
+	registerCommand("/invite", chatCommand{usage: "/invite [username]", help: "Invite a user to your room", needsRoom: true, run: invite})
+}
+
+// whitelistError returns why client may not join room, if the room is
+// whitelisted and client is not allowed in. The caller must hold mutex.
+func whitelistError(client *Client, room string) error {
+	meta := roomMetas[room]
+	if meta == nil || !meta.whitelist || meta.owner == client || meta.allowed[client.username] {
+		return nil
+	}
+	return localErrorf("whitelist.invite_only", room)
+}
+
+func setWhitelist(client *Client, room, args string) {
+	if args != "on" && args != "off" {
+		client.say("whitelist.usage")
+		return
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("whitelist.owner_only")
+		return
+	}
+	meta.whitelist = args == "on"
+	mutex.Unlock()
+	if args == "on" {
+		client.say("whitelist.on", room)
+	} else {
+		client.say("whitelist.off", room)
+	}
+}
+
+func allowCommand(client *Client, room, name string) {
+	if name == "" {
+		client.say("allow.usage")
+		return
+	}
+	mutex.Lock()
+	meta := metaFor(room)
+	if meta.owner != client {
+		mutex.Unlock()
+		client.say("allow.owner_only")
+		return
+	}
+	meta.allowed[name] = true
+	meta.invitedBy[name] = client.username
+	mutex.Unlock()
+	client.say("allow.done", name, room)
+}
+
+func allowedCommand(client *Client, room, args string) {
+	mutex.Lock()
+	meta := metaFor(room)
+	names := make([]string, 0, len(meta.allowed))
+	for name := range meta.allowed {
+		names = append(names, name)
+	}
+	whitelist := meta.whitelist
+	mutex.Unlock()
+	sort.Strings(names)
+	mode := "off"
+	if whitelist {
+		mode = "on"
+	}
+	if len(names) == 0 {
+		client.say("allowed.none", room, mode)
+		return
+	}
+	client.say("allowed.list", room, mode, strings.Join(names, ", "))
+}
+
+// invite allows a user into the room and tells them about it.
+func invite(client *Client, room, name string) {
+	if name == "" {
+		client.say("invite.usage")
+		return
+	}
+	mutex.Lock()
+	target := findClient(name)
+	if target == nil {
+		mutex.Unlock()
+		client.say("user.missing", name)
+		return
+	}
+	if err := blockError(client, target); err != nil {
+		mutex.Unlock()
+		client.sayError(err)
+		return
+	}
+	meta := metaFor(room)
+	meta.allowed[name] = true
+	meta.invitedBy[name] = client.username
+	target.deliver(localMessage("", MESSAGE_NOTICE, "invite.received", client.username, room, room))
+	mutex.Unlock()
+	client.say("invite.done", name, room)
+}
+
+// noteInviteUsed tells the owner of room, wherever they are, that client
+// joined it on an invite if the room is whitelisted. The caller must hold
+// mutex.
+func noteInviteUsed(client *Client, room string) {
+	meta := roomMetas[room]
+	if meta == nil || !meta.whitelist || meta.owner == nil || meta.owner == client || !meta.allowed[client.username] {
+		return
+	}
+	meta.owner.deliver(localMessage("", MESSAGE_NOTICE, "invite.used", client.username, room, meta.invitedBy[client.username]))
+}
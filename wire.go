@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"final_project/framing"
+)
+
+// wireConn is a client connection that can switch from newline-delimited
+// lines to length-prefixed frames after the HELLO handshake. Once framed,
+// each Write becomes one frame without its trailing newline.
+type wireConn struct {
+	net.Conn
+	mu     sync.Mutex
+	frames *framing.Writer
+	// counters are the client's, whose byte counts include framing.
+	counters *clientCounters
+	// crlf ends unframed output lines with "\r\n" instead of "\n". It
+	// follows the client's first line until HELLO or /prefs set it.
+	crlf     atomic.Bool
+	detected atomic.Bool
+}
+
+func (w *wireConn) Read(p []byte) (int, error) {
+	n, err := w.Conn.Read(p)
+	w.counters.bytesIn.Add(int64(n))
+	if !w.detected.Load() {
+		if i := bytes.IndexByte(p[:n], '\n'); i >= 0 {
+			w.crlf.Store(i > 0 && p[i-1] == '\r')
+			w.detected.Store(true)
+		}
+	}
+	return n, err
+}
+
+// setCRLF picks the line endings of output for good.
+func (w *wireConn) setCRLF(on bool) {
+	w.detected.Store(true)
+	w.crlf.Store(on)
+}
+
+// writeRaw writes to the connection, counting the bytes.
+func (w *wireConn) writeRaw(p []byte) (int, error) {
+	n, err := w.Conn.Write(p)
+	w.counters.bytesOut.Add(int64(n))
+	return n, err
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// framed reports whether the connection switched to frames.
+func (w *wireConn) framed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.frames != nil
+}
+
+func (w *wireConn) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.frames == nil {
+		if !w.crlf.Load() {
+			return w.writeRaw(p)
+		}
+		if _, err := w.writeRaw(crlfLines(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	payload := bytes.TrimSuffix(p, []byte("\n"))
+	if len(payload) <= framing.DEFAULT_MAX_SIZE {
+		if err := w.frames.WriteFrame(payload); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	// Large writes, such as a backfill, go out a line per frame.
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		if err := w.frames.WriteFrame(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// crlfLines turns the bare "\n" line endings in p into "\r\n".
+func crlfLines(p []byte) []byte {
+	out := make([]byte, 0, len(p)+bytes.Count(p, []byte("\n")))
+	for i, b := range p {
+		if b == '\n' && (i == 0 || p[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// startFraming writes welcome as a line and frames everything written
+// after it.
+func (w *wireConn) startFraming(welcome []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.crlf.Load() {
+		welcome = crlfLines(welcome)
+	}
+	if _, err := w.writeRaw(welcome); err != nil {
+		return err
+	}
+	w.frames = framing.NewWriter(writerFunc(w.writeRaw), framing.DEFAULT_MAX_SIZE)
+	return nil
+}
+
+// readFrame reads the next frame as one message, which may span several
+// lines.
+func readFrame(frames *framing.Reader) (string, error) {
+	payload, err := frames.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(payload), string(utf8.RuneError)), nil
+}